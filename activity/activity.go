@@ -0,0 +1,62 @@
+// Package activity is the API available to code running inside an activity function.
+//
+// The runtime executing the activity, such as a pitlane.Worker or
+// pitlanetest.TestWorkflowEnvironment, describes the activity run in the activity's context.
+package activity
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Info describes the activity run being executed.
+type Info struct {
+	ActivityRunID string
+	ActivityName  string
+	WorkflowRunID string
+	// Attempt is 1 for the first execution of the run and incremented for each retry.
+	Attempt int
+}
+
+type activityKey struct{}
+
+type activityContext struct {
+	info   Info
+	logger *slog.Logger
+}
+
+// WithInfo returns an activity context for the activity run described by info, whose logger logs
+// to logger, or to slog.Default when it is nil. It is meant for runtimes executing activities.
+func WithInfo(ctx context.Context, info Info, logger *slog.Logger) context.Context {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return context.WithValue(ctx, activityKey{}, &activityContext{
+		info: info,
+		logger: logger.With(
+			slog.String("activity_run_id", info.ActivityRunID),
+			slog.String("activity_name", info.ActivityName),
+			slog.String("workflow_run_id", info.WorkflowRunID),
+			slog.Int("attempt", info.Attempt),
+		),
+	})
+}
+
+// GetInfo describes the activity run of ctx. It reports false outside an activity.
+func GetInfo(ctx context.Context) (Info, bool) {
+	activity, ok := ctx.Value(activityKey{}).(*activityContext)
+	if !ok {
+		return Info{}, false
+	}
+	return activity.info, true
+}
+
+// GetLogger returns a logger with the run ID, activity name, workflow run ID and attempt of the
+// activity run. Outside an activity it returns slog.Default.
+func GetLogger(ctx context.Context) *slog.Logger {
+	activity, ok := ctx.Value(activityKey{}).(*activityContext)
+	if !ok {
+		return slog.Default()
+	}
+	return activity.logger
+}
@@ -0,0 +1,27 @@
+// Package archive keeps the history of closed workflow runs after retention deletes them from
+// the database.
+//
+// The engine's cleanup passes each batch of expiring runs to an Archiver and only deletes the
+// runs once Archive returned without an error. Archiving happens outside of any database
+// transaction, so a run whose deletion fails afterwards is passed to Archive again by the next
+// cleanup pass.
+package archive
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nurburg-dev/pitlane/history"
+)
+
+// ErrNotFound is returned by Archiver.Get for runs that were never archived.
+var ErrNotFound = errors.New("archived workflow run not found")
+
+// Archiver stores the history of workflow runs before they are deleted.
+type Archiver interface {
+	// Archive stores docs durably before returning. A run may be archived again, after a failed
+	// deletion or when it changed before it was deleted.
+	Archive(ctx context.Context, docs []*history.Document) error
+	// Get returns the history of a workflow run archived last, or an error matching ErrNotFound.
+	Get(ctx context.Context, workflowRunID string) (*history.Document, error)
+}
@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/history"
+)
+
+const (
+	dayLayout     = "2006-01-02"
+	batchLayout   = "20060102T150405.000000000Z"
+	fileExtension = ".jsonl.gz"
+)
+
+// FileArchiver is an Archiver writing gzip-compressed JSON Lines files on a local filesystem,
+// one file per batch, day and workflow:
+//
+//	<dir>/<YYYY-MM-DD>/<escaped workflow name>/<batch>.jsonl.gz
+//
+// The day is the UTC date a run closed on, and workflow names are escaped with url.PathEscape.
+// Batch names start with the UTC time of the call to Archive, so they sort chronologically. Each
+// line is a history document as written by history.Write, without indentation.
+//
+// Batch files are written to a temporary file and renamed into place, so readers never see a
+// partial batch and several processes may archive into the same directory. Archiving a run again
+// writes another copy of it; Get returns the copy archived last. Reads skip the unreadable rest
+// of a damaged file instead of failing.
+//
+// Get scans the files from the newest day back, so looking up a run is linear in the size of
+// the archive; it is meant for occasional inspection rather than serving traffic.
+type FileArchiver struct {
+	dir string
+}
+
+var _ Archiver = (*FileArchiver)(nil)
+
+func NewFileArchiver(dir string) (*FileArchiver, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &FileArchiver{dir: dir}, nil
+}
+
+func (a *FileArchiver) Archive(_ context.Context, docs []*history.Document) error {
+	batch, err := batchName(time.Now())
+	if err != nil {
+		return err
+	}
+	dirs := map[string][]*history.Document{}
+	for _, doc := range docs {
+		dir := a.dirOf(doc)
+		dirs[dir] = append(dirs[dir], doc)
+	}
+
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		path := filepath.Join(dir, batch+fileExtension)
+		if err := writeDocuments(path, dirs[dir]); err != nil {
+			return fmt.Errorf("failed to archive to %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// batchName returns a unique file name for a batch archived at now, sorting after the batches
+// archived before it.
+func batchName(now time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate batch name: %w", err)
+	}
+	return now.UTC().Format(batchLayout) + "-" + hex.EncodeToString(suffix), nil
+}
+
+func (a *FileArchiver) dirOf(doc *history.Document) string {
+	closedAt := doc.ExportedAt
+	if doc.Run.ClosedAt != nil {
+		closedAt = *doc.Run.ClosedAt
+	}
+	day := closedAt.UTC().Format(dayLayout)
+	return filepath.Join(a.dir, day, url.PathEscape(doc.Run.WorkflowName))
+}
+
+// writeDocuments writes docs to a new file at path through a temporary file.
+func writeDocuments(path string, docs []*history.Document) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, doc := range docs {
+		line, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to encode history of workflow run %s: %w", doc.Run.ID, err)
+		}
+		if _, err := zw.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	// The temporary file lacks the extension, so Get skips it until it is renamed.
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (a *FileArchiver) Get(ctx context.Context, workflowRunID string) (*history.Document, error) {
+	days, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive directory: %w", err)
+	}
+	// Matching the encoded ID first skips decoding the documents of other runs.
+	needle, err := json.Marshal(workflowRunID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Name() > days[j].Name()
+	})
+	for _, day := range days {
+		if _, err := time.Parse(dayLayout, day.Name()); err != nil || !day.IsDir() {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(a.dir, day.Name(), "*", "*"+fileExtension))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive directory: %w", err)
+		}
+		// The batches of a day are searched newest first, whichever workflow they belong to.
+		sort.Slice(files, func(i, j int) bool {
+			return filepath.Base(files[i]) > filepath.Base(files[j])
+		})
+		for _, file := range files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			doc, err := findDocument(file, workflowRunID, needle)
+			if err != nil || doc != nil {
+				return doc, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, workflowRunID)
+}
+
+// findDocument returns the last document of workflowRunID in the file at path, or nil. A file
+// that is truncated or damaged is read up to the damage.
+func findDocument(path, workflowRunID string, needle []byte) (*history.Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+
+	var found *history.Document
+	r := bufio.NewReader(zr)
+	for {
+		line, err := r.ReadBytes('\n')
+		// A line without its newline was cut off by the damage.
+		if err == nil && bytes.Contains(line, needle) {
+			doc, decodeErr := history.Read(bytes.NewReader(line))
+			if decodeErr == nil && doc.Run.ID == workflowRunID {
+				found = doc
+			}
+		}
+		if err != nil {
+			return found, nil
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package archive_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/archive"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func document(id, workflowName string, closedAt time.Time) *history.Document {
+	return &history.Document{
+		FormatVersion: history.FormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		Workflow:      backend.Workflow{Name: workflowName},
+		Run: backend.WorkflowRun{
+			ID:           id,
+			WorkflowName: workflowName,
+			Input:        json.RawMessage(`{"payloads":[]}`),
+			Status:       backend.WorkflowStatusFinished,
+			ClosedAt:     &closedAt,
+		},
+	}
+}
+
+// readBatches returns the lines of each batch file in the directory of a day and workflow.
+func readBatches(t *testing.T, dir string) [][]string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	require.NoError(t, err)
+	batches := make([][]string, 0, len(files))
+	for _, file := range files {
+		f, err := os.Open(file)
+		require.NoError(t, err)
+		zr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		data, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		batches = append(batches, strings.Split(strings.TrimSpace(string(data)), "\n"))
+	}
+	return batches
+}
+
+func TestFileArchiver(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	a, err := archive.NewFileArchiver(dir)
+	require.NoError(t, err)
+
+	day1 := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+	require.NoError(t, a.Archive(ctx, []*history.Document{
+		document("run-1", "github.com/acme/shop.OrderWorkflow", day1),
+		document("run-2", "github.com/acme/shop.OrderWorkflow", day2),
+	}))
+	// A second batch goes to its own file.
+	require.NoError(t, a.Archive(ctx, []*history.Document{
+		document("run-3", "github.com/acme/shop.OrderWorkflow", day1),
+		document("run-4", "refund", day1),
+	}))
+
+	batches := readBatches(t, filepath.Join(dir, "2024-03-01", "github.com%2Facme%2Fshop.OrderWorkflow"))
+	require.Len(t, batches, 2)
+	require.Len(t, batches[0], 1)
+	assert.Contains(t, batches[0][0], `"id":"run-1"`)
+	assert.Contains(t, batches[1][0], `"id":"run-3"`)
+	assert.Len(t, readBatches(t, filepath.Join(dir, "2024-03-02", "github.com%2Facme%2Fshop.OrderWorkflow")), 1)
+	assert.Len(t, readBatches(t, filepath.Join(dir, "2024-03-01", "refund")), 1)
+
+	for _, id := range []string{"run-1", "run-2", "run-3", "run-4"} {
+		doc, err := a.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, id, doc.Run.ID)
+	}
+	doc, err := a.Get(ctx, "run-2")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"payloads":[]}`, string(doc.Run.Input))
+
+	// Archiving a run again, as after it changed before its deletion, makes Get return the new copy.
+	changed := document("run-1", "github.com/acme/shop.OrderWorkflow", day1)
+	changed.Run.Input = json.RawMessage(`{"payloads":["changed"]}`)
+	require.NoError(t, a.Archive(ctx, []*history.Document{changed}))
+	doc, err = a.Get(ctx, "run-1")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"payloads":["changed"]}`, string(doc.Run.Input))
+
+	_, err = a.Get(ctx, "run")
+	require.ErrorIs(t, err, archive.ErrNotFound)
+}
+
+func TestFileArchiver_DamagedFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	a, err := archive.NewFileArchiver(dir)
+	require.NoError(t, err)
+
+	closedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, a.Archive(ctx, []*history.Document{document("run-1", "refund", closedAt)}))
+	docs := []*history.Document{document("run-2", "refund", closedAt)}
+	for i := range 100 {
+		docs = append(docs, document(fmt.Sprintf("run-%d", i+3), "refund", closedAt))
+	}
+	require.NoError(t, a.Archive(ctx, docs))
+
+	// Truncate the newest batch and leave an empty file behind, as after a crash.
+	files, err := filepath.Glob(filepath.Join(dir, "2024-03-01", "refund", "*.jsonl.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	info, err := os.Stat(files[1])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(files[1], info.Size()/2))
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(files[1]), "99999999.jsonl.gz"), nil, 0o600))
+
+	for _, id := range []string{"run-1", "run-2"} {
+		doc, err := a.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, id, doc.Run.ID)
+	}
+	_, err = a.Get(ctx, "run-102")
+	require.ErrorIs(t, err, archive.ErrNotFound)
+
+	// Archiving still works next to the damaged file.
+	require.NoError(t, a.Archive(ctx, []*history.Document{document("run-102", "refund", closedAt)}))
+	doc, err := a.Get(ctx, "run-102")
+	require.NoError(t, err)
+	assert.Equal(t, "run-102", doc.Run.ID)
+}
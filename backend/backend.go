@@ -0,0 +1,218 @@
+// Package backend defines the storage interface the workflow engine runs on.
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/internal/entities"
+)
+
+type (
+	Workflow       = entities.DBWorkflow
+	WorkflowRun    = entities.DBWorkflowRun
+	ActivityRun    = entities.DBActivityRun
+	WorkflowStatus = entities.WorkflowStatus
+	ActivityStatus = entities.ActivityStatus
+
+	WorkflowEvent     = entities.DBWorkflowEvent
+	WorkflowEventType = entities.WorkflowEventType
+
+	Worker = entities.DBWorker
+)
+
+const (
+	WorkflowStatusFailed    = entities.WorkflowStatusFailed
+	WorkflowStatusExecuting = entities.WorkflowStatusExecuting
+	WorkflowStatusPending   = entities.WorkflowStatusPending
+	WorkflowStatusFinished  = entities.WorkflowStatusFinished
+	WorkflowStatusAborted   = entities.WorkflowStatusAborted
+
+	ActivityStatusExecuting = entities.ActivityStatusExecuting
+	ActivityStatusFailed    = entities.ActivityStatusFailed
+	ActivityStatusPending   = entities.ActivityStatusPending
+	ActivityStatusFinished  = entities.ActivityStatusFinished
+
+	WorkflowEventRunCreated               = entities.WorkflowEventRunCreated
+	WorkflowEventRunStatusChanged         = entities.WorkflowEventRunStatusChanged
+	WorkflowEventActivityRunCreated       = entities.WorkflowEventActivityRunCreated
+	WorkflowEventActivityRunStatusChanged = entities.WorkflowEventActivityRunStatusChanged
+	WorkflowEventRunAttributesUpdated     = entities.WorkflowEventRunAttributesUpdated
+)
+
+// ErrLeaseLost is returned when renewing the lease of a run that is no longer executing under
+// the lease of the caller, typically because the lease expired and the run was reset.
+var ErrLeaseLost = errors.New("lease lost")
+
+// ErrAttemptsExhausted is the error message of the runs the lease reaper fails because their
+// lease expired on their last attempt.
+var ErrAttemptsExhausted = errors.New("lease expired on the last attempt")
+
+// ErrWorkerNotFound is returned when recording the heartbeat of a worker that is not
+// registered, e.g. because it was deleted after missing its heartbeats. The worker should
+// register again.
+var ErrWorkerNotFound = errors.New("worker not found")
+
+// WorkflowRepository reads and writes workflows and workflow runs. Getters return nil
+// without an error when nothing matches.
+type WorkflowRepository interface {
+	// GetNextWorkflowRun returns the pending run scheduled last on one of taskQueues, or on any
+	// task queue when none is given.
+	GetNextWorkflowRun(ctx context.Context, taskQueues ...string) (*WorkflowRun, error)
+	// ClaimWorkflowRun changes the run GetNextWorkflowRun would return to executing, leased to
+	// owner for leaseDuration, and returns it. Concurrent claims never return the same run.
+	ClaimWorkflowRun(
+		ctx context.Context,
+		owner string,
+		leaseDuration time.Duration,
+		taskQueues ...string,
+	) (*WorkflowRun, error)
+	// RenewWorkflowRunLease extends the lease of an executing run to leaseDuration from now. It
+	// returns ErrLeaseLost unless the run is executing under a lease of owner.
+	RenewWorkflowRunLease(ctx context.Context, workflowRunID, owner string, leaseDuration time.Duration) error
+	// ReleaseWorkflowRunLease returns an executing run leased to owner to pending, keeping its
+	// attempt, and notifies the listeners of its task queue. Workers release the runs they stop
+	// executing when they shut down. It returns ErrLeaseLost unless owner holds the lease.
+	ReleaseWorkflowRunLease(ctx context.Context, workflowRunID, owner string) error
+	// CloseWorkflowRun moves an executing run leased to owner to status, a closed status, with
+	// the encoded output of a finished run or the error message of a failed one, and ends its
+	// lease. It returns ErrLeaseLost unless owner holds the lease.
+	CloseWorkflowRun(
+		ctx context.Context,
+		workflowRunID, owner string,
+		status WorkflowStatus,
+		output *json.RawMessage,
+		errorMessage *string,
+	) error
+	// ResetExpiredWorkflowRuns returns executing runs whose lease expired to pending, increments
+	// their attempt and notifies the listeners of their task queues. When maxAttempts is
+	// positive, runs whose lease expired on attempt maxAttempts or later are closed as failed
+	// with the message of ErrAttemptsExhausted instead. It returns the number of runs reset or
+	// failed.
+	ResetExpiredWorkflowRuns(ctx context.Context, maxAttempts int) (int64, error)
+	GetWorkflow(ctx context.Context, name string) (*Workflow, error)
+	// ListWorkflows returns every workflow that has been started, ordered by name.
+	ListWorkflows(ctx context.Context) ([]Workflow, error)
+	GetWorkflowRun(ctx context.Context, workflowRunID string) (*WorkflowRun, error)
+	// ListWorkflowRuns returns up to limit runs matching query in listing order, starting after
+	// the cursor when it is set.
+	ListWorkflowRuns(
+		ctx context.Context,
+		query WorkflowRunQuery,
+		after *WorkflowRunCursor,
+		limit int,
+	) ([]WorkflowRun, error)
+	CountWorkflowRuns(ctx context.Context, query WorkflowRunQuery) (int64, error)
+	// UpdateWorkflowRunAttributes merges normalized search attributes and a JSON object of memo
+	// payloads into those of a run. Either may be empty.
+	UpdateWorkflowRunAttributes(
+		ctx context.Context,
+		workflowRunID string,
+		searchAttributes map[string]any,
+		memo json.RawMessage,
+	) error
+	UpsertWorkflow(ctx context.Context, workflow *Workflow) error
+	// CreateWorkflowRun stores workflowRun, on DefaultTaskQueue when its TaskQueue is empty and
+	// with attempt 1 when its Attempt is 0, and notifies the listeners of its task queue once the
+	// transaction commits.
+	CreateWorkflowRun(ctx context.Context, workflowRun *WorkflowRun) error
+	ChangeWorkflowRunStatus(ctx context.Context, workflowRunID string, status WorkflowStatus) error
+	// DeleteWorkflowRuns deletes the runs selected by deletion together with their activity runs
+	// and events, and unlinks their child runs, which are kept. The conditions of deletion are
+	// checked on the locked rows, so a run changing concurrently is either deleted after the
+	// change commits and still matches, or kept. It returns the IDs of the runs deleted; unknown
+	// IDs are skipped.
+	DeleteWorkflowRuns(ctx context.Context, deletion WorkflowRunDeletion) ([]string, error)
+}
+
+// ActivityRunRepository reads and writes activity runs. Getters return nil without an
+// error when nothing matches.
+type ActivityRunRepository interface {
+	// GetNextActivityRun returns the pending run scheduled last on one of taskQueues, or on any
+	// task queue when none is given.
+	GetNextActivityRun(ctx context.Context, taskQueues ...string) (*ActivityRun, error)
+	// ClaimActivityRun, RenewActivityRunLease, ReleaseActivityRunLease and
+	// ResetExpiredActivityRuns lease activity runs like their WorkflowRepository counterparts.
+	ClaimActivityRun(
+		ctx context.Context,
+		owner string,
+		leaseDuration time.Duration,
+		taskQueues ...string,
+	) (*ActivityRun, error)
+	RenewActivityRunLease(ctx context.Context, activityRunID, owner string, leaseDuration time.Duration) error
+	ReleaseActivityRunLease(ctx context.Context, activityRunID, owner string) error
+	// CloseActivityRun moves an executing run leased to owner to status, finished or failed, with
+	// its encoded output or error message, and ends its lease like CloseWorkflowRun.
+	CloseActivityRun(
+		ctx context.Context,
+		activityRunID, owner string,
+		status ActivityStatus,
+		output *json.RawMessage,
+		errorMessage *string,
+	) error
+	ResetExpiredActivityRuns(ctx context.Context, maxAttempts int) (int64, error)
+	GetActivityRunHistory(ctx context.Context, workflowRunId string) ([]ActivityRun, error)
+	// CreateActivityRun stores activityRun, on the task queue of its workflow run when its
+	// TaskQueue is empty and with attempt 1 when its Attempt is 0, and notifies the listeners of
+	// its task queue once the transaction commits.
+	CreateActivityRun(ctx context.Context, activityRun *ActivityRun) error
+	ChangeActivityRunStatus(ctx context.Context, activityRunID string, status ActivityStatus) error
+	GetActivityRun(ctx context.Context, activityRunID string) (*ActivityRun, error)
+}
+
+// WorkflowEventRepository reads and appends to the event history of workflow runs. Events are
+// never updated or deleted while their run exists. The workflow and activity run repositories
+// append an event for every run they create and every status they change, with a payload of
+// type WorkflowEventPayload. Events record the output and error of closed activity runs, so the
+// history of a run can be rebuilt from its events alone.
+type WorkflowEventRepository interface {
+	// AppendWorkflowEvent stores event with the next sequence number of its run, starting at 1,
+	// and sets event.Sequence.
+	AppendWorkflowEvent(ctx context.Context, event *WorkflowEvent) error
+	// GetWorkflowEvents returns the events of a run with a sequence number above afterSequence,
+	// in sequence order.
+	GetWorkflowEvents(ctx context.Context, workflowRunID string, afterSequence int64) ([]WorkflowEvent, error)
+	// ReplaceWorkflowEvents replaces the events of a run with events as they are, keeping their
+	// sequence numbers, which must be 1, 2, 3, ..., so that appending continues after them. It is
+	// meant for importing the history of a run.
+	ReplaceWorkflowEvents(ctx context.Context, workflowRunID string, events []WorkflowEvent) error
+}
+
+// WorkerRepository reads and writes the registry of worker processes. Liveness is judged by the
+// clock of the storage: a worker is alive when its last heartbeat is at most aliveWithin old.
+type WorkerRepository interface {
+	// RegisterWorker stores worker, replacing a registered worker with the same ID.
+	RegisterWorker(ctx context.Context, worker *Worker) error
+	// RecordWorkerHeartbeat sets the last heartbeat of a worker to now. It returns an error
+	// matching ErrWorkerNotFound for workers that are not registered.
+	RecordWorkerHeartbeat(ctx context.Context, workerID string) error
+	// DeregisterWorker removes a worker; unknown IDs are ignored.
+	DeregisterWorker(ctx context.Context, workerID string) error
+	// ListWorkers returns the registered workers ordered by ID.
+	ListWorkers(ctx context.Context) ([]Worker, error)
+	// DeleteInactiveWorkers removes the workers that are not alive and returns how many it removed.
+	DeleteInactiveWorkers(ctx context.Context, aliveWithin time.Duration) (int64, error)
+	// ListTaskQueues returns the task queues that have pending runs or registered workers,
+	// ordered by name, with the number of alive workers polling them.
+	ListTaskQueues(ctx context.Context, aliveWithin time.Duration) ([]TaskQueueInfo, error)
+}
+
+// Tx gives access to the repositories within a single transaction.
+type Tx interface {
+	WorkflowRepository() WorkflowRepository
+	ActivityRunRepository() ActivityRunRepository
+	WorkflowEventRepository() WorkflowEventRepository
+	WorkerRepository() WorkerRepository
+}
+
+// Backend stores workflow state.
+type Backend interface {
+	// Init prepares the storage for use, e.g. by applying schema migrations.
+	Init(ctx context.Context) error
+	// Verify checks that the storage is ready for use without modifying it.
+	Verify(ctx context.Context) error
+	// RunInTx runs fn in a transaction that is committed if fn returns nil and rolled back otherwise.
+	RunInTx(ctx context.Context, fn func(tx Tx) error) error
+}
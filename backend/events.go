@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WorkflowEventPayload is the payload of the events the workflow and activity run repositories
+// append for their changes. Implementations create these events with the constructors below.
+type WorkflowEventPayload struct {
+	WorkflowName string           `json:"workflow_name,omitempty"`
+	ActivityName string           `json:"activity_name,omitempty"`
+	Status       string           `json:"status"`
+	Input        json.RawMessage  `json:"input,omitempty"`
+	Output       *json.RawMessage `json:"output,omitempty"`
+	ErrorMessage *string          `json:"error_message,omitempty"`
+	// SearchAttributes and Memo hold the values set by an attribute update.
+	SearchAttributes map[string]any  `json:"search_attributes,omitempty"`
+	Memo             json.RawMessage `json:"memo,omitempty"`
+}
+
+func newWorkflowEvent(
+	workflowRunID string,
+	eventType WorkflowEventType,
+	activityRunID *string,
+	payload WorkflowEventPayload,
+) *WorkflowEvent {
+	// The payload only holds strings, JSON documents and normalized search attributes, which always marshal.
+	data, _ := json.Marshal(payload)
+	return &WorkflowEvent{
+		WorkflowRunID: workflowRunID,
+		EventType:     eventType,
+		ActivityRunID: activityRunID,
+		Payload:       data,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// WorkflowRunCreatedEvent records the creation of run, including its input.
+func WorkflowRunCreatedEvent(run *WorkflowRun) *WorkflowEvent {
+	return newWorkflowEvent(run.ID, WorkflowEventRunCreated, nil, WorkflowEventPayload{
+		WorkflowName: run.WorkflowName,
+		Status:       string(run.Status),
+		Input:        run.Input,
+	})
+}
+
+// WorkflowRunStatusChangedEvent records a status change of a workflow run.
+func WorkflowRunStatusChangedEvent(workflowRunID string, status WorkflowStatus) *WorkflowEvent {
+	return newWorkflowEvent(workflowRunID, WorkflowEventRunStatusChanged, nil, WorkflowEventPayload{
+		Status: string(status),
+	})
+}
+
+// WorkflowRunClosedEvent records the status change of a workflow run that closed with the
+// encoded output of a finished run or the error message of a failed one.
+func WorkflowRunClosedEvent(
+	workflowRunID string,
+	status WorkflowStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) *WorkflowEvent {
+	return newWorkflowEvent(workflowRunID, WorkflowEventRunStatusChanged, nil, WorkflowEventPayload{
+		Status:       string(status),
+		Output:       output,
+		ErrorMessage: errorMessage,
+	})
+}
+
+// WorkflowRunAttributesUpdatedEvent records an update of the search attributes and memo of a run.
+func WorkflowRunAttributesUpdatedEvent(
+	workflowRunID string,
+	searchAttributes map[string]any,
+	memo json.RawMessage,
+) *WorkflowEvent {
+	return newWorkflowEvent(workflowRunID, WorkflowEventRunAttributesUpdated, nil, WorkflowEventPayload{
+		SearchAttributes: searchAttributes,
+		Memo:             memo,
+	})
+}
+
+// ActivityRunCreatedEvent records the scheduling of an activity with its input, and its result
+// when it is created already completed.
+func ActivityRunCreatedEvent(run *ActivityRun) *WorkflowEvent {
+	id := run.ID
+	return newWorkflowEvent(run.WorkflowRunID, WorkflowEventActivityRunCreated, &id, WorkflowEventPayload{
+		ActivityName: run.ActivityName,
+		Status:       string(run.Status),
+		Input:        run.Input,
+		Output:       run.Output,
+		ErrorMessage: run.ErrorMessage,
+	})
+}
+
+// ActivityRunStatusChangedEvent records a status change of an activity run.
+func ActivityRunStatusChangedEvent(workflowRunID, activityRunID string, status ActivityStatus) *WorkflowEvent {
+	return newWorkflowEvent(workflowRunID, WorkflowEventActivityRunStatusChanged, &activityRunID, WorkflowEventPayload{
+		Status: string(status),
+	})
+}
+
+// ActivityRunClosedEvent records the status change of an activity run that finished with an
+// encoded output or failed with an error message.
+func ActivityRunClosedEvent(
+	workflowRunID, activityRunID string,
+	status ActivityStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) *WorkflowEvent {
+	return newWorkflowEvent(workflowRunID, WorkflowEventActivityRunStatusChanged, &activityRunID, WorkflowEventPayload{
+		Status:       string(status),
+		Output:       output,
+		ErrorMessage: errorMessage,
+	})
+}
@@ -0,0 +1,877 @@
+// Package memory implements backend.Backend in process memory, for unit tests and local
+// prototyping. State is lost when the process exits.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+// Backend serializes transactions: each one holds an exclusive lock and works on a copy of
+// the state that replaces the committed state only when it succeeds.
+type Backend struct {
+	mu    sync.Mutex
+	state *state
+	tasks *backend.TaskBroadcaster
+}
+
+var (
+	_ backend.Backend      = (*Backend)(nil)
+	_ backend.TaskNotifier = (*Backend)(nil)
+)
+
+type state struct {
+	workflows    map[string]backend.Workflow
+	workflowRuns map[string]backend.WorkflowRun
+	activityRuns map[string]backend.ActivityRun
+	// events holds the event history of each workflow run; a transaction only ever appends to a
+	// clipped copy of a run's slice, so the committed slice is never written to.
+	events  map[string][]backend.WorkflowEvent
+	workers map[string]backend.Worker
+	// pendingOn lists the task queues of the runs the transaction working on this state created
+	// or returned to pending; it is not carried over to the next transaction.
+	pendingOn []string
+}
+
+func New() *Backend {
+	return &Backend{
+		state: &state{
+			workflows:    map[string]backend.Workflow{},
+			workflowRuns: map[string]backend.WorkflowRun{},
+			activityRuns: map[string]backend.ActivityRun{},
+			events:       map[string][]backend.WorkflowEvent{},
+			workers:      map[string]backend.Worker{},
+		},
+		tasks: backend.NewTaskBroadcaster(),
+	}
+}
+
+func (b *Backend) Init(_ context.Context) error {
+	return nil
+}
+
+func (b *Backend) Verify(_ context.Context) error {
+	return nil
+}
+
+func (b *Backend) RunInTx(ctx context.Context, fn func(tx backend.Tx) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	working := b.state.clone()
+	if err := fn(&memoryTx{state: working}); err != nil {
+		return err
+	}
+	b.tasks.Notify(working.pendingOn...)
+	working.pendingOn = nil
+	b.state = working
+	return nil
+}
+
+func (b *Backend) ListenForTasks(_ context.Context, taskQueues []string) (backend.TaskListener, error) {
+	return b.tasks.Listen(taskQueues), nil
+}
+
+// clone copies the maps; values are only replaced, never mutated in place, so sharing them is safe.
+func (s *state) clone() *state {
+	return &state{
+		workflows:    maps.Clone(s.workflows),
+		workflowRuns: maps.Clone(s.workflowRuns),
+		activityRuns: maps.Clone(s.activityRuns),
+		events:       maps.Clone(s.events),
+		workers:      maps.Clone(s.workers),
+	}
+}
+
+type memoryTx struct {
+	state *state
+}
+
+func (t *memoryTx) WorkflowRepository() backend.WorkflowRepository {
+	return &workflowRepository{state: t.state}
+}
+
+func (t *memoryTx) ActivityRunRepository() backend.ActivityRunRepository {
+	return &activityRunRepository{state: t.state}
+}
+
+func (t *memoryTx) WorkflowEventRepository() backend.WorkflowEventRepository {
+	return &workflowEventRepository{state: t.state}
+}
+
+func (t *memoryTx) WorkerRepository() backend.WorkerRepository {
+	return &workerRepository{state: t.state}
+}
+
+type workflowRepository struct {
+	state *state
+}
+
+func (r *workflowRepository) GetNextWorkflowRun(
+	_ context.Context,
+	taskQueues ...string,
+) (*backend.WorkflowRun, error) {
+	var next *backend.WorkflowRun
+	for _, run := range r.state.workflowRuns {
+		if run.Status != backend.WorkflowStatusPending || !onTaskQueue(run.TaskQueue, taskQueues) {
+			continue
+		}
+		if next == nil || run.ScheduledAt.After(next.ScheduledAt) {
+			next = &run
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	return cloneWorkflowRun(*next), nil
+}
+
+func (r *workflowRepository) ClaimWorkflowRun(
+	ctx context.Context,
+	owner string,
+	leaseDuration time.Duration,
+	taskQueues ...string,
+) (*backend.WorkflowRun, error) {
+	run, err := r.GetNextWorkflowRun(ctx, taskQueues...)
+	if err != nil || run == nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(leaseDuration)
+	run.Status = backend.WorkflowStatusExecuting
+	run.UpdatedAt = now
+	run.LeaseOwner = &owner
+	run.LeaseExpiresAt = &expiresAt
+	r.state.workflowRuns[run.ID] = *cloneWorkflowRun(*run)
+	if err := r.state.appendEvent(backend.WorkflowRunStatusChangedEvent(run.ID, run.Status)); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *workflowRepository) RenewWorkflowRunLease(
+	_ context.Context,
+	workflowRunID, owner string,
+	leaseDuration time.Duration,
+) error {
+	run, ok := r.state.workflowRuns[workflowRunID]
+	if !ok || run.Status != backend.WorkflowStatusExecuting || !leasedTo(run.LeaseOwner, owner) {
+		return fmt.Errorf("%w: workflow run %s", backend.ErrLeaseLost, workflowRunID)
+	}
+	expiresAt := time.Now().Add(leaseDuration)
+	run.LeaseExpiresAt = &expiresAt
+	r.state.workflowRuns[workflowRunID] = run
+	return nil
+}
+
+func (r *workflowRepository) ReleaseWorkflowRunLease(_ context.Context, workflowRunID, owner string) error {
+	run, ok := r.state.workflowRuns[workflowRunID]
+	if !ok || run.Status != backend.WorkflowStatusExecuting || !leasedTo(run.LeaseOwner, owner) {
+		return fmt.Errorf("%w: workflow run %s", backend.ErrLeaseLost, workflowRunID)
+	}
+	run.Status = backend.WorkflowStatusPending
+	run.UpdatedAt = time.Now()
+	run.LeaseOwner = nil
+	run.LeaseExpiresAt = nil
+	r.state.workflowRuns[workflowRunID] = run
+	r.state.pendingOn = append(r.state.pendingOn, run.TaskQueue)
+	return r.state.appendEvent(backend.WorkflowRunStatusChangedEvent(workflowRunID, run.Status))
+}
+
+func (r *workflowRepository) CloseWorkflowRun(
+	_ context.Context,
+	workflowRunID, owner string,
+	status backend.WorkflowStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) error {
+	run, ok := r.state.workflowRuns[workflowRunID]
+	if !ok || run.Status != backend.WorkflowStatusExecuting || !leasedTo(run.LeaseOwner, owner) {
+		return fmt.Errorf("%w: workflow run %s", backend.ErrLeaseLost, workflowRunID)
+	}
+	run.Status = status
+	run.UpdatedAt = time.Now()
+	closedAt := run.UpdatedAt
+	run.ClosedAt = &closedAt
+	run.Output = output
+	run.ErrorMessage = errorMessage
+	run.LeaseOwner = nil
+	run.LeaseExpiresAt = nil
+	r.state.workflowRuns[workflowRunID] = *cloneWorkflowRun(run)
+	return r.state.appendEvent(backend.WorkflowRunClosedEvent(workflowRunID, status, output, errorMessage))
+}
+
+func (r *workflowRepository) ResetExpiredWorkflowRuns(_ context.Context, maxAttempts int) (int64, error) {
+	now := time.Now()
+	var reset int64
+	for _, id := range slices.Sorted(maps.Keys(r.state.workflowRuns)) {
+		run := r.state.workflowRuns[id]
+		if run.Status != backend.WorkflowStatusExecuting || !leaseExpired(run.LeaseExpiresAt, now) {
+			continue
+		}
+		run.UpdatedAt = now
+		run.LeaseOwner = nil
+		run.LeaseExpiresAt = nil
+		event := backend.WorkflowRunStatusChangedEvent(id, backend.WorkflowStatusPending)
+		if attemptsExhausted(run.Attempt, maxAttempts) {
+			message := backend.ErrAttemptsExhausted.Error()
+			closedAt := now
+			run.Status = backend.WorkflowStatusFailed
+			run.ClosedAt = &closedAt
+			run.ErrorMessage = &message
+			event = backend.WorkflowRunClosedEvent(id, run.Status, nil, &message)
+		} else {
+			run.Status = backend.WorkflowStatusPending
+			run.Attempt++
+			r.state.pendingOn = append(r.state.pendingOn, run.TaskQueue)
+		}
+		r.state.workflowRuns[id] = run
+		if err := r.state.appendEvent(event); err != nil {
+			return reset, err
+		}
+		reset++
+	}
+	return reset, nil
+}
+
+// attemptsExhausted reports whether a run whose lease expired on attempt is failed rather than
+// retried.
+func attemptsExhausted(attempt, maxAttempts int) bool {
+	return maxAttempts > 0 && attempt >= maxAttempts
+}
+
+func leasedTo(leaseOwner *string, owner string) bool {
+	return leaseOwner != nil && *leaseOwner == owner
+}
+
+func leaseExpired(leaseExpiresAt *time.Time, now time.Time) bool {
+	return leaseExpiresAt != nil && leaseExpiresAt.Before(now)
+}
+
+func (r *workflowRepository) GetWorkflow(_ context.Context, name string) (*backend.Workflow, error) {
+	workflow, ok := r.state.workflows[name]
+	if !ok {
+		return nil, nil
+	}
+	return &workflow, nil
+}
+
+func (r *workflowRepository) ListWorkflows(_ context.Context) ([]backend.Workflow, error) {
+	workflows := slices.Collect(maps.Values(r.state.workflows))
+	sort.Slice(workflows, func(i, j int) bool {
+		return workflows[i].Name < workflows[j].Name
+	})
+	return workflows, nil
+}
+
+func (r *workflowRepository) GetWorkflowRun(_ context.Context, workflowRunID string) (*backend.WorkflowRun, error) {
+	run, ok := r.state.workflowRuns[workflowRunID]
+	if !ok {
+		return nil, nil
+	}
+	return cloneWorkflowRun(run), nil
+}
+
+func (r *workflowRepository) UpsertWorkflow(_ context.Context, workflow *backend.Workflow) error {
+	if existing, ok := r.state.workflows[workflow.Name]; ok {
+		existing.UpdatedAt = workflow.UpdatedAt
+		r.state.workflows[workflow.Name] = existing
+		return nil
+	}
+	r.state.workflows[workflow.Name] = *workflow
+	return nil
+}
+
+func (r *workflowRepository) CreateWorkflowRun(_ context.Context, workflowRun *backend.WorkflowRun) error {
+	if _, ok := r.state.workflows[workflowRun.WorkflowName]; !ok {
+		return fmt.Errorf("workflow %s does not exist", workflowRun.WorkflowName)
+	}
+	if _, ok := r.state.workflowRuns[workflowRun.ID]; ok {
+		return fmt.Errorf("workflow run %s already exists", workflowRun.ID)
+	}
+	if parentID := workflowRun.ParentWorkflowRunID; parentID != nil {
+		if _, ok := r.state.workflowRuns[*parentID]; !ok {
+			return fmt.Errorf("parent workflow run %s does not exist", *parentID)
+		}
+	}
+	if workflowRun.TaskQueue == "" {
+		workflowRun.TaskQueue = backend.DefaultTaskQueue
+	}
+	if workflowRun.Attempt == 0 {
+		workflowRun.Attempt = 1
+	}
+	r.state.workflowRuns[workflowRun.ID] = *cloneWorkflowRun(*workflowRun)
+	r.state.pendingOn = append(r.state.pendingOn, workflowRun.TaskQueue)
+	return r.state.appendEvent(backend.WorkflowRunCreatedEvent(workflowRun))
+}
+
+// onTaskQueue reports whether a run on taskQueue is on one of taskQueues, where none means any.
+func onTaskQueue(taskQueue string, taskQueues []string) bool {
+	return len(taskQueues) == 0 || slices.Contains(taskQueues, taskQueue)
+}
+
+func (r *workflowRepository) ChangeWorkflowRunStatus(
+	_ context.Context,
+	workflowRunID string,
+	status backend.WorkflowStatus,
+) error {
+	run, ok := r.state.workflowRuns[workflowRunID]
+	if !ok {
+		return nil
+	}
+	run.Status = status
+	run.UpdatedAt = time.Now()
+	switch {
+	case !status.IsClosed():
+		run.ClosedAt = nil
+	case run.ClosedAt == nil:
+		closedAt := run.UpdatedAt
+		run.ClosedAt = &closedAt
+	}
+	r.state.workflowRuns[workflowRunID] = run
+	return r.state.appendEvent(backend.WorkflowRunStatusChangedEvent(workflowRunID, status))
+}
+
+func (r *workflowRepository) DeleteWorkflowRuns(
+	_ context.Context,
+	deletion backend.WorkflowRunDeletion,
+) ([]string, error) {
+	deleted := map[string]bool{}
+	var ids []string
+	for _, id := range deletion.WorkflowRunIDs {
+		run, ok := r.state.workflowRuns[id]
+		if ok && !deleted[id] && deletion.Selects(id, run.ClosedAt, int64(len(r.state.events[id]))) {
+			deleted[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for id, activityRun := range r.state.activityRuns {
+		if deleted[activityRun.WorkflowRunID] {
+			delete(r.state.activityRuns, id)
+		}
+	}
+	for id, run := range r.state.workflowRuns {
+		if run.ParentWorkflowRunID != nil && deleted[*run.ParentWorkflowRunID] {
+			run.ParentWorkflowRunID = nil
+			r.state.workflowRuns[id] = run
+		}
+	}
+	for id := range deleted {
+		delete(r.state.workflowRuns, id)
+		delete(r.state.events, id)
+	}
+	return ids, nil
+}
+
+func (r *workflowRepository) ListWorkflowRuns(
+	_ context.Context,
+	query backend.WorkflowRunQuery,
+	after *backend.WorkflowRunCursor,
+	limit int,
+) ([]backend.WorkflowRun, error) {
+	runs, err := r.matching(query)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return backend.WorkflowRunCursor{CreatedAt: runs[i].CreatedAt, ID: runs[i].ID}.IsAfter(&runs[j])
+	})
+	if after != nil {
+		start := sort.Search(len(runs), func(i int) bool {
+			return after.IsAfter(&runs[i])
+		})
+		runs = runs[start:]
+	}
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (r *workflowRepository) CountWorkflowRuns(_ context.Context, query backend.WorkflowRunQuery) (int64, error) {
+	runs, err := r.matching(query)
+	return int64(len(runs)), err
+}
+
+func (r *workflowRepository) matching(query backend.WorkflowRunQuery) ([]backend.WorkflowRun, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	var runs []backend.WorkflowRun
+	for _, run := range r.state.workflowRuns {
+		if query.Matches(&run) {
+			runs = append(runs, *cloneWorkflowRun(run))
+		}
+	}
+	return runs, nil
+}
+
+func (r *workflowRepository) UpdateWorkflowRunAttributes(
+	_ context.Context,
+	workflowRunID string,
+	searchAttributes map[string]any,
+	memo json.RawMessage,
+) error {
+	run, ok := r.state.workflowRuns[workflowRunID]
+	if !ok {
+		return fmt.Errorf("workflow run %s does not exist", workflowRunID)
+	}
+	if len(searchAttributes) > 0 {
+		run.SearchAttributes = maps.Clone(run.SearchAttributes)
+		if run.SearchAttributes == nil {
+			run.SearchAttributes = make(map[string]any, len(searchAttributes))
+		}
+		maps.Copy(run.SearchAttributes, searchAttributes)
+	}
+	if len(memo) > 0 {
+		merged, err := mergeMemo(run.Memo, memo)
+		if err != nil {
+			return err
+		}
+		run.Memo = merged
+	}
+	run.UpdatedAt = time.Now()
+	r.state.workflowRuns[workflowRunID] = run
+	return r.state.appendEvent(backend.WorkflowRunAttributesUpdatedEvent(workflowRunID, searchAttributes, memo))
+}
+
+// mergeMemo adds the top-level fields of update to the JSON object memo, like the jsonb ||
+// operator does for the Postgres backend.
+func mergeMemo(memo, update json.RawMessage) (json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if len(memo) > 0 {
+		if err := json.Unmarshal(memo, &fields); err != nil {
+			return nil, fmt.Errorf("failed to decode memo: %w", err)
+		}
+	}
+	var updates map[string]json.RawMessage
+	if err := json.Unmarshal(update, &updates); err != nil {
+		return nil, fmt.Errorf("failed to decode memo update: %w", err)
+	}
+	maps.Copy(fields, updates)
+	return json.Marshal(fields)
+}
+
+type activityRunRepository struct {
+	state *state
+}
+
+func (r *activityRunRepository) GetNextActivityRun(
+	_ context.Context,
+	taskQueues ...string,
+) (*backend.ActivityRun, error) {
+	var next *backend.ActivityRun
+	for _, run := range r.state.activityRuns {
+		if run.Status != backend.ActivityStatusPending || !onTaskQueue(run.TaskQueue, taskQueues) {
+			continue
+		}
+		if next == nil || run.ScheduledAt.After(next.ScheduledAt) {
+			next = &run
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	return cloneActivityRun(*next), nil
+}
+
+func (r *activityRunRepository) ClaimActivityRun(
+	ctx context.Context,
+	owner string,
+	leaseDuration time.Duration,
+	taskQueues ...string,
+) (*backend.ActivityRun, error) {
+	run, err := r.GetNextActivityRun(ctx, taskQueues...)
+	if err != nil || run == nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(leaseDuration)
+	run.Status = backend.ActivityStatusExecuting
+	run.UpdatedAt = now
+	run.LeaseOwner = &owner
+	run.LeaseExpiresAt = &expiresAt
+	r.state.activityRuns[run.ID] = *cloneActivityRun(*run)
+	event := backend.ActivityRunStatusChangedEvent(run.WorkflowRunID, run.ID, run.Status)
+	if err := r.state.appendEvent(event); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *activityRunRepository) RenewActivityRunLease(
+	_ context.Context,
+	activityRunID, owner string,
+	leaseDuration time.Duration,
+) error {
+	run, ok := r.state.activityRuns[activityRunID]
+	if !ok || run.Status != backend.ActivityStatusExecuting || !leasedTo(run.LeaseOwner, owner) {
+		return fmt.Errorf("%w: activity run %s", backend.ErrLeaseLost, activityRunID)
+	}
+	expiresAt := time.Now().Add(leaseDuration)
+	run.LeaseExpiresAt = &expiresAt
+	r.state.activityRuns[activityRunID] = run
+	return nil
+}
+
+func (r *activityRunRepository) ReleaseActivityRunLease(_ context.Context, activityRunID, owner string) error {
+	run, ok := r.state.activityRuns[activityRunID]
+	if !ok || run.Status != backend.ActivityStatusExecuting || !leasedTo(run.LeaseOwner, owner) {
+		return fmt.Errorf("%w: activity run %s", backend.ErrLeaseLost, activityRunID)
+	}
+	run.Status = backend.ActivityStatusPending
+	run.UpdatedAt = time.Now()
+	run.LeaseOwner = nil
+	run.LeaseExpiresAt = nil
+	r.state.activityRuns[activityRunID] = run
+	r.state.pendingOn = append(r.state.pendingOn, run.TaskQueue)
+	return r.state.appendEvent(backend.ActivityRunStatusChangedEvent(run.WorkflowRunID, activityRunID, run.Status))
+}
+
+func (r *activityRunRepository) CloseActivityRun(
+	_ context.Context,
+	activityRunID, owner string,
+	status backend.ActivityStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) error {
+	run, ok := r.state.activityRuns[activityRunID]
+	if !ok || run.Status != backend.ActivityStatusExecuting || !leasedTo(run.LeaseOwner, owner) {
+		return fmt.Errorf("%w: activity run %s", backend.ErrLeaseLost, activityRunID)
+	}
+	run.Status = status
+	run.UpdatedAt = time.Now()
+	run.Output = output
+	run.ErrorMessage = errorMessage
+	run.LeaseOwner = nil
+	run.LeaseExpiresAt = nil
+	r.state.activityRuns[activityRunID] = *cloneActivityRun(run)
+	event := backend.ActivityRunClosedEvent(run.WorkflowRunID, activityRunID, status, output, errorMessage)
+	return r.state.appendEvent(event)
+}
+
+func (r *activityRunRepository) ResetExpiredActivityRuns(_ context.Context, maxAttempts int) (int64, error) {
+	now := time.Now()
+	var reset int64
+	for _, id := range slices.Sorted(maps.Keys(r.state.activityRuns)) {
+		run := r.state.activityRuns[id]
+		if run.Status != backend.ActivityStatusExecuting || !leaseExpired(run.LeaseExpiresAt, now) {
+			continue
+		}
+		run.UpdatedAt = now
+		run.LeaseOwner = nil
+		run.LeaseExpiresAt = nil
+		event := backend.ActivityRunStatusChangedEvent(run.WorkflowRunID, id, backend.ActivityStatusPending)
+		if attemptsExhausted(run.Attempt, maxAttempts) {
+			message := backend.ErrAttemptsExhausted.Error()
+			run.Status = backend.ActivityStatusFailed
+			run.ErrorMessage = &message
+			event = backend.ActivityRunClosedEvent(run.WorkflowRunID, id, run.Status, nil, &message)
+		} else {
+			run.Status = backend.ActivityStatusPending
+			run.Attempt++
+			r.state.pendingOn = append(r.state.pendingOn, run.TaskQueue)
+		}
+		r.state.activityRuns[id] = run
+		if err := r.state.appendEvent(event); err != nil {
+			return reset, err
+		}
+		reset++
+	}
+	return reset, nil
+}
+
+func (r *activityRunRepository) GetActivityRunHistory(
+	_ context.Context,
+	workflowRunId string,
+) ([]backend.ActivityRun, error) {
+	var history []backend.ActivityRun
+	for _, run := range r.state.activityRuns {
+		if run.WorkflowRunID == workflowRunId {
+			history = append(history, *cloneActivityRun(run))
+		}
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].CreatedAt.Before(history[j].CreatedAt)
+	})
+	return history, nil
+}
+
+func (r *activityRunRepository) CreateActivityRun(_ context.Context, activityRun *backend.ActivityRun) error {
+	workflowRun, ok := r.state.workflowRuns[activityRun.WorkflowRunID]
+	if !ok {
+		return fmt.Errorf("workflow run %s does not exist", activityRun.WorkflowRunID)
+	}
+	if _, ok := r.state.activityRuns[activityRun.ID]; ok {
+		return fmt.Errorf("activity run %s already exists", activityRun.ID)
+	}
+	if activityRun.TaskQueue == "" {
+		activityRun.TaskQueue = workflowRun.TaskQueue
+	}
+	if activityRun.Attempt == 0 {
+		activityRun.Attempt = 1
+	}
+	if len(activityRun.TraceContext) == 0 {
+		activityRun.TraceContext = maps.Clone(workflowRun.TraceContext)
+	}
+	r.state.activityRuns[activityRun.ID] = *cloneActivityRun(*activityRun)
+	r.state.pendingOn = append(r.state.pendingOn, activityRun.TaskQueue)
+	return r.state.appendEvent(backend.ActivityRunCreatedEvent(activityRun))
+}
+
+func (r *activityRunRepository) ChangeActivityRunStatus(
+	_ context.Context,
+	activityRunID string,
+	status backend.ActivityStatus,
+) error {
+	run, ok := r.state.activityRuns[activityRunID]
+	if !ok {
+		return nil
+	}
+	run.Status = status
+	run.UpdatedAt = time.Now()
+	r.state.activityRuns[activityRunID] = run
+	return r.state.appendEvent(backend.ActivityRunStatusChangedEvent(run.WorkflowRunID, activityRunID, status))
+}
+
+func (r *activityRunRepository) GetActivityRun(_ context.Context, activityRunID string) (*backend.ActivityRun, error) {
+	run, ok := r.state.activityRuns[activityRunID]
+	if !ok {
+		return nil, nil
+	}
+	return cloneActivityRun(run), nil
+}
+
+type workflowEventRepository struct {
+	state *state
+}
+
+func (r *workflowEventRepository) AppendWorkflowEvent(_ context.Context, event *backend.WorkflowEvent) error {
+	return r.state.appendEvent(event)
+}
+
+func (s *state) appendEvent(event *backend.WorkflowEvent) error {
+	if _, ok := s.workflowRuns[event.WorkflowRunID]; !ok {
+		return fmt.Errorf("workflow run %s does not exist", event.WorkflowRunID)
+	}
+	events := slices.Clip(s.events[event.WorkflowRunID])
+	event.Sequence = int64(len(events)) + 1
+	s.events[event.WorkflowRunID] = append(events, *cloneWorkflowEvent(*event))
+	return nil
+}
+
+func (r *workflowEventRepository) GetWorkflowEvents(
+	_ context.Context,
+	workflowRunID string,
+	afterSequence int64,
+) ([]backend.WorkflowEvent, error) {
+	var events []backend.WorkflowEvent
+	for _, event := range r.state.events[workflowRunID] {
+		if event.Sequence > afterSequence {
+			events = append(events, *cloneWorkflowEvent(event))
+		}
+	}
+	return events, nil
+}
+
+func (r *workflowEventRepository) ReplaceWorkflowEvents(
+	_ context.Context,
+	workflowRunID string,
+	events []backend.WorkflowEvent,
+) error {
+	if _, ok := r.state.workflowRuns[workflowRunID]; !ok {
+		return fmt.Errorf("workflow run %s does not exist", workflowRunID)
+	}
+	replaced := make([]backend.WorkflowEvent, len(events))
+	for i, event := range events {
+		replaced[i] = *cloneWorkflowEvent(event)
+	}
+	r.state.events[workflowRunID] = replaced
+	return nil
+}
+
+type workerRepository struct {
+	state *state
+}
+
+func (r *workerRepository) RegisterWorker(_ context.Context, worker *backend.Worker) error {
+	r.state.workers[worker.ID] = cloneWorker(*worker)
+	return nil
+}
+
+func (r *workerRepository) RecordWorkerHeartbeat(_ context.Context, workerID string) error {
+	worker, ok := r.state.workers[workerID]
+	if !ok {
+		return fmt.Errorf("%w: %s", backend.ErrWorkerNotFound, workerID)
+	}
+	worker.LastHeartbeatAt = time.Now()
+	r.state.workers[workerID] = worker
+	return nil
+}
+
+func (r *workerRepository) DeregisterWorker(_ context.Context, workerID string) error {
+	delete(r.state.workers, workerID)
+	return nil
+}
+
+func (r *workerRepository) ListWorkers(_ context.Context) ([]backend.Worker, error) {
+	var workers []backend.Worker
+	for _, id := range slices.Sorted(maps.Keys(r.state.workers)) {
+		workers = append(workers, cloneWorker(r.state.workers[id]))
+	}
+	return workers, nil
+}
+
+func (r *workerRepository) DeleteInactiveWorkers(_ context.Context, aliveWithin time.Duration) (int64, error) {
+	aliveAfter := time.Now().Add(-aliveWithin)
+	var deleted int64
+	for id, worker := range r.state.workers {
+		if worker.LastHeartbeatAt.Before(aliveAfter) {
+			delete(r.state.workers, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *workerRepository) ListTaskQueues(
+	_ context.Context,
+	aliveWithin time.Duration,
+) ([]backend.TaskQueueInfo, error) {
+	queues := map[string]*backend.TaskQueueInfo{}
+	queue := func(name string) *backend.TaskQueueInfo {
+		if queues[name] == nil {
+			queues[name] = &backend.TaskQueueInfo{Name: name}
+		}
+		return queues[name]
+	}
+
+	aliveAfter := time.Now().Add(-aliveWithin)
+	for _, worker := range r.state.workers {
+		for _, taskQueue := range worker.TaskQueues {
+			info := queue(taskQueue)
+			if !worker.LastHeartbeatAt.Before(aliveAfter) {
+				info.Pollers++
+			}
+		}
+	}
+	for _, run := range r.state.workflowRuns {
+		if run.Status == backend.WorkflowStatusPending {
+			info := queue(run.TaskQueue)
+			info.PendingWorkflowRuns++
+			info.OldestPendingScheduledAt = earliest(info.OldestPendingScheduledAt, run.ScheduledAt)
+		}
+	}
+	for _, run := range r.state.activityRuns {
+		if run.Status == backend.ActivityStatusPending {
+			info := queue(run.TaskQueue)
+			info.PendingActivityRuns++
+			info.OldestPendingScheduledAt = earliest(info.OldestPendingScheduledAt, run.ScheduledAt)
+		}
+	}
+
+	infos := make([]backend.TaskQueueInfo, 0, len(queues))
+	for _, name := range slices.Sorted(maps.Keys(queues)) {
+		infos = append(infos, *queues[name])
+	}
+	return infos, nil
+}
+
+func earliest(t *time.Time, other time.Time) *time.Time {
+	if t != nil && !other.Before(*t) {
+		return t
+	}
+	return &other
+}
+
+func cloneWorker(worker backend.Worker) backend.Worker {
+	worker.TaskQueues = slices.Clone(worker.TaskQueues)
+	worker.Workflows = slices.Clone(worker.Workflows)
+	worker.Activities = slices.Clone(worker.Activities)
+	return worker
+}
+
+func cloneWorkflowEvent(event backend.WorkflowEvent) *backend.WorkflowEvent {
+	event.Payload = cloneRaw(event.Payload)
+	if event.ActivityRunID != nil {
+		id := *event.ActivityRunID
+		event.ActivityRunID = &id
+	}
+	return &event
+}
+
+func cloneWorkflowRun(run backend.WorkflowRun) *backend.WorkflowRun {
+	run.Input = cloneRaw(run.Input)
+	if run.ClosedAt != nil {
+		closedAt := *run.ClosedAt
+		run.ClosedAt = &closedAt
+	}
+	run.Labels = maps.Clone(run.Labels)
+	run.SearchAttributes = maps.Clone(run.SearchAttributes)
+	run.TraceContext = maps.Clone(run.TraceContext)
+	run.Memo = cloneRaw(run.Memo)
+	if run.ParentWorkflowRunID != nil {
+		parentID := *run.ParentWorkflowRunID
+		run.ParentWorkflowRunID = &parentID
+	}
+	if run.Output != nil {
+		output := cloneRaw(*run.Output)
+		run.Output = &output
+	}
+	if run.ErrorMessage != nil {
+		msg := *run.ErrorMessage
+		run.ErrorMessage = &msg
+	}
+	run.LeaseOwner, run.LeaseExpiresAt = cloneLease(run.LeaseOwner, run.LeaseExpiresAt)
+	return &run
+}
+
+func cloneLease(owner *string, expiresAt *time.Time) (*string, *time.Time) {
+	if owner != nil {
+		o := *owner
+		owner = &o
+	}
+	if expiresAt != nil {
+		t := *expiresAt
+		expiresAt = &t
+	}
+	return owner, expiresAt
+}
+
+func cloneActivityRun(run backend.ActivityRun) *backend.ActivityRun {
+	run.Input = cloneRaw(run.Input)
+	run.TraceContext = maps.Clone(run.TraceContext)
+	if run.ErrorMessage != nil {
+		msg := *run.ErrorMessage
+		run.ErrorMessage = &msg
+	}
+	if run.Output != nil {
+		output := cloneRaw(*run.Output)
+		run.Output = &output
+	}
+	if run.RetryStatus != nil {
+		retryStatus := cloneRaw(*run.RetryStatus)
+		run.RetryStatus = &retryStatus
+	}
+	run.LeaseOwner, run.LeaseExpiresAt = cloneLease(run.LeaseOwner, run.LeaseExpiresAt)
+	return &run
+}
+
+func cloneRaw(raw json.RawMessage) json.RawMessage {
+	if raw == nil {
+		return nil
+	}
+	return append(json.RawMessage(nil), raw...)
+}
@@ -0,0 +1,881 @@
+package memory_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/backend/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createWorkflowRun(ctx context.Context, t *testing.T, tx backend.Tx, id string, scheduledAt time.Time) {
+	t.Helper()
+	repo := tx.WorkflowRepository()
+	require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{
+		Name:      "test-workflow",
+		CreatedAt: scheduledAt,
+		UpdatedAt: scheduledAt,
+	}))
+	require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+		ID:           id,
+		Input:        json.RawMessage(`{}`),
+		WorkflowName: "test-workflow",
+		Status:       backend.WorkflowStatusPending,
+		ScheduledAt:  scheduledAt,
+		CreatedAt:    scheduledAt,
+		UpdatedAt:    scheduledAt,
+	}))
+}
+
+func TestWorkflowRepository(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		createWorkflowRun(ctx, t, tx, "run-1", now)
+		createWorkflowRun(ctx, t, tx, "run-2", now.Add(time.Second))
+
+		workflow, err := repo.GetWorkflow(ctx, "test-workflow")
+		require.NoError(t, err)
+		require.NotNil(t, workflow)
+
+		// Latest scheduled run first, matching the Postgres backend.
+		next, err := repo.GetNextWorkflowRun(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, next)
+		assert.Equal(t, "run-2", next.ID)
+
+		run, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		require.NotNil(t, run)
+		assert.Equal(t, "test-workflow", run.WorkflowName)
+		run, err = repo.GetWorkflowRun(ctx, "missing")
+		require.NoError(t, err)
+		assert.Nil(t, run)
+
+		require.NoError(t, repo.ChangeWorkflowRunStatus(ctx, "run-2", backend.WorkflowStatusExecuting))
+		require.NoError(t, repo.ChangeWorkflowRunStatus(ctx, "run-1", backend.WorkflowStatusExecuting))
+		next, err = repo.GetNextWorkflowRun(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, next)
+
+		err = repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{ID: "run-3", WorkflowName: "unknown"})
+		require.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestActivityRunRepository(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		createWorkflowRun(ctx, t, tx, "run-1", now)
+		repo := tx.ActivityRunRepository()
+
+		for i, id := range []string{"activity-1", "activity-2"} {
+			require.NoError(t, repo.CreateActivityRun(ctx, &backend.ActivityRun{
+				ID:            id,
+				ActivityName:  "test-activity",
+				WorkflowRunID: "run-1",
+				Input:         json.RawMessage(`{"test": "data"}`),
+				Status:        backend.ActivityStatusPending,
+				ScheduledAt:   now,
+				CreatedAt:     now.Add(time.Duration(i) * time.Second),
+				UpdatedAt:     now,
+			}))
+		}
+
+		history, err := repo.GetActivityRunHistory(ctx, "run-1")
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, "activity-1", history[0].ID)
+		assert.Equal(t, "activity-2", history[1].ID)
+
+		require.NoError(t, repo.ChangeActivityRunStatus(ctx, "activity-1", backend.ActivityStatusFinished))
+		activity, err := repo.GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.ActivityStatusFinished, activity.Status)
+
+		next, err := repo.GetNextActivityRun(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, next)
+		assert.Equal(t, "activity-2", next.ID)
+
+		err = repo.CreateActivityRun(ctx, &backend.ActivityRun{ID: "activity-3", WorkflowRunID: "unknown"})
+		require.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestRunInTx_Rollback(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+
+	errRollback := errors.New("rollback")
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		createWorkflowRun(ctx, t, tx, "run-1", time.Now())
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		workflow, err := tx.WorkflowRepository().GetWorkflow(ctx, "test-workflow")
+		require.NoError(t, err)
+		assert.Nil(t, workflow)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestWorkflowEventRepository(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		createWorkflowRun(ctx, t, tx, "run-1", now)
+		require.NoError(t, tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, "run-1", backend.WorkflowStatusExecuting))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Events appended by a failed transaction are discarded with it.
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		require.NoError(t, tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, "run-1", backend.WorkflowStatusFailed))
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowEventRepository()
+		event := &backend.WorkflowEvent{WorkflowRunID: "run-1", EventType: "timer_started", Payload: json.RawMessage(`{}`)}
+		require.NoError(t, repo.AppendWorkflowEvent(ctx, event))
+		assert.Equal(t, int64(3), event.Sequence)
+		require.Error(t, repo.AppendWorkflowEvent(ctx, &backend.WorkflowEvent{WorkflowRunID: "missing"}))
+
+		events, err := repo.GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+		assert.Equal(t, backend.WorkflowEventRunCreated, events[0].EventType)
+		assert.Equal(t, backend.WorkflowEventRunStatusChanged, events[1].EventType)
+		assert.JSONEq(t, `{"status": "executing"}`, string(events[1].Payload))
+
+		events, err = repo.GetWorkflowEvents(ctx, "run-1", 2)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, int64(3), events[0].Sequence)
+
+		// Replaced events are stored as they are and appending continues after them.
+		now := time.Now()
+		replaced := []backend.WorkflowEvent{
+			{
+				WorkflowRunID: "run-1", Sequence: 1, EventType: backend.WorkflowEventRunCreated,
+				Payload: json.RawMessage(`{}`), CreatedAt: now.Add(-time.Hour),
+			},
+			{
+				WorkflowRunID: "run-1", Sequence: 2, EventType: "timer_started",
+				Payload: json.RawMessage(`{}`), CreatedAt: now.Add(-time.Minute),
+			},
+		}
+		require.NoError(t, repo.ReplaceWorkflowEvents(ctx, "run-1", replaced))
+		events, err = repo.GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, int64(2), events[1].Sequence)
+		assert.True(t, now.Add(-time.Minute).Equal(events[1].CreatedAt))
+		appended := &backend.WorkflowEvent{
+			WorkflowRunID: "run-1", EventType: "timer_fired", Payload: json.RawMessage(`{}`),
+		}
+		require.NoError(t, repo.AppendWorkflowEvent(ctx, appended))
+		assert.Equal(t, int64(3), appended.Sequence)
+		require.Error(t, repo.ReplaceWorkflowEvents(ctx, "missing", replaced))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestWorkflowRepository_ListWorkflowRuns(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+
+	parentID := "run-0"
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		for _, name := range []string{"order-workflow", "other-workflow"} {
+			require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{Name: name, CreatedAt: now, UpdatedAt: now}))
+		}
+		for i := range 4 {
+			run := &backend.WorkflowRun{
+				ID:           fmt.Sprintf("run-%d", i),
+				Input:        json.RawMessage(`[]`),
+				WorkflowName: "order-workflow",
+				Status:       backend.WorkflowStatusPending,
+				ScheduledAt:  now,
+				// run-1 and run-2 share a creation time, so the ID breaks the tie.
+				CreatedAt: now.Add(time.Duration(min(i, 2)) * time.Second),
+				UpdatedAt: now,
+				Labels:    map[string]string{"region": []string{"eu", "us"}[i%2]},
+			}
+			if i > 0 {
+				run.ParentWorkflowRunID = &parentID
+			}
+			require.NoError(t, repo.CreateWorkflowRun(ctx, run))
+		}
+		require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID: "other", Input: json.RawMessage(`[]`), WorkflowName: "other-workflow",
+			Status: backend.WorkflowStatusPending, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+		}))
+		return repo.ChangeWorkflowRunStatus(ctx, "run-2", backend.WorkflowStatusFailed)
+	})
+	require.NoError(t, err)
+
+	list := func(query backend.WorkflowRunQuery, after *backend.WorkflowRunCursor, limit int) []string {
+		var ids []string
+		err := b.RunInTx(ctx, func(tx backend.Tx) error {
+			runs, err := tx.WorkflowRepository().ListWorkflowRuns(ctx, query, after, limit)
+			for _, run := range runs {
+				ids = append(ids, run.ID)
+			}
+			return err
+		})
+		require.NoError(t, err)
+		return ids
+	}
+
+	orders := backend.WorkflowRunQuery{WorkflowName: "order-workflow"}
+	assert.Equal(t, []string{"run-3", "run-2", "run-1", "run-0"}, list(orders, nil, 10))
+	assert.Equal(t, []string{"run-3", "run-2"}, list(orders, nil, 2))
+	assert.Equal(t, []string{"run-1", "run-0"},
+		list(orders, &backend.WorkflowRunCursor{CreatedAt: now.Add(2 * time.Second), ID: "run-2"}, 2))
+
+	byLabel := backend.WorkflowRunQuery{Labels: map[string]string{"region": "us"}}
+	assert.Equal(t, []string{"run-3", "run-1"}, list(byLabel, nil, 10))
+	children := backend.WorkflowRunQuery{ParentWorkflowRunID: parentID}
+	assert.Equal(t, []string{"run-3", "run-2", "run-1"}, list(children, nil, 10))
+	assert.Equal(t, []string{"run-2"}, list(backend.WorkflowRunQuery{
+		Statuses:    []backend.WorkflowStatus{backend.WorkflowStatusFailed, backend.WorkflowStatusAborted},
+		ClosedAfter: now,
+	}, nil, 10))
+	assert.Equal(t, []string{"run-1", "run-0"}, list(backend.WorkflowRunQuery{
+		WorkflowName:  "order-workflow",
+		CreatedAfter:  now,
+		CreatedBefore: now.Add(2 * time.Second),
+	}, nil, 10))
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		count, err := tx.WorkflowRepository().CountWorkflowRuns(ctx, backend.WorkflowRunQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), count)
+
+		run, err := tx.WorkflowRepository().GetWorkflowRun(ctx, "run-2")
+		require.NoError(t, err)
+		require.NotNil(t, run.ClosedAt)
+		assert.Equal(t, map[string]string{"region": "eu"}, run.Labels)
+		require.NotNil(t, run.ParentWorkflowRunID)
+		assert.Equal(t, parentID, *run.ParentWorkflowRunID)
+
+		// Reopening a run clears its closing time.
+		require.NoError(t, tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, "run-2", backend.WorkflowStatusPending))
+		run, err = tx.WorkflowRepository().GetWorkflowRun(ctx, "run-2")
+		require.NoError(t, err)
+		assert.Nil(t, run.ClosedAt)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestWorkflowRepository_SearchAttributes(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+	dueAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	attributes := []map[string]any{
+		{"CustomerID": "c-1", "Region": "eu", "OrderTotal": 25, "Priority": true, "DueAt": dueAt},
+		{"CustomerID": "c-2", "Region": "us", "OrderTotal": 120.5},
+		{"OrderTotal": "unknown"},
+		nil,
+	}
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		workflow := &backend.Workflow{Name: "order-workflow", CreatedAt: now, UpdatedAt: now}
+		require.NoError(t, repo.UpsertWorkflow(ctx, workflow))
+		for i, attrs := range attributes {
+			normalized, err := backend.NormalizeSearchAttributes(attrs)
+			require.NoError(t, err)
+			require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+				ID:               fmt.Sprintf("run-%d", i),
+				Input:            json.RawMessage(`[]`),
+				WorkflowName:     "order-workflow",
+				Status:           backend.WorkflowStatusPending,
+				ScheduledAt:      now,
+				CreatedAt:        now.Add(time.Duration(i) * time.Second),
+				UpdatedAt:        now,
+				SearchAttributes: normalized,
+				Memo:             json.RawMessage(`{"note":{"metadata":{"encoding":"json/plain"},"data":"first"}}`),
+			}))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	list := func(conditions ...backend.SearchAttributeCondition) ([]string, error) {
+		var ids []string
+		err := b.RunInTx(ctx, func(tx backend.Tx) error {
+			query := backend.WorkflowRunQuery{SearchAttributes: conditions}
+			runs, err := tx.WorkflowRepository().ListWorkflowRuns(ctx, query, nil, 10)
+			for _, run := range runs {
+				ids = append(ids, run.ID)
+			}
+			return err
+		})
+		return ids, err
+	}
+	assertListed := func(expected []string, conditions ...backend.SearchAttributeCondition) {
+		t.Helper()
+		ids, err := list(conditions...)
+		require.NoError(t, err)
+		assert.Equal(t, expected, ids)
+	}
+
+	assertListed([]string{"run-0"}, backend.SearchAttributeCondition{Key: "CustomerID", Value: "c-1"})
+	// A string value never matches a numeric condition.
+	assertListed([]string{"run-1"}, backend.SearchAttributeCondition{
+		Key: "OrderTotal", Operator: backend.SearchAttributeGreaterOrEqual, Value: 100,
+	})
+	assertListed([]string{"run-0"}, backend.SearchAttributeCondition{
+		Key: "OrderTotal", Operator: backend.SearchAttributeLess, Value: 100,
+	})
+	// Runs without the attribute do not match a not-equal condition.
+	assertListed([]string{"run-1"}, backend.SearchAttributeCondition{
+		Key: "Region", Operator: backend.SearchAttributeNotEqual, Value: "eu",
+	})
+	assertListed([]string{"run-0"},
+		backend.SearchAttributeCondition{Key: "Priority", Value: true},
+		backend.SearchAttributeCondition{Key: "DueAt", Operator: backend.SearchAttributeLess, Value: dueAt.Add(time.Hour)},
+	)
+	assertListed(nil, backend.SearchAttributeCondition{
+		Key: "DueAt", Operator: backend.SearchAttributeGreater, Value: dueAt,
+	})
+
+	_, err = list(backend.SearchAttributeCondition{Key: "customer-id", Value: "c-1"})
+	require.ErrorIs(t, err, backend.ErrInvalidSearchAttribute)
+	_, err = list(backend.SearchAttributeCondition{Key: "Priority", Operator: backend.SearchAttributeGreater, Value: true})
+	require.ErrorIs(t, err, backend.ErrInvalidSearchAttribute)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		require.NoError(t, repo.UpdateWorkflowRunAttributes(ctx, "run-1", map[string]any{"OrderTotal": 80.0},
+			json.RawMessage(`{"status":{"metadata":{"encoding":"json/plain"},"data":"shipped"}}`)))
+		run, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"CustomerID": "c-2", "Region": "us", "OrderTotal": 80.0}, run.SearchAttributes)
+		assert.JSONEq(t, `{
+			"note": {"metadata": {"encoding": "json/plain"}, "data": "first"},
+			"status": {"metadata": {"encoding": "json/plain"}, "data": "shipped"}
+		}`, string(run.Memo))
+
+		run, err = repo.GetWorkflowRun(ctx, "run-0")
+		require.NoError(t, err)
+		assert.Equal(t, "2024-03-01T12:00:00.000000000Z", run.SearchAttributes["DueAt"])
+
+		events, err := tx.WorkflowEventRepository().GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, backend.WorkflowEventRunAttributesUpdated, events[1].EventType)
+
+		require.Error(t, repo.UpdateWorkflowRunAttributes(ctx, "missing", map[string]any{"Region": "eu"}, nil))
+		return nil
+	})
+	require.NoError(t, err)
+	assertListed([]string{"run-1", "run-0"}, backend.SearchAttributeCondition{
+		Key: "OrderTotal", Operator: backend.SearchAttributeLessOrEqual, Value: 80,
+	})
+}
+
+func TestNormalizeSearchAttributes_Precision(t *testing.T) {
+	dueAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	normalized, err := backend.NormalizeSearchAttributes(map[string]any{
+		"Largest": int64(1 << 53), "Smallest": -(1 << 53), "DueAt": dueAt,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, float64(1<<53), normalized["Largest"])
+	assert.Equal(t, float64(-(1 << 53)), normalized["Smallest"])
+	// Times are returned as strings and decoded with ParseSearchAttributeTime.
+	parsed, err := backend.ParseSearchAttributeTime(normalized["DueAt"])
+	require.NoError(t, err)
+	assert.True(t, dueAt.Equal(parsed))
+
+	for _, value := range []any{int64(1<<53 + 1), -(1<<53 + 1), uint64(math.MaxUint64)} {
+		_, err := backend.NormalizeSearchAttributes(map[string]any{"OrderID": value})
+		require.ErrorIs(t, err, backend.ErrInvalidSearchAttribute, value)
+	}
+	_, err = backend.ParseSearchAttributeTime(12.5)
+	require.ErrorIs(t, err, backend.ErrInvalidSearchAttribute)
+}
+
+func TestWorkflowRepository_DeleteWorkflowRuns(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+
+	parentID := "run-1"
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		for _, name := range []string{"b-workflow", "a-workflow"} {
+			require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{Name: name, CreatedAt: now, UpdatedAt: now}))
+		}
+		for i, id := range []string{"run-1", "run-2", "child"} {
+			run := &backend.WorkflowRun{
+				ID: id, Input: json.RawMessage(`[]`), WorkflowName: "a-workflow",
+				Status: backend.WorkflowStatusPending, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+			}
+			if i == 2 {
+				run.ParentWorkflowRunID = &parentID
+			}
+			require.NoError(t, repo.CreateWorkflowRun(ctx, run))
+			require.NoError(t, tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+				ID: "activity-" + id, ActivityName: "test-activity", WorkflowRunID: id, Input: json.RawMessage(`[]`),
+				Status: backend.ActivityStatusPending, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+			}))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		workflows, err := repo.ListWorkflows(ctx)
+		require.NoError(t, err)
+		require.Len(t, workflows, 2)
+		assert.Equal(t, "a-workflow", workflows[0].Name)
+
+		// Runs that are not closed, or that changed after their last event was read, are kept.
+		deleted, err := repo.DeleteWorkflowRuns(ctx, backend.WorkflowRunDeletion{
+			WorkflowRunIDs: []string{"run-1", "run-2"},
+			ClosedBefore:   now.Add(time.Hour),
+		})
+		require.NoError(t, err)
+		assert.Empty(t, deleted)
+		deleted, err = repo.DeleteWorkflowRuns(ctx, backend.WorkflowRunDeletion{
+			WorkflowRunIDs:     []string{"run-1", "run-2", "missing"},
+			LastEventSequences: map[string]int64{"run-1": 2, "run-2": 1, "missing": 0},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"run-1"}, deleted)
+		deleted, err = repo.DeleteWorkflowRuns(ctx, backend.WorkflowRunDeletion{})
+		require.NoError(t, err)
+		assert.Empty(t, deleted)
+
+		run, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Nil(t, run)
+		activityRun, err := tx.ActivityRunRepository().GetActivityRun(ctx, "activity-run-1")
+		require.NoError(t, err)
+		assert.Nil(t, activityRun)
+		events, err := tx.WorkflowEventRepository().GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		// Other runs keep their history; children only lose their parent link.
+		child, err := repo.GetWorkflowRun(ctx, "child")
+		require.NoError(t, err)
+		require.NotNil(t, child)
+		assert.Nil(t, child.ParentWorkflowRunID)
+		activityRun, err = tx.ActivityRunRepository().GetActivityRun(ctx, "activity-run-2")
+		require.NoError(t, err)
+		assert.NotNil(t, activityRun)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_TaskQueues(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+
+	listener, err := b.ListenForTasks(ctx, []string{"orders"})
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	createRun := func(tx backend.Tx, id, taskQueue string) error {
+		repo := tx.WorkflowRepository()
+		require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{Name: "test-workflow"}))
+		return repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           id,
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			TaskQueue:    taskQueue,
+		})
+	}
+
+	require.NoError(t, b.RunInTx(ctx, func(tx backend.Tx) error {
+		return createRun(tx, "run-default", "")
+	}))
+	assert.Empty(t, listener.C())
+
+	// Runs created by a rolled back transaction are not announced.
+	rollback := errors.New("rollback")
+	require.ErrorIs(t, b.RunInTx(ctx, func(tx backend.Tx) error {
+		require.NoError(t, createRun(tx, "run-rolled-back", "orders"))
+		return rollback
+	}), rollback)
+	assert.Empty(t, listener.C())
+
+	require.NoError(t, b.RunInTx(ctx, func(tx backend.Tx) error {
+		return createRun(tx, "run-orders", "orders")
+	}))
+	require.Len(t, listener.C(), 1)
+	<-listener.C()
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		run, err := repo.GetWorkflowRun(ctx, "run-default")
+		require.NoError(t, err)
+		assert.Equal(t, backend.DefaultTaskQueue, run.TaskQueue)
+
+		next, err := repo.GetNextWorkflowRun(ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, next)
+		assert.Equal(t, "run-orders", next.ID)
+		next, err = repo.GetNextWorkflowRun(ctx, "payments")
+		require.NoError(t, err)
+		assert.Nil(t, next)
+
+		activityRepo := tx.ActivityRunRepository()
+		activity := &backend.ActivityRun{ID: "activity-1", WorkflowRunID: "run-orders", Status: backend.ActivityStatusPending}
+		require.NoError(t, activityRepo.CreateActivityRun(ctx, activity))
+		assert.Equal(t, "orders", activity.TaskQueue)
+		require.NoError(t, activityRepo.CreateActivityRun(ctx, &backend.ActivityRun{
+			ID: "activity-2", WorkflowRunID: "run-orders", Status: backend.ActivityStatusPending, TaskQueue: "payments",
+		}))
+
+		nextActivity, err := activityRepo.GetNextActivityRun(ctx, "payments")
+		require.NoError(t, err)
+		require.NotNil(t, nextActivity)
+		assert.Equal(t, "activity-2", nextActivity.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, listener.C(), 1)
+
+	// Waiting falls back to polling when there is no notification.
+	<-listener.C()
+	start := time.Now()
+	require.NoError(t, backend.WaitForTask(ctx, listener, 10*time.Millisecond))
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+}
+
+func TestBackend_Leases(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+
+	require.NoError(t, b.RunInTx(ctx, func(tx backend.Tx) error {
+		createWorkflowRun(ctx, t, tx, "run-1", now)
+		return tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+			ID: "activity-1", WorkflowRunID: "run-1", Status: backend.ActivityStatusPending, ScheduledAt: now,
+		})
+	}))
+
+	listener, err := b.ListenForTasks(ctx, []string{backend.DefaultTaskQueue})
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		run, err := repo.ClaimWorkflowRun(ctx, "worker-1", time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, run)
+		assert.Equal(t, "run-1", run.ID)
+		assert.Equal(t, backend.WorkflowStatusExecuting, run.Status)
+		assert.Equal(t, 1, run.Attempt)
+		require.NotNil(t, run.LeaseOwner)
+		assert.Equal(t, "worker-1", *run.LeaseOwner)
+
+		next, err := repo.ClaimWorkflowRun(ctx, "worker-2", time.Minute)
+		require.NoError(t, err)
+		assert.Nil(t, next)
+
+		require.ErrorIs(t, repo.RenewWorkflowRunLease(ctx, "run-1", "worker-2", time.Minute), backend.ErrLeaseLost)
+		// A lease renewed into the past has expired.
+		require.NoError(t, repo.RenewWorkflowRunLease(ctx, "run-1", "worker-1", -time.Second))
+
+		activity, err := tx.ActivityRunRepository().ClaimActivityRun(ctx, "worker-1", -time.Second)
+		require.NoError(t, err)
+		require.NotNil(t, activity)
+		assert.Equal(t, backend.ActivityStatusExecuting, activity.Status)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		reset, err := tx.WorkflowRepository().ResetExpiredWorkflowRuns(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), reset)
+		reset, err = tx.ActivityRunRepository().ResetExpiredActivityRuns(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), reset)
+
+		run, err := tx.WorkflowRepository().GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.WorkflowStatusPending, run.Status)
+		assert.Equal(t, 2, run.Attempt)
+		assert.Nil(t, run.LeaseOwner)
+		assert.Nil(t, run.LeaseExpiresAt)
+
+		activity, err := tx.ActivityRunRepository().GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.ActivityStatusPending, activity.Status)
+		assert.Equal(t, 2, activity.Attempt)
+
+		require.ErrorIs(t, tx.WorkflowRepository().RenewWorkflowRunLease(ctx, "run-1", "worker-1", time.Minute),
+			backend.ErrLeaseLost)
+		return nil
+	})
+	require.NoError(t, err)
+	// Runs returned to pending are announced like new ones.
+	require.Len(t, listener.C(), 1)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		_, err := repo.ClaimWorkflowRun(ctx, "worker-2", time.Minute)
+		require.NoError(t, err)
+		require.ErrorIs(t, repo.ReleaseWorkflowRunLease(ctx, "run-1", "worker-1"), backend.ErrLeaseLost)
+		require.NoError(t, repo.ReleaseWorkflowRunLease(ctx, "run-1", "worker-2"))
+
+		run, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.WorkflowStatusPending, run.Status)
+		assert.Equal(t, 2, run.Attempt)
+		assert.Nil(t, run.LeaseOwner)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A lease expiring on the last attempt fails the run instead.
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		_, err := tx.WorkflowRepository().ClaimWorkflowRun(ctx, "worker-3", -time.Second)
+		require.NoError(t, err)
+		_, err = tx.ActivityRunRepository().ClaimActivityRun(ctx, "worker-3", -time.Second)
+		require.NoError(t, err)
+
+		failed, err := tx.WorkflowRepository().ResetExpiredWorkflowRuns(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), failed)
+		failed, err = tx.ActivityRunRepository().ResetExpiredActivityRuns(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), failed)
+
+		run, err := tx.WorkflowRepository().GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.WorkflowStatusFailed, run.Status)
+		assert.Equal(t, 2, run.Attempt)
+		assert.NotNil(t, run.ClosedAt)
+		require.NotNil(t, run.ErrorMessage)
+		assert.Equal(t, backend.ErrAttemptsExhausted.Error(), *run.ErrorMessage)
+		assert.Nil(t, run.LeaseOwner)
+
+		activity, err := tx.ActivityRunRepository().GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.ActivityStatusFailed, activity.Status)
+		require.NotNil(t, activity.ErrorMessage)
+		assert.Equal(t, backend.ErrAttemptsExhausted.Error(), *activity.ErrorMessage)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_Workers(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		createWorkflowRun(ctx, t, tx, "run-1", now)
+		repo := tx.WorkerRepository()
+		require.NoError(t, repo.RegisterWorker(ctx, &backend.Worker{
+			ID: "worker-1", Hostname: "host-1", TaskQueues: []string{backend.DefaultTaskQueue, "emails"},
+			StartedAt: now, LastHeartbeatAt: now,
+		}))
+		require.NoError(t, repo.RegisterWorker(ctx, &backend.Worker{
+			ID: "worker-2", Hostname: "host-2", TaskQueues: []string{"emails"},
+			StartedAt: now, LastHeartbeatAt: now.Add(-time.Hour),
+		}))
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkerRepository()
+		workers, err := repo.ListWorkers(ctx)
+		require.NoError(t, err)
+		require.Len(t, workers, 2)
+		assert.Equal(t, "worker-1", workers[0].ID)
+		assert.Equal(t, "host-2", workers[1].Hostname)
+
+		queues, err := repo.ListTaskQueues(ctx, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, queues, 2)
+		require.NotNil(t, queues[0].OldestPendingScheduledAt)
+		assert.WithinDuration(t, now, *queues[0].OldestPendingScheduledAt, time.Millisecond)
+		queues[0].OldestPendingScheduledAt = nil
+		assert.Equal(t, []backend.TaskQueueInfo{
+			{Name: backend.DefaultTaskQueue, Pollers: 1, PendingWorkflowRuns: 1},
+			{Name: "emails", Pollers: 1},
+		}, queues)
+
+		require.ErrorIs(t, repo.RecordWorkerHeartbeat(ctx, "worker-3"), backend.ErrWorkerNotFound)
+		require.NoError(t, repo.RecordWorkerHeartbeat(ctx, "worker-2"))
+		queues, err = repo.ListTaskQueues(ctx, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, 2, queues[1].Pollers)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkerRepository()
+		require.NoError(t, repo.DeregisterWorker(ctx, "worker-1"))
+		deleted, err := repo.DeleteInactiveWorkers(ctx, -time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		workers, err := repo.ListWorkers(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, workers)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_TraceContext(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+	traceContext := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		createWorkflowRun(ctx, t, tx, "run-1", now)
+		workflowRepo := tx.WorkflowRepository()
+		require.NoError(t, workflowRepo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-2",
+			Input:        json.RawMessage(`{}`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			TraceContext: traceContext,
+		}))
+		run, err := workflowRepo.GetWorkflowRun(ctx, "run-2")
+		require.NoError(t, err)
+		assert.Equal(t, traceContext, run.TraceContext)
+
+		// Activity runs inherit the trace context of their workflow run unless they have one.
+		activityRepo := tx.ActivityRunRepository()
+		own := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01"}
+		for _, activity := range []backend.ActivityRun{
+			{ID: "inherited", WorkflowRunID: "run-2"},
+			{ID: "own", WorkflowRunID: "run-2", TraceContext: own},
+			{ID: "untraced", WorkflowRunID: "run-1"},
+		} {
+			activity.ActivityName = "test-activity"
+			activity.Input = json.RawMessage(`{}`)
+			activity.Status = backend.ActivityStatusPending
+			activity.ScheduledAt, activity.CreatedAt, activity.UpdatedAt = now, now, now
+			require.NoError(t, activityRepo.CreateActivityRun(ctx, &activity))
+		}
+		for id, want := range map[string]map[string]string{"inherited": traceContext, "own": own, "untraced": nil} {
+			activity, err := activityRepo.GetActivityRun(ctx, id)
+			require.NoError(t, err)
+			assert.Equal(t, want, activity.TraceContext, id)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_CloseWorkflowRun(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+	output := json.RawMessage(`{"payloads":[]}`)
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		createWorkflowRun(ctx, t, tx, "run-1", now)
+		repo := tx.WorkflowRepository()
+		require.ErrorIs(t, repo.CloseWorkflowRun(ctx, "run-1", "worker-1", backend.WorkflowStatusFinished, &output, nil),
+			backend.ErrLeaseLost)
+		_, err := repo.ClaimWorkflowRun(ctx, "worker-1", time.Minute)
+		require.NoError(t, err)
+		require.ErrorIs(t, repo.CloseWorkflowRun(ctx, "run-1", "worker-2", backend.WorkflowStatusFinished, &output, nil),
+			backend.ErrLeaseLost)
+		require.NoError(t, repo.CloseWorkflowRun(ctx, "run-1", "worker-1", backend.WorkflowStatusFinished, &output, nil))
+
+		run, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.WorkflowStatusFinished, run.Status)
+		assert.JSONEq(t, string(output), string(*run.Output))
+		assert.Nil(t, run.ErrorMessage)
+		assert.NotNil(t, run.ClosedAt)
+		assert.Nil(t, run.LeaseOwner)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_CloseActivityRun(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	now := time.Now()
+	output := json.RawMessage(`{"payloads":[]}`)
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		createWorkflowRun(ctx, t, tx, "run-1", now)
+		repo := tx.ActivityRunRepository()
+		require.NoError(t, repo.CreateActivityRun(ctx, &backend.ActivityRun{
+			ID:            "activity-1",
+			ActivityName:  "test-activity",
+			WorkflowRunID: "run-1",
+			Input:         json.RawMessage(`{}`),
+			Status:        backend.ActivityStatusPending,
+			ScheduledAt:   now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}))
+		require.ErrorIs(t, repo.CloseActivityRun(ctx, "activity-1", "worker-1", backend.ActivityStatusFinished, &output, nil),
+			backend.ErrLeaseLost)
+		_, err := repo.ClaimActivityRun(ctx, "worker-1", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, repo.CloseActivityRun(ctx, "activity-1", "worker-1", backend.ActivityStatusFinished, &output, nil))
+
+		run, err := repo.GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.ActivityStatusFinished, run.Status)
+		assert.JSONEq(t, string(output), string(*run.Output))
+		assert.Nil(t, run.LeaseOwner)
+		return nil
+	})
+	require.NoError(t, err)
+}
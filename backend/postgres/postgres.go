@@ -0,0 +1,81 @@
+// Package postgres implements backend.Backend on PostgreSQL.
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/nurburg-dev/pitlane/internal/dbrepo"
+)
+
+type Backend struct {
+	pool   *pgxpool.Pool
+	tables db.Tables
+}
+
+var _ backend.Backend = (*Backend)(nil)
+
+// New creates a backend storing its tables in schema (public when empty), with names prefixed by tablePrefix.
+func New(pool *pgxpool.Pool, schema, tablePrefix string) *Backend {
+	return &Backend{
+		pool:   pool,
+		tables: db.NewTables(schema, tablePrefix),
+	}
+}
+
+// Pool returns the connection pool the backend runs on.
+func (b *Backend) Pool() *pgxpool.Pool {
+	return b.pool
+}
+
+func (b *Backend) Init(ctx context.Context) error {
+	return db.NewPGInitiator(b.pool, b.tables).Init(ctx)
+}
+
+func (b *Backend) Verify(ctx context.Context) error {
+	return db.NewPGInitiator(b.pool, b.tables).Verify(ctx)
+}
+
+func (b *Backend) RunInTx(ctx context.Context, fn func(tx backend.Tx) error) error {
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if err := fn(&pgTx{tx: tx, tables: b.tables}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+type pgTx struct {
+	tx     pgx.Tx
+	tables db.Tables
+}
+
+func (t *pgTx) WorkflowRepository() backend.WorkflowRepository {
+	return dbrepo.NewPGWorkflowRepository(t.tx, t.tables)
+}
+
+func (t *pgTx) ActivityRunRepository() backend.ActivityRunRepository {
+	return dbrepo.NewPGActivityRunRepository(t.tx, t.tables)
+}
+
+func (t *pgTx) WorkflowEventRepository() backend.WorkflowEventRepository {
+	return dbrepo.NewPGWorkflowEventRepository(t.tx, t.tables)
+}
+
+func (t *pgTx) WorkerRepository() backend.WorkerRepository {
+	return dbrepo.NewPGWorkerRepository(t.tx, t.tables)
+}
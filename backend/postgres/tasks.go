@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+// reconnectInterval is how long a listener waits before replacing a broken connection.
+const reconnectInterval = time.Second
+
+var _ backend.TaskNotifier = (*Backend)(nil)
+
+// ListenForTasks listens for the notifications CreateWorkflowRun and CreateActivityRun send
+// when their transaction commits, from any process using the same schema and table prefix.
+//
+// The listener holds a connection taken out of the pool until it is closed; a worker opens a
+// single listener and shares it between its pollers. When the connection breaks, it fires once,
+// since notifications may have been lost, and reconnects in the background.
+func (b *Backend) ListenForTasks(ctx context.Context, taskQueues []string) (backend.TaskListener, error) {
+	channels := make([]string, len(taskQueues))
+	for i, taskQueue := range taskQueues {
+		channels[i] = b.tables.TaskQueueChannel(taskQueue)
+	}
+
+	conn, err := b.listen(ctx, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	listenCtx, cancel := context.WithCancel(context.Background())
+	l := &taskListener{
+		c:      make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go l.run(listenCtx, conn, func(ctx context.Context) (*pgx.Conn, error) {
+		return b.listen(ctx, channels)
+	})
+	return l, nil
+}
+
+// listen takes a connection out of the pool and subscribes it to channels.
+func (b *Backend) listen(ctx context.Context, channels []string) (*pgx.Conn, error) {
+	pooled, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire listener connection: %w", err)
+	}
+	conn := pooled.Hijack()
+
+	for _, channel := range channels {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			_ = conn.Close(context.Background())
+			return nil, fmt.Errorf("failed to listen on %s: %w", channel, err)
+		}
+	}
+	return conn, nil
+}
+
+type taskListener struct {
+	c      chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (l *taskListener) C() <-chan struct{} {
+	return l.c
+}
+
+func (l *taskListener) Close() error {
+	l.cancel()
+	<-l.done
+	return nil
+}
+
+func (l *taskListener) notify() {
+	select {
+	case l.c <- struct{}{}:
+	default:
+	}
+}
+
+func (l *taskListener) run(
+	ctx context.Context,
+	conn *pgx.Conn,
+	reconnect func(ctx context.Context) (*pgx.Conn, error),
+) {
+	defer close(l.done)
+
+	for {
+		if conn == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reconnectInterval):
+			}
+			var err error
+			if conn, err = reconnect(ctx); err != nil {
+				continue
+			}
+			// Runs created while reconnecting were not announced.
+			l.notify()
+		}
+
+		_, err := conn.WaitForNotification(ctx)
+		if err == nil {
+			l.notify()
+			continue
+		}
+		_ = conn.Close(context.Background())
+		conn = nil
+		if ctx.Err() != nil {
+			return
+		}
+		l.notify()
+	}
+}
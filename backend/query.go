@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"slices"
+	"time"
+)
+
+// WorkflowRunQuery selects workflow runs. Zero fields do not restrict the result; all set
+// fields must match. Time ranges include their After bound and exclude their Before bound.
+type WorkflowRunQuery struct {
+	WorkflowName string
+	// Statuses matches runs in any of the given statuses.
+	Statuses        []WorkflowStatus
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+	ScheduledAfter  time.Time
+	ScheduledBefore time.Time
+	// ClosedAfter and ClosedBefore only match closed runs.
+	ClosedAfter  time.Time
+	ClosedBefore time.Time
+	// Labels matches runs carrying all of the given labels.
+	Labels              map[string]string
+	ParentWorkflowRunID string
+	// SearchAttributes matches runs satisfying all of the given conditions.
+	SearchAttributes []SearchAttributeCondition
+}
+
+// WorkflowRunDeletion selects the runs deleted by WorkflowRepository.DeleteWorkflowRuns among
+// WorkflowRunIDs. Zero conditions do not restrict the deletion.
+type WorkflowRunDeletion struct {
+	WorkflowRunIDs []string
+	// ClosedBefore only deletes runs that are still closed, and closed before it.
+	ClosedBefore time.Time
+	// LastEventSequences only deletes the runs whose last event still has the sequence given for
+	// them, which have not changed since they were read. Runs missing from it are kept.
+	LastEventSequences map[string]int64
+}
+
+// Selects reports whether d deletes the run with the given closing time and last event
+// sequence, for backends that check the conditions in process.
+func (d WorkflowRunDeletion) Selects(workflowRunID string, closedAt *time.Time, lastEventSequence int64) bool {
+	if !d.ClosedBefore.IsZero() && (closedAt == nil || !closedAt.Before(d.ClosedBefore)) {
+		return false
+	}
+	if d.LastEventSequences != nil {
+		sequence, ok := d.LastEventSequences[workflowRunID]
+		return ok && sequence == lastEventSequence
+	}
+	return true
+}
+
+// Normalize validates q and returns it with its search attribute conditions normalized.
+// Backends call it before translating a query.
+func (q WorkflowRunQuery) Normalize() (WorkflowRunQuery, error) {
+	if len(q.SearchAttributes) == 0 {
+		return q, nil
+	}
+	conditions := make([]SearchAttributeCondition, len(q.SearchAttributes))
+	for i, condition := range q.SearchAttributes {
+		normalized, err := condition.Normalize()
+		if err != nil {
+			return q, err
+		}
+		conditions[i] = normalized
+	}
+	q.SearchAttributes = conditions
+	return q, nil
+}
+
+// Matches reports whether run is selected by q, for backends that filter in process. q must be
+// normalized.
+func (q WorkflowRunQuery) Matches(run *WorkflowRun) bool {
+	if q.WorkflowName != "" && run.WorkflowName != q.WorkflowName {
+		return false
+	}
+	if len(q.Statuses) > 0 && !slices.Contains(q.Statuses, run.Status) {
+		return false
+	}
+	if !inRange(run.CreatedAt, q.CreatedAfter, q.CreatedBefore) ||
+		!inRange(run.ScheduledAt, q.ScheduledAfter, q.ScheduledBefore) {
+		return false
+	}
+	if !q.ClosedAfter.IsZero() || !q.ClosedBefore.IsZero() {
+		if run.ClosedAt == nil || !inRange(*run.ClosedAt, q.ClosedAfter, q.ClosedBefore) {
+			return false
+		}
+	}
+	for key, value := range q.Labels {
+		if v, ok := run.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	if q.ParentWorkflowRunID != "" &&
+		(run.ParentWorkflowRunID == nil || *run.ParentWorkflowRunID != q.ParentWorkflowRunID) {
+		return false
+	}
+	for _, condition := range q.SearchAttributes {
+		if !condition.Matches(run.SearchAttributes) {
+			return false
+		}
+	}
+	return true
+}
+
+func inRange(t, after, before time.Time) bool {
+	return (after.IsZero() || !t.Before(after)) && (before.IsZero() || t.Before(before))
+}
+
+// WorkflowRunCursor is the position of a run in listing order: newest created first, and by
+// descending ID among runs created at the same time.
+type WorkflowRunCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// IsAfter reports whether run comes after the cursor in listing order.
+func (c WorkflowRunCursor) IsAfter(run *WorkflowRun) bool {
+	if run.CreatedAt.Equal(c.CreatedAt) {
+		return run.ID < c.ID
+	}
+	return run.CreatedAt.Before(c.CreatedAt)
+}
@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+)
+
+// SearchAttributeTimeFormat is the layout time.Time search attributes are stored in. Times are
+// converted to UTC and formatted with a fixed width, so they sort chronologically as strings.
+const SearchAttributeTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// ErrInvalidSearchAttribute is returned for search attributes and conditions with an invalid
+// key, an unsupported value type or an unsupported operator.
+var ErrInvalidSearchAttribute = errors.New("invalid search attribute")
+
+// maxExactSearchAttributeInteger is the largest magnitude of an integer search attribute. Numbers
+// are stored as float64, which represents every integer up to 2^53 exactly.
+const maxExactSearchAttributeInteger = 1 << 53
+
+var searchAttributeKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// NormalizeSearchAttributes validates attributes and converts their values to the types every
+// backend stores and returns: strings, bools, float64 for all numeric types, and time.Time
+// formatted with SearchAttributeTimeFormat. Integers beyond ±2^53, which float64 cannot hold
+// exactly, are rejected. Times come back as strings; ParseSearchAttributeTime decodes them. Keys
+// are identifiers of at most 64 characters.
+func NormalizeSearchAttributes(attributes map[string]any) (map[string]any, error) {
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	normalized := make(map[string]any, len(attributes))
+	for key, value := range attributes {
+		if !searchAttributeKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%w: key %q is not an identifier", ErrInvalidSearchAttribute, key)
+		}
+		v, err := normalizeSearchAttributeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidSearchAttribute, key, err)
+		}
+		normalized[key] = v
+	}
+	return normalized, nil
+}
+
+func normalizeSearchAttributeValue(value any) (any, error) {
+	switch v := value.(type) {
+	case string, bool:
+		return v, nil
+	case time.Time:
+		return v.UTC().Format(SearchAttributeTimeFormat), nil
+	case int:
+		return normalizeSearchAttributeInteger(int64(v))
+	case int8:
+		return normalizeSearchAttributeInteger(int64(v))
+	case int16:
+		return normalizeSearchAttributeInteger(int64(v))
+	case int32:
+		return normalizeSearchAttributeInteger(int64(v))
+	case int64:
+		return normalizeSearchAttributeInteger(v)
+	case uint:
+		return normalizeSearchAttributeUnsigned(uint64(v))
+	case uint8:
+		return normalizeSearchAttributeUnsigned(uint64(v))
+	case uint16:
+		return normalizeSearchAttributeUnsigned(uint64(v))
+	case uint32:
+		return normalizeSearchAttributeUnsigned(uint64(v))
+	case uint64:
+		return normalizeSearchAttributeUnsigned(v)
+	case float32:
+		return normalizeSearchAttributeFloat(float64(v))
+	case float64:
+		return normalizeSearchAttributeFloat(v)
+	default:
+		return nil, fmt.Errorf("unsupported type %T", value)
+	}
+}
+
+func normalizeSearchAttributeInteger(v int64) (any, error) {
+	if v > maxExactSearchAttributeInteger || v < -maxExactSearchAttributeInteger {
+		return nil, fmt.Errorf("integer %d cannot be stored exactly as float64", v)
+	}
+	return float64(v), nil
+}
+
+func normalizeSearchAttributeUnsigned(v uint64) (any, error) {
+	if v > maxExactSearchAttributeInteger {
+		return nil, fmt.Errorf("integer %d cannot be stored exactly as float64", v)
+	}
+	return float64(v), nil
+}
+
+func normalizeSearchAttributeFloat(v float64) (any, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("%v is not a finite number", v)
+	}
+	return v, nil
+}
+
+// ParseSearchAttributeTime decodes a time.Time search attribute, which backends return as a
+// string in SearchAttributeTimeFormat.
+func ParseSearchAttributeTime(value any) (time.Time, error) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %T is not a time string", ErrInvalidSearchAttribute, value)
+	}
+	t, err := time.Parse(SearchAttributeTimeFormat, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrInvalidSearchAttribute, err)
+	}
+	return t, nil
+}
+
+// SearchAttributeOperator compares a search attribute with the value of a condition.
+type SearchAttributeOperator string
+
+const (
+	SearchAttributeEqual          SearchAttributeOperator = "="
+	SearchAttributeNotEqual       SearchAttributeOperator = "!="
+	SearchAttributeLess           SearchAttributeOperator = "<"
+	SearchAttributeLessOrEqual    SearchAttributeOperator = "<="
+	SearchAttributeGreater        SearchAttributeOperator = ">"
+	SearchAttributeGreaterOrEqual SearchAttributeOperator = ">="
+)
+
+// SearchAttributeCondition matches runs whose search attribute Key compares to Value with
+// Operator. A run without the attribute, or with a value of another type, never matches; not
+// even a SearchAttributeNotEqual condition. Bools only support equality.
+type SearchAttributeCondition struct {
+	Key      string
+	Operator SearchAttributeOperator
+	Value    any
+}
+
+// Normalize validates c and returns it with Value normalized like a stored search attribute.
+// An empty Operator means SearchAttributeEqual.
+func (c SearchAttributeCondition) Normalize() (SearchAttributeCondition, error) {
+	if c.Operator == "" {
+		c.Operator = SearchAttributeEqual
+	}
+	normalized, err := NormalizeSearchAttributes(map[string]any{c.Key: c.Value})
+	if err != nil {
+		return c, err
+	}
+	c.Value = normalized[c.Key]
+	switch c.Operator {
+	case SearchAttributeEqual, SearchAttributeNotEqual:
+	case SearchAttributeLess, SearchAttributeLessOrEqual, SearchAttributeGreater, SearchAttributeGreaterOrEqual:
+		if _, ok := c.Value.(bool); ok {
+			return c, fmt.Errorf("%w: %s: bools do not support operator %s",
+				ErrInvalidSearchAttribute, c.Key, c.Operator)
+		}
+	default:
+		return c, fmt.Errorf("%w: %s: unsupported operator %q", ErrInvalidSearchAttribute, c.Key, c.Operator)
+	}
+	return c, nil
+}
+
+// Matches reports whether the normalized search attributes satisfy c, which must be normalized.
+func (c SearchAttributeCondition) Matches(attributes map[string]any) bool {
+	var order int
+	switch want := c.Value.(type) {
+	case string:
+		got, ok := attributes[c.Key].(string)
+		if !ok {
+			return false
+		}
+		order = cmp.Compare(got, want)
+	case float64:
+		got, ok := attributes[c.Key].(float64)
+		if !ok {
+			return false
+		}
+		order = cmp.Compare(got, want)
+	case bool:
+		got, ok := attributes[c.Key].(bool)
+		if !ok {
+			return false
+		}
+		return (got == want) == (c.Operator == SearchAttributeEqual)
+	default:
+		return false
+	}
+
+	switch c.Operator {
+	case SearchAttributeEqual:
+		return order == 0
+	case SearchAttributeNotEqual:
+		return order != 0
+	case SearchAttributeLess:
+		return order < 0
+	case SearchAttributeLessOrEqual:
+		return order <= 0
+	case SearchAttributeGreater:
+		return order > 0
+	case SearchAttributeGreaterOrEqual:
+		return order >= 0
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+-- Timestamps are stored as Unix nanoseconds so they sort correctly.
+
+CREATE TABLE workflows (
+    name TEXT PRIMARY KEY NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE workflow_runs (
+    id TEXT PRIMARY KEY NOT NULL,
+    input TEXT NOT NULL,
+    workflow_name TEXT REFERENCES workflows(name) NOT NULL,
+    status TEXT NOT NULL,
+    scheduled_at INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE activity_runs (
+    id TEXT PRIMARY KEY NOT NULL,
+    activity_name TEXT NOT NULL,
+    workflow_run_id TEXT REFERENCES workflow_runs(id) NOT NULL,
+    error_message TEXT,
+    input TEXT NOT NULL,
+    output TEXT,
+    status TEXT NOT NULL,
+    retry_status TEXT,
+    scheduled_at INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+-- Indexes for optimal pending task fetching (latest scheduled first)
+CREATE INDEX idx_workflow_runs_pending ON workflow_runs (status, scheduled_at DESC) WHERE status = 'pending';
+CREATE INDEX idx_activity_runs_pending ON activity_runs (status, scheduled_at DESC) WHERE status = 'pending';
+
+-- Index for activity run history by workflow run ID
+CREATE INDEX idx_activity_runs_workflow_history ON activity_runs (workflow_run_id, created_at ASC);
@@ -0,0 +1,14 @@
+-- Append-only history of every change to a workflow run. Sequence numbers are allocated from
+-- workflow_runs.last_event_sequence, so they are gap-free per run.
+
+ALTER TABLE workflow_runs ADD COLUMN last_event_sequence INTEGER DEFAULT 0 NOT NULL;
+
+CREATE TABLE workflow_events (
+    workflow_run_id TEXT REFERENCES workflow_runs(id) NOT NULL,
+    sequence INTEGER NOT NULL,
+    event_type TEXT NOT NULL,
+    activity_run_id TEXT,
+    payload TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (workflow_run_id, sequence)
+);
@@ -0,0 +1,11 @@
+-- Columns and indexes for listing and filtering workflow runs, ordered by (created_at DESC, id DESC).
+
+ALTER TABLE workflow_runs ADD COLUMN closed_at INTEGER;
+ALTER TABLE workflow_runs ADD COLUMN labels TEXT DEFAULT '{}' NOT NULL;
+ALTER TABLE workflow_runs ADD COLUMN parent_workflow_run_id TEXT REFERENCES workflow_runs(id);
+
+CREATE INDEX idx_workflow_runs_created ON workflow_runs (created_at DESC, id DESC);
+CREATE INDEX idx_workflow_runs_name_created ON workflow_runs (workflow_name, created_at DESC, id DESC);
+CREATE INDEX idx_workflow_runs_status_created ON workflow_runs (status, created_at DESC, id DESC);
+CREATE INDEX idx_workflow_runs_closed ON workflow_runs (closed_at) WHERE closed_at IS NOT NULL;
+CREATE INDEX idx_workflow_runs_parent ON workflow_runs (parent_workflow_run_id) WHERE parent_workflow_run_id IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Custom search attributes and memo of workflow runs, stored as JSON objects.
+
+ALTER TABLE workflow_runs ADD COLUMN search_attributes TEXT DEFAULT '{}' NOT NULL;
+ALTER TABLE workflow_runs ADD COLUMN memo TEXT DEFAULT '{}' NOT NULL;
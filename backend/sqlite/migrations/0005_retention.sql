@@ -0,0 +1,7 @@
+-- Retention deletes runs by workflow name and closing time. Runs closed before closed_at was
+-- added get their last update time, so that they are not kept forever.
+
+UPDATE workflow_runs SET closed_at = updated_at
+WHERE closed_at IS NULL AND status IN ('finished', 'failed', 'aborted');
+
+CREATE INDEX idx_workflow_runs_name_closed ON workflow_runs (workflow_name, closed_at) WHERE closed_at IS NOT NULL;
@@ -0,0 +1,8 @@
+-- Task queues route pending runs to the workers polling them. Activity runs are created on the
+-- queue of their workflow run unless they name another one.
+
+ALTER TABLE workflow_runs ADD COLUMN task_queue TEXT DEFAULT 'default' NOT NULL;
+ALTER TABLE activity_runs ADD COLUMN task_queue TEXT DEFAULT 'default' NOT NULL;
+
+CREATE INDEX idx_workflow_runs_queue_pending ON workflow_runs (task_queue, scheduled_at DESC) WHERE status = 'pending';
+CREATE INDEX idx_activity_runs_queue_pending ON activity_runs (task_queue, scheduled_at DESC) WHERE status = 'pending';
@@ -0,0 +1,16 @@
+-- Executing runs are leased to the worker that claimed them. Runs whose lease expired are
+-- returned to pending with their attempt incremented. Runs already executing have no lease to
+-- renew, so theirs expires right away and the reaper returns them to pending.
+
+ALTER TABLE workflow_runs ADD COLUMN attempt INTEGER DEFAULT 1 NOT NULL;
+ALTER TABLE workflow_runs ADD COLUMN lease_owner TEXT;
+ALTER TABLE workflow_runs ADD COLUMN lease_expires_at INTEGER;
+ALTER TABLE activity_runs ADD COLUMN attempt INTEGER DEFAULT 1 NOT NULL;
+ALTER TABLE activity_runs ADD COLUMN lease_owner TEXT;
+ALTER TABLE activity_runs ADD COLUMN lease_expires_at INTEGER;
+
+UPDATE workflow_runs SET lease_expires_at = updated_at WHERE status = 'executing';
+UPDATE activity_runs SET lease_expires_at = updated_at WHERE status = 'executing';
+
+CREATE INDEX idx_workflow_runs_lease_expires ON workflow_runs (lease_expires_at) WHERE status = 'executing';
+CREATE INDEX idx_activity_runs_lease_expires ON activity_runs (lease_expires_at) WHERE status = 'executing';
@@ -0,0 +1,13 @@
+-- Worker processes register themselves and heartbeat, so operators can see which workers are
+-- alive, what they poll and which build they run. Lists are stored as JSON arrays.
+
+CREATE TABLE workers (
+    id TEXT PRIMARY KEY NOT NULL,
+    hostname TEXT NOT NULL,
+    task_queues TEXT DEFAULT '[]' NOT NULL,
+    workflows TEXT DEFAULT '[]' NOT NULL,
+    activities TEXT DEFAULT '[]' NOT NULL,
+    build_version TEXT NOT NULL,
+    started_at INTEGER NOT NULL,
+    last_heartbeat_at INTEGER NOT NULL
+);
@@ -0,0 +1,5 @@
+-- Runs keep the trace context of the span that started them, so that the spans of workers
+-- executing them later join the same trace.
+
+ALTER TABLE workflow_runs ADD COLUMN trace_context TEXT DEFAULT '{}' NOT NULL;
+ALTER TABLE activity_runs ADD COLUMN trace_context TEXT DEFAULT '{}' NOT NULL;
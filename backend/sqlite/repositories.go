@@ -0,0 +1,1331 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+func now() time.Time {
+	return time.Now()
+}
+
+func toUnix(t time.Time) int64 {
+	return t.UnixNano()
+}
+
+func fromUnix(n int64) time.Time {
+	return time.Unix(0, n)
+}
+
+func rawOrNil(raw *json.RawMessage) any {
+	if raw == nil {
+		return nil
+	}
+	return []byte(*raw)
+}
+
+type workflowRepository struct {
+	tx *sql.Tx
+	// pending records the task queue of each run created or returned to pending.
+	pending func(taskQueue string)
+}
+
+var _ backend.WorkflowRepository = (*workflowRepository)(nil)
+
+const workflowRunColumns = `id, input, workflow_name, status, scheduled_at, created_at, updated_at,
+	closed_at, labels, parent_workflow_run_id, search_attributes, memo, task_queue, attempt, lease_owner,
+	lease_expires_at, trace_context, output, error_message`
+
+func scanWorkflowRun(row interface{ Scan(dest ...any) error }) (*backend.WorkflowRun, error) {
+	var run backend.WorkflowRun
+	var input, labels, searchAttributes, memo, traceContext, output []byte
+	var scheduledAt, createdAt, updatedAt int64
+	var closedAt, leaseExpiresAt sql.NullInt64
+	var parentID, leaseOwner, errorMessage sql.NullString
+	err := row.Scan(&run.ID, &input, &run.WorkflowName, &run.Status, &scheduledAt, &createdAt, &updatedAt,
+		&closedAt, &labels, &parentID, &searchAttributes, &memo, &run.TaskQueue, &run.Attempt, &leaseOwner,
+		&leaseExpiresAt, &traceContext, &output, &errorMessage)
+	if err != nil {
+		return nil, err
+	}
+	if run.TraceContext, err = scanTraceContext(traceContext); err != nil {
+		return nil, fmt.Errorf("failed to decode trace context of workflow run %s: %w", run.ID, err)
+	}
+	run.LeaseOwner, run.LeaseExpiresAt = scanLease(leaseOwner, leaseExpiresAt)
+	run.Input = input
+	run.ScheduledAt = fromUnix(scheduledAt)
+	run.CreatedAt = fromUnix(createdAt)
+	run.UpdatedAt = fromUnix(updatedAt)
+	if closedAt.Valid {
+		t := fromUnix(closedAt.Int64)
+		run.ClosedAt = &t
+	}
+	if err := json.Unmarshal(labels, &run.Labels); err != nil {
+		return nil, fmt.Errorf("failed to decode labels of workflow run %s: %w", run.ID, err)
+	}
+	if parentID.Valid {
+		run.ParentWorkflowRunID = &parentID.String
+	}
+	if err := json.Unmarshal(searchAttributes, &run.SearchAttributes); err != nil {
+		return nil, fmt.Errorf("failed to decode search attributes of workflow run %s: %w", run.ID, err)
+	}
+	run.Memo = memo
+	if output != nil {
+		raw := json.RawMessage(output)
+		run.Output = &raw
+	}
+	if errorMessage.Valid {
+		run.ErrorMessage = &errorMessage.String
+	}
+	return &run, nil
+}
+
+func scanWorkflowRuns(rows *sql.Rows) ([]backend.WorkflowRun, error) {
+	defer rows.Close()
+
+	var runs []backend.WorkflowRun
+	for rows.Next() {
+		run, err := scanWorkflowRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+	return runs, rows.Err()
+}
+
+// pendingConditions filters runs with the pending status on one of taskQueues, or on any task
+// queue when none is given.
+func pendingConditions(pending string, taskQueues []string) (string, []any) {
+	where := "status = ?"
+	args := []any{pending}
+	if len(taskQueues) > 0 {
+		where += " AND task_queue IN (" + strings.Repeat(", ?", len(taskQueues))[2:] + ")"
+		for _, taskQueue := range taskQueues {
+			args = append(args, taskQueue)
+		}
+	}
+	return where, args
+}
+
+func scanTraceContext(raw []byte) (map[string]string, error) {
+	var traceContext map[string]string
+	if err := json.Unmarshal(raw, &traceContext); err != nil {
+		return nil, err
+	}
+	if len(traceContext) == 0 {
+		return nil, nil
+	}
+	return traceContext, nil
+}
+
+// traceContextJSON encodes a trace context, storing none as an empty object.
+func traceContextJSON(traceContext map[string]string) ([]byte, error) {
+	if traceContext == nil {
+		traceContext = map[string]string{}
+	}
+	return json.Marshal(traceContext)
+}
+
+func scanLease(owner sql.NullString, expiresAt sql.NullInt64) (*string, *time.Time) {
+	var leaseOwner *string
+	var leaseExpiresAt *time.Time
+	if owner.Valid {
+		leaseOwner = &owner.String
+	}
+	if expiresAt.Valid {
+		t := fromUnix(expiresAt.Int64)
+		leaseExpiresAt = &t
+	}
+	return leaseOwner, leaseExpiresAt
+}
+
+func unixOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return toUnix(*t)
+}
+
+func (r *workflowRepository) GetNextWorkflowRun(
+	ctx context.Context,
+	taskQueues ...string,
+) (*backend.WorkflowRun, error) {
+	where, args := pendingConditions(string(backend.WorkflowStatusPending), taskQueues)
+	query := `
+		SELECT ` + workflowRunColumns + `
+		FROM workflow_runs
+		WHERE ` + where + `
+		ORDER BY scheduled_at DESC
+		LIMIT 1
+	`
+
+	run, err := scanWorkflowRun(r.tx.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return run, err
+}
+
+func (r *workflowRepository) ClaimWorkflowRun(
+	ctx context.Context,
+	owner string,
+	leaseDuration time.Duration,
+	taskQueues ...string,
+) (*backend.WorkflowRun, error) {
+	// The single connection of the backend serializes transactions, so no other claim can take
+	// the run between reading and updating it.
+	run, err := r.GetNextWorkflowRun(ctx, taskQueues...)
+	if err != nil || run == nil {
+		return nil, err
+	}
+	ts := now()
+	expiresAt := ts.Add(leaseDuration)
+	_, err = r.tx.ExecContext(ctx, `
+		UPDATE workflow_runs
+		SET status = ?, updated_at = ?, lease_owner = ?, lease_expires_at = ?
+		WHERE id = ?
+	`, backend.WorkflowStatusExecuting, toUnix(ts), owner, toUnix(expiresAt), run.ID)
+	if err != nil {
+		return nil, err
+	}
+	run.Status = backend.WorkflowStatusExecuting
+	run.UpdatedAt = ts
+	run.LeaseOwner = &owner
+	run.LeaseExpiresAt = &expiresAt
+	if err := appendEvent(ctx, r.tx, backend.WorkflowRunStatusChangedEvent(run.ID, run.Status)); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *workflowRepository) RenewWorkflowRunLease(
+	ctx context.Context,
+	workflowRunID, owner string,
+	leaseDuration time.Duration,
+) error {
+	return renewLease(ctx, r.tx, "workflow_runs", workflowRunID, owner, leaseDuration,
+		string(backend.WorkflowStatusExecuting))
+}
+
+func (r *workflowRepository) ReleaseWorkflowRunLease(ctx context.Context, workflowRunID, owner string) error {
+	released, err := releaseLease(ctx, r.tx, "workflow_runs", "id", workflowRunID, owner,
+		string(backend.WorkflowStatusExecuting), string(backend.WorkflowStatusPending))
+	if err != nil {
+		return err
+	}
+	r.pending(released.taskQueue)
+	return appendEvent(ctx, r.tx, backend.WorkflowRunStatusChangedEvent(workflowRunID, backend.WorkflowStatusPending))
+}
+
+func (r *workflowRepository) CloseWorkflowRun(
+	ctx context.Context,
+	workflowRunID, owner string,
+	status backend.WorkflowStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) error {
+	ts := toUnix(now())
+	result, err := r.tx.ExecContext(ctx, `
+		UPDATE workflow_runs
+		SET status = ?, updated_at = ?, closed_at = ?, output = ?, error_message = ?,
+			lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status = ? AND lease_owner = ?
+	`, status, ts, ts, rawOrNil(output), errorMessage, workflowRunID, backend.WorkflowStatusExecuting, owner)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %s", backend.ErrLeaseLost, workflowRunID)
+	}
+	return appendEvent(ctx, r.tx, backend.WorkflowRunClosedEvent(workflowRunID, status, output, errorMessage))
+}
+
+func (r *workflowRepository) ResetExpiredWorkflowRuns(ctx context.Context, maxAttempts int) (int64, error) {
+	message := backend.ErrAttemptsExhausted.Error()
+	failed, err := failExpiredLeases(ctx, r.tx, "workflow_runs", "id", "closed_at = ?, error_message = ?",
+		string(backend.WorkflowStatusExecuting), string(backend.WorkflowStatusFailed), maxAttempts,
+		toUnix(now()), message)
+	if err != nil {
+		return 0, err
+	}
+	for _, run := range failed {
+		event := backend.WorkflowRunClosedEvent(run.id, backend.WorkflowStatusFailed, nil, &message)
+		if err := appendEvent(ctx, r.tx, event); err != nil {
+			return 0, err
+		}
+	}
+
+	reset, err := resetExpiredLeases(ctx, r.tx, "workflow_runs", "id",
+		string(backend.WorkflowStatusExecuting), string(backend.WorkflowStatusPending))
+	if err != nil {
+		return 0, err
+	}
+	for _, run := range reset {
+		r.pending(run.taskQueue)
+		event := backend.WorkflowRunStatusChangedEvent(run.id, backend.WorkflowStatusPending)
+		if err := appendEvent(ctx, r.tx, event); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(failed) + len(reset)), nil
+}
+
+// renewLease extends the lease of owner on a run of table with the executing status.
+func renewLease(
+	ctx context.Context,
+	tx *sql.Tx,
+	table, id, owner string,
+	leaseDuration time.Duration,
+	executing string,
+) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE `+table+`
+		SET lease_expires_at = ?
+		WHERE id = ? AND status = ? AND lease_owner = ?
+	`, toUnix(now().Add(leaseDuration)), id, executing, owner)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %s", backend.ErrLeaseLost, id)
+	}
+	return nil
+}
+
+type resetRun struct {
+	id, workflowRunID, taskQueue string
+}
+
+// releaseLease returns a run of table with the executing status and a lease of owner to pending
+// and returns its ID, the workflow run ID column and task queue.
+func releaseLease(
+	ctx context.Context,
+	tx *sql.Tx,
+	table, workflowRunIDColumn, id, owner, executing, pending string,
+) (resetRun, error) {
+	released := resetRun{id: id}
+	err := tx.QueryRowContext(ctx, `
+		UPDATE `+table+`
+		SET status = ?, updated_at = ?, lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status = ? AND lease_owner = ?
+		RETURNING `+workflowRunIDColumn+`, task_queue
+	`, pending, toUnix(now()), id, executing, owner).Scan(&released.workflowRunID, &released.taskQueue)
+	if errors.Is(err, sql.ErrNoRows) {
+		return released, fmt.Errorf("%w: %s", backend.ErrLeaseLost, id)
+	}
+	return released, err
+}
+
+// resetExpiredLeases returns the runs of table with the executing status and an expired lease
+// to pending and returns their ID, the workflow run ID column and task queue.
+func resetExpiredLeases(
+	ctx context.Context,
+	tx *sql.Tx,
+	table, workflowRunIDColumn, executing, pending string,
+) ([]resetRun, error) {
+	ts := toUnix(now())
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE `+table+`
+		SET status = ?, updated_at = ?, attempt = attempt + 1, lease_owner = NULL, lease_expires_at = NULL
+		WHERE status = ? AND lease_expires_at < ?
+		RETURNING id, `+workflowRunIDColumn+`, task_queue
+	`, pending, ts, executing, ts)
+	if err != nil {
+		return nil, err
+	}
+	return scanResetRuns(rows)
+}
+
+// failExpiredLeases closes the runs of table with the executing status, an expired lease and an
+// attempt of at least maxAttempts as failed, also setting the columns of set to setArgs, and
+// returns their ID, the workflow run ID column and task queue. It fails no run unless
+// maxAttempts is positive.
+func failExpiredLeases(
+	ctx context.Context,
+	tx *sql.Tx,
+	table, workflowRunIDColumn, set, executing, failed string,
+	maxAttempts int,
+	setArgs ...any,
+) ([]resetRun, error) {
+	if maxAttempts <= 0 {
+		return nil, nil
+	}
+	ts := toUnix(now())
+	args := append([]any{failed, ts}, setArgs...)
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE `+table+`
+		SET status = ?, updated_at = ?, lease_owner = NULL, lease_expires_at = NULL, `+set+`
+		WHERE status = ? AND lease_expires_at < ? AND attempt >= ?
+		RETURNING id, `+workflowRunIDColumn+`, task_queue
+	`, append(args, executing, ts, maxAttempts)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanResetRuns(rows)
+}
+
+func scanResetRuns(rows *sql.Rows) ([]resetRun, error) {
+	defer rows.Close()
+
+	var reset []resetRun
+	for rows.Next() {
+		var run resetRun
+		if err := rows.Scan(&run.id, &run.workflowRunID, &run.taskQueue); err != nil {
+			return nil, err
+		}
+		reset = append(reset, run)
+	}
+	return reset, rows.Err()
+}
+
+func (r *workflowRepository) GetWorkflow(ctx context.Context, name string) (*backend.Workflow, error) {
+	query := `
+		SELECT name, created_at, updated_at
+		FROM workflows
+		WHERE name = ?
+	`
+
+	var workflow backend.Workflow
+	var createdAt, updatedAt int64
+	err := r.tx.QueryRowContext(ctx, query, name).Scan(&workflow.Name, &createdAt, &updatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	workflow.CreatedAt = fromUnix(createdAt)
+	workflow.UpdatedAt = fromUnix(updatedAt)
+	return &workflow, nil
+}
+
+func (r *workflowRepository) ListWorkflows(ctx context.Context) ([]backend.Workflow, error) {
+	rows, err := r.tx.QueryContext(ctx, `
+		SELECT name, created_at, updated_at
+		FROM workflows
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workflows []backend.Workflow
+	for rows.Next() {
+		var workflow backend.Workflow
+		var createdAt, updatedAt int64
+		if err := rows.Scan(&workflow.Name, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		workflow.CreatedAt = fromUnix(createdAt)
+		workflow.UpdatedAt = fromUnix(updatedAt)
+		workflows = append(workflows, workflow)
+	}
+	return workflows, rows.Err()
+}
+
+func (r *workflowRepository) GetWorkflowRun(ctx context.Context, workflowRunID string) (*backend.WorkflowRun, error) {
+	query := `
+		SELECT ` + workflowRunColumns + `
+		FROM workflow_runs
+		WHERE id = ?
+	`
+
+	run, err := scanWorkflowRun(r.tx.QueryRowContext(ctx, query, workflowRunID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return run, err
+}
+
+func (r *workflowRepository) UpsertWorkflow(ctx context.Context, workflow *backend.Workflow) error {
+	query := `
+		INSERT INTO workflows (name, created_at, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET
+			updated_at = excluded.updated_at
+	`
+
+	_, err := r.tx.ExecContext(ctx, query, workflow.Name, toUnix(workflow.CreatedAt), toUnix(workflow.UpdatedAt))
+	return err
+}
+
+func (r *workflowRepository) CreateWorkflowRun(ctx context.Context, workflowRun *backend.WorkflowRun) error {
+	query := `
+		INSERT INTO workflow_runs (` + workflowRunColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	if workflowRun.TaskQueue == "" {
+		workflowRun.TaskQueue = backend.DefaultTaskQueue
+	}
+	if workflowRun.Attempt == 0 {
+		workflowRun.Attempt = 1
+	}
+	labels := workflowRun.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	searchAttributes := workflowRun.SearchAttributes
+	if searchAttributes == nil {
+		searchAttributes = map[string]any{}
+	}
+	searchAttributesJSON, err := json.Marshal(searchAttributes)
+	if err != nil {
+		return err
+	}
+	memo := []byte(workflowRun.Memo)
+	if len(memo) == 0 {
+		memo = []byte("{}")
+	}
+	traceContext, err := traceContextJSON(workflowRun.TraceContext)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.tx.ExecContext(ctx, query,
+		workflowRun.ID,
+		[]byte(workflowRun.Input),
+		workflowRun.WorkflowName,
+		workflowRun.Status,
+		toUnix(workflowRun.ScheduledAt),
+		toUnix(workflowRun.CreatedAt),
+		toUnix(workflowRun.UpdatedAt),
+		unixOrNil(workflowRun.ClosedAt),
+		labelsJSON,
+		workflowRun.ParentWorkflowRunID,
+		searchAttributesJSON,
+		memo,
+		workflowRun.TaskQueue,
+		workflowRun.Attempt,
+		workflowRun.LeaseOwner,
+		unixOrNil(workflowRun.LeaseExpiresAt),
+		traceContext,
+		rawOrNil(workflowRun.Output),
+		workflowRun.ErrorMessage,
+	)
+	if err != nil {
+		return err
+	}
+	r.pending(workflowRun.TaskQueue)
+	return appendEvent(ctx, r.tx, backend.WorkflowRunCreatedEvent(workflowRun))
+}
+
+func (r *workflowRepository) ChangeWorkflowRunStatus(
+	ctx context.Context,
+	workflowRunID string,
+	status backend.WorkflowStatus,
+) error {
+	query := `
+		UPDATE workflow_runs
+		SET status = ?, updated_at = ?, closed_at = CASE WHEN ? THEN COALESCE(closed_at, ?) END
+		WHERE id = ?
+	`
+
+	ts := toUnix(now())
+	result, err := r.tx.ExecContext(ctx, query, status, ts, status.IsClosed(), ts, workflowRunID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return err
+	}
+	return appendEvent(ctx, r.tx, backend.WorkflowRunStatusChangedEvent(workflowRunID, status))
+}
+
+func (r *workflowRepository) UpdateWorkflowRunAttributes(
+	ctx context.Context,
+	workflowRunID string,
+	searchAttributes map[string]any,
+	memo json.RawMessage,
+) error {
+	var currentMemo []byte
+	err := r.tx.QueryRowContext(ctx, `SELECT memo FROM workflow_runs WHERE id = ?`, workflowRunID).Scan(&currentMemo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("workflow run %s does not exist", workflowRunID)
+	}
+	if err != nil {
+		return err
+	}
+	if len(memo) > 0 {
+		// json_patch would merge the payload documents of existing keys instead of replacing them.
+		if currentMemo, err = mergeMemo(currentMemo, memo); err != nil {
+			return err
+		}
+	}
+	searchAttributesJSON := []byte("{}")
+	if len(searchAttributes) > 0 {
+		if searchAttributesJSON, err = json.Marshal(searchAttributes); err != nil {
+			return err
+		}
+	}
+
+	_, err = r.tx.ExecContext(ctx, `
+		UPDATE workflow_runs
+		SET search_attributes = json_patch(search_attributes, ?), memo = ?, updated_at = ?
+		WHERE id = ?
+	`, searchAttributesJSON, currentMemo, toUnix(now()), workflowRunID)
+	if err != nil {
+		return err
+	}
+	return appendEvent(ctx, r.tx, backend.WorkflowRunAttributesUpdatedEvent(workflowRunID, searchAttributes, memo))
+}
+
+// mergeMemo adds the top-level fields of the JSON object update to the JSON object memo.
+func mergeMemo(memo, update []byte) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(memo, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode memo: %w", err)
+	}
+	var updates map[string]json.RawMessage
+	if err := json.Unmarshal(update, &updates); err != nil {
+		return nil, fmt.Errorf("failed to decode memo update: %w", err)
+	}
+	maps.Copy(fields, updates)
+	return json.Marshal(fields)
+}
+
+func (r *workflowRepository) DeleteWorkflowRuns(
+	ctx context.Context,
+	deletion backend.WorkflowRunDeletion,
+) ([]string, error) {
+	workflowRunIDs, err := r.selectDeletedRuns(ctx, deletion)
+	if err != nil || len(workflowRunIDs) == 0 {
+		return nil, err
+	}
+	placeholders := strings.Repeat(", ?", len(workflowRunIDs))[2:]
+	args := make([]any, len(workflowRunIDs))
+	for i, id := range workflowRunIDs {
+		args[i] = id
+	}
+
+	// Rows referencing the runs go first, so the foreign keys hold after every statement.
+	statements := []string{
+		`DELETE FROM workflow_events WHERE workflow_run_id IN (` + placeholders + `)`,
+		`DELETE FROM activity_runs WHERE workflow_run_id IN (` + placeholders + `)`,
+		`UPDATE workflow_runs SET parent_workflow_run_id = NULL WHERE parent_workflow_run_id IN (` + placeholders + `)`,
+	}
+	for _, statement := range statements {
+		if _, err := r.tx.ExecContext(ctx, statement, args...); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.tx.ExecContext(ctx, `DELETE FROM workflow_runs WHERE id IN (`+placeholders+`)`, args...); err != nil {
+		return nil, err
+	}
+	return workflowRunIDs, nil
+}
+
+// selectDeletedRuns returns the IDs of the runs deletion selects. Transactions are serialized,
+// so the runs cannot change before they are deleted.
+func (r *workflowRepository) selectDeletedRuns(
+	ctx context.Context,
+	deletion backend.WorkflowRunDeletion,
+) ([]string, error) {
+	if len(deletion.WorkflowRunIDs) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.Repeat(", ?", len(deletion.WorkflowRunIDs))[2:]
+	args := make([]any, len(deletion.WorkflowRunIDs))
+	for i, id := range deletion.WorkflowRunIDs {
+		args[i] = id
+	}
+	rows, err := r.tx.QueryContext(ctx, `
+		SELECT id, closed_at, last_event_sequence
+		FROM workflow_runs
+		WHERE id IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		var closedAtUnix sql.NullInt64
+		var lastEventSequence int64
+		if err := rows.Scan(&id, &closedAtUnix, &lastEventSequence); err != nil {
+			return nil, err
+		}
+		var closedAt *time.Time
+		if closedAtUnix.Valid {
+			t := fromUnix(closedAtUnix.Int64)
+			closedAt = &t
+		}
+		if deletion.Selects(id, closedAt, lastEventSequence) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+func (r *workflowRepository) ListWorkflowRuns(
+	ctx context.Context,
+	query backend.WorkflowRunQuery,
+	after *backend.WorkflowRunCursor,
+	limit int,
+) ([]backend.WorkflowRun, error) {
+	where, args, err := workflowRunConditions(query)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		where = append(where, "(created_at, id) < (?, ?)")
+		args = append(args, toUnix(after.CreatedAt), after.ID)
+	}
+	args = append(args, limit)
+
+	rows, err := r.tx.QueryContext(ctx, `
+		SELECT `+workflowRunColumns+`
+		FROM workflow_runs
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanWorkflowRuns(rows)
+}
+
+func (r *workflowRepository) CountWorkflowRuns(ctx context.Context, query backend.WorkflowRunQuery) (int64, error) {
+	where, args, err := workflowRunConditions(query)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = r.tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM workflow_runs
+		WHERE `+strings.Join(where, " AND "), args...).Scan(&count)
+	return count, err
+}
+
+// workflowRunConditions translates query into SQL conditions and their positional arguments.
+func workflowRunConditions(query backend.WorkflowRunQuery) ([]string, []any, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	where := []string{"1 = 1"}
+	var args []any
+
+	if query.WorkflowName != "" {
+		where = append(where, "workflow_name = ?")
+		args = append(args, query.WorkflowName)
+	}
+	if len(query.Statuses) > 0 {
+		placeholders := make([]string, len(query.Statuses))
+		for i, status := range query.Statuses {
+			placeholders[i] = "?"
+			args = append(args, status)
+		}
+		where = append(where, "status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	timeRanges := []struct {
+		column        string
+		after, before time.Time
+	}{
+		{"created_at", query.CreatedAfter, query.CreatedBefore},
+		{"scheduled_at", query.ScheduledAfter, query.ScheduledBefore},
+		{"closed_at", query.ClosedAfter, query.ClosedBefore},
+	}
+	for _, tr := range timeRanges {
+		if !tr.after.IsZero() {
+			where = append(where, tr.column+" >= ?")
+			args = append(args, toUnix(tr.after))
+		}
+		if !tr.before.IsZero() {
+			where = append(where, tr.column+" < ?")
+			args = append(args, toUnix(tr.before))
+		}
+	}
+
+	for key, value := range query.Labels {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(labels) WHERE key = ? AND value = ?)")
+		args = append(args, key, value)
+	}
+	if query.ParentWorkflowRunID != "" {
+		where = append(where, "parent_workflow_run_id = ?")
+		args = append(args, query.ParentWorkflowRunID)
+	}
+	for _, condition := range query.SearchAttributes {
+		// Normalized keys are identifiers, so the path needs no quoting. The type check keeps
+		// SQLite from ordering values of different types instead of not matching them.
+		path := "$." + condition.Key
+		var jsonTypes string
+		switch condition.Value.(type) {
+		case string:
+			jsonTypes = "'text'"
+		case float64:
+			jsonTypes = "'integer', 'real'"
+		case bool:
+			jsonTypes = "'true', 'false'"
+		}
+		where = append(where, fmt.Sprintf(
+			"json_type(search_attributes, ?) IN (%s) AND json_extract(search_attributes, ?) %s ?",
+			jsonTypes, condition.Operator))
+		args = append(args, path, path, condition.Value)
+	}
+	return where, args, nil
+}
+
+type activityRunRepository struct {
+	tx      *sql.Tx
+	pending func(taskQueue string)
+}
+
+var _ backend.ActivityRunRepository = (*activityRunRepository)(nil)
+
+const activityRunColumns = `id, activity_name, workflow_run_id, error_message, input, output,
+	status, retry_status, scheduled_at, created_at, updated_at, task_queue, attempt, lease_owner, lease_expires_at,
+	trace_context`
+
+func scanActivityRun(row interface{ Scan(dest ...any) error }) (*backend.ActivityRun, error) {
+	var run backend.ActivityRun
+	var errorMessage, leaseOwner sql.NullString
+	var input, output, retryStatus, traceContext []byte
+	var scheduledAt, createdAt, updatedAt int64
+	var leaseExpiresAt sql.NullInt64
+	err := row.Scan(&run.ID, &run.ActivityName, &run.WorkflowRunID, &errorMessage, &input, &output,
+		&run.Status, &retryStatus, &scheduledAt, &createdAt, &updatedAt, &run.TaskQueue, &run.Attempt, &leaseOwner,
+		&leaseExpiresAt, &traceContext)
+	if err != nil {
+		return nil, err
+	}
+	if run.TraceContext, err = scanTraceContext(traceContext); err != nil {
+		return nil, fmt.Errorf("failed to decode trace context of activity run %s: %w", run.ID, err)
+	}
+	run.LeaseOwner, run.LeaseExpiresAt = scanLease(leaseOwner, leaseExpiresAt)
+	if errorMessage.Valid {
+		run.ErrorMessage = &errorMessage.String
+	}
+	run.Input = input
+	if output != nil {
+		raw := json.RawMessage(output)
+		run.Output = &raw
+	}
+	if retryStatus != nil {
+		raw := json.RawMessage(retryStatus)
+		run.RetryStatus = &raw
+	}
+	run.ScheduledAt = fromUnix(scheduledAt)
+	run.CreatedAt = fromUnix(createdAt)
+	run.UpdatedAt = fromUnix(updatedAt)
+	return &run, nil
+}
+
+func (r *activityRunRepository) GetNextActivityRun(
+	ctx context.Context,
+	taskQueues ...string,
+) (*backend.ActivityRun, error) {
+	where, args := pendingConditions(string(backend.ActivityStatusPending), taskQueues)
+	query := `
+		SELECT ` + activityRunColumns + `
+		FROM activity_runs
+		WHERE ` + where + `
+		ORDER BY scheduled_at DESC
+		LIMIT 1
+	`
+
+	run, err := scanActivityRun(r.tx.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return run, err
+}
+
+func (r *activityRunRepository) ClaimActivityRun(
+	ctx context.Context,
+	owner string,
+	leaseDuration time.Duration,
+	taskQueues ...string,
+) (*backend.ActivityRun, error) {
+	run, err := r.GetNextActivityRun(ctx, taskQueues...)
+	if err != nil || run == nil {
+		return nil, err
+	}
+	ts := now()
+	expiresAt := ts.Add(leaseDuration)
+	_, err = r.tx.ExecContext(ctx, `
+		UPDATE activity_runs
+		SET status = ?, updated_at = ?, lease_owner = ?, lease_expires_at = ?
+		WHERE id = ?
+	`, backend.ActivityStatusExecuting, toUnix(ts), owner, toUnix(expiresAt), run.ID)
+	if err != nil {
+		return nil, err
+	}
+	run.Status = backend.ActivityStatusExecuting
+	run.UpdatedAt = ts
+	run.LeaseOwner = &owner
+	run.LeaseExpiresAt = &expiresAt
+	event := backend.ActivityRunStatusChangedEvent(run.WorkflowRunID, run.ID, run.Status)
+	if err := appendEvent(ctx, r.tx, event); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *activityRunRepository) RenewActivityRunLease(
+	ctx context.Context,
+	activityRunID, owner string,
+	leaseDuration time.Duration,
+) error {
+	return renewLease(ctx, r.tx, "activity_runs", activityRunID, owner, leaseDuration,
+		string(backend.ActivityStatusExecuting))
+}
+
+func (r *activityRunRepository) ReleaseActivityRunLease(ctx context.Context, activityRunID, owner string) error {
+	released, err := releaseLease(ctx, r.tx, "activity_runs", "workflow_run_id", activityRunID, owner,
+		string(backend.ActivityStatusExecuting), string(backend.ActivityStatusPending))
+	if err != nil {
+		return err
+	}
+	r.pending(released.taskQueue)
+	event := backend.ActivityRunStatusChangedEvent(released.workflowRunID, activityRunID, backend.ActivityStatusPending)
+	return appendEvent(ctx, r.tx, event)
+}
+
+func (r *activityRunRepository) CloseActivityRun(
+	ctx context.Context,
+	activityRunID, owner string,
+	status backend.ActivityStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) error {
+	var workflowRunID string
+	err := r.tx.QueryRowContext(ctx, `
+		UPDATE activity_runs
+		SET status = ?, updated_at = ?, output = ?, error_message = ?, lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status = ? AND lease_owner = ?
+		RETURNING workflow_run_id
+	`, status, toUnix(now()), rawOrNil(output), errorMessage, activityRunID, backend.ActivityStatusExecuting,
+		owner).Scan(&workflowRunID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", backend.ErrLeaseLost, activityRunID)
+	}
+	if err != nil {
+		return err
+	}
+	event := backend.ActivityRunClosedEvent(workflowRunID, activityRunID, status, output, errorMessage)
+	return appendEvent(ctx, r.tx, event)
+}
+
+func (r *activityRunRepository) ResetExpiredActivityRuns(ctx context.Context, maxAttempts int) (int64, error) {
+	message := backend.ErrAttemptsExhausted.Error()
+	failed, err := failExpiredLeases(ctx, r.tx, "activity_runs", "workflow_run_id", "error_message = ?",
+		string(backend.ActivityStatusExecuting), string(backend.ActivityStatusFailed), maxAttempts, message)
+	if err != nil {
+		return 0, err
+	}
+	for _, run := range failed {
+		event := backend.ActivityRunClosedEvent(run.workflowRunID, run.id, backend.ActivityStatusFailed, nil, &message)
+		if err := appendEvent(ctx, r.tx, event); err != nil {
+			return 0, err
+		}
+	}
+
+	reset, err := resetExpiredLeases(ctx, r.tx, "activity_runs", "workflow_run_id",
+		string(backend.ActivityStatusExecuting), string(backend.ActivityStatusPending))
+	if err != nil {
+		return 0, err
+	}
+	for _, run := range reset {
+		r.pending(run.taskQueue)
+		event := backend.ActivityRunStatusChangedEvent(run.workflowRunID, run.id, backend.ActivityStatusPending)
+		if err := appendEvent(ctx, r.tx, event); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(failed) + len(reset)), nil
+}
+
+func (r *activityRunRepository) GetActivityRunHistory(
+	ctx context.Context,
+	workflowRunId string,
+) ([]backend.ActivityRun, error) {
+	query := `
+		SELECT ` + activityRunColumns + `
+		FROM activity_runs
+		WHERE workflow_run_id = ?
+		ORDER BY created_at ASC
+	`
+
+	rows, err := r.tx.QueryContext(ctx, query, workflowRunId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activities []backend.ActivityRun
+	for rows.Next() {
+		run, err := scanActivityRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, *run)
+	}
+	return activities, rows.Err()
+}
+
+func (r *activityRunRepository) CreateActivityRun(ctx context.Context, activityRun *backend.ActivityRun) error {
+	query := `
+		INSERT INTO activity_runs (` + activityRunColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+			COALESCE(NULLIF(?, ''), (SELECT task_queue FROM workflow_runs WHERE id = ?), ?), ?, ?, ?,
+			COALESCE(NULLIF(?, '{}'), (SELECT trace_context FROM workflow_runs WHERE id = ?), '{}'))
+		RETURNING task_queue, trace_context
+	`
+
+	if activityRun.Attempt == 0 {
+		activityRun.Attempt = 1
+	}
+	traceContext, err := traceContextJSON(activityRun.TraceContext)
+	if err != nil {
+		return err
+	}
+	var storedTraceContext []byte
+	err = r.tx.QueryRowContext(ctx, query,
+		activityRun.ID,
+		activityRun.ActivityName,
+		activityRun.WorkflowRunID,
+		activityRun.ErrorMessage,
+		[]byte(activityRun.Input),
+		rawOrNil(activityRun.Output),
+		activityRun.Status,
+		rawOrNil(activityRun.RetryStatus),
+		toUnix(activityRun.ScheduledAt),
+		toUnix(activityRun.CreatedAt),
+		toUnix(activityRun.UpdatedAt),
+		activityRun.TaskQueue,
+		activityRun.WorkflowRunID,
+		backend.DefaultTaskQueue,
+		activityRun.Attempt,
+		activityRun.LeaseOwner,
+		unixOrNil(activityRun.LeaseExpiresAt),
+		// A string, since SQLite never considers a blob equal to the text '{}'.
+		string(traceContext),
+		activityRun.WorkflowRunID,
+	).Scan(&activityRun.TaskQueue, &storedTraceContext)
+	if err != nil {
+		return err
+	}
+	if activityRun.TraceContext, err = scanTraceContext(storedTraceContext); err != nil {
+		return err
+	}
+	r.pending(activityRun.TaskQueue)
+	return appendEvent(ctx, r.tx, backend.ActivityRunCreatedEvent(activityRun))
+}
+
+func (r *activityRunRepository) ChangeActivityRunStatus(
+	ctx context.Context,
+	activityRunID string,
+	status backend.ActivityStatus,
+) error {
+	query := `
+		UPDATE activity_runs
+		SET status = ?, updated_at = ?
+		WHERE id = ?
+		RETURNING workflow_run_id
+	`
+
+	var workflowRunID string
+	err := r.tx.QueryRowContext(ctx, query, status, toUnix(now()), activityRunID).Scan(&workflowRunID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	return appendEvent(ctx, r.tx, backend.ActivityRunStatusChangedEvent(workflowRunID, activityRunID, status))
+}
+
+func (r *activityRunRepository) GetActivityRun(
+	ctx context.Context,
+	activityRunID string,
+) (*backend.ActivityRun, error) {
+	query := `
+		SELECT ` + activityRunColumns + `
+		FROM activity_runs
+		WHERE id = ?
+	`
+
+	run, err := scanActivityRun(r.tx.QueryRowContext(ctx, query, activityRunID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return run, err
+}
+
+type workflowEventRepository struct {
+	tx *sql.Tx
+}
+
+var _ backend.WorkflowEventRepository = (*workflowEventRepository)(nil)
+
+func (r *workflowEventRepository) AppendWorkflowEvent(ctx context.Context, event *backend.WorkflowEvent) error {
+	return appendEvent(ctx, r.tx, event)
+}
+
+func appendEvent(ctx context.Context, tx *sql.Tx, event *backend.WorkflowEvent) error {
+	err := tx.QueryRowContext(ctx, `
+		UPDATE workflow_runs
+		SET last_event_sequence = last_event_sequence + 1
+		WHERE id = ?
+		RETURNING last_event_sequence
+	`, event.WorkflowRunID).Scan(&event.Sequence)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("workflow run %s does not exist", event.WorkflowRunID)
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workflow_events (workflow_run_id, sequence, event_type, activity_run_id, payload, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`,
+		event.WorkflowRunID,
+		event.Sequence,
+		event.EventType,
+		event.ActivityRunID,
+		[]byte(event.Payload),
+		toUnix(event.CreatedAt),
+	)
+	return err
+}
+
+func (r *workflowEventRepository) GetWorkflowEvents(
+	ctx context.Context,
+	workflowRunID string,
+	afterSequence int64,
+) ([]backend.WorkflowEvent, error) {
+	query := `
+		SELECT workflow_run_id, sequence, event_type, activity_run_id, payload, created_at
+		FROM workflow_events
+		WHERE workflow_run_id = ? AND sequence > ?
+		ORDER BY sequence ASC
+	`
+
+	rows, err := r.tx.QueryContext(ctx, query, workflowRunID, afterSequence)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []backend.WorkflowEvent
+	for rows.Next() {
+		var event backend.WorkflowEvent
+		var activityRunID sql.NullString
+		var payload []byte
+		var createdAt int64
+		err := rows.Scan(&event.WorkflowRunID, &event.Sequence, &event.EventType, &activityRunID, &payload, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		if activityRunID.Valid {
+			event.ActivityRunID = &activityRunID.String
+		}
+		event.Payload = payload
+		event.CreatedAt = fromUnix(createdAt)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r *workflowEventRepository) ReplaceWorkflowEvents(
+	ctx context.Context,
+	workflowRunID string,
+	events []backend.WorkflowEvent,
+) error {
+	result, err := r.tx.ExecContext(ctx, `
+		UPDATE workflow_runs SET last_event_sequence = ? WHERE id = ?
+	`, len(events), workflowRunID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("workflow run %s does not exist", workflowRunID)
+	}
+	_, err = r.tx.ExecContext(ctx, `DELETE FROM workflow_events WHERE workflow_run_id = ?`, workflowRunID)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		_, err := r.tx.ExecContext(ctx, `
+			INSERT INTO workflow_events (workflow_run_id, sequence, event_type, activity_run_id, payload, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`,
+			workflowRunID,
+			event.Sequence,
+			event.EventType,
+			event.ActivityRunID,
+			[]byte(event.Payload),
+			toUnix(event.CreatedAt),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type workerRepository struct {
+	tx *sql.Tx
+}
+
+var _ backend.WorkerRepository = (*workerRepository)(nil)
+
+const workerColumns = `id, hostname, task_queues, workflows, activities, build_version, started_at,
+	last_heartbeat_at`
+
+func (r *workerRepository) RegisterWorker(ctx context.Context, worker *backend.Worker) error {
+	lists := make([][]byte, 3)
+	for i, list := range [][]string{worker.TaskQueues, worker.Workflows, worker.Activities} {
+		if list == nil {
+			list = []string{}
+		}
+		data, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+		lists[i] = data
+	}
+
+	_, err := r.tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO workers (`+workerColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, worker.ID, worker.Hostname, lists[0], lists[1], lists[2], worker.BuildVersion,
+		toUnix(worker.StartedAt), toUnix(worker.LastHeartbeatAt))
+	return err
+}
+
+func (r *workerRepository) RecordWorkerHeartbeat(ctx context.Context, workerID string) error {
+	result, err := r.tx.ExecContext(ctx, `UPDATE workers SET last_heartbeat_at = ? WHERE id = ?`,
+		toUnix(now()), workerID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %s", backend.ErrWorkerNotFound, workerID)
+	}
+	return nil
+}
+
+func (r *workerRepository) DeregisterWorker(ctx context.Context, workerID string) error {
+	_, err := r.tx.ExecContext(ctx, `DELETE FROM workers WHERE id = ?`, workerID)
+	return err
+}
+
+func (r *workerRepository) ListWorkers(ctx context.Context) ([]backend.Worker, error) {
+	rows, err := r.tx.QueryContext(ctx, `SELECT `+workerColumns+` FROM workers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workers []backend.Worker
+	for rows.Next() {
+		var worker backend.Worker
+		var taskQueues, workflows, activities []byte
+		var startedAt, lastHeartbeatAt int64
+		err := rows.Scan(&worker.ID, &worker.Hostname, &taskQueues, &workflows, &activities,
+			&worker.BuildVersion, &startedAt, &lastHeartbeatAt)
+		if err != nil {
+			return nil, err
+		}
+		lists := []*[]string{&worker.TaskQueues, &worker.Workflows, &worker.Activities}
+		for i, data := range [][]byte{taskQueues, workflows, activities} {
+			if err := json.Unmarshal(data, lists[i]); err != nil {
+				return nil, fmt.Errorf("failed to decode worker %s: %w", worker.ID, err)
+			}
+		}
+		worker.StartedAt = fromUnix(startedAt)
+		worker.LastHeartbeatAt = fromUnix(lastHeartbeatAt)
+		workers = append(workers, worker)
+	}
+	return workers, rows.Err()
+}
+
+func (r *workerRepository) DeleteInactiveWorkers(ctx context.Context, aliveWithin time.Duration) (int64, error) {
+	result, err := r.tx.ExecContext(ctx, `DELETE FROM workers WHERE last_heartbeat_at < ?`,
+		toUnix(now().Add(-aliveWithin)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *workerRepository) ListTaskQueues(
+	ctx context.Context,
+	aliveWithin time.Duration,
+) ([]backend.TaskQueueInfo, error) {
+	rows, err := r.tx.QueryContext(ctx, `
+		SELECT name, SUM(pollers), SUM(workflow_runs), SUM(activity_runs), MIN(scheduled_at)
+		FROM (
+			SELECT q.value AS name, w.last_heartbeat_at >= ? AS pollers, 0 AS workflow_runs, 0 AS activity_runs,
+				   NULL AS scheduled_at
+			FROM workers w, json_each(w.task_queues) q
+			UNION ALL
+			SELECT task_queue, 0, 1, 0, scheduled_at FROM workflow_runs WHERE status = ?
+			UNION ALL
+			SELECT task_queue, 0, 0, 1, scheduled_at FROM activity_runs WHERE status = ?
+		)
+		GROUP BY name
+		ORDER BY name
+	`, toUnix(now().Add(-aliveWithin)), backend.WorkflowStatusPending, backend.ActivityStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queues []backend.TaskQueueInfo
+	for rows.Next() {
+		var info backend.TaskQueueInfo
+		var oldestPending sql.NullInt64
+		err := rows.Scan(&info.Name, &info.Pollers, &info.PendingWorkflowRuns, &info.PendingActivityRuns, &oldestPending)
+		if err != nil {
+			return nil, err
+		}
+		if oldestPending.Valid {
+			scheduledAt := fromUnix(oldestPending.Int64)
+			info.OldestPendingScheduledAt = &scheduledAt
+		}
+		queues = append(queues, info)
+	}
+	return queues, rows.Err()
+}
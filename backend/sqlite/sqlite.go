@@ -0,0 +1,201 @@
+// Package sqlite implements backend.Backend on SQLite using a pure-Go driver, for single-node
+// and embedded deployments.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/url"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/internal/db"
+
+	// Registers the "sqlite" database/sql driver.
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// Backend serializes all access through a single connection, so a transaction that reads the
+// next pending run and changes its status cannot interleave with another one in the same process.
+// Running several processes against the same database file is not supported.
+type Backend struct {
+	db    *sql.DB
+	tasks *backend.TaskBroadcaster
+}
+
+var (
+	_ backend.Backend      = (*Backend)(nil)
+	_ backend.TaskNotifier = (*Backend)(nil)
+)
+
+// Open opens or creates the database file at path. Use ":memory:" for a throwaway database.
+func Open(path string) (*Backend, error) {
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma": {"foreign_keys(1)", "busy_timeout(5000)"},
+	}.Encode()
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// A single connection also keeps ":memory:" databases alive, since they live only as long as their connection.
+	sqlDB.SetMaxOpenConns(1)
+	return &Backend{db: sqlDB, tasks: backend.NewTaskBroadcaster()}, nil
+}
+
+func (b *Backend) Close() error {
+	return b.db.Close()
+}
+
+func (b *Backend) Init(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS pitlane_schema_migrations (
+			version INTEGER PRIMARY KEY NOT NULL,
+			name TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	current, err := b.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("%w: database is at version %d, newer than supported version %d",
+			db.ErrSchemaVersionMismatch, current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		if err := b.apply(ctx, m); err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func (b *Backend) apply(ctx context.Context, m db.Migration) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO pitlane_schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, toUnix(now()),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *Backend) Verify(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	current, err := b.schemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current != len(migrations) {
+		return fmt.Errorf("%w: database is at version %d, expected %d",
+			db.ErrSchemaVersionMismatch, current, len(migrations))
+	}
+	return nil
+}
+
+// schemaVersion returns the highest applied migration, or 0 for a database that was never migrated.
+func (b *Backend) schemaVersion(ctx context.Context) (int, error) {
+	var exists bool
+	err := b.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'pitlane_schema_migrations')`,
+	).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = b.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM pitlane_schema_migrations`).Scan(&version)
+	return version, err
+}
+
+func (b *Backend) RunInTx(ctx context.Context, fn func(tx backend.Tx) error) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	sqliteTx := &sqliteTx{tx: tx}
+	if err := fn(sqliteTx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	b.tasks.Notify(sqliteTx.pendingOn...)
+	return nil
+}
+
+// ListenForTasks notifies of runs created through this Backend only, which is the only one
+// allowed to use the database.
+func (b *Backend) ListenForTasks(_ context.Context, taskQueues []string) (backend.TaskListener, error) {
+	return b.tasks.Listen(taskQueues), nil
+}
+
+func loadMigrations() ([]db.Migration, error) {
+	fsys, err := fs.Sub(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return db.ReadMigrations(fsys)
+}
+
+type sqliteTx struct {
+	tx *sql.Tx
+	// pendingOn lists the task queues of the runs created or returned to pending in the
+	// transaction.
+	pendingOn []string
+}
+
+func (t *sqliteTx) WorkflowRepository() backend.WorkflowRepository {
+	return &workflowRepository{tx: t.tx, pending: t.pending}
+}
+
+func (t *sqliteTx) ActivityRunRepository() backend.ActivityRunRepository {
+	return &activityRunRepository{tx: t.tx, pending: t.pending}
+}
+
+func (t *sqliteTx) WorkerRepository() backend.WorkerRepository {
+	return &workerRepository{tx: t.tx}
+}
+
+func (t *sqliteTx) pending(taskQueue string) {
+	t.pendingOn = append(t.pendingOn, taskQueue)
+}
+
+func (t *sqliteTx) WorkflowEventRepository() backend.WorkflowEventRepository {
+	return &workflowEventRepository{tx: t.tx}
+}
@@ -0,0 +1,937 @@
+package sqlite_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/backend/sqlite"
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openBackend(t *testing.T) *sqlite.Backend {
+	t.Helper()
+	b, err := sqlite.Open(filepath.Join(t.TempDir(), "pitlane.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = b.Close()
+	})
+	return b
+}
+
+func TestBackend_Init(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+
+	require.ErrorIs(t, b.Verify(ctx), db.ErrSchemaVersionMismatch)
+	require.NoError(t, b.Init(ctx))
+	require.NoError(t, b.Init(ctx))
+	require.NoError(t, b.Verify(ctx))
+}
+
+func TestBackend_Repositories(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		workflowRepo := tx.WorkflowRepository()
+		require.NoError(t, workflowRepo.UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, workflowRepo.UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now.Add(time.Second),
+		}))
+		workflow, err := workflowRepo.GetWorkflow(ctx, "test-workflow")
+		require.NoError(t, err)
+		require.NotNil(t, workflow)
+		assert.True(t, now.Equal(workflow.CreatedAt))
+		assert.True(t, now.Add(time.Second).Equal(workflow.UpdatedAt))
+
+		require.NoError(t, workflowRepo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        json.RawMessage(`{"test": "input"}`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}))
+		err = workflowRepo.CreateWorkflowRun(ctx, &backend.WorkflowRun{ID: "run-2", WorkflowName: "unknown"})
+		require.Error(t, err)
+
+		run, err := workflowRepo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		require.NotNil(t, run)
+		assert.True(t, now.Equal(run.ScheduledAt))
+		run, err = workflowRepo.GetWorkflowRun(ctx, "missing")
+		require.NoError(t, err)
+		assert.Nil(t, run)
+
+		next, err := workflowRepo.GetNextWorkflowRun(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, next)
+		assert.Equal(t, "run-1", next.ID)
+		assert.JSONEq(t, `{"test": "input"}`, string(next.Input))
+
+		activityRepo := tx.ActivityRunRepository()
+		output := json.RawMessage(`"done"`)
+		require.NoError(t, activityRepo.CreateActivityRun(ctx, &backend.ActivityRun{
+			ID:            "activity-1",
+			ActivityName:  "test-activity",
+			WorkflowRunID: "run-1",
+			Input:         json.RawMessage(`{"test": "data"}`),
+			Output:        &output,
+			Status:        backend.ActivityStatusPending,
+			ScheduledAt:   now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}))
+		require.NoError(t, activityRepo.ChangeActivityRunStatus(ctx, "activity-1", backend.ActivityStatusFinished))
+
+		activity, err := activityRepo.GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		require.NotNil(t, activity)
+		assert.Equal(t, backend.ActivityStatusFinished, activity.Status)
+		assert.Nil(t, activity.ErrorMessage)
+		require.NotNil(t, activity.Output)
+		assert.JSONEq(t, `"done"`, string(*activity.Output))
+
+		history, err := activityRepo.GetActivityRunHistory(ctx, "run-1")
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+
+		nextActivity, err := activityRepo.GetNextActivityRun(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, nextActivity)
+
+		events, err := tx.WorkflowEventRepository().GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+		assert.Equal(t, backend.WorkflowEventRunCreated, events[0].EventType)
+		assert.Equal(t, backend.WorkflowEventActivityRunCreated, events[1].EventType)
+		assert.Equal(t, backend.WorkflowEventActivityRunStatusChanged, events[2].EventType)
+		require.NotNil(t, events[2].ActivityRunID)
+		assert.Equal(t, "activity-1", *events[2].ActivityRunID)
+		assert.Equal(t, int64(3), events[2].Sequence)
+		assert.JSONEq(t, `{"status": "finished"}`, string(events[2].Payload))
+
+		// Replaced events are stored as they are and appending continues after them.
+		replaced := []backend.WorkflowEvent{
+			{
+				WorkflowRunID: "run-1", Sequence: 1, EventType: backend.WorkflowEventRunCreated,
+				Payload: json.RawMessage(`{}`), CreatedAt: now.Add(-time.Hour),
+			},
+			{
+				WorkflowRunID: "run-1", Sequence: 2, EventType: "timer_started",
+				Payload: json.RawMessage(`{}`), CreatedAt: now.Add(-time.Minute),
+			},
+		}
+		require.NoError(t, tx.WorkflowEventRepository().ReplaceWorkflowEvents(ctx, "run-1", replaced))
+		events, err = tx.WorkflowEventRepository().GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, int64(2), events[1].Sequence)
+		assert.True(t, now.Add(-time.Minute).Equal(events[1].CreatedAt))
+		appended := &backend.WorkflowEvent{
+			WorkflowRunID: "run-1", EventType: "timer_fired", Payload: json.RawMessage(`{}`),
+		}
+		require.NoError(t, tx.WorkflowEventRepository().AppendWorkflowEvent(ctx, appended))
+		assert.Equal(t, int64(3), appended.Sequence)
+		require.Error(t, tx.WorkflowEventRepository().ReplaceWorkflowEvents(ctx, "missing", replaced))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_ConcurrentClaims(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+
+	const runs = 20
+	now := time.Now()
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{Name: "test-workflow", CreatedAt: now, UpdatedAt: now}))
+		for i := range runs {
+			require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+				ID:           fmt.Sprintf("run-%d", i),
+				Input:        json.RawMessage(`[]`),
+				WorkflowName: "test-workflow",
+				Status:       backend.WorkflowStatusPending,
+				ScheduledAt:  now.Add(time.Duration(i) * time.Millisecond),
+				CreatedAt:    now,
+				UpdatedAt:    now,
+			}))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	claimed := map[string]int{}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var id string
+				err := b.RunInTx(ctx, func(tx backend.Tx) error {
+					run, err := tx.WorkflowRepository().GetNextWorkflowRun(ctx)
+					if err != nil || run == nil {
+						return err
+					}
+					id = run.ID
+					return tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, run.ID, backend.WorkflowStatusExecuting)
+				})
+				if !assert.NoError(t, err) || id == "" {
+					return
+				}
+				mu.Lock()
+				claimed[id]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, claimed, runs)
+	for id, count := range claimed {
+		assert.Equal(t, 1, count, id)
+	}
+}
+
+func TestBackend_ListWorkflowRuns(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+
+	parentID := "run-0"
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		for _, name := range []string{"order-workflow", "other-workflow"} {
+			require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{Name: name, CreatedAt: now, UpdatedAt: now}))
+		}
+		for i := range 4 {
+			run := &backend.WorkflowRun{
+				ID:           fmt.Sprintf("run-%d", i),
+				Input:        json.RawMessage(`[]`),
+				WorkflowName: "order-workflow",
+				Status:       backend.WorkflowStatusPending,
+				ScheduledAt:  now,
+				// run-1 and run-2 share a creation time, so the ID breaks the tie.
+				CreatedAt: now.Add(time.Duration(min(i, 2)) * time.Second),
+				UpdatedAt: now,
+				Labels:    map[string]string{"region": []string{"eu", "us"}[i%2]},
+			}
+			if i > 0 {
+				run.ParentWorkflowRunID = &parentID
+			}
+			require.NoError(t, repo.CreateWorkflowRun(ctx, run))
+		}
+		require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID: "other", Input: json.RawMessage(`[]`), WorkflowName: "other-workflow",
+			Status: backend.WorkflowStatusPending, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+		}))
+		return repo.ChangeWorkflowRunStatus(ctx, "run-2", backend.WorkflowStatusFailed)
+	})
+	require.NoError(t, err)
+
+	list := func(query backend.WorkflowRunQuery, after *backend.WorkflowRunCursor, limit int) []string {
+		var ids []string
+		err := b.RunInTx(ctx, func(tx backend.Tx) error {
+			runs, err := tx.WorkflowRepository().ListWorkflowRuns(ctx, query, after, limit)
+			for _, run := range runs {
+				ids = append(ids, run.ID)
+			}
+			return err
+		})
+		require.NoError(t, err)
+		return ids
+	}
+
+	orders := backend.WorkflowRunQuery{WorkflowName: "order-workflow"}
+	assert.Equal(t, []string{"run-3", "run-2", "run-1", "run-0"}, list(orders, nil, 10))
+	assert.Equal(t, []string{"run-3", "run-2"}, list(orders, nil, 2))
+	assert.Equal(t, []string{"run-1", "run-0"},
+		list(orders, &backend.WorkflowRunCursor{CreatedAt: now.Add(2 * time.Second), ID: "run-2"}, 2))
+
+	byLabel := backend.WorkflowRunQuery{Labels: map[string]string{"region": "us"}}
+	assert.Equal(t, []string{"run-3", "run-1"}, list(byLabel, nil, 10))
+	children := backend.WorkflowRunQuery{ParentWorkflowRunID: parentID}
+	assert.Equal(t, []string{"run-3", "run-2", "run-1"}, list(children, nil, 10))
+	assert.Equal(t, []string{"run-2"}, list(backend.WorkflowRunQuery{
+		Statuses:    []backend.WorkflowStatus{backend.WorkflowStatusFailed, backend.WorkflowStatusAborted},
+		ClosedAfter: now,
+	}, nil, 10))
+	assert.Equal(t, []string{"run-1", "run-0"}, list(backend.WorkflowRunQuery{
+		WorkflowName:  "order-workflow",
+		CreatedAfter:  now,
+		CreatedBefore: now.Add(2 * time.Second),
+	}, nil, 10))
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		count, err := tx.WorkflowRepository().CountWorkflowRuns(ctx, backend.WorkflowRunQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), count)
+
+		run, err := tx.WorkflowRepository().GetWorkflowRun(ctx, "run-2")
+		require.NoError(t, err)
+		require.NotNil(t, run.ClosedAt)
+		assert.Equal(t, map[string]string{"region": "eu"}, run.Labels)
+		require.NotNil(t, run.ParentWorkflowRunID)
+		assert.Equal(t, parentID, *run.ParentWorkflowRunID)
+
+		// Reopening a run clears its closing time.
+		require.NoError(t, tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, "run-2", backend.WorkflowStatusPending))
+		run, err = tx.WorkflowRepository().GetWorkflowRun(ctx, "run-2")
+		require.NoError(t, err)
+		assert.Nil(t, run.ClosedAt)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_SearchAttributes(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+	dueAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	attributes := []map[string]any{
+		{"CustomerID": "c-1", "Region": "eu", "OrderTotal": 25, "Priority": true, "DueAt": dueAt},
+		{"CustomerID": "c-2", "Region": "us", "OrderTotal": 120.5},
+		{"OrderTotal": "unknown"},
+		nil,
+	}
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		workflow := &backend.Workflow{Name: "order-workflow", CreatedAt: now, UpdatedAt: now}
+		require.NoError(t, repo.UpsertWorkflow(ctx, workflow))
+		for i, attrs := range attributes {
+			normalized, err := backend.NormalizeSearchAttributes(attrs)
+			require.NoError(t, err)
+			require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+				ID:               fmt.Sprintf("run-%d", i),
+				Input:            json.RawMessage(`[]`),
+				WorkflowName:     "order-workflow",
+				Status:           backend.WorkflowStatusPending,
+				ScheduledAt:      now,
+				CreatedAt:        now.Add(time.Duration(i) * time.Second),
+				UpdatedAt:        now,
+				SearchAttributes: normalized,
+				Memo:             json.RawMessage(`{"note":{"metadata":{"encoding":"json/plain"},"data":"first"}}`),
+			}))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	list := func(conditions ...backend.SearchAttributeCondition) ([]string, error) {
+		var ids []string
+		err := b.RunInTx(ctx, func(tx backend.Tx) error {
+			query := backend.WorkflowRunQuery{SearchAttributes: conditions}
+			runs, err := tx.WorkflowRepository().ListWorkflowRuns(ctx, query, nil, 10)
+			for _, run := range runs {
+				ids = append(ids, run.ID)
+			}
+			return err
+		})
+		return ids, err
+	}
+	assertListed := func(expected []string, conditions ...backend.SearchAttributeCondition) {
+		t.Helper()
+		ids, err := list(conditions...)
+		require.NoError(t, err)
+		assert.Equal(t, expected, ids)
+	}
+
+	assertListed([]string{"run-0"}, backend.SearchAttributeCondition{Key: "CustomerID", Value: "c-1"})
+	// A string value never matches a numeric condition.
+	assertListed([]string{"run-1"}, backend.SearchAttributeCondition{
+		Key: "OrderTotal", Operator: backend.SearchAttributeGreaterOrEqual, Value: 100,
+	})
+	assertListed([]string{"run-0"}, backend.SearchAttributeCondition{
+		Key: "OrderTotal", Operator: backend.SearchAttributeLess, Value: 100,
+	})
+	// Runs without the attribute do not match a not-equal condition.
+	assertListed([]string{"run-1"}, backend.SearchAttributeCondition{
+		Key: "Region", Operator: backend.SearchAttributeNotEqual, Value: "eu",
+	})
+	assertListed([]string{"run-0"},
+		backend.SearchAttributeCondition{Key: "Priority", Value: true},
+		backend.SearchAttributeCondition{Key: "DueAt", Operator: backend.SearchAttributeLess, Value: dueAt.Add(time.Hour)},
+	)
+	assertListed(nil, backend.SearchAttributeCondition{
+		Key: "DueAt", Operator: backend.SearchAttributeGreater, Value: dueAt,
+	})
+
+	_, err = list(backend.SearchAttributeCondition{Key: "customer-id", Value: "c-1"})
+	require.ErrorIs(t, err, backend.ErrInvalidSearchAttribute)
+	_, err = list(backend.SearchAttributeCondition{Key: "Priority", Operator: backend.SearchAttributeGreater, Value: true})
+	require.ErrorIs(t, err, backend.ErrInvalidSearchAttribute)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		require.NoError(t, repo.UpdateWorkflowRunAttributes(ctx, "run-1", map[string]any{"OrderTotal": 80.0},
+			json.RawMessage(`{"status":{"metadata":{"encoding":"json/plain"},"data":"shipped"}}`)))
+		run, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"CustomerID": "c-2", "Region": "us", "OrderTotal": 80.0}, run.SearchAttributes)
+		assert.JSONEq(t, `{
+			"note": {"metadata": {"encoding": "json/plain"}, "data": "first"},
+			"status": {"metadata": {"encoding": "json/plain"}, "data": "shipped"}
+		}`, string(run.Memo))
+
+		run, err = repo.GetWorkflowRun(ctx, "run-0")
+		require.NoError(t, err)
+		assert.Equal(t, "2024-03-01T12:00:00.000000000Z", run.SearchAttributes["DueAt"])
+
+		events, err := tx.WorkflowEventRepository().GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, backend.WorkflowEventRunAttributesUpdated, events[1].EventType)
+
+		require.Error(t, repo.UpdateWorkflowRunAttributes(ctx, "missing", map[string]any{"Region": "eu"}, nil))
+		return nil
+	})
+	require.NoError(t, err)
+	assertListed([]string{"run-1", "run-0"}, backend.SearchAttributeCondition{
+		Key: "OrderTotal", Operator: backend.SearchAttributeLessOrEqual, Value: 80,
+	})
+}
+
+func TestBackend_DeleteWorkflowRuns(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+
+	parentID := "run-1"
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		for _, name := range []string{"b-workflow", "a-workflow"} {
+			require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{Name: name, CreatedAt: now, UpdatedAt: now}))
+		}
+		for i, id := range []string{"run-1", "run-2", "child"} {
+			run := &backend.WorkflowRun{
+				ID: id, Input: json.RawMessage(`[]`), WorkflowName: "a-workflow",
+				Status: backend.WorkflowStatusPending, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+			}
+			if i == 2 {
+				run.ParentWorkflowRunID = &parentID
+			}
+			require.NoError(t, repo.CreateWorkflowRun(ctx, run))
+			require.NoError(t, tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+				ID: "activity-" + id, ActivityName: "test-activity", WorkflowRunID: id, Input: json.RawMessage(`[]`),
+				Status: backend.ActivityStatusPending, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+			}))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		workflows, err := repo.ListWorkflows(ctx)
+		require.NoError(t, err)
+		require.Len(t, workflows, 2)
+		assert.Equal(t, "a-workflow", workflows[0].Name)
+
+		// Runs that are not closed, or that changed after their last event was read, are kept.
+		deleted, err := repo.DeleteWorkflowRuns(ctx, backend.WorkflowRunDeletion{
+			WorkflowRunIDs: []string{"run-1", "run-2"},
+			ClosedBefore:   now.Add(time.Hour),
+		})
+		require.NoError(t, err)
+		assert.Empty(t, deleted)
+		deleted, err = repo.DeleteWorkflowRuns(ctx, backend.WorkflowRunDeletion{
+			WorkflowRunIDs:     []string{"run-1", "run-2", "missing"},
+			LastEventSequences: map[string]int64{"run-1": 2, "run-2": 1, "missing": 0},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"run-1"}, deleted)
+		deleted, err = repo.DeleteWorkflowRuns(ctx, backend.WorkflowRunDeletion{})
+		require.NoError(t, err)
+		assert.Empty(t, deleted)
+
+		run, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Nil(t, run)
+		activityRun, err := tx.ActivityRunRepository().GetActivityRun(ctx, "activity-run-1")
+		require.NoError(t, err)
+		assert.Nil(t, activityRun)
+		events, err := tx.WorkflowEventRepository().GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		// Other runs keep their history; children only lose their parent link.
+		child, err := repo.GetWorkflowRun(ctx, "child")
+		require.NoError(t, err)
+		require.NotNil(t, child)
+		assert.Nil(t, child.ParentWorkflowRunID)
+		activityRun, err = tx.ActivityRunRepository().GetActivityRun(ctx, "activity-run-2")
+		require.NoError(t, err)
+		assert.NotNil(t, activityRun)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_TaskQueues(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+
+	listener, err := b.ListenForTasks(ctx, []string{"orders"})
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+		}))
+		for id, taskQueue := range map[string]string{"run-default": "", "run-orders": "orders"} {
+			require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+				ID:           id,
+				Input:        json.RawMessage(`[]`),
+				WorkflowName: "test-workflow",
+				Status:       backend.WorkflowStatusPending,
+				ScheduledAt:  now,
+				CreatedAt:    now,
+				UpdatedAt:    now,
+				TaskQueue:    taskQueue,
+			}))
+		}
+		// Notifications are only sent once the transaction commits.
+		assert.Empty(t, listener.C())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, listener.C(), 1)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		run, err := repo.GetWorkflowRun(ctx, "run-default")
+		require.NoError(t, err)
+		assert.Equal(t, backend.DefaultTaskQueue, run.TaskQueue)
+
+		next, err := repo.GetNextWorkflowRun(ctx, "orders", "payments")
+		require.NoError(t, err)
+		require.NotNil(t, next)
+		assert.Equal(t, "run-orders", next.ID)
+		next, err = repo.GetNextWorkflowRun(ctx, "payments")
+		require.NoError(t, err)
+		assert.Nil(t, next)
+
+		activityRepo := tx.ActivityRunRepository()
+		activity := &backend.ActivityRun{
+			ID:            "activity-1",
+			ActivityName:  "test-activity",
+			WorkflowRunID: "run-orders",
+			Input:         json.RawMessage(`[]`),
+			Status:        backend.ActivityStatusPending,
+			ScheduledAt:   now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		require.NoError(t, activityRepo.CreateActivityRun(ctx, activity))
+		assert.Equal(t, "orders", activity.TaskQueue)
+
+		nextActivity, err := activityRepo.GetNextActivityRun(ctx, "orders")
+		require.NoError(t, err)
+		require.NotNil(t, nextActivity)
+		assert.Equal(t, "orders", nextActivity.TaskQueue)
+		nextActivity, err = activityRepo.GetNextActivityRun(ctx, backend.DefaultTaskQueue)
+		require.NoError(t, err)
+		assert.Nil(t, nextActivity)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_Leases(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        json.RawMessage(`[]`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}))
+		return tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+			ID:            "activity-1",
+			ActivityName:  "test-activity",
+			WorkflowRunID: "run-1",
+			Input:         json.RawMessage(`[]`),
+			Status:        backend.ActivityStatusPending,
+			ScheduledAt:   now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		run, err := repo.ClaimWorkflowRun(ctx, "worker-1", time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, run)
+		assert.Equal(t, backend.WorkflowStatusExecuting, run.Status)
+		assert.Equal(t, 1, run.Attempt)
+
+		stored, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.WorkflowStatusExecuting, stored.Status)
+		require.NotNil(t, stored.LeaseOwner)
+		assert.Equal(t, "worker-1", *stored.LeaseOwner)
+		require.NotNil(t, stored.LeaseExpiresAt)
+		assert.True(t, stored.LeaseExpiresAt.After(now))
+
+		next, err := repo.ClaimWorkflowRun(ctx, "worker-2", time.Minute)
+		require.NoError(t, err)
+		assert.Nil(t, next)
+
+		require.ErrorIs(t, repo.RenewWorkflowRunLease(ctx, "run-1", "worker-2", time.Minute), backend.ErrLeaseLost)
+		require.NoError(t, repo.RenewWorkflowRunLease(ctx, "run-1", "worker-1", -time.Second))
+
+		activity, err := tx.ActivityRunRepository().ClaimActivityRun(ctx, "worker-1", -time.Second)
+		require.NoError(t, err)
+		require.NotNil(t, activity)
+		assert.Equal(t, backend.ActivityStatusExecuting, activity.Status)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		reset, err := tx.WorkflowRepository().ResetExpiredWorkflowRuns(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), reset)
+		reset, err = tx.ActivityRunRepository().ResetExpiredActivityRuns(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), reset)
+
+		run, err := tx.WorkflowRepository().GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.WorkflowStatusPending, run.Status)
+		assert.Equal(t, 2, run.Attempt)
+		assert.Nil(t, run.LeaseOwner)
+		assert.Nil(t, run.LeaseExpiresAt)
+
+		activity, err := tx.ActivityRunRepository().GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.ActivityStatusPending, activity.Status)
+		assert.Equal(t, 2, activity.Attempt)
+
+		events, err := tx.WorkflowEventRepository().GetWorkflowEvents(ctx, "run-1", 0)
+		require.NoError(t, err)
+		require.Len(t, events, 6)
+		assert.Equal(t, backend.WorkflowEventRunStatusChanged, events[4].EventType)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.ActivityRunRepository()
+		_, err := repo.ClaimActivityRun(ctx, "worker-2", time.Minute)
+		require.NoError(t, err)
+		require.ErrorIs(t, repo.ReleaseActivityRunLease(ctx, "activity-1", "worker-1"), backend.ErrLeaseLost)
+		require.NoError(t, repo.ReleaseActivityRunLease(ctx, "activity-1", "worker-2"))
+
+		activity, err := repo.GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.ActivityStatusPending, activity.Status)
+		assert.Equal(t, 2, activity.Attempt)
+		assert.Nil(t, activity.LeaseOwner)
+		assert.Nil(t, activity.LeaseExpiresAt)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A lease expiring on the last attempt fails the run instead.
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		_, err := tx.WorkflowRepository().ClaimWorkflowRun(ctx, "worker-3", -time.Second)
+		require.NoError(t, err)
+		_, err = tx.ActivityRunRepository().ClaimActivityRun(ctx, "worker-3", -time.Second)
+		require.NoError(t, err)
+
+		failed, err := tx.WorkflowRepository().ResetExpiredWorkflowRuns(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), failed)
+		failed, err = tx.ActivityRunRepository().ResetExpiredActivityRuns(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), failed)
+
+		run, err := tx.WorkflowRepository().GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.WorkflowStatusFailed, run.Status)
+		assert.Equal(t, 2, run.Attempt)
+		assert.NotNil(t, run.ClosedAt)
+		require.NotNil(t, run.ErrorMessage)
+		assert.Equal(t, backend.ErrAttemptsExhausted.Error(), *run.ErrorMessage)
+		assert.Nil(t, run.LeaseOwner)
+
+		activity, err := tx.ActivityRunRepository().GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.ActivityStatusFailed, activity.Status)
+		require.NotNil(t, activity.ErrorMessage)
+		assert.Equal(t, backend.ErrAttemptsExhausted.Error(), *activity.ErrorMessage)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_Workers(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		require.NoError(t, tx.WorkflowRepository().UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, tx.WorkflowRepository().CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        json.RawMessage(`[]`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}))
+		repo := tx.WorkerRepository()
+		require.NoError(t, repo.RegisterWorker(ctx, &backend.Worker{
+			ID:              "worker-1",
+			Hostname:        "host-1",
+			TaskQueues:      []string{backend.DefaultTaskQueue, "emails"},
+			Workflows:       []string{"test-workflow"},
+			BuildVersion:    "v1.0.0",
+			StartedAt:       now,
+			LastHeartbeatAt: now,
+		}))
+		return repo.RegisterWorker(ctx, &backend.Worker{
+			ID:              "worker-2",
+			Hostname:        "host-2",
+			TaskQueues:      []string{"emails"},
+			StartedAt:       now,
+			LastHeartbeatAt: now.Add(-time.Hour),
+		})
+	})
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkerRepository()
+		workers, err := repo.ListWorkers(ctx)
+		require.NoError(t, err)
+		require.Len(t, workers, 2)
+		assert.Equal(t, "worker-1", workers[0].ID)
+		assert.Equal(t, []string{backend.DefaultTaskQueue, "emails"}, workers[0].TaskQueues)
+		assert.Equal(t, []string{"test-workflow"}, workers[0].Workflows)
+		assert.Empty(t, workers[0].Activities)
+		assert.Equal(t, "v1.0.0", workers[0].BuildVersion)
+
+		queues, err := repo.ListTaskQueues(ctx, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, queues, 2)
+		require.NotNil(t, queues[0].OldestPendingScheduledAt)
+		assert.WithinDuration(t, now, *queues[0].OldestPendingScheduledAt, time.Millisecond)
+		queues[0].OldestPendingScheduledAt = nil
+		assert.Equal(t, []backend.TaskQueueInfo{
+			{Name: backend.DefaultTaskQueue, Pollers: 1, PendingWorkflowRuns: 1},
+			{Name: "emails", Pollers: 1},
+		}, queues)
+
+		require.ErrorIs(t, repo.RecordWorkerHeartbeat(ctx, "worker-3"), backend.ErrWorkerNotFound)
+		require.NoError(t, repo.RecordWorkerHeartbeat(ctx, "worker-2"))
+		queues, err = repo.ListTaskQueues(ctx, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, 2, queues[1].Pollers)
+
+		require.NoError(t, repo.DeregisterWorker(ctx, "worker-1"))
+		deleted, err := repo.DeleteInactiveWorkers(ctx, -time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		workers, err = repo.ListWorkers(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, workers)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_TraceContext(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+	traceContext := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		workflowRepo := tx.WorkflowRepository()
+		require.NoError(t, workflowRepo.UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, workflowRepo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        json.RawMessage(`{}`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}))
+		require.NoError(t, workflowRepo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-2",
+			Input:        json.RawMessage(`{}`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			TraceContext: traceContext,
+		}))
+		run, err := workflowRepo.GetWorkflowRun(ctx, "run-2")
+		require.NoError(t, err)
+		assert.Equal(t, traceContext, run.TraceContext)
+
+		// Activity runs inherit the trace context of their workflow run unless they have one.
+		activityRepo := tx.ActivityRunRepository()
+		own := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01"}
+		for _, activity := range []backend.ActivityRun{
+			{ID: "inherited", WorkflowRunID: "run-2"},
+			{ID: "own", WorkflowRunID: "run-2", TraceContext: own},
+			{ID: "untraced", WorkflowRunID: "run-1"},
+		} {
+			activity.ActivityName = "test-activity"
+			activity.Input = json.RawMessage(`{}`)
+			activity.Status = backend.ActivityStatusPending
+			activity.ScheduledAt, activity.CreatedAt, activity.UpdatedAt = now, now, now
+			require.NoError(t, activityRepo.CreateActivityRun(ctx, &activity))
+		}
+		for id, want := range map[string]map[string]string{"inherited": traceContext, "own": own, "untraced": nil} {
+			activity, err := activityRepo.GetActivityRun(ctx, id)
+			require.NoError(t, err)
+			assert.Equal(t, want, activity.TraceContext, id)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_CloseWorkflowRun(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+	message := "card declined"
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		require.NoError(t, repo.UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, repo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        json.RawMessage(`{}`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}))
+		require.ErrorIs(t, repo.CloseWorkflowRun(ctx, "run-1", "worker-1", backend.WorkflowStatusFailed, nil, &message),
+			backend.ErrLeaseLost)
+		_, err := repo.ClaimWorkflowRun(ctx, "worker-1", time.Minute)
+		require.NoError(t, err)
+		require.ErrorIs(t, repo.CloseWorkflowRun(ctx, "run-1", "worker-2", backend.WorkflowStatusFailed, nil, &message),
+			backend.ErrLeaseLost)
+		require.NoError(t, repo.CloseWorkflowRun(ctx, "run-1", "worker-1", backend.WorkflowStatusFailed, nil, &message))
+
+		run, err := repo.GetWorkflowRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.WorkflowStatusFailed, run.Status)
+		assert.Nil(t, run.Output)
+		assert.Equal(t, &message, run.ErrorMessage)
+		assert.NotNil(t, run.ClosedAt)
+		assert.Nil(t, run.LeaseOwner)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBackend_CloseActivityRun(t *testing.T) {
+	ctx := context.Background()
+	b := openBackend(t)
+	require.NoError(t, b.Init(ctx))
+	now := time.Now()
+	output := json.RawMessage(`{"payloads":[]}`)
+
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		workflowRepo := tx.WorkflowRepository()
+		require.NoError(t, workflowRepo.UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, workflowRepo.CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        json.RawMessage(`{}`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}))
+		repo := tx.ActivityRunRepository()
+		require.NoError(t, repo.CreateActivityRun(ctx, &backend.ActivityRun{
+			ID:            "activity-1",
+			ActivityName:  "test-activity",
+			WorkflowRunID: "run-1",
+			Input:         json.RawMessage(`{}`),
+			Status:        backend.ActivityStatusPending,
+			ScheduledAt:   now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}))
+		require.ErrorIs(t, repo.CloseActivityRun(ctx, "activity-1", "worker-1", backend.ActivityStatusFinished, &output, nil),
+			backend.ErrLeaseLost)
+		_, err := repo.ClaimActivityRun(ctx, "worker-1", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, repo.CloseActivityRun(ctx, "activity-1", "worker-1", backend.ActivityStatusFinished, &output, nil))
+
+		run, err := repo.GetActivityRun(ctx, "activity-1")
+		require.NoError(t, err)
+		assert.Equal(t, backend.ActivityStatusFinished, run.Status)
+		assert.JSONEq(t, string(output), string(*run.Output))
+		assert.Nil(t, run.LeaseOwner)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultTaskQueue is the task queue of workflow runs created without one.
+const DefaultTaskQueue = "default"
+
+// TaskQueueInfo describes a task queue, as returned by WorkerRepository.ListTaskQueues.
+type TaskQueueInfo struct {
+	Name string `json:"name"`
+	// Pollers is the number of alive workers polling the queue. A queue with pending runs and no
+	// pollers makes no progress.
+	Pollers             int   `json:"pollers"`
+	PendingWorkflowRuns int64 `json:"pending_workflow_runs"`
+	PendingActivityRuns int64 `json:"pending_activity_runs"`
+	// OldestPendingScheduledAt is the earliest scheduled time of the pending runs, or nil when
+	// there are none.
+	OldestPendingScheduledAt *time.Time `json:"oldest_pending_scheduled_at,omitempty"`
+}
+
+// TaskNotifier is implemented by backends that announce the workflow and activity runs created
+// on each task queue, so that idle workers wake up as soon as there is work instead of polling
+// for it. Notifications are best effort: workers keep polling at a low rate to pick up runs
+// whose notification was lost.
+type TaskNotifier interface {
+	// ListenForTasks returns a listener for runs created on taskQueues by transactions committed
+	// after it returns. ctx only bounds setting up the listener.
+	ListenForTasks(ctx context.Context, taskQueues []string) (TaskListener, error)
+}
+
+// TaskListener receives the notifications of a TaskNotifier until it is closed.
+type TaskListener interface {
+	// C receives a value when runs were created on one of the listened task queues. Notifications
+	// arriving while a value is pending are merged into it.
+	C() <-chan struct{}
+	Close() error
+}
+
+// ListenForTasks listens for runs created on taskQueues when b implements TaskNotifier. For
+// other backends it returns a listener that never fires, leaving workers to poll.
+func ListenForTasks(ctx context.Context, b Backend, taskQueues []string) (TaskListener, error) {
+	notifier, ok := b.(TaskNotifier)
+	if !ok {
+		return pollingListener{}, nil
+	}
+	return notifier.ListenForTasks(ctx, taskQueues)
+}
+
+type pollingListener struct{}
+
+func (pollingListener) C() <-chan struct{} {
+	return nil
+}
+
+func (pollingListener) Close() error {
+	return nil
+}
+
+// WaitForTask blocks until listener signals new runs or pollInterval elapses, and returns
+// ctx.Err() if ctx is done first. Workers call it when they found no pending run to claim.
+func WaitForTask(ctx context.Context, listener TaskListener, pollInterval time.Duration) error {
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-listener.C():
+		return nil
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TaskBroadcaster delivers task notifications within a process, for backends whose
+// transactions all run in the process that listens.
+type TaskBroadcaster struct {
+	mu        sync.Mutex
+	listeners map[*broadcastListener]struct{}
+}
+
+func NewTaskBroadcaster() *TaskBroadcaster {
+	return &TaskBroadcaster{
+		listeners: map[*broadcastListener]struct{}{},
+	}
+}
+
+// Listen returns a listener for the runs later announced on taskQueues.
+func (b *TaskBroadcaster) Listen(taskQueues []string) TaskListener {
+	l := &broadcastListener{
+		broadcaster: b,
+		taskQueues:  make(map[string]struct{}, len(taskQueues)),
+		c:           make(chan struct{}, 1),
+	}
+	for _, taskQueue := range taskQueues {
+		l.taskQueues[taskQueue] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners[l] = struct{}{}
+	return l
+}
+
+// Notify announces runs created on taskQueues to the listeners of any of them.
+func (b *TaskBroadcaster) Notify(taskQueues ...string) {
+	if len(taskQueues) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for l := range b.listeners {
+		for _, taskQueue := range taskQueues {
+			if _, ok := l.taskQueues[taskQueue]; ok {
+				l.notify()
+				break
+			}
+		}
+	}
+}
+
+type broadcastListener struct {
+	broadcaster *TaskBroadcaster
+	taskQueues  map[string]struct{}
+	c           chan struct{}
+}
+
+func (l *broadcastListener) C() <-chan struct{} {
+	return l.c
+}
+
+func (l *broadcastListener) notify() {
+	select {
+	case l.c <- struct{}{}:
+	default:
+	}
+}
+
+func (l *broadcastListener) Close() error {
+	l.broadcaster.mu.Lock()
+	defer l.broadcaster.mu.Unlock()
+	delete(l.broadcaster.listeners, l)
+	return nil
+}
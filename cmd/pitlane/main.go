@@ -0,0 +1,276 @@
+// Command pitlane is the operator CLI for a pitlane database.
+//
+// Usage:
+//
+//	pitlane history export [-dsn DSN] [-schema NAME] [-table-prefix PREFIX] [-o FILE] RUN_ID
+//	pitlane history import [-dsn DSN] [-schema NAME] [-table-prefix PREFIX] [-init] [FILE]
+//	pitlane archive get [-dir DIR] [-o FILE] RUN_ID
+//	pitlane workers list [-dsn DSN] [-schema NAME] [-table-prefix PREFIX] [-alive DURATION]
+//	pitlane queues list [-dsn DSN] [-schema NAME] [-table-prefix PREFIX] [-alive DURATION]
+//
+// The DSN defaults to the PITLANE_DSN environment variable and the archive directory, written
+// by archive.FileArchiver, to PITLANE_ARCHIVE_DIR. Documents are read from stdin and written to
+// stdout unless a file is given. Workers count as alive when their last heartbeat is at most
+// -alive old, one minute by default.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/archive"
+	"github.com/nurburg-dev/pitlane/history"
+)
+
+const usage = `usage:
+  pitlane history export [flags] RUN_ID
+  pitlane history import [flags] [FILE]
+  pitlane archive get [flags] RUN_ID
+  pitlane workers list [flags]
+  pitlane queues list [flags]`
+
+func main() {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "pitlane:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+	switch args[0] + " " + args[1] {
+	case "history export":
+		return exportHistory(ctx, args[2:])
+	case "history import":
+		return importHistory(ctx, args[2:])
+	case "archive get":
+		return getArchivedRun(ctx, args[2:])
+	case "workers list":
+		return listWorkers(ctx, args[2:])
+	case "queues list":
+		return listTaskQueues(ctx, args[2:])
+	default:
+		return errors.New(usage)
+	}
+}
+
+type dbFlags struct {
+	dsn         string
+	schema      string
+	tablePrefix string
+}
+
+func newFlagSet(name string, dbf *dbFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&dbf.dsn, "dsn", os.Getenv("PITLANE_DSN"), "Postgres connection string")
+	fs.StringVar(&dbf.schema, "schema", "", "schema holding pitlane's tables")
+	fs.StringVar(&dbf.tablePrefix, "table-prefix", "", "prefix of pitlane's table names")
+	return fs
+}
+
+// openEngine connects to the database described by dbf. The returned function closes the pool.
+func openEngine(ctx context.Context, dbf *dbFlags, initDB bool) (*pitlane.WorkflowEngine, func(), error) {
+	if dbf.dsn == "" {
+		return nil, nil, errors.New("no database given, set -dsn or PITLANE_DSN")
+	}
+	pool, err := pgxpool.New(ctx, dbf.dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	config := &pitlane.EngineConfig{
+		InitDB:       initDB,
+		SchemaConfig: pitlane.SchemaConfig{Schema: dbf.schema, TablePrefix: dbf.tablePrefix},
+	}
+	engine, err := pitlane.NewWorkflowEngineWithPool(ctx, pool, config)
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+	return engine, pool.Close, nil
+}
+
+func exportHistory(ctx context.Context, args []string) error {
+	var dbf dbFlags
+	fs := newFlagSet("history export", &dbf)
+	output := fs.String("o", "", "file to write the document to instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("history export takes exactly one run ID")
+	}
+
+	engine, closePool, err := openEngine(ctx, &dbf, false)
+	if err != nil {
+		return err
+	}
+	defer closePool()
+
+	doc, err := engine.ExportWorkflowRun(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return writeDocument(*output, doc)
+}
+
+// writeDocument writes doc to the file named output, or to stdout if output is empty.
+func writeDocument(output string, doc *history.Document) error {
+	if output == "" {
+		return history.Write(os.Stdout, doc)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", output, err)
+	}
+	if err := history.Write(f, doc); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func importHistory(ctx context.Context, args []string) error {
+	var dbf dbFlags
+	fs := newFlagSet("history import", &dbf)
+	initDB := fs.Bool("init", false, "create or migrate the schema before importing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("history import takes at most one file")
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", fs.Arg(0), err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+	doc, err := history.Read(r)
+	if err != nil {
+		return err
+	}
+
+	engine, closePool, err := openEngine(ctx, &dbf, *initDB)
+	if err != nil {
+		return err
+	}
+	defer closePool()
+
+	if err := engine.ImportWorkflowRun(ctx, doc); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported workflow run %s\n", doc.Run.ID)
+	return nil
+}
+
+func getArchivedRun(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("archive get", flag.ContinueOnError)
+	dir := fs.String("dir", os.Getenv("PITLANE_ARCHIVE_DIR"), "directory written by the file archiver")
+	output := fs.String("o", "", "file to write the document to instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("archive get takes exactly one run ID")
+	}
+	if *dir == "" {
+		return errors.New("no archive given, set -dir or PITLANE_ARCHIVE_DIR")
+	}
+	if _, err := os.Stat(*dir); err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	archiver, err := archive.NewFileArchiver(*dir)
+	if err != nil {
+		return err
+	}
+	doc, err := archiver.Get(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return writeDocument(*output, doc)
+}
+
+const defaultAliveWithin = time.Minute
+
+func listWorkers(ctx context.Context, args []string) error {
+	var dbf dbFlags
+	fs := newFlagSet("workers list", &dbf)
+	aliveWithin := fs.Duration("alive", defaultAliveWithin, "maximum age of the last heartbeat of alive workers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	engine, closePool, err := openEngine(ctx, &dbf, false)
+	if err != nil {
+		return err
+	}
+	defer closePool()
+
+	workers, err := engine.ListWorkers(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tHOSTNAME\tSTATUS\tBUILD\tTASK QUEUES\tSTARTED\tLAST HEARTBEAT")
+	for _, worker := range workers {
+		status := "alive"
+		if time.Since(worker.LastHeartbeatAt) > *aliveWithin {
+			status = "dead"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", worker.ID, worker.Hostname, status, worker.BuildVersion,
+			strings.Join(worker.TaskQueues, ","), worker.StartedAt.Format(time.RFC3339),
+			worker.LastHeartbeatAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func listTaskQueues(ctx context.Context, args []string) error {
+	var dbf dbFlags
+	fs := newFlagSet("queues list", &dbf)
+	aliveWithin := fs.Duration("alive", defaultAliveWithin, "maximum age of the last heartbeat of alive workers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	engine, closePool, err := openEngine(ctx, &dbf, false)
+	if err != nil {
+		return err
+	}
+	defer closePool()
+
+	queues, err := engine.ListTaskQueues(ctx, *aliveWithin)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK QUEUE\tPOLLERS\tPENDING WORKFLOWS\tPENDING ACTIVITIES\tOLDEST PENDING")
+	for _, queue := range queues {
+		oldest := "-"
+		if queue.OldestPendingScheduledAt != nil {
+			oldest = queue.OldestPendingScheduledAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", queue.Name, queue.Pollers, queue.PendingWorkflowRuns,
+			queue.PendingActivityRuns, oldest)
+	}
+	return w.Flush()
+}
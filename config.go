@@ -1,11 +1,44 @@
 package pitlane
 
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nurburg-dev/pitlane/converter"
+)
+
 type DBConfig struct {
+	// DSN is a full connection string, either a URL or key=value pairs. When set, the
+	// connection fields below are ignored; the pool and session settings still apply.
+	DSN string
+
 	Host     string
 	Port     string
 	Username string
 	Database string
 	Password string
+
+	// SSLMode is one of Postgres' sslmode values and defaults to disable.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	// Pool settings; zero values keep the pgxpool defaults.
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// Session settings applied to every connection.
+	StatementTimeout time.Duration
+	ApplicationName  string
 }
 
 func NewDBConfig(host, port, username, database, password string) *DBConfig {
@@ -18,9 +51,114 @@ func NewDBConfig(host, port, username, database, password string) *DBConfig {
 	}
 }
 
+func NewDBConfigFromDSN(dsn string) *DBConfig {
+	return &DBConfig{
+		DSN: dsn,
+	}
+}
+
+func (c *DBConfig) connString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	query := url.Values{}
+	query.Set("sslmode", sslMode)
+	if c.SSLRootCert != "" {
+		query.Set("sslrootcert", c.SSLRootCert)
+	}
+	if c.SSLCert != "" {
+		query.Set("sslcert", c.SSLCert)
+	}
+	if c.SSLKey != "" {
+		query.Set("sslkey", c.SSLKey)
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     "/" + c.Database,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+func (c *DBConfig) poolConfig() (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(c.connString())
+	if err != nil {
+		return nil, fmt.Errorf("invalid database config: %w", err)
+	}
+
+	if c.MaxConns > 0 {
+		poolConfig.MaxConns = c.MaxConns
+	}
+	if c.MinConns > 0 {
+		poolConfig.MinConns = c.MinConns
+	}
+	if c.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = c.MaxConnLifetime
+	}
+	if c.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	if c.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = c.HealthCheckPeriod
+	}
+
+	runtimeParams := poolConfig.ConnConfig.RuntimeParams
+	if c.StatementTimeout > 0 {
+		runtimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	if c.ApplicationName != "" {
+		runtimeParams["application_name"] = c.ApplicationName
+	}
+	return poolConfig, nil
+}
+
+// PayloadSizeLimits bounds the size of encoded payloads, measured after any codecs are applied.
+// A zero limit is disabled.
+type PayloadSizeLimits struct {
+	// SoftLimit accepts larger payloads but reports them to OnSoftLimitExceeded.
+	SoftLimit           int
+	OnSoftLimitExceeded func(err *PayloadSizeError)
+	// HardLimit rejects larger payloads with a *PayloadSizeError.
+	HardLimit int
+}
+
+// SchemaConfig places pitlane's tables in their own Postgres schema and/or prefixes their names,
+// for databases shared with other services.
+type SchemaConfig struct {
+	// Schema defaults to public and is created by migrations if missing.
+	Schema      string
+	TablePrefix string
+}
+
 type EngineConfig struct {
 	DBConfig *DBConfig
 	InitDB   bool
+	// DataConverter encodes workflow and activity payloads. Defaults to
+	// converter.GetDefaultDataConverter when nil.
+	DataConverter     converter.DataConverter
+	PayloadSizeLimits PayloadSizeLimits
+	SchemaConfig      SchemaConfig
+	// Retention sets how long closed runs are kept. By default they are kept forever.
+	Retention RetentionConfig
+	// Leases sets how long workers hold the runs they claim and how often expired leases are
+	// reaped.
+	Leases LeaseConfig
+	// Metrics sets where the engine and its workers record metrics. By default they are discarded.
+	Metrics MetricsConfig
+	// Tracing sets how invocations and worker tasks are traced. By default spans go to the global
+	// tracer provider.
+	Tracing TracingConfig
+	// Logger receives the logs of the engine and its workers, and of activities executed by workers.
+	// It defaults to slog.Default.
+	Logger *slog.Logger
 }
 
 func NewEngineConfig(dbc *DBConfig, initDB bool) *EngineConfig {
@@ -0,0 +1,52 @@
+package pitlane
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBConfig_PoolConfig(t *testing.T) {
+	cfg := NewDBConfig("db.internal", "5432", "pitlane", "workflows", "p@ss:w/rd")
+	cfg.SSLMode = "require"
+	cfg.MaxConns = 20
+	cfg.MinConns = 2
+	cfg.MaxConnLifetime = time.Hour
+	cfg.StatementTimeout = 5 * time.Second
+	cfg.ApplicationName = "orders"
+
+	poolConfig, err := cfg.poolConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal", poolConfig.ConnConfig.Host)
+	assert.Equal(t, "p@ss:w/rd", poolConfig.ConnConfig.Password)
+	assert.Equal(t, "workflows", poolConfig.ConnConfig.Database)
+	assert.NotNil(t, poolConfig.ConnConfig.TLSConfig)
+	assert.Equal(t, int32(20), poolConfig.MaxConns)
+	assert.Equal(t, int32(2), poolConfig.MinConns)
+	assert.Equal(t, time.Hour, poolConfig.MaxConnLifetime)
+	assert.Equal(t, "5000", poolConfig.ConnConfig.RuntimeParams["statement_timeout"])
+	assert.Equal(t, "orders", poolConfig.ConnConfig.RuntimeParams["application_name"])
+}
+
+func TestDBConfig_PoolConfigFromDSN(t *testing.T) {
+	cfg := NewDBConfigFromDSN("host=localhost port=5433 user=pitlane dbname=workflows sslmode=disable")
+	cfg.ApplicationName = "orders"
+
+	poolConfig, err := cfg.poolConfig()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(5433), poolConfig.ConnConfig.Port)
+	assert.Nil(t, poolConfig.ConnConfig.TLSConfig)
+	assert.Equal(t, "orders", poolConfig.ConnConfig.RuntimeParams["application_name"])
+
+	_, err = NewDBConfigFromDSN("postgres://%zz").poolConfig()
+	require.Error(t, err)
+
+	// Certificates are read while parsing the config.
+	cfg = NewDBConfig("localhost", "5432", "pitlane", "workflows", "")
+	cfg.SSLMode = "verify-full"
+	cfg.SSLRootCert = "/nonexistent/ca.pem"
+	_, err = cfg.poolConfig()
+	require.ErrorContains(t, err, "/nonexistent/ca.pem")
+}
@@ -0,0 +1,142 @@
+package converter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	EncodingBlobRef = "binary/blob-ref"
+	// MetadataBlobKey records the key of an offloaded payload in the blob store.
+	MetadataBlobKey = "blob-key"
+)
+
+// ErrBlobNotFound is returned by BlobStore.Get for unknown keys.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores offloaded payloads by key. Keys are lowercase hex strings, which are
+// valid object names for S3-compatible stores and file names on local filesystems.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// FileBlobStore is a BlobStore keeping each blob in a file under a directory.
+type FileBlobStore struct {
+	dir string
+}
+
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+// Put writes to a temporary file first so readers never see a partially written blob.
+func (s *FileBlobStore) Put(_ context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, key+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileBlobStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	return data, err
+}
+
+// path rejects keys that are not hex, since keys are read back from the database.
+func (s *FileBlobStore) path(key string) (string, error) {
+	if _, err := hex.DecodeString(key); err != nil || key == "" {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// BlobCodec offloads payloads whose encoded size is at least threshold bytes to a BlobStore,
+// keeping only a reference in the database. Blobs are keyed by the SHA-256 of their content,
+// so identical payloads are stored once.
+type BlobCodec struct {
+	store     BlobStore
+	threshold int
+}
+
+func NewBlobCodec(store BlobStore, threshold int) *BlobCodec {
+	return &BlobCodec{
+		store:     store,
+		threshold: threshold,
+	}
+}
+
+// Encode runs without a caller context since codecs are context free; the store is expected
+// to apply its own timeouts.
+func (c *BlobCodec) Encode(payload *Payload) (*Payload, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < c.threshold {
+		return payload, nil
+	}
+
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	if err := c.store.Put(context.Background(), key, data); err != nil {
+		return nil, fmt.Errorf("failed to offload payload: %w", err)
+	}
+
+	ref := NewPayload(EncodingBlobRef, nil)
+	ref.Metadata[MetadataBlobKey] = key
+	return ref, nil
+}
+
+func (c *BlobCodec) Decode(payload *Payload) (*Payload, error) {
+	if payload.Encoding() != EncodingBlobRef {
+		return payload, nil
+	}
+
+	key := payload.Metadata[MetadataBlobKey]
+	data, err := c.store.Get(context.Background(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load offloaded payload: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != key {
+		return nil, fmt.Errorf("offloaded payload %s is corrupted", key)
+	}
+
+	var resolved Payload
+	if err := json.Unmarshal(data, &resolved); err != nil {
+		return nil, err
+	}
+	return &resolved, nil
+}
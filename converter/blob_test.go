@@ -0,0 +1,58 @@
+package converter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobCodec(t *testing.T) {
+	dir := t.TempDir()
+	store, err := converter.NewFileBlobStore(dir)
+	require.NoError(t, err)
+	dc := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), converter.NewBlobCodec(store, 1024))
+
+	large := strings.Repeat("pitlane ", 1000)
+	data, err := converter.EncodeValues(dc, large, "small")
+	require.NoError(t, err)
+	assert.Less(t, len(data), 1024)
+	assert.Contains(t, string(data), converter.EncodingBlobRef)
+	assert.Contains(t, string(data), `"data":"small"`)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	var outLarge, outSmall string
+	require.NoError(t, converter.DecodeValues(dc, data, &outLarge, &outSmall))
+	assert.Equal(t, large, outLarge)
+	assert.Equal(t, "small", outSmall)
+
+	// Tampered blobs are detected.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, files[0].Name()), []byte(`{}`), 0o600))
+	err = converter.DecodeValues(dc, data, &outLarge, &outSmall)
+	require.Error(t, err)
+}
+
+func TestFileBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := converter.NewFileBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "abcd", []byte("data")))
+	data, err := store.Get(ctx, "abcd")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	_, err = store.Get(ctx, "ef01")
+	require.ErrorIs(t, err, converter.ErrBlobNotFound)
+
+	_, err = store.Get(ctx, "../etc/passwd")
+	require.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package converter
+
+// PayloadCodec transforms payloads after they are encoded and before they are decoded,
+// e.g. to encrypt or compress them. Decode must return payloads it did not encode
+// unchanged, so data written before a codec was configured stays readable.
+type PayloadCodec interface {
+	Encode(payload *Payload) (*Payload, error)
+	Decode(payload *Payload) (*Payload, error)
+}
+
+// CodecDataConverter applies codecs on top of another DataConverter. Codecs are applied
+// in order when encoding and in reverse order when decoding.
+type CodecDataConverter struct {
+	parent DataConverter
+	codecs []PayloadCodec
+}
+
+func NewCodecDataConverter(parent DataConverter, codecs ...PayloadCodec) *CodecDataConverter {
+	return &CodecDataConverter{
+		parent: parent,
+		codecs: codecs,
+	}
+}
+
+func (c *CodecDataConverter) Encoding() string {
+	return c.parent.Encoding()
+}
+
+func (c *CodecDataConverter) ToPayload(value any) (*Payload, error) {
+	payload, err := c.parent.ToPayload(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, codec := range c.codecs {
+		payload, err = codec.Encode(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+func (c *CodecDataConverter) FromPayload(payload *Payload, valuePtr any) error {
+	var err error
+	for i := len(c.codecs) - 1; i >= 0; i-- {
+		payload, err = c.codecs[i].Decode(payload)
+		if err != nil {
+			return err
+		}
+	}
+	return c.parent.FromPayload(payload, valuePtr)
+}
@@ -0,0 +1,167 @@
+package converter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingCompressed = "binary/compressed"
+	// MetadataCompression records the algorithm a payload was compressed with.
+	MetadataCompression = "compression"
+)
+
+// DefaultMaxDecompressedSize is the size in bytes above which a CompressionCodec refuses to
+// decompress a payload, unless changed with SetMaxDecompressedSize.
+const DefaultMaxDecompressedSize = 64 << 20
+
+// ErrDecompressedSizeExceeded is returned by CompressionCodec.Decode for payloads that
+// decompress to more than the codec's maximum size, e.g. decompression bombs.
+var ErrDecompressedSizeExceeded = errors.New("decompressed payload exceeds maximum size")
+
+type CompressionAlgorithm string
+
+const (
+	CompressionGzip CompressionAlgorithm = "gzip"
+	CompressionZstd CompressionAlgorithm = "zstd"
+)
+
+// CompressionCodec compresses payloads whose encoded size is at least threshold bytes.
+// Payloads that do not shrink are stored uncompressed. When combined with encryption,
+// compression must come first since encrypted data does not compress.
+type CompressionCodec struct {
+	algorithm           CompressionAlgorithm
+	threshold           int
+	maxDecompressedSize int
+	zstdEncoder         *zstd.Encoder
+	zstdDecoder         *zstd.Decoder
+}
+
+func NewCompressionCodec(algorithm CompressionAlgorithm, threshold int) (*CompressionCodec, error) {
+	if algorithm != CompressionGzip && algorithm != CompressionZstd {
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+	zstdEncoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	zstdDecoder, err := newZstdDecoder(DefaultMaxDecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressionCodec{
+		algorithm:           algorithm,
+		threshold:           threshold,
+		maxDecompressedSize: DefaultMaxDecompressedSize,
+		zstdEncoder:         zstdEncoder,
+		zstdDecoder:         zstdDecoder,
+	}, nil
+}
+
+func newZstdDecoder(maxDecompressedSize int) (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxDecompressedSize)))
+}
+
+// SetMaxDecompressedSize sets the size in bytes above which Decode refuses to decompress a
+// payload. Call it before the codec is used.
+func (c *CompressionCodec) SetMaxDecompressedSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("maximum decompressed size must be positive, got %d", size)
+	}
+	zstdDecoder, err := newZstdDecoder(size)
+	if err != nil {
+		return err
+	}
+	c.zstdDecoder.Close()
+	c.zstdDecoder = zstdDecoder
+	c.maxDecompressedSize = size
+	return nil
+}
+
+func (c *CompressionCodec) Encode(payload *Payload) (*Payload, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < c.threshold {
+		return payload, nil
+	}
+
+	var compressed []byte
+	switch c.algorithm {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		compressed = buf.Bytes()
+	case CompressionZstd:
+		compressed = c.zstdEncoder.EncodeAll(data, nil)
+	}
+	if len(compressed) >= len(data) {
+		return payload, nil
+	}
+
+	result := NewPayload(EncodingCompressed, compressed)
+	result.Metadata[MetadataCompression] = string(c.algorithm)
+	return result, nil
+}
+
+// Decode handles both algorithms regardless of the configured one.
+func (c *CompressionCodec) Decode(payload *Payload) (*Payload, error) {
+	if payload.Encoding() != EncodingCompressed {
+		return payload, nil
+	}
+
+	var data []byte
+	var err error
+	switch algorithm := CompressionAlgorithm(payload.Metadata[MetadataCompression]); algorithm {
+	case CompressionGzip:
+		data, err = c.gunzip(payload.Data)
+	case CompressionZstd:
+		data, err = c.zstdDecoder.DecodeAll(payload.Data, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			err = fmt.Errorf("%w: limit is %d bytes", ErrDecompressedSizeExceeded, c.maxDecompressedSize)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %w", err)
+	}
+
+	var decompressed Payload
+	if err := json.Unmarshal(data, &decompressed); err != nil {
+		return nil, err
+	}
+	return &decompressed, nil
+}
+
+// gunzip reads at most one byte past the maximum size, so a decompression bomb is never
+// inflated in full.
+func (c *CompressionCodec) gunzip(compressed []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, int64(c.maxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > c.maxDecompressedSize {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrDecompressedSizeExceeded, c.maxDecompressedSize)
+	}
+	return data, nil
+}
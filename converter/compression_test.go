@@ -0,0 +1,79 @@
+package converter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressionCodec(t *testing.T) {
+	large := strings.Repeat("pitlane ", 1000)
+
+	for _, algorithm := range []converter.CompressionAlgorithm{converter.CompressionGzip, converter.CompressionZstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			codec, err := converter.NewCompressionCodec(algorithm, 1024)
+			require.NoError(t, err)
+			dc := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec)
+
+			data, err := converter.EncodeValues(dc, large, "small")
+			require.NoError(t, err)
+			assert.Less(t, len(data), len(large))
+			assert.Contains(t, string(data), `"compression":"`+string(algorithm)+`"`)
+			assert.Contains(t, string(data), `"data":"small"`)
+
+			var outLarge, outSmall string
+			require.NoError(t, converter.DecodeValues(dc, data, &outLarge, &outSmall))
+			assert.Equal(t, large, outLarge)
+			assert.Equal(t, "small", outSmall)
+		})
+	}
+}
+
+func TestCompressionCodec_WithEncryption(t *testing.T) {
+	compression, err := converter.NewCompressionCodec(converter.CompressionZstd, 0)
+	require.NoError(t, err)
+	encryption, err := converter.NewEncryptionCodec("k1", map[string][]byte{"k1": key1})
+	require.NoError(t, err)
+	dc := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), compression, encryption)
+
+	large := strings.Repeat("pitlane ", 1000)
+	data, err := converter.EncodeValues(dc, large)
+	require.NoError(t, err)
+	assert.Less(t, len(data), len(large))
+
+	var out string
+	require.NoError(t, converter.DecodeValues(dc, data, &out))
+	assert.Equal(t, large, out)
+}
+
+func TestNewCompressionCodec_UnsupportedAlgorithm(t *testing.T) {
+	_, err := converter.NewCompressionCodec("lz4", 0)
+	require.Error(t, err)
+}
+
+func TestCompressionCodec_MaxDecompressedSize(t *testing.T) {
+	large := strings.Repeat("pitlane ", 10000)
+
+	for _, algorithm := range []converter.CompressionAlgorithm{converter.CompressionGzip, converter.CompressionZstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			codec, err := converter.NewCompressionCodec(algorithm, 0)
+			require.NoError(t, err)
+			data, err := converter.EncodeValues(
+				converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec), large)
+			require.NoError(t, err)
+
+			limited, err := converter.NewCompressionCodec(algorithm, 0)
+			require.NoError(t, err)
+			require.Error(t, limited.SetMaxDecompressedSize(0))
+			require.NoError(t, limited.SetMaxDecompressedSize(len(large)/2))
+			dc := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), limited)
+
+			var out string
+			err = converter.DecodeValues(dc, data, &out)
+			require.ErrorIs(t, err, converter.ErrDecompressedSizeExceeded)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// Package converter turns workflow and activity inputs and outputs into payloads stored by the engine.
+package converter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownEncoding is returned when no converter can decode a payload's encoding.
+var ErrUnknownEncoding = errors.New("unknown payload encoding")
+
+// DataConverter encodes values into payloads and decodes them back.
+type DataConverter interface {
+	// Encoding is the value written to the payload's encoding metadata.
+	Encoding() string
+	ToPayload(value any) (*Payload, error)
+	FromPayload(payload *Payload, valuePtr any) error
+}
+
+// CompositeDataConverter encodes with a single converter and decodes with whichever
+// converter matches the payload's encoding, so payloads written before switching
+// converters can still be read.
+type CompositeDataConverter struct {
+	encoder  DataConverter
+	decoders map[string]DataConverter
+}
+
+// NewCompositeDataConverter creates a converter that encodes with encoder and can decode
+// the encodings of encoder and all the given decoders.
+func NewCompositeDataConverter(encoder DataConverter, decoders ...DataConverter) *CompositeDataConverter {
+	c := &CompositeDataConverter{
+		encoder:  encoder,
+		decoders: map[string]DataConverter{encoder.Encoding(): encoder},
+	}
+	for _, d := range decoders {
+		if _, exists := c.decoders[d.Encoding()]; !exists {
+			c.decoders[d.Encoding()] = d
+		}
+	}
+	return c
+}
+
+// NewDataConverter creates a converter that encodes with encoder and decodes every built-in encoding.
+func NewDataConverter(encoder DataConverter) *CompositeDataConverter {
+	return NewCompositeDataConverter(encoder, NewJSONConverter(), NewGobConverter(), NewProtoConverter())
+}
+
+// GetDefaultDataConverter returns the converter used when none is configured: JSON encoding
+// with support for decoding every built-in encoding.
+func GetDefaultDataConverter() *CompositeDataConverter {
+	return NewDataConverter(NewJSONConverter())
+}
+
+func (c *CompositeDataConverter) Encoding() string {
+	return c.encoder.Encoding()
+}
+
+func (c *CompositeDataConverter) ToPayload(value any) (*Payload, error) {
+	return c.encoder.ToPayload(value)
+}
+
+func (c *CompositeDataConverter) FromPayload(payload *Payload, valuePtr any) error {
+	d, ok := c.decoders[payload.Encoding()]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownEncoding, payload.Encoding())
+	}
+	return d.FromPayload(payload, valuePtr)
+}
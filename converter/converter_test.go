@@ -0,0 +1,89 @@
+package converter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type order struct {
+	ID       int64
+	PlacedAt time.Time
+}
+
+func TestEncodeDecodeValues_JSON(t *testing.T) {
+	dc := converter.GetDefaultDataConverter()
+
+	data, err := converter.EncodeValues(dc, "test", 42)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"payloads":[
+		{"metadata":{"encoding":"json/plain"},"data":"test"},
+		{"metadata":{"encoding":"json/plain"},"data":42}
+	]}`, string(data))
+
+	var name string
+	var count int
+	require.NoError(t, converter.DecodeValues(dc, data, &name, &count))
+	assert.Equal(t, "test", name)
+	assert.Equal(t, 42, count)
+}
+
+func TestEncodeDecodeValues_Gob(t *testing.T) {
+	dc := converter.NewDataConverter(converter.NewGobConverter())
+	in := order{
+		ID:       1<<62 + 1,
+		PlacedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.FixedZone("IST", 19800)),
+	}
+
+	data, err := converter.EncodeValues(dc, in)
+	require.NoError(t, err)
+
+	var out order
+	require.NoError(t, converter.DecodeValues(dc, data, &out))
+	assert.Equal(t, in.ID, out.ID)
+	assert.True(t, in.PlacedAt.Equal(out.PlacedAt))
+	_, offset := out.PlacedAt.Zone()
+	assert.Equal(t, 19800, offset)
+}
+
+func TestEncodeDecodeValues_Proto(t *testing.T) {
+	dc := converter.NewDataConverter(converter.NewProtoConverter())
+
+	data, err := converter.EncodeValues(dc, wrapperspb.String("test"))
+	require.NoError(t, err)
+
+	var out *wrapperspb.StringValue
+	require.NoError(t, converter.DecodeValues(dc, data, &out))
+	assert.Equal(t, "test", out.GetValue())
+
+	_, err = converter.EncodeValues(dc, "not a message")
+	require.Error(t, err)
+}
+
+func TestDecodeValues_AfterSwitchingConverter(t *testing.T) {
+	data, err := converter.EncodeValues(converter.NewGobConverter(), int64(7))
+	require.NoError(t, err)
+
+	var out int64
+	require.NoError(t, converter.DecodeValues(converter.GetDefaultDataConverter(), data, &out))
+	assert.Equal(t, int64(7), out)
+
+	err = converter.DecodeValues(converter.NewCompositeDataConverter(converter.NewJSONConverter()), data, &out)
+	require.ErrorIs(t, err, converter.ErrUnknownEncoding)
+}
+
+func TestDecodeValues_Legacy(t *testing.T) {
+	var name string
+	var count int
+	err := converter.DecodeValues(converter.GetDefaultDataConverter(), []byte(`["test", 42]`), &name, &count)
+	require.NoError(t, err)
+	assert.Equal(t, "test", name)
+	assert.Equal(t, 42, count)
+
+	err = converter.DecodeValues(converter.GetDefaultDataConverter(), []byte(`["test"]`), &name, &count)
+	require.Error(t, err)
+}
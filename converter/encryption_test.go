@@ -0,0 +1,63 @@
+package converter_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestEncryptionCodec_KeyRotation(t *testing.T) {
+	codec1, err := converter.NewEncryptionCodec("k1", map[string][]byte{"k1": key1})
+	require.NoError(t, err)
+	dc1 := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec1)
+
+	data, err := converter.EncodeValues(dc1, "customer@example.com")
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "customer@example.com")
+	assert.Contains(t, string(data), `"encryption-key-id":"k1"`)
+
+	// Rotate to k2 while keeping k1 for historical payloads.
+	codec2, err := converter.NewEncryptionCodec("k2", map[string][]byte{"k1": key1, "k2": key2})
+	require.NoError(t, err)
+	dc2 := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec2)
+
+	var out string
+	require.NoError(t, converter.DecodeValues(dc2, data, &out))
+	assert.Equal(t, "customer@example.com", out)
+
+	data2, err := converter.EncodeValues(dc2, "new")
+	require.NoError(t, err)
+	assert.Contains(t, string(data2), `"encryption-key-id":"k2"`)
+
+	err = converter.DecodeValues(dc1, data2, &out)
+	require.ErrorIs(t, err, converter.ErrUnknownEncryptionKey)
+}
+
+func TestEncryptionCodec_PlaintextPassthrough(t *testing.T) {
+	codec, err := converter.NewEncryptionCodec("k1", map[string][]byte{"k1": key1})
+	require.NoError(t, err)
+	dc := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec)
+
+	data, err := converter.EncodeValues(converter.GetDefaultDataConverter(), 42)
+	require.NoError(t, err)
+
+	var out int
+	require.NoError(t, converter.DecodeValues(dc, data, &out))
+	assert.Equal(t, 42, out)
+}
+
+func TestEncryptionCodec_InvalidKeys(t *testing.T) {
+	_, err := converter.NewEncryptionCodec("missing", map[string][]byte{"k1": key1})
+	require.ErrorIs(t, err, converter.ErrUnknownEncryptionKey)
+
+	_, err = converter.NewEncryptionCodec("k1", map[string][]byte{"k1": []byte("short")})
+	require.Error(t, err)
+}
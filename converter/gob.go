@@ -0,0 +1,30 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// GobConverter encodes values with encoding/gob. Unlike JSON it keeps time zones and full int64
+// precision, but values stored in interface fields must be registered with gob.Register.
+type GobConverter struct{}
+
+func NewGobConverter() *GobConverter {
+	return &GobConverter{}
+}
+
+func (c *GobConverter) Encoding() string {
+	return EncodingGob
+}
+
+func (c *GobConverter) ToPayload(value any) (*Payload, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return NewPayload(EncodingGob, buf.Bytes()), nil
+}
+
+func (c *GobConverter) FromPayload(payload *Payload, valuePtr any) error {
+	return gob.NewDecoder(bytes.NewReader(payload.Data)).Decode(valuePtr)
+}
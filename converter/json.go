@@ -0,0 +1,32 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSONConverter encodes values with encoding/json.
+type JSONConverter struct{}
+
+func NewJSONConverter() *JSONConverter {
+	return &JSONConverter{}
+}
+
+func (c *JSONConverter) Encoding() string {
+	return EncodingJSON
+}
+
+func (c *JSONConverter) ToPayload(value any) (*Payload, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return NewPayload(EncodingJSON, data), nil
+}
+
+// FromPayload decodes numbers into interface values as json.Number so int64 values keep their precision.
+func (c *JSONConverter) FromPayload(payload *Payload, valuePtr any) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload.Data))
+	decoder.UseNumber()
+	return decoder.Decode(valuePtr)
+}
@@ -0,0 +1,141 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MetadataEncoding is the metadata key every payload carries its encoding under.
+const MetadataEncoding = "encoding"
+
+const (
+	EncodingJSON  = "json/plain"
+	EncodingGob   = "binary/gob"
+	EncodingProto = "binary/protobuf"
+)
+
+// Payload is a single encoded value tagged with the metadata needed to decode it again.
+type Payload struct {
+	Metadata map[string]string
+	Data     []byte
+}
+
+// NewPayload creates a payload with the given encoding.
+func NewPayload(encoding string, data []byte) *Payload {
+	return &Payload{
+		Metadata: map[string]string{MetadataEncoding: encoding},
+		Data:     data,
+	}
+}
+
+// Encoding returns the encoding the payload was written with.
+func (p *Payload) Encoding() string {
+	return p.Metadata[MetadataEncoding]
+}
+
+type payloadJSON struct {
+	Metadata map[string]string `json:"metadata"`
+	Data     json.RawMessage   `json:"data"`
+}
+
+// MarshalJSON keeps JSON encoded data readable in the JSONB columns; any other encoding is stored as base64.
+func (p *Payload) MarshalJSON() ([]byte, error) {
+	data := json.RawMessage(p.Data)
+	if p.Encoding() != EncodingJSON {
+		var err error
+		data, err = json.Marshal(p.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(payloadJSON{Metadata: p.Metadata, Data: data})
+}
+
+func (p *Payload) UnmarshalJSON(b []byte) error {
+	var pj payloadJSON
+	if err := json.Unmarshal(b, &pj); err != nil {
+		return err
+	}
+	p.Metadata = pj.Metadata
+	if p.Encoding() == EncodingJSON {
+		p.Data = pj.Data
+		return nil
+	}
+	return json.Unmarshal(pj.Data, &p.Data)
+}
+
+// Payloads is the document stored in the input and output columns.
+type Payloads struct {
+	Payloads []*Payload `json:"payloads"`
+}
+
+// EncodeValues encodes values with dc into the document stored in the database.
+func EncodeValues(dc DataConverter, values ...any) ([]byte, error) {
+	payloads := &Payloads{Payloads: make([]*Payload, 0, len(values))}
+	for i, value := range values {
+		payload, err := dc.ToPayload(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value %d: %w", i, err)
+		}
+		payloads.Payloads = append(payloads.Payloads, payload)
+	}
+	return json.Marshal(payloads)
+}
+
+// DecodeValues decodes a document written by EncodeValues into valuePtrs. Documents written before payloads
+// were tagged with their encoding are plain JSON arrays and are decoded as JSON.
+func DecodeValues(dc DataConverter, data []byte, valuePtrs ...any) error {
+	var payloads Payloads
+	var legacy []json.RawMessage
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		for _, raw := range legacy {
+			payloads.Payloads = append(payloads.Payloads, NewPayload(EncodingJSON, raw))
+		}
+	} else if err := json.Unmarshal(data, &payloads); err != nil {
+		return fmt.Errorf("failed to unmarshal payloads: %w", err)
+	}
+
+	if len(payloads.Payloads) != len(valuePtrs) {
+		return fmt.Errorf("expected %d values, got %d payloads", len(valuePtrs), len(payloads.Payloads))
+	}
+	for i, payload := range payloads.Payloads {
+		if err := dc.FromPayload(payload, valuePtrs[i]); err != nil {
+			return fmt.Errorf("failed to decode value %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// EncodeMemo encodes each value of memo with dc into the JSON object of payloads stored as the
+// memo of a workflow run.
+func EncodeMemo(dc DataConverter, memo map[string]any) ([]byte, error) {
+	payloads := make(map[string]*Payload, len(memo))
+	for key, value := range memo {
+		payload, err := dc.ToPayload(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode memo %s: %w", key, err)
+		}
+		payloads[key] = payload
+	}
+	return json.Marshal(payloads)
+}
+
+// DecodeMemoValue decodes the value stored under key in a memo written by EncodeMemo into
+// valuePtr. It reports false if the memo has no such key.
+func DecodeMemoValue(dc DataConverter, data []byte, key string, valuePtr any) (bool, error) {
+	if len(data) == 0 {
+		return false, nil
+	}
+	var payloads map[string]*Payload
+	if err := json.Unmarshal(data, &payloads); err != nil {
+		return false, fmt.Errorf("failed to unmarshal memo: %w", err)
+	}
+	payload, ok := payloads[key]
+	if !ok {
+		return false, nil
+	}
+	if err := dc.FromPayload(payload, valuePtr); err != nil {
+		return false, fmt.Errorf("failed to decode memo %s: %w", key, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,53 @@
+package converter
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtoConverter encodes protobuf messages in their binary wire format.
+type ProtoConverter struct{}
+
+func NewProtoConverter() *ProtoConverter {
+	return &ProtoConverter{}
+}
+
+func (c *ProtoConverter) Encoding() string {
+	return EncodingProto
+}
+
+func (c *ProtoConverter) ToPayload(value any) (*Payload, error) {
+	msg, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("value must be a proto.Message, got %T", value)
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return NewPayload(EncodingProto, data), nil
+}
+
+// FromPayload accepts either a message (*T) or a pointer to a message pointer (**T), which is
+// allocated when nil.
+func (c *ProtoConverter) FromPayload(payload *Payload, valuePtr any) error {
+	if msg, ok := valuePtr.(proto.Message); ok {
+		return proto.Unmarshal(payload.Data, msg)
+	}
+
+	ptr := reflect.ValueOf(valuePtr)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("value must be a proto.Message, got %T", valuePtr)
+	}
+	elem := ptr.Elem()
+	if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+	msg, ok := elem.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("value must be a proto.Message, got %T", valuePtr)
+	}
+	return proto.Unmarshal(payload.Data, msg)
+}
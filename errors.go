@@ -1 +1,42 @@
 package pitlane
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+// ErrPayloadTooLarge is matched by every PayloadSizeError.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// PayloadSizeError reports an encoded payload larger than a configured limit.
+type PayloadSizeError struct {
+	// Kind describes what the payload is, e.g. "workflow input".
+	Kind  string
+	Size  int
+	Limit int
+}
+
+func (e *PayloadSizeError) Error() string {
+	return fmt.Sprintf("%s of %d bytes exceeds limit of %d bytes", e.Kind, e.Size, e.Limit)
+}
+
+func (e *PayloadSizeError) Unwrap() error {
+	return ErrPayloadTooLarge
+}
+
+// WorkflowRunError reports a workflow run that failed or was aborted instead of finishing.
+type WorkflowRunError struct {
+	WorkflowRunID string
+	Status        backend.WorkflowStatus
+	// Message is the error the run failed with, if any.
+	Message string
+}
+
+func (e *WorkflowRunError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("workflow run %s %s", e.WorkflowRunID, e.Status)
+	}
+	return fmt.Sprintf("workflow run %s %s: %s", e.WorkflowRunID, e.Status, e.Message)
+}
@@ -0,0 +1,40 @@
+package pitlane_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// OrderWorkflow is the workflow most engine tests invoke.
+func OrderWorkflow(_ context.Context, orderID string) (string, error) {
+	return orderID, nil
+}
+
+// registerOrderWorkflow registers OrderWorkflow once for all tests, since registering a workflow
+// twice fails.
+var registerOrderWorkflow = sync.OnceValue(func() error {
+	return pitlane.RegisterWorkflow(OrderWorkflow)
+})
+
+// newMemoryEngine returns an engine on a new memory backend with OrderWorkflow registered.
+// configure, when not nil, adjusts the engine config first.
+func newMemoryEngine(
+	t *testing.T,
+	configure func(config *pitlane.EngineConfig),
+) (*pitlane.WorkflowEngine, *memory.Backend) {
+	t.Helper()
+	require.NoError(t, registerOrderWorkflow())
+	b := memory.New()
+	config := pitlane.NewEngineConfig(nil, true)
+	if configure != nil {
+		configure(config)
+	}
+	we, err := pitlane.NewWorkflowEngineWithBackend(context.Background(), b, config)
+	require.NoError(t, err)
+	return we, b
+}
@@ -0,0 +1,19 @@
+package pitlane
+
+import (
+	"context"
+
+	"github.com/nurburg-dev/pitlane/history"
+)
+
+// ExportWorkflowRun returns the workflow run with the given ID and its activity runs as a
+// history document, which history.Write serializes in its documented JSON format.
+func (we *WorkflowEngine) ExportWorkflowRun(ctx context.Context, workflowRunID string) (*history.Document, error) {
+	return history.Export(ctx, we.backend, workflowRunID)
+}
+
+// ImportWorkflowRun stores an exported workflow run, e.g. to reproduce a production run in
+// another database. The run keeps its ID and must not exist yet; see history.Import.
+func (we *WorkflowEngine) ImportWorkflowRun(ctx context.Context, doc *history.Document) error {
+	return history.Import(ctx, we.backend, doc)
+}
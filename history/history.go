@@ -0,0 +1,282 @@
+// Package history exports and imports complete workflow runs as versioned JSON documents.
+//
+// A document written by format version 1 looks like:
+//
+//	{
+//	  "format_version": 1,
+//	  "exported_at": "2024-01-01T12:00:00Z",
+//	  "workflow": {"name": "...", "created_at": "...", "updated_at": "..."},
+//	  "run": {"id": "...", "workflow_name": "...", "status": "finished", "input": {...}, ...},
+//	  "activities": [{"id": "...", "activity_name": "...", "status": "finished", "input": {...},
+//	                  "output": {...}, "error_message": null, ...}],
+//	  "events": [{"workflow_run_id": "...", "sequence": 1, "event_type": "workflow_run_created", ...}]
+//	}
+//
+// "run" and each entry of "activities" hold every column of the workflow_runs and activity_runs
+// rows under the column name, with timestamps in RFC 3339. Inputs and outputs are the payload
+// documents written by the data converter, {"payloads": [...]}, so encrypted or compressed values
+// stay opaque. Activities are ordered by creation time and events by sequence number.
+//
+// Events are the source of truth for the activities of a run: Export takes their names, inputs,
+// statuses, outputs and errors from the events, and only scheduling details such as the task
+// queue and attempt from the activity rows. Replayers read activity results from the events
+// when a document has them. Import recreates the rows from "activities" and stores "events" as
+// they are. The engine does not store signals or timers yet; they will be added as new
+// fields without changing the meaning of existing ones.
+//
+// Readers must ignore unknown fields. A change that alters existing fields increments FormatVersion.
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+// FormatVersion is the version of the documents written by this package.
+const FormatVersion = 1
+
+// ErrUnsupportedFormatVersion is returned when reading a document written by a newer format version.
+var ErrUnsupportedFormatVersion = errors.New("unsupported history format version")
+
+// Document is a complete workflow run.
+type Document struct {
+	FormatVersion int                   `json:"format_version"`
+	ExportedAt    time.Time             `json:"exported_at"`
+	Workflow      backend.Workflow      `json:"workflow"`
+	Run           backend.WorkflowRun   `json:"run"`
+	Activities    []backend.ActivityRun `json:"activities"`
+	// Events is the event history of the run; documents written before events existed omit it.
+	Events []backend.WorkflowEvent `json:"events,omitempty"`
+}
+
+// Export reads the workflow run with the given ID and its activity runs from b.
+func Export(ctx context.Context, b backend.Backend, workflowRunID string) (*Document, error) {
+	var doc *Document
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		doc, err = ExportTx(ctx, tx, workflowRunID)
+		return err
+	})
+	return doc, err
+}
+
+// ExportTx is Export within an existing transaction.
+func ExportTx(ctx context.Context, tx backend.Tx, workflowRunID string) (*Document, error) {
+	run, err := tx.WorkflowRepository().GetWorkflowRun(ctx, workflowRunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow run: %w", err)
+	}
+	if run == nil {
+		return nil, fmt.Errorf("workflow run %s does not exist", workflowRunID)
+	}
+	workflow, err := tx.WorkflowRepository().GetWorkflow(ctx, run.WorkflowName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	events, err := tx.WorkflowEventRepository().GetWorkflowEvents(ctx, workflowRunID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow events: %w", err)
+	}
+	rows, err := tx.ActivityRunRepository().GetActivityRunHistory(ctx, workflowRunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activity run history: %w", err)
+	}
+	activities := rows
+	if len(events) > 0 {
+		if activities, err = exportActivities(events, rows); err != nil {
+			return nil, err
+		}
+	}
+
+	doc := &Document{
+		FormatVersion: FormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		Run:           *run,
+		Activities:    activities,
+		Events:        events,
+	}
+	if workflow != nil {
+		doc.Workflow = *workflow
+	}
+	return doc, nil
+}
+
+// ActivitiesFromEvents rebuilds the activity runs of a workflow run from its events, in the order
+// they were created. Each run has the name and input of its creation event, and the status,
+// output and error of its latest events. Times are those of the events; the details the events
+// do not record, such as the task queue and attempt, are left empty.
+func ActivitiesFromEvents(events []backend.WorkflowEvent) ([]backend.ActivityRun, error) {
+	var activities []backend.ActivityRun
+	indexes := map[string]int{}
+	for _, event := range events {
+		if event.ActivityRunID == nil {
+			continue
+		}
+		id := *event.ActivityRunID
+		var payload backend.WorkflowEventPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("failed to decode event %d: %w", event.Sequence, err)
+		}
+
+		switch event.EventType {
+		case backend.WorkflowEventActivityRunCreated:
+			indexes[id] = len(activities)
+			activities = append(activities, backend.ActivityRun{
+				ID:            id,
+				ActivityName:  payload.ActivityName,
+				WorkflowRunID: event.WorkflowRunID,
+				Input:         payload.Input,
+				Output:        payload.Output,
+				ErrorMessage:  payload.ErrorMessage,
+				Status:        backend.ActivityStatus(payload.Status),
+				ScheduledAt:   event.CreatedAt,
+				CreatedAt:     event.CreatedAt,
+				UpdatedAt:     event.CreatedAt,
+			})
+		case backend.WorkflowEventActivityRunStatusChanged:
+			i, ok := indexes[id]
+			if !ok {
+				return nil, fmt.Errorf("event %d changes activity run %s before creating it", event.Sequence, id)
+			}
+			activity := &activities[i]
+			activity.Status = backend.ActivityStatus(payload.Status)
+			activity.UpdatedAt = event.CreatedAt
+			if payload.Output != nil || payload.ErrorMessage != nil {
+				activity.Output, activity.ErrorMessage = payload.Output, payload.ErrorMessage
+			}
+		}
+	}
+	return activities, nil
+}
+
+// exportActivities rebuilds the activity runs from events, completing them with the scheduling
+// details of their rows.
+func exportActivities(events []backend.WorkflowEvent, rows []backend.ActivityRun) ([]backend.ActivityRun, error) {
+	activities, err := ActivitiesFromEvents(events)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]backend.ActivityRun, len(rows))
+	for _, row := range rows {
+		byID[row.ID] = row
+	}
+	for i, activity := range activities {
+		row, ok := byID[activity.ID]
+		if !ok {
+			continue
+		}
+		row.ActivityName, row.Input, row.Status = activity.ActivityName, activity.Input, activity.Status
+		row.Output, row.ErrorMessage = activity.Output, activity.ErrorMessage
+		activities[i] = row
+	}
+	return activities, nil
+}
+
+// Import writes the workflow run in doc, its activity runs and its events to b in a single
+// transaction, keeping their IDs and event sequence numbers. Only closed runs whose activity runs
+// are closed too can be imported, so that no worker picks them up; their leases are dropped. It
+// fails if the run already exists. A document without events gets the events of newly created
+// runs.
+func Import(ctx context.Context, b backend.Backend, doc *Document) error {
+	if err := checkImport(doc); err != nil {
+		return err
+	}
+	workflow := doc.Workflow
+	if workflow.Name == "" {
+		workflow = backend.Workflow{Name: doc.Run.WorkflowName, CreatedAt: doc.Run.CreatedAt, UpdatedAt: doc.Run.CreatedAt}
+	}
+	run := doc.Run
+	run.LeaseOwner, run.LeaseExpiresAt = nil, nil
+
+	return b.RunInTx(ctx, func(tx backend.Tx) error {
+		existing, err := tx.WorkflowRepository().GetWorkflowRun(ctx, doc.Run.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get workflow run: %w", err)
+		}
+		if existing != nil {
+			return fmt.Errorf("workflow run %s already exists", doc.Run.ID)
+		}
+
+		if err := tx.WorkflowRepository().UpsertWorkflow(ctx, &workflow); err != nil {
+			return fmt.Errorf("failed to upsert workflow: %w", err)
+		}
+		if err := tx.WorkflowRepository().CreateWorkflowRun(ctx, &run); err != nil {
+			return fmt.Errorf("failed to create workflow run: %w", err)
+		}
+		for _, activity := range doc.Activities {
+			activity.LeaseOwner, activity.LeaseExpiresAt = nil, nil
+			if err := tx.ActivityRunRepository().CreateActivityRun(ctx, &activity); err != nil {
+				return fmt.Errorf("failed to create activity run %s: %w", activity.ID, err)
+			}
+		}
+		if len(doc.Events) == 0 {
+			return nil
+		}
+		// The events created with the rows above are replaced by the recorded ones.
+		if err := tx.WorkflowEventRepository().ReplaceWorkflowEvents(ctx, run.ID, doc.Events); err != nil {
+			return fmt.Errorf("failed to import workflow events: %w", err)
+		}
+		return nil
+	})
+}
+
+// checkImport returns an error unless doc holds a closed run with closed activity runs and
+// events numbered 1, 2, 3, ...
+func checkImport(doc *Document) error {
+	if !doc.Run.Status.IsClosed() {
+		return fmt.Errorf("workflow run %s is %s, only closed runs can be imported", doc.Run.ID, doc.Run.Status)
+	}
+	for _, activity := range doc.Activities {
+		if !activity.Status.IsClosed() {
+			return fmt.Errorf("activity run %s is %s, only closed activity runs can be imported",
+				activity.ID, activity.Status)
+		}
+	}
+	for i, event := range doc.Events {
+		if event.WorkflowRunID != doc.Run.ID {
+			return fmt.Errorf("event %d belongs to workflow run %s, not %s", event.Sequence, event.WorkflowRunID, doc.Run.ID)
+		}
+		if event.Sequence != int64(i+1) {
+			return fmt.Errorf("event %d of workflow run %s is out of sequence", event.Sequence, doc.Run.ID)
+		}
+	}
+	return nil
+}
+
+// Write encodes doc as indented JSON.
+func Write(w io.Writer, doc *Document) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode history: %w", err)
+	}
+	return nil
+}
+
+// Read decodes a document. Documents without a format version, holding only "run" and
+// "activities", are read as version 1.
+func Read(r io.Reader) (*Document, error) {
+	var doc Document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode history: %w", err)
+	}
+	if doc.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedFormatVersion, doc.FormatVersion)
+	}
+	if doc.Run.ID == "" || doc.Run.WorkflowName == "" {
+		return nil, errors.New("history has no workflow run")
+	}
+	for _, activity := range doc.Activities {
+		if activity.WorkflowRunID != doc.Run.ID {
+			return nil, fmt.Errorf("activity run %s belongs to workflow run %s, not %s",
+				activity.ID, activity.WorkflowRunID, doc.Run.ID)
+		}
+	}
+	return &doc, nil
+}
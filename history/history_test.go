@@ -0,0 +1,194 @@
+package history_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/backend/memory"
+	"github.com/nurburg-dev/pitlane/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seed(ctx context.Context, t *testing.T, b backend.Backend) {
+	t.Helper()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	output := json.RawMessage(`{"payloads":[{"metadata":{"encoding":"json/plain"},"data":"done"}]}`)
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		require.NoError(t, tx.WorkflowRepository().UpsertWorkflow(ctx, &backend.Workflow{
+			Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, tx.WorkflowRepository().CreateWorkflowRun(ctx, &backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        json.RawMessage(`{"payloads":[]}`),
+			WorkflowName: "test-workflow",
+			Status:       backend.WorkflowStatusFinished,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}))
+		for i, id := range []string{"activity-1", "activity-2"} {
+			require.NoError(t, tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+				ID:            id,
+				ActivityName:  "test-activity",
+				WorkflowRunID: "run-1",
+				Input:         json.RawMessage(`{"payloads":[]}`),
+				Output:        &output,
+				Status:        backend.ActivityStatusFinished,
+				ScheduledAt:   now,
+				CreatedAt:     now.Add(time.Duration(i) * time.Second),
+				UpdatedAt:     now,
+			}))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	source := memory.New()
+	seed(ctx, t, source)
+
+	doc, err := history.Export(ctx, source, "run-1")
+	require.NoError(t, err)
+	assert.Equal(t, history.FormatVersion, doc.FormatVersion)
+	assert.Equal(t, "test-workflow", doc.Workflow.Name)
+	require.Len(t, doc.Activities, 2)
+	assert.Equal(t, "activity-1", doc.Activities[0].ID)
+	require.Len(t, doc.Events, 3)
+	assert.Equal(t, backend.WorkflowEventRunCreated, doc.Events[0].EventType)
+
+	var buf bytes.Buffer
+	require.NoError(t, history.Write(&buf, doc))
+	assert.Contains(t, buf.String(), `"format_version": 1`)
+
+	read, err := history.Read(&buf)
+	require.NoError(t, err)
+
+	target := memory.New()
+	require.NoError(t, history.Import(ctx, target, read))
+	imported, err := history.Export(ctx, target, "run-1")
+	require.NoError(t, err)
+	// Write indents the payload documents, which is insignificant to every backend.
+	assert.JSONEq(t, string(doc.Run.Input), string(imported.Run.Input))
+	imported.Run.Input = doc.Run.Input
+	assert.Equal(t, doc.Run, imported.Run)
+	require.Len(t, imported.Activities, 2)
+	for i, activity := range imported.Activities {
+		assert.Equal(t, doc.Activities[i].ID, activity.ID)
+		assert.True(t, doc.Activities[i].CreatedAt.Equal(activity.CreatedAt))
+		require.NotNil(t, activity.Output)
+		assert.JSONEq(t, string(*doc.Activities[i].Output), string(*activity.Output))
+	}
+
+	// Events are imported as they were recorded rather than recreated.
+	require.Len(t, imported.Events, len(doc.Events))
+	for i, event := range imported.Events {
+		assert.Equal(t, doc.Events[i].Sequence, event.Sequence)
+		assert.Equal(t, doc.Events[i].EventType, event.EventType)
+		assert.True(t, doc.Events[i].CreatedAt.Equal(event.CreatedAt))
+		assert.JSONEq(t, string(doc.Events[i].Payload), string(event.Payload))
+	}
+
+	require.ErrorContains(t, history.Import(ctx, target, read), "already exists")
+	_, err = history.Export(ctx, target, "missing")
+	require.Error(t, err)
+}
+
+func TestImport_Rejected(t *testing.T) {
+	ctx := context.Background()
+	source := memory.New()
+	seed(ctx, t, source)
+	doc, err := history.Export(ctx, source, "run-1")
+	require.NoError(t, err)
+	target := memory.New()
+
+	open := *doc
+	open.Run.Status = backend.WorkflowStatusPending
+	require.ErrorContains(t, history.Import(ctx, target, &open), "only closed runs")
+
+	open = *doc
+	open.Activities = slices.Clone(doc.Activities)
+	open.Activities[1].Status = backend.ActivityStatusExecuting
+	require.ErrorContains(t, history.Import(ctx, target, &open), "only closed activity runs")
+
+	gap := *doc
+	gap.Events = doc.Events[1:]
+	require.ErrorContains(t, history.Import(ctx, target, &gap), "out of sequence")
+
+	_, err = history.Export(ctx, target, "run-1")
+	require.Error(t, err)
+
+	// Leases left in a document are dropped.
+	leased := *doc
+	owner, expiresAt := "worker-1", time.Now().Add(time.Hour)
+	leased.Run.LeaseOwner, leased.Run.LeaseExpiresAt = &owner, &expiresAt
+	require.NoError(t, history.Import(ctx, target, &leased))
+	imported, err := history.Export(ctx, target, "run-1")
+	require.NoError(t, err)
+	assert.Nil(t, imported.Run.LeaseOwner)
+	assert.Nil(t, imported.Run.LeaseExpiresAt)
+}
+
+func TestRead(t *testing.T) {
+	_, err := history.Read(strings.NewReader(`{"format_version": 2, "run": {"id": "run-1", "workflow_name": "w"}}`))
+	require.ErrorIs(t, err, history.ErrUnsupportedFormatVersion)
+
+	_, err = history.Read(strings.NewReader(`{"format_version": 1}`))
+	require.ErrorContains(t, err, "no workflow run")
+
+	_, err = history.Read(strings.NewReader(`{
+		"run": {"id": "run-1", "workflow_name": "w"},
+		"activities": [{"id": "activity-1", "workflow_run_id": "run-2"}]
+	}`))
+	require.ErrorContains(t, err, "belongs to workflow run run-2")
+
+	doc, err := history.Read(strings.NewReader(`{"run": {"id": "run-1", "workflow_name": "w"}, "unknown": true}`))
+	require.NoError(t, err)
+	assert.Equal(t, "run-1", doc.Run.ID)
+}
+
+func TestActivitiesFromEvents(t *testing.T) {
+	output := json.RawMessage(`{"payloads":[{"metadata":{"encoding":"json/plain"},"data":"done"}]}`)
+	message := "card declined"
+	events := []*backend.WorkflowEvent{
+		backend.ActivityRunCreatedEvent(&backend.ActivityRun{
+			ID: "activity-1", ActivityName: "charge", WorkflowRunID: "run-1",
+			Input: json.RawMessage(`{"payloads":[]}`), Status: backend.ActivityStatusPending,
+		}),
+		backend.ActivityRunCreatedEvent(&backend.ActivityRun{
+			ID: "activity-2", ActivityName: "ship", WorkflowRunID: "run-1", Status: backend.ActivityStatusPending,
+		}),
+		backend.ActivityRunStatusChangedEvent("run-1", "activity-1", backend.ActivityStatusExecuting),
+		backend.ActivityRunClosedEvent("run-1", "activity-1", backend.ActivityStatusFinished, &output, nil),
+		backend.WorkflowRunStatusChangedEvent("run-1", backend.WorkflowStatusExecuting),
+		backend.ActivityRunClosedEvent("run-1", "activity-2", backend.ActivityStatusFailed, nil, &message),
+	}
+	recorded := make([]backend.WorkflowEvent, len(events))
+	for i, event := range events {
+		event.Sequence = int64(i + 1)
+		recorded[i] = *event
+	}
+
+	activities, err := history.ActivitiesFromEvents(recorded)
+	require.NoError(t, err)
+	require.Len(t, activities, 2)
+	assert.Equal(t, "activity-1", activities[0].ID)
+	assert.Equal(t, "charge", activities[0].ActivityName)
+	assert.Equal(t, "run-1", activities[0].WorkflowRunID)
+	assert.JSONEq(t, `{"payloads":[]}`, string(activities[0].Input))
+	assert.Equal(t, backend.ActivityStatusFinished, activities[0].Status)
+	assert.JSONEq(t, string(output), string(*activities[0].Output))
+	assert.Equal(t, backend.ActivityStatusFailed, activities[1].Status)
+	assert.Equal(t, &message, activities[1].ErrorMessage)
+
+	_, err = history.ActivitiesFromEvents(recorded[2:])
+	require.ErrorContains(t, err, "before creating it")
+}
@@ -2,7 +2,14 @@ package db
 
 import (
 	"context"
-	_ "embed"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -11,20 +18,204 @@ type DBInitator interface {
 	Init(ctx context.Context) error
 }
 
-//go:embed pgschema.sql
-var schemaSQL string
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// ErrSchemaVersionMismatch is returned when the database schema is older or newer than the
+// migrations this build knows about.
+var ErrSchemaVersionMismatch = errors.New("schema version mismatch")
+
+// migrationLockName seeds the pg_advisory_lock key serializing migrations across engines.
+const migrationLockName = "pitlane_schema_migrations"
+
+// Migration is a single schema change, loaded from migrations/<version>_<name>.sql. The SQL is a
+// text/template rendered with the table and index functions, which resolve names through Tables.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Render returns the migration's SQL for the given tables.
+func (m Migration) Render(tables Tables) (string, error) {
+	tmpl, err := template.New(m.Name).Funcs(template.FuncMap{
+		"table": tables.Table,
+		"index": tables.Index,
+	}).Parse(m.SQL)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, nil); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// LoadMigrations returns the embedded Postgres migrations ordered by version.
+func LoadMigrations() ([]Migration, error) {
+	fsys, err := fs.Sub(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return ReadMigrations(fsys)
+}
+
+// ReadMigrations reads <version>_<name>.sql files from the root of fsys, ordered by version.
+func ReadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		versionStr, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+		sql, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(sql)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be sequential from 1, found %d at position %d", m.Version, i+1)
+		}
+	}
+	return migrations, nil
+}
 
 type PGInitiator struct {
-	pool *pgxpool.Pool
+	pool   *pgxpool.Pool
+	tables Tables
 }
 
-func NewPGInitiator(pool *pgxpool.Pool) *PGInitiator {
+func NewPGInitiator(pool *pgxpool.Pool, tables Tables) *PGInitiator {
 	return &PGInitiator{
-		pool: pool,
+		pool:   pool,
+		tables: tables,
 	}
 }
 
+// Init applies pending migrations. A session level advisory lock makes concurrent callers
+// wait for each other, so each migration runs exactly once.
 func (p *PGInitiator) Init(ctx context.Context) error {
-	_, err := p.pool.Exec(ctx, schemaSQL)
-	return err
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	lockID := p.tables.lockID()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		_, _ = conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockID)
+	}()
+
+	if _, err := conn.Exec(ctx, `CREATE SCHEMA IF NOT EXISTS `+p.tables.QuotedSchema()); err != nil {
+		return fmt.Errorf("failed to create schema: %w", err)
+	}
+	_, err = conn.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version INTEGER PRIMARY KEY NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+		)
+	`, p.tables.Table(TableSchemaMigrations)))
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	current, err := p.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("%w: database is at version %d, newer than supported version %d",
+			ErrSchemaVersionMismatch, current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		if err := p.apply(ctx, conn, m); err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *PGInitiator) apply(ctx context.Context, conn *pgxpool.Conn, m Migration) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	sql, err := m.Render(p.tables)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		fmt.Sprintf(`INSERT INTO %s (version, name) VALUES ($1, $2)`, p.tables.Table(TableSchemaMigrations)),
+		m.Version, m.Name,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SchemaVersion returns the highest applied migration, or 0 for a database that was never migrated.
+func (p *PGInitiator) SchemaVersion(ctx context.Context) (int, error) {
+	exists, err := TableExists(ctx, p.pool, p.tables.SchemaName(), p.tables.TableName(TableSchemaMigrations))
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	query := fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s`, p.tables.Table(TableSchemaMigrations))
+	err = p.pool.QueryRow(ctx, query).Scan(&version)
+	return version, err
+}
+
+// Verify checks that the database schema matches the migrations this build knows about.
+func (p *PGInitiator) Verify(ctx context.Context) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	current, err := p.SchemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current != len(migrations) {
+		return fmt.Errorf("%w: database is at version %d, expected %d",
+			ErrSchemaVersionMismatch, current, len(migrations))
+	}
+	return nil
 }
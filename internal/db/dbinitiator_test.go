@@ -0,0 +1,34 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := db.LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Name)
+	}
+}
+
+func TestMigration_Render(t *testing.T) {
+	migrations, err := db.LoadMigrations()
+	require.NoError(t, err)
+
+	sql, err := migrations[0].Render(db.NewTables("pitlane", "pl_"))
+	require.NoError(t, err)
+	assert.Contains(t, sql, `CREATE TABLE IF NOT EXISTS "pitlane"."pl_workflow_runs"`)
+	assert.Contains(t, sql, `REFERENCES "pitlane"."pl_workflows"(name)`)
+	assert.Contains(t, sql, `CREATE INDEX IF NOT EXISTS "pl_idx_workflow_runs_pending" ON "pitlane"."pl_workflow_runs"`)
+
+	sql, err = migrations[0].Render(db.Tables{})
+	require.NoError(t, err)
+	assert.Contains(t, sql, `CREATE TABLE IF NOT EXISTS "public"."workflow_runs"`)
+}
@@ -0,0 +1,14 @@
+-- Append-only history of every change to a workflow run. Sequence numbers are allocated from
+-- workflow_runs.last_event_sequence, whose row lock keeps them gap-free per run.
+
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS last_event_sequence BIGINT DEFAULT 0 NOT NULL;
+
+CREATE TABLE IF NOT EXISTS {{table "workflow_events"}} (
+    workflow_run_id VARCHAR(255) REFERENCES {{table "workflow_runs"}}(id) NOT NULL,
+    sequence BIGINT NOT NULL,
+    event_type VARCHAR(255) NOT NULL,
+    activity_run_id VARCHAR(255),
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (workflow_run_id, sequence)
+);
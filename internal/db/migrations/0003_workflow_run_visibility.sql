@@ -0,0 +1,13 @@
+-- Columns and indexes for listing and filtering workflow runs. Listings are ordered by
+-- (created_at DESC, id DESC), which the indexes below end with so keyset pages are index scans.
+
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS closed_at TIMESTAMPTZ;
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS labels JSONB DEFAULT '{}' NOT NULL;
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS parent_workflow_run_id VARCHAR(255) REFERENCES {{table "workflow_runs"}}(id);
+
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_created"}} ON {{table "workflow_runs"}} (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_name_created"}} ON {{table "workflow_runs"}} (workflow_name, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_status_created"}} ON {{table "workflow_runs"}} (status, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_closed"}} ON {{table "workflow_runs"}} (closed_at) WHERE closed_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_parent"}} ON {{table "workflow_runs"}} (parent_workflow_run_id) WHERE parent_workflow_run_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_labels"}} ON {{table "workflow_runs"}} USING GIN (labels jsonb_path_ops);
@@ -0,0 +1,7 @@
+-- Custom search attributes and memo of workflow runs. Search attributes are indexed for
+-- containment (equality) filters; the memo is only returned with the run.
+
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS search_attributes JSONB DEFAULT '{}' NOT NULL;
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS memo JSONB DEFAULT '{}' NOT NULL;
+
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_search_attributes"}} ON {{table "workflow_runs"}} USING GIN (search_attributes jsonb_path_ops);
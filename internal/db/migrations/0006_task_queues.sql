@@ -0,0 +1,8 @@
+-- Task queues route pending runs to the workers polling them. Activity runs are created on the
+-- queue of their workflow run unless they name another one.
+
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS task_queue VARCHAR(255) DEFAULT 'default' NOT NULL;
+ALTER TABLE {{table "activity_runs"}} ADD COLUMN IF NOT EXISTS task_queue VARCHAR(255) DEFAULT 'default' NOT NULL;
+
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_queue_pending"}} ON {{table "workflow_runs"}} (task_queue, scheduled_at DESC) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS {{index "idx_activity_runs_queue_pending"}} ON {{table "activity_runs"}} (task_queue, scheduled_at DESC) WHERE status = 'pending';
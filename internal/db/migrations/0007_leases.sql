@@ -0,0 +1,16 @@
+-- Executing runs are leased to the worker that claimed them. Runs whose lease expired are
+-- returned to pending with their attempt incremented. Runs already executing have no lease to
+-- renew, so theirs expires right away and the reaper returns them to pending.
+
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS attempt INTEGER DEFAULT 1 NOT NULL;
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS lease_owner VARCHAR(255);
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+ALTER TABLE {{table "activity_runs"}} ADD COLUMN IF NOT EXISTS attempt INTEGER DEFAULT 1 NOT NULL;
+ALTER TABLE {{table "activity_runs"}} ADD COLUMN IF NOT EXISTS lease_owner VARCHAR(255);
+ALTER TABLE {{table "activity_runs"}} ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+
+UPDATE {{table "workflow_runs"}} SET lease_expires_at = NOW() WHERE status = 'executing' AND lease_expires_at IS NULL;
+UPDATE {{table "activity_runs"}} SET lease_expires_at = NOW() WHERE status = 'executing' AND lease_expires_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS {{index "idx_workflow_runs_lease_expires"}} ON {{table "workflow_runs"}} (lease_expires_at) WHERE status = 'executing';
+CREATE INDEX IF NOT EXISTS {{index "idx_activity_runs_lease_expires"}} ON {{table "activity_runs"}} (lease_expires_at) WHERE status = 'executing';
@@ -0,0 +1,15 @@
+-- Worker processes register themselves and heartbeat, so operators can see which workers are
+-- alive, what they poll and which build they run.
+
+CREATE TABLE IF NOT EXISTS {{table "workers"}} (
+    id VARCHAR(255) PRIMARY KEY NOT NULL,
+    hostname VARCHAR(255) NOT NULL,
+    task_queues TEXT[] DEFAULT '{}' NOT NULL,
+    workflows TEXT[] DEFAULT '{}' NOT NULL,
+    activities TEXT[] DEFAULT '{}' NOT NULL,
+    build_version VARCHAR(255) NOT NULL,
+    started_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    last_heartbeat_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS {{index "idx_workers_task_queues"}} ON {{table "workers"}} USING GIN (task_queues);
@@ -0,0 +1,5 @@
+-- Runs keep the trace context of the span that started them, so that the spans of workers
+-- executing them later join the same trace.
+
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS trace_context JSONB DEFAULT '{}' NOT NULL;
+ALTER TABLE {{table "activity_runs"}} ADD COLUMN IF NOT EXISTS trace_context JSONB DEFAULT '{}' NOT NULL;
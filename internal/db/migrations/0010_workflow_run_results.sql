@@ -0,0 +1,5 @@
+-- Closed runs keep the output of a finished run or the error of a failed one, so that callers
+-- can wait for the result of the runs they start.
+
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS output JSONB;
+ALTER TABLE {{table "workflow_runs"}} ADD COLUMN IF NOT EXISTS error_message TEXT;
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	TableWorkflows        = "workflows"
+	TableWorkflowRuns     = "workflow_runs"
+	TableActivityRuns     = "activity_runs"
+	TableWorkflowEvents   = "workflow_events"
+	TableWorkers          = "workers"
+	TableSchemaMigrations = "pitlane_schema_migrations"
+)
+
+const defaultSchema = "public"
+
+// Tables resolves pitlane table names within a Postgres schema and with an optional prefix.
+// The zero value uses the public schema without a prefix.
+type Tables struct {
+	Schema string
+	Prefix string
+}
+
+func NewTables(schema, prefix string) Tables {
+	return Tables{
+		Schema: schema,
+		Prefix: prefix,
+	}
+}
+
+// SchemaName returns the Postgres schema holding the tables.
+func (t Tables) SchemaName() string {
+	if t.Schema == "" {
+		return defaultSchema
+	}
+	return t.Schema
+}
+
+// TableName returns the unqualified, unquoted name of a table.
+func (t Tables) TableName(name string) string {
+	return t.Prefix + name
+}
+
+// Table returns the quoted, schema qualified name of a table for use in queries.
+func (t Tables) Table(name string) string {
+	return pgx.Identifier{t.SchemaName(), t.TableName(name)}.Sanitize()
+}
+
+// Index returns the quoted name of an index. Indexes always live in their table's schema,
+// so they are only prefixed.
+func (t Tables) Index(name string) string {
+	return pgx.Identifier{t.TableName(name)}.Sanitize()
+}
+
+// QuotedSchema returns the quoted schema name for use in queries.
+func (t Tables) QuotedSchema() string {
+	return pgx.Identifier{t.SchemaName()}.Sanitize()
+}
+
+// TaskQueueChannel returns the channel runs created on taskQueue are announced on with NOTIFY.
+// Channel names are identifiers of limited length, so the name is derived from a hash of the
+// schema, prefix and task queue.
+func (t Tables) TaskQueueChannel(taskQueue string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(t.SchemaName() + ":" + t.Prefix + ":" + taskQueue))
+	return fmt.Sprintf("pitlane_tasks_%016x", h.Sum64())
+}
+
+// lockID derives the migration advisory lock key, so engines using different schemas or
+// prefixes do not wait for each other.
+func (t Tables) lockID() int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(migrationLockName + ":" + t.SchemaName() + ":" + t.Prefix))
+	return int64(h.Sum64())
+}
@@ -9,16 +9,16 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func TableExists(ctx context.Context, pool *pgxpool.Pool, tableName string) (bool, error) {
+func TableExists(ctx context.Context, pool *pgxpool.Pool, schema, tableName string) (bool, error) {
 	var exists bool
 	query := `
 		SELECT EXISTS (
 			SELECT FROM information_schema.tables
-			WHERE table_schema = 'public'
-			AND table_name = $1
+			WHERE table_schema = $1
+			AND table_name = $2
 		)
 	`
-	err := pool.QueryRow(ctx, query, tableName).Scan(&exists)
+	err := pool.QueryRow(ctx, query, schema, tableName).Scan(&exists)
 	return exists, err
 }
 
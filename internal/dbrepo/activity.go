@@ -2,45 +2,52 @@ package dbrepo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/nurburg-dev/pitlane/backend"
 	"github.com/nurburg-dev/pitlane/internal/db"
 	"github.com/nurburg-dev/pitlane/internal/entities"
 )
 
-type ActivityRunRepository interface {
-	GetNextActivityRun(ctx context.Context) (*entities.DBActivityRun, error)
-	GetActivityRunHistory(ctx context.Context, workflowRunId string) ([]entities.DBActivityRun, error)
-	CreateActivityRun(ctx context.Context, activityRun *entities.DBActivityRun) error
-	ChangeActivityRunStatus(ctx context.Context, activityRunID string, status entities.ActivityStatus) error
-	GetActivityRun(ctx context.Context, activityRunID string) (*entities.DBActivityRun, error)
-}
+var _ backend.ActivityRunRepository = (*PGActivityRunRepository)(nil)
+
+const activityRunColumns = `id, activity_name, workflow_run_id, errorMessage, input, output,
+			   status, retry_status, scheduled_at, created_at, updated_at, task_queue,
+			   attempt, lease_owner, lease_expires_at, trace_context`
 
 type PGActivityRunRepository struct {
 	tx     pgx.Tx
+	tables db.Tables
 	mapper *db.RowMapper
 }
 
-func NewPGActivityRunRepository(tx pgx.Tx) *PGActivityRunRepository {
+func NewPGActivityRunRepository(tx pgx.Tx, tables db.Tables) *PGActivityRunRepository {
 	return &PGActivityRunRepository{
 		tx:     tx,
+		tables: tables,
 		mapper: db.NewRowMapper(),
 	}
 }
 
-func (r *PGActivityRunRepository) GetNextActivityRun(ctx context.Context) (*entities.DBActivityRun, error) {
-	query := `
-		SELECT id, activity_name, workflow_run_id, errorMessage, input, output,
-			   status, retry_status, scheduled_at, created_at, updated_at
-		FROM activity_runs
-		WHERE status = @status
+func (r *PGActivityRunRepository) GetNextActivityRun(
+	ctx context.Context,
+	taskQueues ...string,
+) (*entities.DBActivityRun, error) {
+	query := fmt.Sprintf(`
+		SELECT `+activityRunColumns+`
+		FROM %s
+		WHERE %s
 		ORDER BY scheduled_at DESC
 		LIMIT 1
-	`
+	`, r.tables.Table(db.TableActivityRuns), pendingCondition(taskQueues))
 
 	args := map[string]interface{}{
-		"status": entities.ActivityStatusPending,
+		"status":      entities.ActivityStatusPending,
+		"task_queues": taskQueues,
 	}
 
 	row := r.tx.QueryRow(ctx, query, pgx.NamedArgs(args))
@@ -57,17 +64,147 @@ func (r *PGActivityRunRepository) GetNextActivityRun(ctx context.Context) (*enti
 	return &activityRun, nil
 }
 
+func (r *PGActivityRunRepository) ClaimActivityRun(
+	ctx context.Context,
+	owner string,
+	leaseDuration time.Duration,
+	taskQueues ...string,
+) (*entities.DBActivityRun, error) {
+	table := r.tables.Table(db.TableActivityRuns)
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET status = @executing, updated_at = NOW(),
+			lease_owner = @owner, lease_expires_at = NOW() + make_interval(secs => @lease_seconds)
+		WHERE id = (
+			SELECT id
+			FROM %s
+			WHERE %s
+			ORDER BY scheduled_at DESC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+activityRunColumns+`
+	`, table, table, pendingCondition(taskQueues))
+
+	args := map[string]interface{}{
+		"status":        entities.ActivityStatusPending,
+		"task_queues":   taskQueues,
+		"executing":     entities.ActivityStatusExecuting,
+		"owner":         owner,
+		"lease_seconds": leaseDuration.Seconds(),
+	}
+
+	var activityRun entities.DBActivityRun
+	err := r.mapper.ScanRow(r.tx.QueryRow(ctx, query, pgx.NamedArgs(args)), &activityRun)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	event := backend.ActivityRunStatusChangedEvent(activityRun.WorkflowRunID, activityRun.ID, activityRun.Status)
+	if err := NewPGWorkflowEventRepository(r.tx, r.tables).AppendWorkflowEvent(ctx, event); err != nil {
+		return nil, err
+	}
+	return &activityRun, nil
+}
+
+func (r *PGActivityRunRepository) RenewActivityRunLease(
+	ctx context.Context,
+	activityRunID, owner string,
+	leaseDuration time.Duration,
+) error {
+	return renewLease(ctx, r.tx, r.tables.Table(db.TableActivityRuns), activityRunID, owner, leaseDuration,
+		string(entities.ActivityStatusExecuting))
+}
+
+func (r *PGActivityRunRepository) ReleaseActivityRunLease(ctx context.Context, activityRunID, owner string) error {
+	released, err := releaseLease(ctx, r.tx, r.tables, db.TableActivityRuns, "workflow_run_id", activityRunID, owner,
+		string(entities.ActivityStatusExecuting), string(entities.ActivityStatusPending))
+	if err != nil {
+		return err
+	}
+	event := backend.ActivityRunStatusChangedEvent(released.workflowRunID, activityRunID, entities.ActivityStatusPending)
+	return NewPGWorkflowEventRepository(r.tx, r.tables).AppendWorkflowEvent(ctx, event)
+}
+
+func (r *PGActivityRunRepository) CloseActivityRun(
+	ctx context.Context,
+	activityRunID, owner string,
+	status entities.ActivityStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) error {
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET status = @status, updated_at = NOW(), output = @output, errorMessage = @error_message,
+			lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = @id AND status = @executing AND lease_owner = @owner
+		RETURNING workflow_run_id
+	`, r.tables.Table(db.TableActivityRuns))
+
+	args := map[string]interface{}{
+		"id":            activityRunID,
+		"owner":         owner,
+		"status":        status,
+		"executing":     entities.ActivityStatusExecuting,
+		"output":        output,
+		"error_message": errorMessage,
+	}
+
+	var workflowRunID string
+	err := r.tx.QueryRow(ctx, query, pgx.NamedArgs(args)).Scan(&workflowRunID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %s", backend.ErrLeaseLost, activityRunID)
+	}
+	if err != nil {
+		return err
+	}
+	return NewPGWorkflowEventRepository(r.tx, r.tables).
+		AppendWorkflowEvent(ctx, backend.ActivityRunClosedEvent(workflowRunID, activityRunID, status, output, errorMessage))
+}
+
+func (r *PGActivityRunRepository) ResetExpiredActivityRuns(ctx context.Context, maxAttempts int) (int64, error) {
+	message := backend.ErrAttemptsExhausted.Error()
+	failed, err := failExpiredLeases(ctx, r.tx, r.tables, db.TableActivityRuns, "workflow_run_id",
+		"errorMessage = @error_message",
+		string(entities.ActivityStatusExecuting), string(entities.ActivityStatusFailed), maxAttempts,
+		map[string]interface{}{"error_message": message})
+	if err != nil {
+		return 0, err
+	}
+	events := NewPGWorkflowEventRepository(r.tx, r.tables)
+	for _, run := range failed {
+		event := backend.ActivityRunClosedEvent(run.workflowRunID, run.id, entities.ActivityStatusFailed, nil, &message)
+		if err := events.AppendWorkflowEvent(ctx, event); err != nil {
+			return 0, err
+		}
+	}
+
+	reset, err := resetExpiredLeases(ctx, r.tx, r.tables, db.TableActivityRuns, "workflow_run_id",
+		string(entities.ActivityStatusExecuting), string(entities.ActivityStatusPending))
+	if err != nil {
+		return 0, err
+	}
+	for _, run := range reset {
+		event := backend.ActivityRunStatusChangedEvent(run.workflowRunID, run.id, entities.ActivityStatusPending)
+		if err := events.AppendWorkflowEvent(ctx, event); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(failed) + len(reset)), nil
+}
+
 func (r *PGActivityRunRepository) GetActivityRunHistory(
 	ctx context.Context,
 	workflowRunId string,
 ) ([]entities.DBActivityRun, error) {
-	query := `
-		SELECT id, activity_name, workflow_run_id, errorMessage, input, output,
-			   status, retry_status, scheduled_at, created_at, updated_at
-		FROM activity_runs
+	query := fmt.Sprintf(`
+		SELECT `+activityRunColumns+`
+		FROM %s
 		WHERE workflow_run_id = @workflow_run_id
 		ORDER BY created_at ASC
-	`
+	`, r.tables.Table(db.TableActivityRuns))
 
 	args := map[string]interface{}{
 		"workflow_run_id": workflowRunId,
@@ -88,29 +225,51 @@ func (r *PGActivityRunRepository) GetActivityRunHistory(
 }
 
 func (r *PGActivityRunRepository) CreateActivityRun(ctx context.Context, activityRun *entities.DBActivityRun) error {
-	query := `
-		INSERT INTO activity_runs (id, activity_name, workflow_run_id, errorMessage, input, output,
-								  status, retry_status, scheduled_at, created_at, updated_at)
+	query := fmt.Sprintf(`
+		INSERT INTO %s (`+activityRunColumns+`)
 		VALUES (@id, @activity_name, @workflow_run_id, @error_message, @input, @output,
-				@status, @retry_status, @scheduled_at, @created_at, @updated_at)
-	`
+				@status, @retry_status, @scheduled_at, @created_at, @updated_at,
+				COALESCE(NULLIF(@task_queue, ''), (SELECT task_queue FROM %s WHERE id = @workflow_run_id),
+					@default_task_queue),
+				@attempt, @lease_owner, @lease_expires_at,
+				COALESCE(NULLIF(@trace_context::jsonb, '{}'), (SELECT trace_context FROM %s WHERE id = @workflow_run_id),
+					'{}'))
+		RETURNING task_queue, trace_context
+	`, r.tables.Table(db.TableActivityRuns), r.tables.Table(db.TableWorkflowRuns), r.tables.Table(db.TableWorkflowRuns))
+
+	if activityRun.Attempt == 0 {
+		activityRun.Attempt = 1
+	}
 
 	args := map[string]interface{}{
-		"id":              activityRun.ID,
-		"activity_name":   activityRun.ActivityName,
-		"workflow_run_id": activityRun.WorkflowRunID,
-		"error_message":   activityRun.ErrorMessage,
-		"input":           activityRun.Input,
-		"output":          activityRun.Output,
-		"status":          activityRun.Status,
-		"retry_status":    activityRun.RetryStatus,
-		"scheduled_at":    activityRun.ScheduledAt,
-		"created_at":      activityRun.CreatedAt,
-		"updated_at":      activityRun.UpdatedAt,
-	}
-
-	_, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
-	return err
+		"id":                 activityRun.ID,
+		"activity_name":      activityRun.ActivityName,
+		"workflow_run_id":    activityRun.WorkflowRunID,
+		"error_message":      activityRun.ErrorMessage,
+		"input":              activityRun.Input,
+		"output":             activityRun.Output,
+		"status":             activityRun.Status,
+		"retry_status":       activityRun.RetryStatus,
+		"scheduled_at":       activityRun.ScheduledAt,
+		"created_at":         activityRun.CreatedAt,
+		"updated_at":         activityRun.UpdatedAt,
+		"task_queue":         activityRun.TaskQueue,
+		"default_task_queue": backend.DefaultTaskQueue,
+		"attempt":            activityRun.Attempt,
+		"lease_owner":        activityRun.LeaseOwner,
+		"lease_expires_at":   activityRun.LeaseExpiresAt,
+		"trace_context":      labelsOrEmpty(activityRun.TraceContext),
+	}
+
+	row := r.tx.QueryRow(ctx, query, pgx.NamedArgs(args))
+	if err := row.Scan(&activityRun.TaskQueue, &activityRun.TraceContext); err != nil {
+		return err
+	}
+	if err := notifyTaskQueue(ctx, r.tx, r.tables, activityRun.TaskQueue); err != nil {
+		return err
+	}
+	return NewPGWorkflowEventRepository(r.tx, r.tables).
+		AppendWorkflowEvent(ctx, backend.ActivityRunCreatedEvent(activityRun))
 }
 
 func (r *PGActivityRunRepository) ChangeActivityRunStatus(
@@ -118,31 +277,39 @@ func (r *PGActivityRunRepository) ChangeActivityRunStatus(
 	activityRunID string,
 	status entities.ActivityStatus,
 ) error {
-	query := `
-		UPDATE activity_runs
+	query := fmt.Sprintf(`
+		UPDATE %s
 		SET status = @status, updated_at = NOW()
 		WHERE id = @id
-	`
+		RETURNING workflow_run_id
+	`, r.tables.Table(db.TableActivityRuns))
 
 	args := map[string]interface{}{
 		"id":     activityRunID,
 		"status": status,
 	}
 
-	_, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
-	return err
+	var workflowRunID string
+	err := r.tx.QueryRow(ctx, query, pgx.NamedArgs(args)).Scan(&workflowRunID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	return NewPGWorkflowEventRepository(r.tx, r.tables).
+		AppendWorkflowEvent(ctx, backend.ActivityRunStatusChangedEvent(workflowRunID, activityRunID, status))
 }
 
 func (r *PGActivityRunRepository) GetActivityRun(
 	ctx context.Context,
 	activityRunID string,
 ) (*entities.DBActivityRun, error) {
-	query := `
-		SELECT id, activity_name, workflow_run_id, errorMessage, input, output,
-			   status, retry_status, scheduled_at, created_at, updated_at
-		FROM activity_runs
+	query := fmt.Sprintf(`
+		SELECT `+activityRunColumns+`
+		FROM %s
 		WHERE id = @id
-	`
+	`, r.tables.Table(db.TableActivityRuns))
 
 	args := map[string]interface{}{
 		"id": activityRunID,
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/nurburg-dev/pitlane/backend"
 	"github.com/nurburg-dev/pitlane/internal/db"
 	"github.com/nurburg-dev/pitlane/internal/dbrepo"
 	"github.com/nurburg-dev/pitlane/internal/entities"
@@ -29,7 +30,7 @@ func TestPGActivityRunRepository_CreateAndGetNextActivityRun(t *testing.T) {
 	}()
 
 	// Create workflow repository and insert test data
-	workflowRepo := dbrepo.NewPGWorkflowRepository(tx)
+	workflowRepo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
 
 	// Create test workflow
 	now := time.Now()
@@ -56,7 +57,7 @@ func TestPGActivityRunRepository_CreateAndGetNextActivityRun(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create activity repository
-	repo := dbrepo.NewPGActivityRunRepository(tx)
+	repo := dbrepo.NewPGActivityRunRepository(tx, db.Tables{})
 
 	// Test data
 	activityRun := &entities.DBActivityRun{
@@ -94,3 +95,123 @@ func TestPGActivityRunRepository_CreateAndGetNextActivityRun(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, nextActivityAfterUpdate)
 }
+
+func TestPGActivityRunRepository_TraceContext(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	workflowRepo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+	repo := dbrepo.NewPGActivityRunRepository(tx, db.Tables{})
+	now := time.Now()
+	require.NoError(t, workflowRepo.UpsertWorkflow(ctx, &entities.DBWorkflow{
+		Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+	}))
+
+	traceContext := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	workflowRunID := db.GenerateReadableID()
+	require.NoError(t, workflowRepo.CreateWorkflowRun(ctx, &entities.DBWorkflowRun{
+		ID:           workflowRunID,
+		Input:        json.RawMessage(`{}`),
+		WorkflowName: "test-workflow",
+		Status:       entities.WorkflowStatusPending,
+		ScheduledAt:  now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		TraceContext: traceContext,
+	}))
+	run, err := workflowRepo.GetWorkflowRun(ctx, workflowRunID)
+	require.NoError(t, err)
+	assert.Equal(t, traceContext, run.TraceContext)
+
+	// Activity runs inherit the trace context of their workflow run unless they have one.
+	own := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01"}
+	inherited := &entities.DBActivityRun{
+		ID:            db.GenerateReadableID(),
+		ActivityName:  "test-activity",
+		WorkflowRunID: workflowRunID,
+		Input:         json.RawMessage(`{}`),
+		Status:        entities.ActivityStatusPending,
+		ScheduledAt:   now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	require.NoError(t, repo.CreateActivityRun(ctx, inherited))
+	assert.Equal(t, traceContext, inherited.TraceContext)
+
+	withOwn := *inherited
+	withOwn.ID = db.GenerateReadableID()
+	withOwn.TraceContext = own
+	require.NoError(t, repo.CreateActivityRun(ctx, &withOwn))
+
+	for id, want := range map[string]map[string]string{inherited.ID: traceContext, withOwn.ID: own} {
+		activity, err := repo.GetActivityRun(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, want, activity.TraceContext)
+	}
+}
+
+func TestPGActivityRunRepository_CloseActivityRun(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	workflowRepo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+	repo := dbrepo.NewPGActivityRunRepository(tx, db.Tables{})
+	now := time.Now()
+	require.NoError(t, workflowRepo.UpsertWorkflow(ctx, &entities.DBWorkflow{
+		Name: "test-workflow", CreatedAt: now, UpdatedAt: now,
+	}))
+	workflowRunID := db.GenerateReadableID()
+	require.NoError(t, workflowRepo.CreateWorkflowRun(ctx, &entities.DBWorkflowRun{
+		ID:           workflowRunID,
+		Input:        json.RawMessage(`{}`),
+		WorkflowName: "test-workflow",
+		Status:       entities.WorkflowStatusPending,
+		ScheduledAt:  now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}))
+	activityRunID := db.GenerateReadableID()
+	require.NoError(t, repo.CreateActivityRun(ctx, &entities.DBActivityRun{
+		ID:            activityRunID,
+		ActivityName:  "test-activity",
+		WorkflowRunID: workflowRunID,
+		Input:         json.RawMessage(`{}`),
+		Status:        entities.ActivityStatusPending,
+		ScheduledAt:   now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		TaskQueue:     "close-activity-queue",
+	}))
+
+	message := "card declined"
+	err = repo.CloseActivityRun(ctx, activityRunID, "worker-1", entities.ActivityStatusFailed, nil, &message)
+	require.ErrorIs(t, err, backend.ErrLeaseLost)
+	_, err = repo.ClaimActivityRun(ctx, "worker-1", time.Minute, "close-activity-queue")
+	require.NoError(t, err)
+	require.NoError(t, repo.CloseActivityRun(ctx, activityRunID, "worker-1", entities.ActivityStatusFailed, nil, &message))
+
+	run, err := repo.GetActivityRun(ctx, activityRunID)
+	require.NoError(t, err)
+	require.Equal(t, entities.ActivityStatusFailed, run.Status)
+	require.Equal(t, &message, run.ErrorMessage)
+	require.Nil(t, run.Output)
+	require.Nil(t, run.LeaseOwner)
+}
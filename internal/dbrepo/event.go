@@ -0,0 +1,138 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/nurburg-dev/pitlane/internal/entities"
+)
+
+var _ backend.WorkflowEventRepository = (*PGWorkflowEventRepository)(nil)
+
+type PGWorkflowEventRepository struct {
+	tx     pgx.Tx
+	tables db.Tables
+	mapper *db.RowMapper
+}
+
+func NewPGWorkflowEventRepository(tx pgx.Tx, tables db.Tables) *PGWorkflowEventRepository {
+	return &PGWorkflowEventRepository{
+		tx:     tx,
+		tables: tables,
+		mapper: db.NewRowMapper(),
+	}
+}
+
+func (r *PGWorkflowEventRepository) AppendWorkflowEvent(ctx context.Context, event *entities.DBWorkflowEvent) error {
+	// Incrementing the run's counter locks its row until the transaction ends, so concurrent
+	// appends to the same run are serialized and sequence numbers stay gap-free.
+	query := fmt.Sprintf(`
+		WITH next AS (
+			UPDATE %s
+			SET last_event_sequence = last_event_sequence + 1
+			WHERE id = @workflow_run_id
+			RETURNING last_event_sequence
+		)
+		INSERT INTO %s (workflow_run_id, sequence, event_type, activity_run_id, payload, created_at)
+		SELECT @workflow_run_id, last_event_sequence, @event_type, @activity_run_id, @payload, @created_at
+		FROM next
+		RETURNING sequence
+	`, r.tables.Table(db.TableWorkflowRuns), r.tables.Table(db.TableWorkflowEvents))
+
+	args := map[string]interface{}{
+		"workflow_run_id": event.WorkflowRunID,
+		"event_type":      event.EventType,
+		"activity_run_id": event.ActivityRunID,
+		"payload":         event.Payload,
+		"created_at":      event.CreatedAt,
+	}
+
+	err := r.tx.QueryRow(ctx, query, pgx.NamedArgs(args)).Scan(&event.Sequence)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("workflow run %s does not exist", event.WorkflowRunID)
+	}
+	return err
+}
+
+func (r *PGWorkflowEventRepository) GetWorkflowEvents(
+	ctx context.Context,
+	workflowRunID string,
+	afterSequence int64,
+) ([]entities.DBWorkflowEvent, error) {
+	query := fmt.Sprintf(`
+		SELECT workflow_run_id, sequence, event_type, activity_run_id, payload, created_at
+		FROM %s
+		WHERE workflow_run_id = @workflow_run_id AND sequence > @after_sequence
+		ORDER BY sequence ASC
+	`, r.tables.Table(db.TableWorkflowEvents))
+
+	args := map[string]interface{}{
+		"workflow_run_id": workflowRunID,
+		"after_sequence":  afterSequence,
+	}
+
+	rows, err := r.tx.Query(ctx, query, pgx.NamedArgs(args))
+	if err != nil {
+		return nil, err
+	}
+
+	var events []entities.DBWorkflowEvent
+	err = r.mapper.ScanRows(rows, &events)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *PGWorkflowEventRepository) ReplaceWorkflowEvents(
+	ctx context.Context,
+	workflowRunID string,
+	events []entities.DBWorkflowEvent,
+) error {
+	// Setting the run's counter first locks its row like AppendWorkflowEvent does.
+	query := fmt.Sprintf(`
+		UPDATE %s SET last_event_sequence = @last_event_sequence WHERE id = @workflow_run_id
+	`, r.tables.Table(db.TableWorkflowRuns))
+
+	args := map[string]interface{}{
+		"workflow_run_id":     workflowRunID,
+		"last_event_sequence": len(events),
+	}
+
+	tag, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("workflow run %s does not exist", workflowRunID)
+	}
+
+	query = fmt.Sprintf(`DELETE FROM %s WHERE workflow_run_id = @workflow_run_id`, r.tables.Table(db.TableWorkflowEvents))
+	if _, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args)); err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`
+		INSERT INTO %s (workflow_run_id, sequence, event_type, activity_run_id, payload, created_at)
+		VALUES (@workflow_run_id, @sequence, @event_type, @activity_run_id, @payload, @created_at)
+	`, r.tables.Table(db.TableWorkflowEvents))
+	for _, event := range events {
+		args := map[string]interface{}{
+			"workflow_run_id": workflowRunID,
+			"sequence":        event.Sequence,
+			"event_type":      event.EventType,
+			"activity_run_id": event.ActivityRunID,
+			"payload":         event.Payload,
+			"created_at":      event.CreatedAt,
+		}
+		if _, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package dbrepo_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/nurburg-dev/pitlane/internal/dbrepo"
+	"github.com/nurburg-dev/pitlane/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGWorkflowEventRepository(t *testing.T) {
+	ctx := context.Background()
+
+	// Get connection from pool
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	// Start transaction
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	workflowRepo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+	activityRepo := dbrepo.NewPGActivityRunRepository(tx, db.Tables{})
+	repo := dbrepo.NewPGWorkflowEventRepository(tx, db.Tables{})
+
+	now := time.Now()
+	err = workflowRepo.UpsertWorkflow(ctx, &entities.DBWorkflow{Name: "test-workflow", CreatedAt: now, UpdatedAt: now})
+	require.NoError(t, err)
+
+	workflowRunID := db.GenerateReadableID()
+	err = workflowRepo.CreateWorkflowRun(ctx, &entities.DBWorkflowRun{
+		ID:           workflowRunID,
+		Input:        json.RawMessage(`{}`),
+		WorkflowName: "test-workflow",
+		Status:       entities.WorkflowStatusPending,
+		ScheduledAt:  now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
+	require.NoError(t, err)
+	require.NoError(t, workflowRepo.ChangeWorkflowRunStatus(ctx, workflowRunID, entities.WorkflowStatusExecuting))
+
+	activityRunID := db.GenerateReadableID()
+	err = activityRepo.CreateActivityRun(ctx, &entities.DBActivityRun{
+		ID:            activityRunID,
+		ActivityName:  "test-activity",
+		WorkflowRunID: workflowRunID,
+		Input:         json.RawMessage(`{}`),
+		Status:        entities.ActivityStatusPending,
+		ScheduledAt:   now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+	require.NoError(t, err)
+	require.NoError(t, activityRepo.ChangeActivityRunStatus(ctx, activityRunID, entities.ActivityStatusFinished))
+
+	// Changes to missing runs record nothing.
+	require.NoError(t, workflowRepo.ChangeWorkflowRunStatus(ctx, "missing", entities.WorkflowStatusExecuting))
+	require.NoError(t, activityRepo.ChangeActivityRunStatus(ctx, "missing", entities.ActivityStatusFinished))
+
+	events, err := repo.GetWorkflowEvents(ctx, workflowRunID, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	for i, event := range events {
+		assert.Equal(t, int64(i+1), event.Sequence)
+	}
+	assert.Equal(t, entities.WorkflowEventRunCreated, events[0].EventType)
+	assert.Equal(t, entities.WorkflowEventRunStatusChanged, events[1].EventType)
+	assert.JSONEq(t, `{"status": "executing"}`, string(events[1].Payload))
+	assert.Equal(t, entities.WorkflowEventActivityRunCreated, events[2].EventType)
+	require.NotNil(t, events[3].ActivityRunID)
+	assert.Equal(t, activityRunID, *events[3].ActivityRunID)
+
+	event := &entities.DBWorkflowEvent{
+		WorkflowRunID: workflowRunID,
+		EventType:     "timer_started",
+		Payload:       json.RawMessage(`{"duration": "1h"}`),
+		CreatedAt:     now,
+	}
+	require.NoError(t, repo.AppendWorkflowEvent(ctx, event))
+	assert.Equal(t, int64(5), event.Sequence)
+
+	events, err = repo.GetWorkflowEvents(ctx, workflowRunID, 4)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, entities.WorkflowEventType("timer_started"), events[0].EventType)
+
+	event.WorkflowRunID = "missing"
+	require.Error(t, repo.AppendWorkflowEvent(ctx, event))
+
+	// Replaced events are stored as they are and appending continues after them.
+	replaced := []entities.DBWorkflowEvent{
+		{
+			WorkflowRunID: workflowRunID, Sequence: 1, EventType: entities.WorkflowEventRunCreated,
+			Payload: json.RawMessage(`{}`), CreatedAt: now.Add(-time.Hour),
+		},
+		{
+			WorkflowRunID: workflowRunID, Sequence: 2, EventType: "timer_started",
+			Payload: json.RawMessage(`{}`), CreatedAt: now.Add(-time.Minute),
+		},
+	}
+	require.NoError(t, repo.ReplaceWorkflowEvents(ctx, workflowRunID, replaced))
+	events, err = repo.GetWorkflowEvents(ctx, workflowRunID, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(2), events[1].Sequence)
+	assert.True(t, now.Add(-time.Minute).Equal(events[1].CreatedAt))
+	appended := &entities.DBWorkflowEvent{
+		WorkflowRunID: workflowRunID, EventType: "timer_fired", Payload: json.RawMessage(`{}`),
+	}
+	require.NoError(t, repo.AppendWorkflowEvent(ctx, appended))
+	assert.Equal(t, int64(3), appended.Sequence)
+	require.Error(t, repo.ReplaceWorkflowEvents(ctx, "missing", replaced))
+}
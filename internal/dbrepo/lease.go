@@ -0,0 +1,171 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/internal/db"
+)
+
+// renewLease extends the lease of owner on a run of table with the executing status. Lease
+// times come from the database clock, so workers with skewed clocks agree on expiry.
+func renewLease(
+	ctx context.Context,
+	tx pgx.Tx,
+	table, id, owner string,
+	leaseDuration time.Duration,
+	executing string,
+) error {
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET lease_expires_at = NOW() + make_interval(secs => @lease_seconds)
+		WHERE id = @id AND status = @executing AND lease_owner = @owner
+	`, table)
+
+	args := map[string]interface{}{
+		"id":            id,
+		"owner":         owner,
+		"executing":     executing,
+		"lease_seconds": leaseDuration.Seconds(),
+	}
+
+	tag, err := tx.Exec(ctx, query, pgx.NamedArgs(args))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", backend.ErrLeaseLost, id)
+	}
+	return nil
+}
+
+type resetRun struct {
+	id, workflowRunID, taskQueue string
+}
+
+// releaseLease returns a run of table with the executing status and a lease of owner to
+// pending, notifies its task queue and returns its ID, the workflow run ID column and task queue.
+func releaseLease(
+	ctx context.Context,
+	tx pgx.Tx,
+	tables db.Tables,
+	table, workflowRunIDColumn, id, owner, executing, pending string,
+) (resetRun, error) {
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET status = @pending, updated_at = NOW(), lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = @id AND status = @executing AND lease_owner = @owner
+		RETURNING %s, task_queue
+	`, tables.Table(table), workflowRunIDColumn)
+
+	args := map[string]interface{}{
+		"id":        id,
+		"owner":     owner,
+		"executing": executing,
+		"pending":   pending,
+	}
+
+	released := resetRun{id: id}
+	err := tx.QueryRow(ctx, query, pgx.NamedArgs(args)).Scan(&released.workflowRunID, &released.taskQueue)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return released, fmt.Errorf("%w: %s", backend.ErrLeaseLost, id)
+	}
+	if err != nil {
+		return released, err
+	}
+	return released, notifyTaskQueue(ctx, tx, tables, released.taskQueue)
+}
+
+// resetExpiredLeases returns the runs of table with the executing status and an expired lease
+// to pending, notifies their task queues and returns their ID, the workflow run ID column and
+// task queue.
+func resetExpiredLeases(
+	ctx context.Context,
+	tx pgx.Tx,
+	tables db.Tables,
+	table, workflowRunIDColumn, executing, pending string,
+) ([]resetRun, error) {
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET status = @pending, updated_at = NOW(), attempt = attempt + 1,
+			lease_owner = NULL, lease_expires_at = NULL
+		WHERE status = @executing AND lease_expires_at < NOW()
+		RETURNING id, %s, task_queue
+	`, tables.Table(table), workflowRunIDColumn)
+
+	args := map[string]interface{}{
+		"executing": executing,
+		"pending":   pending,
+	}
+
+	reset, err := queryResetRuns(ctx, tx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	notified := map[string]bool{}
+	for _, run := range reset {
+		if notified[run.taskQueue] {
+			continue
+		}
+		notified[run.taskQueue] = true
+		if err := notifyTaskQueue(ctx, tx, tables, run.taskQueue); err != nil {
+			return nil, err
+		}
+	}
+	return reset, nil
+}
+
+// failExpiredLeases closes the runs of table with the executing status, an expired lease and an
+// attempt of at least maxAttempts as failed, also applying the assignments of set with the
+// arguments of setArgs, and returns their ID, the workflow run ID column and task queue. It
+// fails no run unless maxAttempts is positive.
+func failExpiredLeases(
+	ctx context.Context,
+	tx pgx.Tx,
+	tables db.Tables,
+	table, workflowRunIDColumn, set, executing, failed string,
+	maxAttempts int,
+	setArgs map[string]interface{},
+) ([]resetRun, error) {
+	if maxAttempts <= 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET status = @failed, updated_at = NOW(), lease_owner = NULL, lease_expires_at = NULL, %s
+		WHERE status = @executing AND lease_expires_at < NOW() AND attempt >= @max_attempts
+		RETURNING id, %s, task_queue
+	`, tables.Table(table), set, workflowRunIDColumn)
+
+	args := map[string]interface{}{
+		"executing":    executing,
+		"failed":       failed,
+		"max_attempts": maxAttempts,
+	}
+	maps.Copy(args, setArgs)
+	return queryResetRuns(ctx, tx, query, args)
+}
+
+func queryResetRuns(ctx context.Context, tx pgx.Tx, query string, args map[string]interface{}) ([]resetRun, error) {
+	rows, err := tx.Query(ctx, query, pgx.NamedArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	var runs []resetRun
+	for rows.Next() {
+		var run resetRun
+		if err := rows.Scan(&run.id, &run.workflowRunID, &run.taskQueue); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	rows.Close()
+	return runs, rows.Err()
+}
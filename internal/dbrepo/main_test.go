@@ -22,7 +22,7 @@ func TestMain(m *testing.M) {
 	}
 
 	// Initialize database schema
-	initiator := db.NewPGInitiator(testContainer.GetPool())
+	initiator := db.NewPGInitiator(testContainer.GetPool(), db.Tables{})
 	err = initiator.Init(ctx)
 	if err != nil {
 		log.Fatalf("Failed to initialize database schema: %v", err)
@@ -0,0 +1,156 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/nurburg-dev/pitlane/internal/entities"
+)
+
+var _ backend.WorkerRepository = (*PGWorkerRepository)(nil)
+
+const workerColumns = `id, hostname, task_queues, workflows, activities, build_version, started_at,
+			   last_heartbeat_at`
+
+type PGWorkerRepository struct {
+	tx     pgx.Tx
+	tables db.Tables
+	mapper *db.RowMapper
+}
+
+func NewPGWorkerRepository(tx pgx.Tx, tables db.Tables) *PGWorkerRepository {
+	return &PGWorkerRepository{
+		tx:     tx,
+		tables: tables,
+		mapper: db.NewRowMapper(),
+	}
+}
+
+func (r *PGWorkerRepository) RegisterWorker(ctx context.Context, worker *entities.DBWorker) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (`+workerColumns+`)
+		VALUES (@id, @hostname, @task_queues, @workflows, @activities, @build_version, @started_at,
+				@last_heartbeat_at)
+		ON CONFLICT (id) DO UPDATE
+		SET hostname = EXCLUDED.hostname, task_queues = EXCLUDED.task_queues,
+			workflows = EXCLUDED.workflows, activities = EXCLUDED.activities,
+			build_version = EXCLUDED.build_version, started_at = EXCLUDED.started_at,
+			last_heartbeat_at = EXCLUDED.last_heartbeat_at
+	`, r.tables.Table(db.TableWorkers))
+
+	args := map[string]interface{}{
+		"id":                worker.ID,
+		"hostname":          worker.Hostname,
+		"task_queues":       listOrEmpty(worker.TaskQueues),
+		"workflows":         listOrEmpty(worker.Workflows),
+		"activities":        listOrEmpty(worker.Activities),
+		"build_version":     worker.BuildVersion,
+		"started_at":        worker.StartedAt,
+		"last_heartbeat_at": worker.LastHeartbeatAt,
+	}
+
+	_, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
+	return err
+}
+
+func (r *PGWorkerRepository) RecordWorkerHeartbeat(ctx context.Context, workerID string) error {
+	query := fmt.Sprintf(`UPDATE %s SET last_heartbeat_at = NOW() WHERE id = @id`, r.tables.Table(db.TableWorkers))
+
+	tag, err := r.tx.Exec(ctx, query, pgx.NamedArgs{"id": workerID})
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", backend.ErrWorkerNotFound, workerID)
+	}
+	return nil
+}
+
+func (r *PGWorkerRepository) DeregisterWorker(ctx context.Context, workerID string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = @id`, r.tables.Table(db.TableWorkers))
+
+	_, err := r.tx.Exec(ctx, query, pgx.NamedArgs{"id": workerID})
+	return err
+}
+
+func (r *PGWorkerRepository) ListWorkers(ctx context.Context) ([]entities.DBWorker, error) {
+	query := fmt.Sprintf(`
+		SELECT `+workerColumns+`
+		FROM %s
+		ORDER BY id
+	`, r.tables.Table(db.TableWorkers))
+
+	rows, err := r.tx.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var workers []entities.DBWorker
+	if err := r.mapper.ScanRows(rows, &workers); err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
+
+func (r *PGWorkerRepository) DeleteInactiveWorkers(ctx context.Context, aliveWithin time.Duration) (int64, error) {
+	query := fmt.Sprintf(`
+		DELETE FROM %s
+		WHERE last_heartbeat_at < NOW() - make_interval(secs => @alive_seconds)
+	`, r.tables.Table(db.TableWorkers))
+
+	tag, err := r.tx.Exec(ctx, query, pgx.NamedArgs{"alive_seconds": aliveWithin.Seconds()})
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *PGWorkerRepository) ListTaskQueues(
+	ctx context.Context,
+	aliveWithin time.Duration,
+) ([]backend.TaskQueueInfo, error) {
+	query := fmt.Sprintf(`
+		SELECT name, SUM(pollers)::int, SUM(workflow_runs)::bigint, SUM(activity_runs)::bigint,
+			   MIN(scheduled_at)
+		FROM (
+			SELECT unnest(task_queues) AS name,
+				   (last_heartbeat_at >= NOW() - make_interval(secs => @alive_seconds))::int AS pollers,
+				   0 AS workflow_runs, 0 AS activity_runs, NULL::timestamptz AS scheduled_at
+			FROM %s
+			UNION ALL
+			SELECT task_queue, 0, 1, 0, scheduled_at FROM %s WHERE status = @workflow_pending
+			UNION ALL
+			SELECT task_queue, 0, 0, 1, scheduled_at FROM %s WHERE status = @activity_pending
+		) queues
+		GROUP BY name
+		ORDER BY name
+	`, r.tables.Table(db.TableWorkers), r.tables.Table(db.TableWorkflowRuns), r.tables.Table(db.TableActivityRuns))
+
+	args := map[string]interface{}{
+		"alive_seconds":    aliveWithin.Seconds(),
+		"workflow_pending": entities.WorkflowStatusPending,
+		"activity_pending": entities.ActivityStatusPending,
+	}
+
+	rows, err := r.tx.Query(ctx, query, pgx.NamedArgs(args))
+	if err != nil {
+		return nil, err
+	}
+
+	var queues []backend.TaskQueueInfo
+	if err := r.mapper.ScanRows(rows, &queues); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+func listOrEmpty(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
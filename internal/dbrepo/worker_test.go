@@ -0,0 +1,109 @@
+package dbrepo_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/nurburg-dev/pitlane/internal/dbrepo"
+	"github.com/nurburg-dev/pitlane/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGWorkerRepository(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	repo := dbrepo.NewPGWorkerRepository(tx, db.Tables{})
+	workflowRepo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+
+	now := time.Now()
+	err = workflowRepo.UpsertWorkflow(ctx, &entities.DBWorkflow{Name: "worker-workflow", CreatedAt: now, UpdatedAt: now})
+	require.NoError(t, err)
+	require.NoError(t, workflowRepo.CreateWorkflowRun(ctx, &entities.DBWorkflowRun{
+		ID:           "worker-run-1",
+		Input:        json.RawMessage(`[]`),
+		WorkflowName: "worker-workflow",
+		Status:       entities.WorkflowStatusPending,
+		ScheduledAt:  now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		TaskQueue:    "worker-queue-1",
+	}))
+
+	require.NoError(t, repo.RegisterWorker(ctx, &entities.DBWorker{
+		ID:              "worker-1",
+		Hostname:        "host-1",
+		TaskQueues:      []string{"worker-queue-1", "worker-queue-2"},
+		Workflows:       []string{"worker-workflow"},
+		BuildVersion:    "v1.0.0",
+		StartedAt:       now,
+		LastHeartbeatAt: now,
+	}))
+	require.NoError(t, repo.RegisterWorker(ctx, &entities.DBWorker{
+		ID:              "worker-2",
+		Hostname:        "host-2",
+		TaskQueues:      []string{"worker-queue-2"},
+		StartedAt:       now,
+		LastHeartbeatAt: now.Add(-time.Hour),
+	}))
+
+	workers, err := repo.ListWorkers(ctx)
+	require.NoError(t, err)
+	require.Len(t, workers, 2)
+	assert.Equal(t, "worker-1", workers[0].ID)
+	assert.Equal(t, []string{"worker-queue-1", "worker-queue-2"}, workers[0].TaskQueues)
+	assert.Equal(t, []string{"worker-workflow"}, workers[0].Workflows)
+	assert.Empty(t, workers[0].Activities)
+	assert.Equal(t, "v1.0.0", workers[0].BuildVersion)
+
+	queues := listTaskQueues(ctx, t, repo)
+	queue := queues["worker-queue-1"]
+	require.NotNil(t, queue.OldestPendingScheduledAt)
+	assert.WithinDuration(t, now, *queue.OldestPendingScheduledAt, time.Millisecond)
+	queue.OldestPendingScheduledAt = nil
+	assert.Equal(t, backend.TaskQueueInfo{Name: "worker-queue-1", Pollers: 1, PendingWorkflowRuns: 1}, queue)
+	assert.Equal(t, backend.TaskQueueInfo{Name: "worker-queue-2", Pollers: 1}, queues["worker-queue-2"])
+
+	require.ErrorIs(t, repo.RecordWorkerHeartbeat(ctx, "worker-3"), backend.ErrWorkerNotFound)
+	require.NoError(t, repo.RecordWorkerHeartbeat(ctx, "worker-2"))
+	queues = listTaskQueues(ctx, t, repo)
+	assert.Equal(t, 2, queues["worker-queue-2"].Pollers)
+
+	require.NoError(t, repo.DeregisterWorker(ctx, "worker-1"))
+	deleted, err := repo.DeleteInactiveWorkers(ctx, -time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	workers, err = repo.ListWorkers(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, workers)
+}
+
+func listTaskQueues(
+	ctx context.Context,
+	t *testing.T,
+	repo *dbrepo.PGWorkerRepository,
+) map[string]backend.TaskQueueInfo {
+	t.Helper()
+	queues, err := repo.ListTaskQueues(ctx, time.Minute)
+	require.NoError(t, err)
+	byName := make(map[string]backend.TaskQueueInfo, len(queues))
+	for _, queue := range queues {
+		byName[queue.Name] = queue
+	}
+	return byName
+}
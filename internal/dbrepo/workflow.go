@@ -2,44 +2,53 @@ package dbrepo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/nurburg-dev/pitlane/backend"
 	"github.com/nurburg-dev/pitlane/internal/db"
 	"github.com/nurburg-dev/pitlane/internal/entities"
 )
 
-type WorkflowRepository interface {
-	GetNextWorkflowRun(ctx context.Context) (*entities.DBWorkflowRun, error)
-	GetWorkflow(ctx context.Context, name string) (*entities.DBWorkflow, error)
-	UpsertWorkflow(ctx context.Context, workflow *entities.DBWorkflow) error
-	CreateWorkflowRun(ctx context.Context, workflowRun *entities.DBWorkflowRun) error
-	ChangeWorkflowRunStatus(ctx context.Context, workflowRunID string, status entities.WorkflowStatus) error
-}
+var _ backend.WorkflowRepository = (*PGWorkflowRepository)(nil)
+
+const workflowRunColumns = `id, input, workflow_name, status, scheduled_at, created_at, updated_at,
+			   closed_at, labels, parent_workflow_run_id, search_attributes, memo, task_queue,
+			   attempt, lease_owner, lease_expires_at, trace_context, output, error_message`
 
 type PGWorkflowRepository struct {
 	tx     pgx.Tx
+	tables db.Tables
 	mapper *db.RowMapper
 }
 
-func NewPGWorkflowRepository(tx pgx.Tx) *PGWorkflowRepository {
+func NewPGWorkflowRepository(tx pgx.Tx, tables db.Tables) *PGWorkflowRepository {
 	return &PGWorkflowRepository{
 		tx:     tx,
+		tables: tables,
 		mapper: db.NewRowMapper(),
 	}
 }
 
-func (r *PGWorkflowRepository) GetNextWorkflowRun(ctx context.Context) (*entities.DBWorkflowRun, error) {
-	query := `
-		SELECT id, input, workflow_name, status, scheduled_at, created_at, updated_at
-		FROM workflow_runs
-		WHERE status = @status
+func (r *PGWorkflowRepository) GetNextWorkflowRun(
+	ctx context.Context,
+	taskQueues ...string,
+) (*entities.DBWorkflowRun, error) {
+	query := fmt.Sprintf(`
+		SELECT `+workflowRunColumns+`
+		FROM %s
+		WHERE %s
 		ORDER BY scheduled_at DESC
 		LIMIT 1
-	`
+	`, r.tables.Table(db.TableWorkflowRuns), pendingCondition(taskQueues))
 
 	args := map[string]interface{}{
-		"status": entities.WorkflowStatusPending,
+		"status":      entities.WorkflowStatusPending,
+		"task_queues": taskQueues,
 	}
 
 	row := r.tx.QueryRow(ctx, query, pgx.NamedArgs(args))
@@ -56,12 +65,142 @@ func (r *PGWorkflowRepository) GetNextWorkflowRun(ctx context.Context) (*entitie
 	return &workflowRun, nil
 }
 
+func (r *PGWorkflowRepository) ClaimWorkflowRun(
+	ctx context.Context,
+	owner string,
+	leaseDuration time.Duration,
+	taskQueues ...string,
+) (*entities.DBWorkflowRun, error) {
+	table := r.tables.Table(db.TableWorkflowRuns)
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET status = @executing, updated_at = NOW(),
+			lease_owner = @owner, lease_expires_at = NOW() + make_interval(secs => @lease_seconds)
+		WHERE id = (
+			SELECT id
+			FROM %s
+			WHERE %s
+			ORDER BY scheduled_at DESC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+workflowRunColumns+`
+	`, table, table, pendingCondition(taskQueues))
+
+	args := map[string]interface{}{
+		"status":        entities.WorkflowStatusPending,
+		"task_queues":   taskQueues,
+		"executing":     entities.WorkflowStatusExecuting,
+		"owner":         owner,
+		"lease_seconds": leaseDuration.Seconds(),
+	}
+
+	var workflowRun entities.DBWorkflowRun
+	err := r.mapper.ScanRow(r.tx.QueryRow(ctx, query, pgx.NamedArgs(args)), &workflowRun)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	err = NewPGWorkflowEventRepository(r.tx, r.tables).
+		AppendWorkflowEvent(ctx, backend.WorkflowRunStatusChangedEvent(workflowRun.ID, workflowRun.Status))
+	if err != nil {
+		return nil, err
+	}
+	return &workflowRun, nil
+}
+
+func (r *PGWorkflowRepository) RenewWorkflowRunLease(
+	ctx context.Context,
+	workflowRunID, owner string,
+	leaseDuration time.Duration,
+) error {
+	return renewLease(ctx, r.tx, r.tables.Table(db.TableWorkflowRuns), workflowRunID, owner, leaseDuration,
+		string(entities.WorkflowStatusExecuting))
+}
+
+func (r *PGWorkflowRepository) ReleaseWorkflowRunLease(ctx context.Context, workflowRunID, owner string) error {
+	_, err := releaseLease(ctx, r.tx, r.tables, db.TableWorkflowRuns, "id", workflowRunID, owner,
+		string(entities.WorkflowStatusExecuting), string(entities.WorkflowStatusPending))
+	if err != nil {
+		return err
+	}
+	event := backend.WorkflowRunStatusChangedEvent(workflowRunID, entities.WorkflowStatusPending)
+	return NewPGWorkflowEventRepository(r.tx, r.tables).AppendWorkflowEvent(ctx, event)
+}
+
+func (r *PGWorkflowRepository) CloseWorkflowRun(
+	ctx context.Context,
+	workflowRunID, owner string,
+	status entities.WorkflowStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) error {
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET status = @status, updated_at = NOW(), closed_at = NOW(), output = @output,
+			error_message = @error_message, lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = @id AND status = @executing AND lease_owner = @owner
+	`, r.tables.Table(db.TableWorkflowRuns))
+
+	args := map[string]interface{}{
+		"id":            workflowRunID,
+		"owner":         owner,
+		"status":        status,
+		"executing":     entities.WorkflowStatusExecuting,
+		"output":        output,
+		"error_message": errorMessage,
+	}
+
+	tag, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", backend.ErrLeaseLost, workflowRunID)
+	}
+	return NewPGWorkflowEventRepository(r.tx, r.tables).
+		AppendWorkflowEvent(ctx, backend.WorkflowRunClosedEvent(workflowRunID, status, output, errorMessage))
+}
+
+func (r *PGWorkflowRepository) ResetExpiredWorkflowRuns(ctx context.Context, maxAttempts int) (int64, error) {
+	message := backend.ErrAttemptsExhausted.Error()
+	failed, err := failExpiredLeases(ctx, r.tx, r.tables, db.TableWorkflowRuns, "id",
+		"closed_at = NOW(), error_message = @error_message",
+		string(entities.WorkflowStatusExecuting), string(entities.WorkflowStatusFailed), maxAttempts,
+		map[string]interface{}{"error_message": message})
+	if err != nil {
+		return 0, err
+	}
+	events := NewPGWorkflowEventRepository(r.tx, r.tables)
+	for _, run := range failed {
+		event := backend.WorkflowRunClosedEvent(run.id, entities.WorkflowStatusFailed, nil, &message)
+		if err := events.AppendWorkflowEvent(ctx, event); err != nil {
+			return 0, err
+		}
+	}
+
+	reset, err := resetExpiredLeases(ctx, r.tx, r.tables, db.TableWorkflowRuns, "id",
+		string(entities.WorkflowStatusExecuting), string(entities.WorkflowStatusPending))
+	if err != nil {
+		return 0, err
+	}
+	for _, run := range reset {
+		event := backend.WorkflowRunStatusChangedEvent(run.id, entities.WorkflowStatusPending)
+		if err := events.AppendWorkflowEvent(ctx, event); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(failed) + len(reset)), nil
+}
+
 func (r *PGWorkflowRepository) GetWorkflow(ctx context.Context, name string) (*entities.DBWorkflow, error) {
-	query := `
+	query := fmt.Sprintf(`
 		SELECT name, created_at, updated_at
-		FROM workflows
+		FROM %s
 		WHERE name = @name
-	`
+	`, r.tables.Table(db.TableWorkflows))
 
 	args := map[string]interface{}{
 		"name": name,
@@ -81,13 +220,62 @@ func (r *PGWorkflowRepository) GetWorkflow(ctx context.Context, name string) (*e
 	return &workflow, nil
 }
 
+func (r *PGWorkflowRepository) ListWorkflows(ctx context.Context) ([]entities.DBWorkflow, error) {
+	query := fmt.Sprintf(`
+		SELECT name, created_at, updated_at
+		FROM %s
+		ORDER BY name
+	`, r.tables.Table(db.TableWorkflows))
+
+	rows, err := r.tx.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var workflows []entities.DBWorkflow
+	err = r.mapper.ScanRows(rows, &workflows)
+	if err != nil {
+		return nil, err
+	}
+
+	return workflows, nil
+}
+
+func (r *PGWorkflowRepository) GetWorkflowRun(
+	ctx context.Context,
+	workflowRunID string,
+) (*entities.DBWorkflowRun, error) {
+	query := fmt.Sprintf(`
+		SELECT `+workflowRunColumns+`
+		FROM %s
+		WHERE id = @id
+	`, r.tables.Table(db.TableWorkflowRuns))
+
+	args := map[string]interface{}{
+		"id": workflowRunID,
+	}
+
+	row := r.tx.QueryRow(ctx, query, pgx.NamedArgs(args))
+
+	var workflowRun entities.DBWorkflowRun
+	err := r.mapper.ScanRow(row, &workflowRun)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &workflowRun, nil
+}
+
 func (r *PGWorkflowRepository) UpsertWorkflow(ctx context.Context, workflow *entities.DBWorkflow) error {
-	query := `
-		INSERT INTO workflows (name, created_at, updated_at)
+	query := fmt.Sprintf(`
+		INSERT INTO %s (name, created_at, updated_at)
 		VALUES (@name, @created_at, @updated_at)
 		ON CONFLICT (name) DO UPDATE SET
 			updated_at = @updated_at
-	`
+	`, r.tables.Table(db.TableWorkflows))
 
 	args := map[string]interface{}{
 		"name":       workflow.Name,
@@ -104,37 +292,335 @@ func (r *PGWorkflowRepository) ChangeWorkflowRunStatus(
 	workflowRunID string,
 	status entities.WorkflowStatus,
 ) error {
-	query := `
-		UPDATE workflow_runs
-		SET status = @status, updated_at = NOW()
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET status = @status, updated_at = NOW(),
+			closed_at = CASE WHEN @closed THEN COALESCE(closed_at, NOW()) END
 		WHERE id = @id
-	`
+	`, r.tables.Table(db.TableWorkflowRuns))
 
 	args := map[string]interface{}{
 		"id":     workflowRunID,
 		"status": status,
+		"closed": status.IsClosed(),
 	}
 
-	_, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
-	return err
+	tag, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+	return NewPGWorkflowEventRepository(r.tx, r.tables).
+		AppendWorkflowEvent(ctx, backend.WorkflowRunStatusChangedEvent(workflowRunID, status))
 }
 
 func (r *PGWorkflowRepository) CreateWorkflowRun(ctx context.Context, workflowRun *entities.DBWorkflowRun) error {
-	query := `
-		INSERT INTO workflow_runs (id, input, workflow_name, status, scheduled_at, created_at, updated_at)
-		VALUES (@id, @input, @workflow_name, @status, @scheduled_at, @created_at, @updated_at)
-	`
+	query := fmt.Sprintf(`
+		INSERT INTO %s (`+workflowRunColumns+`)
+		VALUES (@id, @input, @workflow_name, @status, @scheduled_at, @created_at, @updated_at,
+				@closed_at, @labels, @parent_workflow_run_id, @search_attributes, @memo, @task_queue,
+				@attempt, @lease_owner, @lease_expires_at, @trace_context, @output, @error_message)
+	`, r.tables.Table(db.TableWorkflowRuns))
+
+	if workflowRun.TaskQueue == "" {
+		workflowRun.TaskQueue = backend.DefaultTaskQueue
+	}
+	if workflowRun.Attempt == 0 {
+		workflowRun.Attempt = 1
+	}
 
 	args := map[string]interface{}{
-		"id":            workflowRun.ID,
-		"input":         workflowRun.Input,
-		"workflow_name": workflowRun.WorkflowName,
-		"status":        workflowRun.Status,
-		"scheduled_at":  workflowRun.ScheduledAt,
-		"created_at":    workflowRun.CreatedAt,
-		"updated_at":    workflowRun.UpdatedAt,
+		"id":                     workflowRun.ID,
+		"input":                  workflowRun.Input,
+		"workflow_name":          workflowRun.WorkflowName,
+		"status":                 workflowRun.Status,
+		"scheduled_at":           workflowRun.ScheduledAt,
+		"created_at":             workflowRun.CreatedAt,
+		"updated_at":             workflowRun.UpdatedAt,
+		"closed_at":              workflowRun.ClosedAt,
+		"labels":                 labelsOrEmpty(workflowRun.Labels),
+		"parent_workflow_run_id": workflowRun.ParentWorkflowRunID,
+		"search_attributes":      searchAttributesOrEmpty(workflowRun.SearchAttributes),
+		"memo":                   memoOrEmpty(workflowRun.Memo),
+		"task_queue":             workflowRun.TaskQueue,
+		"attempt":                workflowRun.Attempt,
+		"lease_owner":            workflowRun.LeaseOwner,
+		"lease_expires_at":       workflowRun.LeaseExpiresAt,
+		"trace_context":          labelsOrEmpty(workflowRun.TraceContext),
+		"output":                 workflowRun.Output,
+		"error_message":          workflowRun.ErrorMessage,
 	}
 
-	_, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
-	return err
+	if _, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args)); err != nil {
+		return err
+	}
+	if err := notifyTaskQueue(ctx, r.tx, r.tables, workflowRun.TaskQueue); err != nil {
+		return err
+	}
+	return NewPGWorkflowEventRepository(r.tx, r.tables).
+		AppendWorkflowEvent(ctx, backend.WorkflowRunCreatedEvent(workflowRun))
+}
+
+func (r *PGWorkflowRepository) UpdateWorkflowRunAttributes(
+	ctx context.Context,
+	workflowRunID string,
+	searchAttributes map[string]any,
+	memo json.RawMessage,
+) error {
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET search_attributes = search_attributes || @search_attributes, memo = memo || @memo, updated_at = NOW()
+		WHERE id = @id
+	`, r.tables.Table(db.TableWorkflowRuns))
+
+	args := map[string]interface{}{
+		"id":                workflowRunID,
+		"search_attributes": searchAttributesOrEmpty(searchAttributes),
+		"memo":              memoOrEmpty(memo),
+	}
+
+	tag, err := r.tx.Exec(ctx, query, pgx.NamedArgs(args))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("workflow run %s does not exist", workflowRunID)
+	}
+	return NewPGWorkflowEventRepository(r.tx, r.tables).
+		AppendWorkflowEvent(ctx, backend.WorkflowRunAttributesUpdatedEvent(workflowRunID, searchAttributes, memo))
+}
+
+func (r *PGWorkflowRepository) DeleteWorkflowRuns(
+	ctx context.Context,
+	deletion backend.WorkflowRunDeletion,
+) ([]string, error) {
+	workflowRunIDs, err := r.lockDeletedRuns(ctx, deletion)
+	if err != nil || len(workflowRunIDs) == 0 {
+		return nil, err
+	}
+	runs := r.tables.Table(db.TableWorkflowRuns)
+
+	// Rows referencing the runs go first, so the foreign keys hold after every statement. Each
+	// statement only locks the rows of the given runs and their children.
+	statements := []string{
+		fmt.Sprintf(`DELETE FROM %s WHERE workflow_run_id = ANY(@ids)`, r.tables.Table(db.TableWorkflowEvents)),
+		fmt.Sprintf(`DELETE FROM %s WHERE workflow_run_id = ANY(@ids)`, r.tables.Table(db.TableActivityRuns)),
+		fmt.Sprintf(`UPDATE %s SET parent_workflow_run_id = NULL WHERE parent_workflow_run_id = ANY(@ids)`, runs),
+	}
+	args := pgx.NamedArgs{"ids": workflowRunIDs}
+	for _, statement := range statements {
+		if _, err := r.tx.Exec(ctx, statement, args); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := r.tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ANY(@ids)`, runs), args); err != nil {
+		return nil, err
+	}
+	return workflowRunIDs, nil
+}
+
+// lockDeletedRuns locks the rows of the runs deletion selects and returns their IDs. Every
+// change to a run appends an event, which updates its row, so a locked run cannot change before
+// it is deleted.
+func (r *PGWorkflowRepository) lockDeletedRuns(
+	ctx context.Context,
+	deletion backend.WorkflowRunDeletion,
+) ([]string, error) {
+	if len(deletion.WorkflowRunIDs) == 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf(`
+		SELECT id, closed_at, last_event_sequence
+		FROM %s
+		WHERE id = ANY(@ids)
+		ORDER BY id
+		FOR UPDATE
+	`, r.tables.Table(db.TableWorkflowRuns))
+
+	rows, err := r.tx.Query(ctx, query, pgx.NamedArgs{"ids": deletion.WorkflowRunIDs})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		var closedAt *time.Time
+		var lastEventSequence int64
+		if err := rows.Scan(&id, &closedAt, &lastEventSequence); err != nil {
+			return nil, err
+		}
+		if deletion.Selects(id, closedAt, lastEventSequence) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+func (r *PGWorkflowRepository) ListWorkflowRuns(
+	ctx context.Context,
+	query backend.WorkflowRunQuery,
+	after *backend.WorkflowRunCursor,
+	limit int,
+) ([]entities.DBWorkflowRun, error) {
+	where, args, err := workflowRunConditions(query)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		where = append(where, "(created_at, id) < (@after_created_at, @after_id)")
+		args["after_created_at"] = after.CreatedAt
+		args["after_id"] = after.ID
+	}
+	args["limit"] = limit
+
+	sql := fmt.Sprintf(`
+		SELECT `+workflowRunColumns+`
+		FROM %s
+		WHERE %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT @limit
+	`, r.tables.Table(db.TableWorkflowRuns), strings.Join(where, " AND "))
+
+	rows, err := r.tx.Query(ctx, sql, pgx.NamedArgs(args))
+	if err != nil {
+		return nil, err
+	}
+
+	var workflowRuns []entities.DBWorkflowRun
+	err = r.mapper.ScanRows(rows, &workflowRuns)
+	if err != nil {
+		return nil, err
+	}
+
+	return workflowRuns, nil
+}
+
+func (r *PGWorkflowRepository) CountWorkflowRuns(ctx context.Context, query backend.WorkflowRunQuery) (int64, error) {
+	where, args, err := workflowRunConditions(query)
+	if err != nil {
+		return 0, err
+	}
+	sql := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM %s
+		WHERE %s
+	`, r.tables.Table(db.TableWorkflowRuns), strings.Join(where, " AND "))
+
+	var count int64
+	err = r.tx.QueryRow(ctx, sql, pgx.NamedArgs(args)).Scan(&count)
+	return count, err
+}
+
+// workflowRunConditions translates query into SQL conditions and their named arguments.
+func workflowRunConditions(query backend.WorkflowRunQuery) ([]string, map[string]interface{}, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	where := []string{"TRUE"}
+	args := map[string]interface{}{}
+
+	if query.WorkflowName != "" {
+		where = append(where, "workflow_name = @workflow_name")
+		args["workflow_name"] = query.WorkflowName
+	}
+	if len(query.Statuses) > 0 {
+		statuses := make([]string, len(query.Statuses))
+		for i, status := range query.Statuses {
+			statuses[i] = string(status)
+		}
+		where = append(where, "status = ANY(@statuses)")
+		args["statuses"] = statuses
+	}
+
+	timeRanges := []struct {
+		column        string
+		after, before time.Time
+	}{
+		{"created_at", query.CreatedAfter, query.CreatedBefore},
+		{"scheduled_at", query.ScheduledAfter, query.ScheduledBefore},
+		{"closed_at", query.ClosedAfter, query.ClosedBefore},
+	}
+	for _, tr := range timeRanges {
+		if !tr.after.IsZero() {
+			where = append(where, fmt.Sprintf("%s >= @%s_after", tr.column, tr.column))
+			args[tr.column+"_after"] = tr.after
+		}
+		if !tr.before.IsZero() {
+			where = append(where, fmt.Sprintf("%s < @%s_before", tr.column, tr.column))
+			args[tr.column+"_before"] = tr.before
+		}
+	}
+
+	if len(query.Labels) > 0 {
+		where = append(where, "labels @> @labels")
+		args["labels"] = query.Labels
+	}
+	if query.ParentWorkflowRunID != "" {
+		where = append(where, "parent_workflow_run_id = @parent_workflow_run_id")
+		args["parent_workflow_run_id"] = query.ParentWorkflowRunID
+	}
+	for i, condition := range query.SearchAttributes {
+		// Equality uses containment, which the GIN index serves. Other comparisons use a jsonpath
+		// filter, which like containment does not match values of another type. Normalized keys
+		// are identifiers, so the path needs no quoting.
+		if condition.Operator == backend.SearchAttributeEqual {
+			where = append(where, fmt.Sprintf("search_attributes @> @search_attribute_%d", i))
+			args[fmt.Sprintf("search_attribute_%d", i)] = map[string]any{condition.Key: condition.Value}
+			continue
+		}
+		where = append(where, fmt.Sprintf(
+			"jsonb_path_exists(search_attributes, @search_attribute_path_%d::jsonpath, @search_attribute_vars_%d)",
+			i, i))
+		args[fmt.Sprintf("search_attribute_path_%d", i)] = fmt.Sprintf("$.%s ? (@ %s $value)",
+			condition.Key, condition.Operator)
+		args[fmt.Sprintf("search_attribute_vars_%d", i)] = map[string]any{"value": condition.Value}
+	}
+	return where, args, nil
+}
+
+// pendingCondition filters runs with the @status argument on one of the @task_queues argument,
+// or on any task queue when none is given.
+func pendingCondition(taskQueues []string) string {
+	if len(taskQueues) == 0 {
+		return "status = @status"
+	}
+	return "status = @status AND task_queue = ANY(@task_queues)"
+}
+
+// notifyTaskQueue announces a run created on taskQueue to the listeners of its channel. Postgres
+// delivers the notification when the transaction commits, once per channel.
+func notifyTaskQueue(ctx context.Context, tx pgx.Tx, tables db.Tables, taskQueue string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_notify(@channel, '')`, pgx.NamedArgs{
+		"channel": tables.TaskQueueChannel(taskQueue),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to notify task queue %s: %w", taskQueue, err)
+	}
+	return nil
+}
+
+func searchAttributesOrEmpty(searchAttributes map[string]any) map[string]any {
+	if searchAttributes == nil {
+		return map[string]any{}
+	}
+	return searchAttributes
+}
+
+func memoOrEmpty(memo json.RawMessage) json.RawMessage {
+	if len(memo) == 0 {
+		return json.RawMessage("{}")
+	}
+	return memo
+}
+
+// labelsOrEmpty stores runs without labels as an empty object rather than JSON null.
+func labelsOrEmpty(labels map[string]string) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+	return labels
 }
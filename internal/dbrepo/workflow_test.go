@@ -3,9 +3,11 @@ package dbrepo_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/nurburg-dev/pitlane/backend"
 	"github.com/nurburg-dev/pitlane/internal/db"
 	"github.com/nurburg-dev/pitlane/internal/dbrepo"
 	"github.com/nurburg-dev/pitlane/internal/entities"
@@ -29,7 +31,7 @@ func TestPGWorkflowRepository_UpsertAndGetWorkflow(t *testing.T) {
 	}()
 
 	// Create repository
-	repo := dbrepo.NewPGWorkflowRepository(tx)
+	repo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
 
 	// Test data
 	now := time.Now()
@@ -64,6 +66,16 @@ func TestPGWorkflowRepository_UpsertAndGetWorkflow(t *testing.T) {
 	err = repo.CreateWorkflowRun(ctx, workflowRun)
 	require.NoError(t, err)
 
+	// Test GetWorkflowRun
+	retrievedRun, err := repo.GetWorkflowRun(ctx, workflowRun.ID)
+	require.NoError(t, err)
+	require.NotNil(t, retrievedRun)
+	assert.Equal(t, workflowRun.WorkflowName, retrievedRun.WorkflowName)
+
+	missingRun, err := repo.GetWorkflowRun(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, missingRun)
+
 	// Test GetNextWorkflowRun
 	nextRun, err := repo.GetNextWorkflowRun(ctx)
 	require.NoError(t, err)
@@ -83,3 +95,486 @@ func TestPGWorkflowRepository_UpsertAndGetWorkflow(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, nextRunAfterUpdate)
 }
+
+func TestPGWorkflowRepository_ListWorkflowRuns(t *testing.T) {
+	ctx := context.Background()
+
+	// Get connection from pool
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	// Truncated to the microsecond precision of TIMESTAMPTZ so cursors compare exactly. Taken before
+	// the transaction starts because closed_at is set from NOW(), the transaction start time.
+	now := time.Now().Truncate(time.Microsecond)
+
+	// Start transaction
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	repo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+	err = repo.UpsertWorkflow(ctx, &entities.DBWorkflow{Name: "list-workflow", CreatedAt: now, UpdatedAt: now})
+	require.NoError(t, err)
+
+	parentID := "list-run-0"
+	for i := range 4 {
+		run := &entities.DBWorkflowRun{
+			ID:           fmt.Sprintf("list-run-%d", i),
+			Input:        json.RawMessage(`[]`),
+			WorkflowName: "list-workflow",
+			Status:       entities.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now.Add(time.Duration(min(i, 2)) * time.Second),
+			UpdatedAt:    now,
+			Labels:       map[string]string{"region": []string{"eu", "us"}[i%2]},
+		}
+		if i > 0 {
+			run.ParentWorkflowRunID = &parentID
+		}
+		require.NoError(t, repo.CreateWorkflowRun(ctx, run))
+	}
+	require.NoError(t, repo.ChangeWorkflowRunStatus(ctx, "list-run-2", entities.WorkflowStatusFailed))
+
+	list := func(query backend.WorkflowRunQuery, after *backend.WorkflowRunCursor, limit int) []string {
+		runs, err := repo.ListWorkflowRuns(ctx, query, after, limit)
+		require.NoError(t, err)
+		ids := make([]string, 0, len(runs))
+		for _, run := range runs {
+			ids = append(ids, run.ID)
+		}
+		return ids
+	}
+
+	query := backend.WorkflowRunQuery{WorkflowName: "list-workflow"}
+	assert.Equal(t, []string{"list-run-3", "list-run-2", "list-run-1", "list-run-0"}, list(query, nil, 10))
+	after := &backend.WorkflowRunCursor{CreatedAt: now.Add(2 * time.Second), ID: "list-run-2"}
+	assert.Equal(t, []string{"list-run-1", "list-run-0"}, list(query, after, 2))
+
+	query.Labels = map[string]string{"region": "us"}
+	assert.Equal(t, []string{"list-run-3", "list-run-1"}, list(query, nil, 10))
+
+	query = backend.WorkflowRunQuery{
+		ParentWorkflowRunID: parentID,
+		Statuses:            []entities.WorkflowStatus{entities.WorkflowStatusFailed},
+		ClosedAfter:         now,
+	}
+	assert.Equal(t, []string{"list-run-2"}, list(query, nil, 10))
+
+	count, err := repo.CountWorkflowRuns(ctx, backend.WorkflowRunQuery{WorkflowName: "list-workflow", CreatedAfter: now})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	run, err := repo.GetWorkflowRun(ctx, "list-run-2")
+	require.NoError(t, err)
+	require.NotNil(t, run.ClosedAt)
+	assert.Equal(t, map[string]string{"region": "eu"}, run.Labels)
+}
+
+func TestPGWorkflowRepository_SearchAttributes(t *testing.T) {
+	ctx := context.Background()
+
+	// Get connection from pool
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	// Start transaction
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	repo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+
+	now := time.Now()
+	dueAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	err = repo.UpsertWorkflow(ctx, &entities.DBWorkflow{Name: "search-workflow", CreatedAt: now, UpdatedAt: now})
+	require.NoError(t, err)
+
+	attributes := []map[string]any{
+		{"CustomerID": "c-1", "Region": "eu", "OrderTotal": 25, "Priority": true, "DueAt": dueAt},
+		{"CustomerID": "c-2", "Region": "us", "OrderTotal": 120.5},
+		{"OrderTotal": "unknown"},
+		nil,
+	}
+	for i, attrs := range attributes {
+		normalized, err := backend.NormalizeSearchAttributes(attrs)
+		require.NoError(t, err)
+		require.NoError(t, repo.CreateWorkflowRun(ctx, &entities.DBWorkflowRun{
+			ID:               fmt.Sprintf("search-run-%d", i),
+			Input:            json.RawMessage(`[]`),
+			WorkflowName:     "search-workflow",
+			Status:           entities.WorkflowStatusPending,
+			ScheduledAt:      now,
+			CreatedAt:        now.Add(time.Duration(i) * time.Second),
+			UpdatedAt:        now,
+			SearchAttributes: normalized,
+			Memo:             json.RawMessage(`{"note":{"metadata":{"encoding":"json/plain"},"data":"first"}}`),
+		}))
+	}
+
+	list := func(conditions ...backend.SearchAttributeCondition) []string {
+		t.Helper()
+		query := backend.WorkflowRunQuery{WorkflowName: "search-workflow", SearchAttributes: conditions}
+		runs, err := repo.ListWorkflowRuns(ctx, query, nil, 10)
+		require.NoError(t, err)
+		var ids []string
+		for _, run := range runs {
+			ids = append(ids, run.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"search-run-0"}, list(backend.SearchAttributeCondition{Key: "CustomerID", Value: "c-1"}))
+	// A string value never matches a numeric condition.
+	assert.Equal(t, []string{"search-run-1"}, list(backend.SearchAttributeCondition{
+		Key: "OrderTotal", Operator: backend.SearchAttributeGreaterOrEqual, Value: 100,
+	}))
+	// Runs without the attribute do not match a not-equal condition.
+	assert.Equal(t, []string{"search-run-1"}, list(backend.SearchAttributeCondition{
+		Key: "Region", Operator: backend.SearchAttributeNotEqual, Value: "eu",
+	}))
+	assert.Equal(t, []string{"search-run-0"}, list(
+		backend.SearchAttributeCondition{Key: "Priority", Value: true},
+		backend.SearchAttributeCondition{Key: "DueAt", Operator: backend.SearchAttributeLess, Value: dueAt.Add(time.Hour)},
+	))
+
+	_, err = repo.ListWorkflowRuns(ctx, backend.WorkflowRunQuery{
+		SearchAttributes: []backend.SearchAttributeCondition{{Key: "customer-id", Value: "c-1"}},
+	}, nil, 10)
+	require.ErrorIs(t, err, backend.ErrInvalidSearchAttribute)
+
+	err = repo.UpdateWorkflowRunAttributes(ctx, "search-run-1", map[string]any{"OrderTotal": 80.0},
+		json.RawMessage(`{"status":{"metadata":{"encoding":"json/plain"},"data":"shipped"}}`))
+	require.NoError(t, err)
+	run, err := repo.GetWorkflowRun(ctx, "search-run-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"CustomerID": "c-2", "Region": "us", "OrderTotal": 80.0}, run.SearchAttributes)
+	assert.JSONEq(t, `{
+		"note": {"metadata": {"encoding": "json/plain"}, "data": "first"},
+		"status": {"metadata": {"encoding": "json/plain"}, "data": "shipped"}
+	}`, string(run.Memo))
+	assert.Equal(t, []string{"search-run-1", "search-run-0"}, list(backend.SearchAttributeCondition{
+		Key: "OrderTotal", Operator: backend.SearchAttributeLessOrEqual, Value: 80,
+	}))
+
+	require.Error(t, repo.UpdateWorkflowRunAttributes(ctx, "missing", map[string]any{"Region": "eu"}, nil))
+}
+
+func TestPGWorkflowRepository_DeleteWorkflowRuns(t *testing.T) {
+	ctx := context.Background()
+
+	// Get connection from pool
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	// Start transaction
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	repo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+	activityRepo := dbrepo.NewPGActivityRunRepository(tx, db.Tables{})
+
+	now := time.Now()
+	err = repo.UpsertWorkflow(ctx, &entities.DBWorkflow{Name: "delete-workflow", CreatedAt: now, UpdatedAt: now})
+	require.NoError(t, err)
+
+	parentID := "delete-run-1"
+	for i, id := range []string{"delete-run-1", "delete-run-2", "delete-child"} {
+		run := &entities.DBWorkflowRun{
+			ID: id, Input: json.RawMessage(`[]`), WorkflowName: "delete-workflow",
+			Status: entities.WorkflowStatusPending, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+		}
+		if i == 2 {
+			run.ParentWorkflowRunID = &parentID
+		}
+		require.NoError(t, repo.CreateWorkflowRun(ctx, run))
+		require.NoError(t, activityRepo.CreateActivityRun(ctx, &entities.DBActivityRun{
+			ID: "activity-" + id, ActivityName: "test-activity", WorkflowRunID: id, Input: json.RawMessage(`[]`),
+			Status: entities.ActivityStatusPending, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+		}))
+	}
+
+	workflows, err := repo.ListWorkflows(ctx)
+	require.NoError(t, err)
+	names := make([]string, len(workflows))
+	for i, workflow := range workflows {
+		names[i] = workflow.Name
+	}
+	assert.Contains(t, names, "delete-workflow")
+
+	// Runs that are not closed, or that changed after their last event was read, are kept.
+	deleted, err := repo.DeleteWorkflowRuns(ctx, backend.WorkflowRunDeletion{
+		WorkflowRunIDs: []string{"delete-run-1", "delete-run-2"},
+		ClosedBefore:   now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+	deleted, err = repo.DeleteWorkflowRuns(ctx, backend.WorkflowRunDeletion{
+		WorkflowRunIDs:     []string{"delete-run-1", "delete-run-2", "missing"},
+		LastEventSequences: map[string]int64{"delete-run-1": 2, "delete-run-2": 1, "missing": 0},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"delete-run-1"}, deleted)
+
+	run, err := repo.GetWorkflowRun(ctx, "delete-run-1")
+	require.NoError(t, err)
+	assert.Nil(t, run)
+	activityRun, err := activityRepo.GetActivityRun(ctx, "activity-delete-run-1")
+	require.NoError(t, err)
+	assert.Nil(t, activityRun)
+	events, err := dbrepo.NewPGWorkflowEventRepository(tx, db.Tables{}).GetWorkflowEvents(ctx, "delete-run-1", 0)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// Other runs keep their history; children only lose their parent link.
+	child, err := repo.GetWorkflowRun(ctx, "delete-child")
+	require.NoError(t, err)
+	require.NotNil(t, child)
+	assert.Nil(t, child.ParentWorkflowRunID)
+	activityRun, err = activityRepo.GetActivityRun(ctx, "activity-delete-run-2")
+	require.NoError(t, err)
+	assert.NotNil(t, activityRun)
+}
+
+func TestPGWorkflowRepository_TaskQueues(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	repo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+	activityRepo := dbrepo.NewPGActivityRunRepository(tx, db.Tables{})
+
+	now := time.Now()
+	err = repo.UpsertWorkflow(ctx, &entities.DBWorkflow{Name: "queue-workflow", CreatedAt: now, UpdatedAt: now})
+	require.NoError(t, err)
+	for id, taskQueue := range map[string]string{"queue-run-default": "", "queue-run-orders": "queue-orders"} {
+		require.NoError(t, repo.CreateWorkflowRun(ctx, &entities.DBWorkflowRun{
+			ID:           id,
+			Input:        json.RawMessage(`[]`),
+			WorkflowName: "queue-workflow",
+			Status:       entities.WorkflowStatusPending,
+			ScheduledAt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			TaskQueue:    taskQueue,
+		}))
+	}
+
+	run, err := repo.GetWorkflowRun(ctx, "queue-run-default")
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	assert.Equal(t, backend.DefaultTaskQueue, run.TaskQueue)
+
+	next, err := repo.GetNextWorkflowRun(ctx, "queue-orders", "queue-payments")
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.Equal(t, "queue-run-orders", next.ID)
+	next, err = repo.GetNextWorkflowRun(ctx, "queue-payments")
+	require.NoError(t, err)
+	assert.Nil(t, next)
+
+	activity := &entities.DBActivityRun{
+		ID:            "queue-activity-1",
+		ActivityName:  "queue-activity",
+		WorkflowRunID: "queue-run-orders",
+		Input:         json.RawMessage(`[]`),
+		Status:        entities.ActivityStatusPending,
+		ScheduledAt:   now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	require.NoError(t, activityRepo.CreateActivityRun(ctx, activity))
+	assert.Equal(t, "queue-orders", activity.TaskQueue)
+
+	nextActivity, err := activityRepo.GetNextActivityRun(ctx, "queue-orders")
+	require.NoError(t, err)
+	require.NotNil(t, nextActivity)
+	assert.Equal(t, "queue-activity-1", nextActivity.ID)
+	assert.Equal(t, "queue-orders", nextActivity.TaskQueue)
+}
+
+func TestPGWorkflowRepository_Leases(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	repo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+	activityRepo := dbrepo.NewPGActivityRunRepository(tx, db.Tables{})
+
+	now := time.Now()
+	err = repo.UpsertWorkflow(ctx, &entities.DBWorkflow{Name: "lease-workflow", CreatedAt: now, UpdatedAt: now})
+	require.NoError(t, err)
+	require.NoError(t, repo.CreateWorkflowRun(ctx, &entities.DBWorkflowRun{
+		ID:           "lease-run-1",
+		Input:        json.RawMessage(`[]`),
+		WorkflowName: "lease-workflow",
+		Status:       entities.WorkflowStatusPending,
+		ScheduledAt:  now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		TaskQueue:    "lease-queue",
+	}))
+	require.NoError(t, activityRepo.CreateActivityRun(ctx, &entities.DBActivityRun{
+		ID:            "lease-activity-1",
+		ActivityName:  "lease-activity",
+		WorkflowRunID: "lease-run-1",
+		Input:         json.RawMessage(`[]`),
+		Status:        entities.ActivityStatusPending,
+		ScheduledAt:   now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}))
+
+	run, err := repo.ClaimWorkflowRun(ctx, "worker-1", time.Minute, "lease-queue")
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	assert.Equal(t, "lease-run-1", run.ID)
+	assert.Equal(t, entities.WorkflowStatusExecuting, run.Status)
+	assert.Equal(t, 1, run.Attempt)
+	require.NotNil(t, run.LeaseOwner)
+	assert.Equal(t, "worker-1", *run.LeaseOwner)
+	require.NotNil(t, run.LeaseExpiresAt)
+
+	next, err := repo.ClaimWorkflowRun(ctx, "worker-2", time.Minute, "lease-queue")
+	require.NoError(t, err)
+	assert.Nil(t, next)
+
+	require.ErrorIs(t, repo.RenewWorkflowRunLease(ctx, "lease-run-1", "worker-2", time.Minute), backend.ErrLeaseLost)
+	// A lease renewed into the past has expired.
+	require.NoError(t, repo.RenewWorkflowRunLease(ctx, "lease-run-1", "worker-1", -time.Second))
+
+	activity, err := activityRepo.ClaimActivityRun(ctx, "worker-1", -time.Second, "lease-queue")
+	require.NoError(t, err)
+	require.NotNil(t, activity)
+	assert.Equal(t, entities.ActivityStatusExecuting, activity.Status)
+
+	reset, err := repo.ResetExpiredWorkflowRuns(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reset)
+	reset, err = activityRepo.ResetExpiredActivityRuns(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reset)
+
+	run, err = repo.GetWorkflowRun(ctx, "lease-run-1")
+	require.NoError(t, err)
+	assert.Equal(t, entities.WorkflowStatusPending, run.Status)
+	assert.Equal(t, 2, run.Attempt)
+	assert.Nil(t, run.LeaseOwner)
+	assert.Nil(t, run.LeaseExpiresAt)
+
+	activity, err = activityRepo.GetActivityRun(ctx, "lease-activity-1")
+	require.NoError(t, err)
+	assert.Equal(t, entities.ActivityStatusPending, activity.Status)
+	assert.Equal(t, 2, activity.Attempt)
+
+	_, err = repo.ClaimWorkflowRun(ctx, "worker-2", time.Minute, "lease-queue")
+	require.NoError(t, err)
+	require.ErrorIs(t, repo.ReleaseWorkflowRunLease(ctx, "lease-run-1", "worker-1"), backend.ErrLeaseLost)
+	require.NoError(t, repo.ReleaseWorkflowRunLease(ctx, "lease-run-1", "worker-2"))
+
+	run, err = repo.GetWorkflowRun(ctx, "lease-run-1")
+	require.NoError(t, err)
+	assert.Equal(t, entities.WorkflowStatusPending, run.Status)
+	assert.Equal(t, 2, run.Attempt)
+	assert.Nil(t, run.LeaseOwner)
+
+	// A lease expiring on the last attempt fails the run instead.
+	_, err = repo.ClaimWorkflowRun(ctx, "worker-3", -time.Second, "lease-queue")
+	require.NoError(t, err)
+	_, err = activityRepo.ClaimActivityRun(ctx, "worker-3", -time.Second, "lease-queue")
+	require.NoError(t, err)
+
+	failed, err := repo.ResetExpiredWorkflowRuns(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), failed)
+	failed, err = activityRepo.ResetExpiredActivityRuns(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), failed)
+
+	run, err = repo.GetWorkflowRun(ctx, "lease-run-1")
+	require.NoError(t, err)
+	assert.Equal(t, entities.WorkflowStatusFailed, run.Status)
+	assert.Equal(t, 2, run.Attempt)
+	assert.NotNil(t, run.ClosedAt)
+	require.NotNil(t, run.ErrorMessage)
+	assert.Equal(t, backend.ErrAttemptsExhausted.Error(), *run.ErrorMessage)
+	assert.Nil(t, run.LeaseOwner)
+
+	activity, err = activityRepo.GetActivityRun(ctx, "lease-activity-1")
+	require.NoError(t, err)
+	assert.Equal(t, entities.ActivityStatusFailed, activity.Status)
+	require.NotNil(t, activity.ErrorMessage)
+	assert.Equal(t, backend.ErrAttemptsExhausted.Error(), *activity.ErrorMessage)
+}
+
+func TestPGWorkflowRepository_CloseWorkflowRun(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := testContainer.GetPool().Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	repo := dbrepo.NewPGWorkflowRepository(tx, db.Tables{})
+	now := time.Now()
+	err = repo.UpsertWorkflow(ctx, &entities.DBWorkflow{Name: "close-workflow", CreatedAt: now, UpdatedAt: now})
+	require.NoError(t, err)
+	require.NoError(t, repo.CreateWorkflowRun(ctx, &entities.DBWorkflowRun{
+		ID:           "close-run-1",
+		Input:        json.RawMessage(`[]`),
+		WorkflowName: "close-workflow",
+		Status:       entities.WorkflowStatusPending,
+		ScheduledAt:  now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		TaskQueue:    "close-queue",
+	}))
+
+	output := json.RawMessage(`{"payloads":[]}`)
+	err = repo.CloseWorkflowRun(ctx, "close-run-1", "worker-1", entities.WorkflowStatusFinished, &output, nil)
+	require.ErrorIs(t, err, backend.ErrLeaseLost)
+	_, err = repo.ClaimWorkflowRun(ctx, "worker-1", time.Minute, "close-queue")
+	require.NoError(t, err)
+	err = repo.CloseWorkflowRun(ctx, "close-run-1", "worker-2", entities.WorkflowStatusFinished, &output, nil)
+	require.ErrorIs(t, err, backend.ErrLeaseLost)
+	err = repo.CloseWorkflowRun(ctx, "close-run-1", "worker-1", entities.WorkflowStatusFinished, &output, nil)
+	require.NoError(t, err)
+
+	run, err := repo.GetWorkflowRun(ctx, "close-run-1")
+	require.NoError(t, err)
+	require.Equal(t, entities.WorkflowStatusFinished, run.Status)
+	require.JSONEq(t, string(output), string(*run.Output))
+	require.Nil(t, run.ErrorMessage)
+	require.NotNil(t, run.ClosedAt)
+	require.Nil(t, run.LeaseOwner)
+}
@@ -19,6 +19,29 @@ type DBWorkflowRun struct {
 	ScheduledAt  time.Time       `json:"scheduled_at" db:"scheduled_at"`
 	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time       `json:"updated_at" db:"updated_at"`
+	// ClosedAt is set when the run reaches a closed status and cleared if it leaves it.
+	ClosedAt            *time.Time        `json:"closed_at" db:"closed_at"`
+	Labels              map[string]string `json:"labels" db:"labels"`
+	ParentWorkflowRunID *string           `json:"parent_workflow_run_id" db:"parent_workflow_run_id"`
+	// SearchAttributes are indexed values for filtering runs, normalized by backend.NormalizeSearchAttributes.
+	SearchAttributes map[string]any `json:"search_attributes" db:"search_attributes"`
+	// Memo is a JSON object of payloads that is returned with the run but not indexed.
+	Memo json.RawMessage `json:"memo,omitempty" db:"memo"`
+	// TaskQueue is the queue of workers the run is dispatched to.
+	TaskQueue string `json:"task_queue" db:"task_queue"`
+	// Attempt is 1 for the first execution and incremented each time an expired lease returns
+	// the run to pending.
+	Attempt int `json:"attempt" db:"attempt"`
+	// LeaseOwner is the worker executing the run, which holds it until LeaseExpiresAt unless it
+	// renews the lease.
+	LeaseOwner     *string    `json:"lease_owner" db:"lease_owner"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at" db:"lease_expires_at"`
+	// TraceContext is the trace context of the span that started the run, as injected by an
+	// OpenTelemetry propagator, so that the spans of its executions join the same trace.
+	TraceContext map[string]string `json:"trace_context,omitempty" db:"trace_context"`
+	// Output is the encoded result of a finished run and ErrorMessage the error of a failed one.
+	Output       *json.RawMessage `json:"output,omitempty" db:"output"`
+	ErrorMessage *string          `json:"error_message,omitempty" db:"error_message"`
 }
 
 type DBActivityRun struct {
@@ -33,4 +56,36 @@ type DBActivityRun struct {
 	ScheduledAt   time.Time        `json:"scheduled_at" db:"scheduled_at"`
 	CreatedAt     time.Time        `json:"created_at" db:"created_at"`
 	UpdatedAt     time.Time        `json:"updated_at" db:"updated_at"`
+	// TaskQueue is the queue of workers the run is dispatched to; it defaults to the queue of
+	// the workflow run.
+	TaskQueue string `json:"task_queue" db:"task_queue"`
+	// Attempt, LeaseOwner and LeaseExpiresAt work as on DBWorkflowRun.
+	Attempt        int        `json:"attempt" db:"attempt"`
+	LeaseOwner     *string    `json:"lease_owner" db:"lease_owner"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at" db:"lease_expires_at"`
+	// TraceContext works as on DBWorkflowRun and defaults to that of the workflow run.
+	TraceContext map[string]string `json:"trace_context,omitempty" db:"trace_context"`
+}
+
+type DBWorkflowEvent struct {
+	WorkflowRunID string            `json:"workflow_run_id" db:"workflow_run_id"`
+	Sequence      int64             `json:"sequence" db:"sequence"`
+	EventType     WorkflowEventType `json:"event_type" db:"event_type"`
+	ActivityRunID *string           `json:"activity_run_id" db:"activity_run_id"`
+	Payload       json.RawMessage   `json:"payload" db:"payload"`
+	CreatedAt     time.Time         `json:"created_at" db:"created_at"`
+}
+
+// DBWorker is a worker process polling task queues, as registered by the worker itself.
+type DBWorker struct {
+	ID       string `json:"id" db:"id"`
+	Hostname string `json:"hostname" db:"hostname"`
+	// TaskQueues are the queues the worker polls, and Workflows and Activities the names of the
+	// functions it can execute.
+	TaskQueues      []string  `json:"task_queues" db:"task_queues"`
+	Workflows       []string  `json:"workflows" db:"workflows"`
+	Activities      []string  `json:"activities" db:"activities"`
+	BuildVersion    string    `json:"build_version" db:"build_version"`
+	StartedAt       time.Time `json:"started_at" db:"started_at"`
+	LastHeartbeatAt time.Time `json:"last_heartbeat_at" db:"last_heartbeat_at"`
 }
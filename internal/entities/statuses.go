@@ -13,6 +13,11 @@ const (
 	ActivityStatusFinished  ActivityStatus = "finished"
 )
 
+// IsClosed reports whether an activity run with this status will not be executed anymore.
+func (s ActivityStatus) IsClosed() bool {
+	return s == ActivityStatusFinished || s == ActivityStatusFailed
+}
+
 type WorkflowStatus string
 
 const (
@@ -22,3 +27,18 @@ const (
 	WorkflowStatusFinished  WorkflowStatus = "finished"
 	WorkflowStatusAborted   WorkflowStatus = "aborted"
 )
+
+// IsClosed reports whether a run with this status will not make progress anymore.
+func (s WorkflowStatus) IsClosed() bool {
+	return s == WorkflowStatusFinished || s == WorkflowStatusFailed || s == WorkflowStatusAborted
+}
+
+type WorkflowEventType string
+
+const (
+	WorkflowEventRunCreated               WorkflowEventType = "workflow_run_created"
+	WorkflowEventRunStatusChanged         WorkflowEventType = "workflow_run_status_changed"
+	WorkflowEventActivityRunCreated       WorkflowEventType = "activity_run_created"
+	WorkflowEventActivityRunStatusChanged WorkflowEventType = "activity_run_status_changed"
+	WorkflowEventRunAttributesUpdated     WorkflowEventType = "workflow_run_attributes_updated"
+)
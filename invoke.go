@@ -0,0 +1,111 @@
+package pitlane
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/nurburg-dev/pitlane/internal/utils"
+)
+
+// RunHandle references a workflow run started through one of the typed Invoke helpers.
+// R is the result type of the workflow function.
+type RunHandle[R any] struct {
+	engine *WorkflowEngine
+	runID  string
+}
+
+// ID returns the workflow run ID.
+func (h *RunHandle[R]) ID() string {
+	return h.runID
+}
+
+// Get waits until the run is closed and returns its result, decoded by the engine's data
+// converter. It returns a *WorkflowRunError when the run failed or was aborted, and ctx's error
+// when ctx is done first. A run finished without an output yields the zero value of R.
+func (h *RunHandle[R]) Get(ctx context.Context) (R, error) {
+	var result R
+	run, err := h.engine.waitForWorkflowRun(ctx, h.runID)
+	if err != nil {
+		return result, err
+	}
+	if run.Status != backend.WorkflowStatusFinished {
+		runErr := &WorkflowRunError{WorkflowRunID: run.ID, Status: run.Status}
+		if run.ErrorMessage != nil {
+			runErr.Message = *run.ErrorMessage
+		}
+		return result, runErr
+	}
+	if run.Output == nil {
+		return result, nil
+	}
+	if err := converter.DecodeValues(h.engine.dataConverter, *run.Output, &result); err != nil {
+		return result, fmt.Errorf("failed to decode output of workflow run %s: %w", run.ID, err)
+	}
+	return result, nil
+}
+
+// Invoke0 starts a workflow that takes no arguments besides the context.
+func Invoke0[R any](
+	ctx context.Context,
+	we *WorkflowEngine,
+	workflowFunction func(context.Context) (R, error),
+) (*RunHandle[R], error) {
+	return invokeTyped[R](ctx, we, workflowFunction)
+}
+
+// Invoke1 starts a workflow that takes a single argument.
+func Invoke1[A, R any](
+	ctx context.Context,
+	we *WorkflowEngine,
+	workflowFunction func(context.Context, A) (R, error),
+	a A,
+) (*RunHandle[R], error) {
+	return invokeTyped[R](ctx, we, workflowFunction, a)
+}
+
+// Invoke2 starts a workflow that takes two arguments.
+func Invoke2[A, B, R any](
+	ctx context.Context,
+	we *WorkflowEngine,
+	workflowFunction func(context.Context, A, B) (R, error),
+	a A,
+	b B,
+) (*RunHandle[R], error) {
+	return invokeTyped[R](ctx, we, workflowFunction, a, b)
+}
+
+// Invoke3 starts a workflow that takes three arguments.
+func Invoke3[A, B, C, R any](
+	ctx context.Context,
+	we *WorkflowEngine,
+	workflowFunction func(context.Context, A, B, C) (R, error),
+	a A,
+	b B,
+	c C,
+) (*RunHandle[R], error) {
+	return invokeTyped[R](ctx, we, workflowFunction, a, b, c)
+}
+
+func invokeTyped[R any](
+	ctx context.Context,
+	we *WorkflowEngine,
+	workflowFunction any,
+	args ...any,
+) (*RunHandle[R], error) {
+	workflowFuncName, err := utils.GetFunctionName(workflowFunction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow function name: %w", err)
+	}
+
+	if _, exist := GetWorkflowStore()[workflowFuncName]; !exist {
+		return nil, fmt.Errorf("workflow %s not registered", workflowFuncName)
+	}
+
+	runID, err := we.createWorkflowRun(ctx, workflowFuncName, args, StartWorkflowOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &RunHandle[R]{engine: we, runID: runID}, nil
+}
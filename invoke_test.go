@@ -0,0 +1,116 @@
+package pitlane_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type GreetingInput struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TypedWorkflow(_ context.Context, in GreetingInput) (string, error) {
+	return fmt.Sprintf("Hello %s %d", in.Name, in.Count), nil
+}
+
+func TypedWorkflow2(_ context.Context, name string, count int) (int, error) {
+	return len(name) + count, nil
+}
+
+func UnregisteredTypedWorkflow(_ context.Context) (string, error) {
+	return "", nil
+}
+
+func TestInvokeTyped(t *testing.T) {
+	ctx := context.Background()
+	cfg := pitlane.NewDBConfig(
+		pgContainer.GetHost(),
+		pgContainer.GetPort(),
+		pgContainer.GetUsername(),
+		pgContainer.GetDatabase(),
+		pgContainer.GetPassword(),
+	)
+
+	we, err := pitlane.NewWorkflowEngine(ctx, pitlane.NewEngineConfig(cfg, true))
+	require.NoError(t, err)
+
+	require.NoError(t, pitlane.RegisterWorkflow(TypedWorkflow))
+	require.NoError(t, pitlane.RegisterWorkflow(TypedWorkflow2))
+
+	handle, err := pitlane.Invoke1(ctx, we, TypedWorkflow, GreetingInput{Name: "test", Count: 42})
+	require.NoError(t, err)
+	require.NotEmpty(t, handle.ID())
+
+	var workflowName string
+	var input []byte
+	err = pgContainer.GetPool().QueryRow(ctx,
+		`SELECT workflow_name, input FROM workflow_runs WHERE id = $1`, handle.ID(),
+	).Scan(&workflowName, &input)
+	require.NoError(t, err)
+	require.Equal(t, "github.com/nurburg-dev/pitlane_test.TypedWorkflow", workflowName)
+	require.JSONEq(t,
+		`{"payloads":[{"metadata":{"encoding":"json/plain"},"data":{"name":"test","count":42}}]}`,
+		string(input),
+	)
+
+	handle2, err := pitlane.Invoke2(ctx, we, TypedWorkflow2, "test", 42)
+	require.NoError(t, err)
+	require.NotEmpty(t, handle2.ID())
+
+	_, err = pitlane.Invoke0(ctx, we, UnregisteredTypedWorkflow)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not registered")
+}
+
+func ResultWorkflow(_ context.Context, name string) (GreetingInput, error) {
+	return GreetingInput{Name: name}, nil
+}
+
+func TestRunHandle_Get(t *testing.T) {
+	ctx := context.Background()
+	we, _ := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.PayloadSizeLimits.HardLimit = 1024
+	})
+	require.NoError(t, pitlane.RegisterWorkflow(ResultWorkflow))
+
+	handle, err := pitlane.Invoke1(ctx, we, ResultWorkflow, "finished")
+	require.NoError(t, err)
+	run, err := we.ClaimWorkflowRun(ctx, "worker-1")
+	require.NoError(t, err)
+	err = we.CompleteWorkflowRun(ctx, run, GreetingInput{Name: strings.Repeat("a", 2048)})
+	require.ErrorIs(t, err, pitlane.ErrPayloadTooLarge)
+	require.NoError(t, we.CompleteWorkflowRun(ctx, run, GreetingInput{Name: "finished", Count: 1}))
+	require.ErrorIs(t, we.CompleteWorkflowRun(ctx, run, GreetingInput{}), backend.ErrLeaseLost)
+
+	result, err := handle.Get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, GreetingInput{Name: "finished", Count: 1}, result)
+
+	handle, err = pitlane.Invoke1(ctx, we, ResultWorkflow, "failed")
+	require.NoError(t, err)
+	run, err = we.ClaimWorkflowRun(ctx, "worker-1")
+	require.NoError(t, err)
+	require.NoError(t, we.FailWorkflowRun(ctx, run, errors.New("card declined")))
+
+	_, err = handle.Get(ctx)
+	var runErr *pitlane.WorkflowRunError
+	require.ErrorAs(t, err, &runErr)
+	require.Equal(t, backend.WorkflowStatusFailed, runErr.Status)
+	require.Equal(t, "card declined", runErr.Message)
+
+	pending, err := pitlane.Invoke1(ctx, we, ResultWorkflow, "pending")
+	require.NoError(t, err)
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = pending.Get(waitCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
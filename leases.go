@@ -0,0 +1,186 @@
+package pitlane
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+const defaultLeaseDuration = 30 * time.Second
+
+// LeaseConfig sets how long workers hold the runs they claim. A worker renews the leases of
+// its runs while it executes them; runs whose lease expired, e.g. because their worker crashed,
+// are returned to pending by RunLeaseReaper for another worker to claim.
+type LeaseConfig struct {
+	// Duration is the time a claim or renewal holds a run and defaults to 30 seconds. Workers
+	// should renew well before it passes, e.g. every third of it.
+	Duration time.Duration
+	// ReapInterval is the time between the passes of RunLeaseReaper and defaults to Duration.
+	ReapInterval time.Duration
+	// MaxAttempts is the number of expired leases after which a run is failed instead of being
+	// returned to pending, so that a run crashing its workers is not retried forever. Zero
+	// retries runs without a limit.
+	MaxAttempts int
+	// OnError is called with the error of a failed pass of RunLeaseReaper, which keeps running.
+	OnError func(err error)
+}
+
+func (c LeaseConfig) duration() time.Duration {
+	if c.Duration <= 0 {
+		return defaultLeaseDuration
+	}
+	return c.Duration
+}
+
+// ClaimWorkflowRun changes the next pending workflow run on one of taskQueues, or on any task
+// queue when none is given, to executing under a lease held by owner, and returns it. It
+// returns nil when there is no pending run.
+func (we *WorkflowEngine) ClaimWorkflowRun(
+	ctx context.Context,
+	owner string,
+	taskQueues ...string,
+) (*backend.WorkflowRun, error) {
+	var run *backend.WorkflowRun
+	start := time.Now()
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		run, err = tx.WorkflowRepository().ClaimWorkflowRun(ctx, owner, we.leases.duration(), taskQueues...)
+		return err
+	})
+	we.recordClaimDuration(MetricKindWorkflow, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim workflow run: %w", err)
+	}
+	if run != nil {
+		we.recordScheduleToStart(MetricKindWorkflow, run.TaskQueue, run.ScheduledAt)
+	}
+	return run, nil
+}
+
+// ClaimActivityRun claims the next pending activity run like ClaimWorkflowRun.
+func (we *WorkflowEngine) ClaimActivityRun(
+	ctx context.Context,
+	owner string,
+	taskQueues ...string,
+) (*backend.ActivityRun, error) {
+	var run *backend.ActivityRun
+	start := time.Now()
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		run, err = tx.ActivityRunRepository().ClaimActivityRun(ctx, owner, we.leases.duration(), taskQueues...)
+		return err
+	})
+	we.recordClaimDuration(MetricKindActivity, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim activity run: %w", err)
+	}
+	if run != nil {
+		we.recordScheduleToStart(MetricKindActivity, run.TaskQueue, run.ScheduledAt)
+	}
+	return run, nil
+}
+
+// RenewWorkflowRunLease extends the lease owner holds on a workflow run by Leases.Duration. It
+// returns an error matching backend.ErrLeaseLost when the run is no longer leased to owner; the
+// worker should then abandon the run, which another worker may be executing.
+func (we *WorkflowEngine) RenewWorkflowRunLease(ctx context.Context, workflowRunID, owner string) error {
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkflowRepository().RenewWorkflowRunLease(ctx, workflowRunID, owner, we.leases.duration())
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew lease of workflow run %s: %w", workflowRunID, err)
+	}
+	return nil
+}
+
+// RenewActivityRunLease extends the lease owner holds on an activity run like
+// RenewWorkflowRunLease.
+func (we *WorkflowEngine) RenewActivityRunLease(ctx context.Context, activityRunID, owner string) error {
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.ActivityRunRepository().RenewActivityRunLease(ctx, activityRunID, owner, we.leases.duration())
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew lease of activity run %s: %w", activityRunID, err)
+	}
+	return nil
+}
+
+// ReleaseWorkflowRunLease returns a workflow run owner stopped executing to pending without
+// counting a new attempt, so that another worker picks it up right away. It returns an error
+// matching backend.ErrLeaseLost when the run is no longer leased to owner.
+func (we *WorkflowEngine) ReleaseWorkflowRunLease(ctx context.Context, workflowRunID, owner string) error {
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkflowRepository().ReleaseWorkflowRunLease(ctx, workflowRunID, owner)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release lease of workflow run %s: %w", workflowRunID, err)
+	}
+	return nil
+}
+
+// ReleaseActivityRunLease returns an activity run to pending like ReleaseWorkflowRunLease.
+func (we *WorkflowEngine) ReleaseActivityRunLease(ctx context.Context, activityRunID, owner string) error {
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.ActivityRunRepository().ReleaseActivityRunLease(ctx, activityRunID, owner)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release lease of activity run %s: %w", activityRunID, err)
+	}
+	return nil
+}
+
+// RunLeaseReaper runs ReapExpiredLeases every Leases.ReapInterval until ctx is done, and then
+// returns ctx's error. Start it in its own goroutine; running it in several processes is safe.
+func (we *WorkflowEngine) RunLeaseReaper(ctx context.Context) error {
+	interval := we.leases.ReapInterval
+	if interval <= 0 {
+		interval = we.leases.duration()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reset, err := we.ReapExpiredLeases(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			we.logger.ErrorContext(ctx, "failed to reap expired leases", slog.Any("error", err))
+			if we.leases.OnError != nil {
+				we.leases.OnError(err)
+			}
+		case reset > 0:
+			we.logger.InfoContext(ctx, "reset runs with expired leases", slog.Int64("count", reset))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReapExpiredLeases returns the executing workflow and activity runs whose lease expired to
+// pending, incrementing their attempt, and returns how many it reset. Runs whose lease expired
+// on their Leases.MaxAttempts-th attempt are failed with backend.ErrAttemptsExhausted instead,
+// and counted as well.
+func (we *WorkflowEngine) ReapExpiredLeases(ctx context.Context) (int64, error) {
+	var reset int64
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		workflowRuns, err := tx.WorkflowRepository().ResetExpiredWorkflowRuns(ctx, we.leases.MaxAttempts)
+		if err != nil {
+			return err
+		}
+		activityRuns, err := tx.ActivityRunRepository().ResetExpiredActivityRuns(ctx, we.leases.MaxAttempts)
+		if err != nil {
+			return err
+		}
+		reset = workflowRuns + activityRuns
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap expired leases: %w", err)
+	}
+	return reset, nil
+}
@@ -0,0 +1,80 @@
+package pitlane_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReapExpiredLeases(t *testing.T) {
+	ctx := context.Background()
+	we, _ := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.Leases = pitlane.LeaseConfig{Duration: 20 * time.Millisecond}
+	})
+
+	options := pitlane.StartWorkflowOptions{TaskQueue: "leases"}
+	workflowRunID, err := we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order-1")
+	require.NoError(t, err)
+
+	run, err := we.ClaimWorkflowRun(ctx, "worker-1", "leases")
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	require.Equal(t, workflowRunID, run.ID)
+	require.NoError(t, we.RenewWorkflowRunLease(ctx, workflowRunID, "worker-1"))
+
+	// A live lease is left alone.
+	reset, err := we.ReapExpiredLeases(ctx)
+	require.NoError(t, err)
+	require.Zero(t, reset)
+
+	time.Sleep(40 * time.Millisecond)
+	reset, err = we.ReapExpiredLeases(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), reset)
+	require.ErrorIs(t, we.RenewWorkflowRunLease(ctx, workflowRunID, "worker-1"), backend.ErrLeaseLost)
+
+	run, err = we.ClaimWorkflowRun(ctx, "worker-2", "leases")
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	require.Equal(t, workflowRunID, run.ID)
+	require.Equal(t, 2, run.Attempt)
+	require.NotNil(t, run.LeaseOwner)
+	require.Equal(t, "worker-2", *run.LeaseOwner)
+}
+
+func TestReapExpiredLeases_MaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	we, b := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.Leases = pitlane.LeaseConfig{Duration: time.Millisecond, MaxAttempts: 2}
+	})
+
+	options := pitlane.StartWorkflowOptions{TaskQueue: "poison"}
+	workflowRunID, err := we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order-1")
+	require.NoError(t, err)
+
+	// Every worker claiming the run crashes before renewing its lease.
+	for attempt := 1; attempt <= 2; attempt++ {
+		run, err := we.ClaimWorkflowRun(ctx, "worker-1", "poison")
+		require.NoError(t, err)
+		require.NotNil(t, run)
+		require.Equal(t, attempt, run.Attempt)
+		time.Sleep(5 * time.Millisecond)
+		reaped, err := we.ReapExpiredLeases(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), reaped)
+	}
+
+	run := getWorkflowRun(ctx, t, b, workflowRunID)
+	require.Equal(t, backend.WorkflowStatusFailed, run.Status)
+	require.NotNil(t, run.ClosedAt)
+	require.NotNil(t, run.ErrorMessage)
+	require.Equal(t, backend.ErrAttemptsExhausted.Error(), *run.ErrorMessage)
+
+	run, err = we.ClaimWorkflowRun(ctx, "worker-1", "poison")
+	require.NoError(t, err)
+	require.Nil(t, run)
+}
@@ -0,0 +1,230 @@
+package pitlane
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+// Metrics recorded by the engine and its workers. Durations are recorded in seconds. Each metric
+// is always recorded with the same label keys, listed next to it.
+const (
+	// MetricWorkflowRunsStarted counts the workflow runs created by InvokeWorkflow.
+	// Labels: workflow_name, task_queue.
+	MetricWorkflowRunsStarted = "pitlane_workflow_runs_started_total"
+	// MetricWorkflowRunsClosed counts the workflow runs a worker's handler closed.
+	// Labels: workflow_name, status.
+	MetricWorkflowRunsClosed = "pitlane_workflow_runs_closed_total"
+	// MetricWorkflowTaskLatency is the time workflow task handlers took. Labels: workflow_name.
+	MetricWorkflowTaskLatency = "pitlane_workflow_task_latency_seconds"
+	// MetricActivityAttempts counts the activity attempts executed by workers.
+	// Labels: activity_name, outcome.
+	MetricActivityAttempts = "pitlane_activity_attempts_total"
+	// MetricActivityLatency is the time activity attempts took. Labels: activity_name.
+	MetricActivityLatency = "pitlane_activity_latency_seconds"
+	// MetricScheduleToStartLatency is the time between the scheduled time of a run and its claim.
+	// Labels: kind, task_queue.
+	MetricScheduleToStartLatency = "pitlane_schedule_to_start_latency_seconds"
+	// MetricClaimDuration is the time claim queries took, including those finding no run.
+	// Labels: kind.
+	MetricClaimDuration = "pitlane_claim_duration_seconds"
+	// MetricTaskQueueBacklog is the number of pending runs of a task queue. Labels: kind, task_queue.
+	MetricTaskQueueBacklog = "pitlane_task_queue_backlog"
+	// MetricTaskQueueOldestPendingAge is the time the oldest pending run of a task queue has been
+	// due, or 0. Labels: task_queue.
+	MetricTaskQueueOldestPendingAge = "pitlane_task_queue_oldest_pending_age_seconds"
+)
+
+// Label keys and the values of the kind and outcome labels.
+const (
+	MetricLabelWorkflowName = "workflow_name"
+	MetricLabelActivityName = "activity_name"
+	MetricLabelTaskQueue    = "task_queue"
+	MetricLabelStatus       = "status"
+	MetricLabelKind         = "kind"
+	MetricLabelOutcome      = "outcome"
+
+	MetricKindWorkflow = "workflow"
+	MetricKindActivity = "activity"
+
+	MetricOutcomeCompleted = "completed"
+	MetricOutcomeFailed    = "failed"
+	// MetricOutcomeReleased is the outcome of runs handed back to pending unfinished.
+	MetricOutcomeReleased = "released"
+)
+
+// MetricsHandler receives the metrics of the engine, e.g. to export them to Prometheus with
+// metrics/prometheus. Its methods are called concurrently and must not block.
+type MetricsHandler interface {
+	// Counter adds delta to a counter.
+	Counter(name string, labels map[string]string, delta float64)
+	// Histogram records an observation.
+	Histogram(name string, labels map[string]string, value float64)
+	// Gauge sets a gauge to value.
+	Gauge(name string, labels map[string]string, value float64)
+}
+
+// MetricsGaugeDeleter is implemented by metrics handlers that can remove the series of a gauge.
+// The engine deletes the task queue gauges of queues that no longer exist; handlers without
+// DeleteGauge get those gauges set to 0 instead.
+type MetricsGaugeDeleter interface {
+	// DeleteGauge removes the gauge with the given labels.
+	DeleteGauge(name string, labels map[string]string)
+}
+
+type nopMetricsHandler struct{}
+
+func (nopMetricsHandler) Counter(string, map[string]string, float64)   {}
+func (nopMetricsHandler) Histogram(string, map[string]string, float64) {}
+func (nopMetricsHandler) Gauge(string, map[string]string, float64)     {}
+
+const defaultMetricsReportInterval = 15 * time.Second
+
+// MetricsConfig sets where the engine records its metrics.
+type MetricsConfig struct {
+	// Handler receives the metrics. Metrics are discarded when it is nil.
+	Handler MetricsHandler
+	// ReportInterval is the time between the task queue reports of RunMetricsReporter and
+	// defaults to 15 seconds.
+	ReportInterval time.Duration
+	// OnError is called with the error of a failed report of RunMetricsReporter, which keeps
+	// running.
+	OnError func(err error)
+}
+
+func (c MetricsConfig) handler() MetricsHandler {
+	if c.Handler == nil {
+		return nopMetricsHandler{}
+	}
+	return c.Handler
+}
+
+// RunMetricsReporter runs ReportTaskQueueMetrics every Metrics.ReportInterval until ctx is done,
+// and then returns ctx's error. Start it in its own goroutine in a single process, since every
+// process would report the same values.
+func (we *WorkflowEngine) RunMetricsReporter(ctx context.Context) error {
+	interval := we.metrics.ReportInterval
+	if interval <= 0 {
+		interval = defaultMetricsReportInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := we.ReportTaskQueueMetrics(ctx); err != nil && ctx.Err() == nil {
+			we.logger.ErrorContext(ctx, "failed to report task queue metrics", slog.Any("error", err))
+			if we.metrics.OnError != nil {
+				we.metrics.OnError(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReportTaskQueueMetrics records the backlog and the age of the oldest pending run of every task
+// queue that has pending runs or registered workers. The gauges of queues reported before that
+// have neither anymore are deleted, see MetricsGaugeDeleter.
+func (we *WorkflowEngine) ReportTaskQueueMetrics(ctx context.Context) error {
+	var queues []backend.TaskQueueInfo
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		// Pollers are not reported, so any liveness window will do.
+		queues, err = tx.WorkerRepository().ListTaskQueues(ctx, time.Minute)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to report task queue metrics: %w", err)
+	}
+
+	handler := we.metrics.handler()
+	now := time.Now()
+	reported := make(map[string]struct{}, len(queues))
+	for _, queue := range queues {
+		reported[queue.Name] = struct{}{}
+		handler.Gauge(MetricTaskQueueBacklog, backlogLabels(MetricKindWorkflow, queue.Name),
+			float64(queue.PendingWorkflowRuns))
+		handler.Gauge(MetricTaskQueueBacklog, backlogLabels(MetricKindActivity, queue.Name),
+			float64(queue.PendingActivityRuns))
+
+		var age time.Duration
+		if queue.OldestPendingScheduledAt != nil {
+			age = max(now.Sub(*queue.OldestPendingScheduledAt), 0)
+		}
+		handler.Gauge(MetricTaskQueueOldestPendingAge, oldestPendingAgeLabels(queue.Name), age.Seconds())
+	}
+	for _, taskQueue := range we.taskQueueGauges.replace(reported) {
+		clearTaskQueueGauges(handler, taskQueue)
+	}
+	return nil
+}
+
+// taskQueueGauges tracks the task queues whose gauges the last report recorded.
+type taskQueueGauges struct {
+	mu       sync.Mutex
+	reported map[string]struct{}
+}
+
+// replace records the queues of a report and returns the queues of the previous report missing
+// from it.
+func (g *taskQueueGauges) replace(reported map[string]struct{}) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var gone []string
+	for taskQueue := range g.reported {
+		if _, ok := reported[taskQueue]; !ok {
+			gone = append(gone, taskQueue)
+		}
+	}
+	g.reported = reported
+	return gone
+}
+
+// clearTaskQueueGauges deletes the gauges of a task queue, or sets them to 0 when the handler
+// cannot delete gauges.
+func clearTaskQueueGauges(handler MetricsHandler, taskQueue string) {
+	reset := func(name string, labels map[string]string) {
+		if deleter, ok := handler.(MetricsGaugeDeleter); ok {
+			deleter.DeleteGauge(name, labels)
+			return
+		}
+		handler.Gauge(name, labels, 0)
+	}
+	reset(MetricTaskQueueBacklog, backlogLabels(MetricKindWorkflow, taskQueue))
+	reset(MetricTaskQueueBacklog, backlogLabels(MetricKindActivity, taskQueue))
+	reset(MetricTaskQueueOldestPendingAge, oldestPendingAgeLabels(taskQueue))
+}
+
+func backlogLabels(kind, taskQueue string) map[string]string {
+	return map[string]string{
+		MetricLabelKind:      kind,
+		MetricLabelTaskQueue: taskQueue,
+	}
+}
+
+func oldestPendingAgeLabels(taskQueue string) map[string]string {
+	return map[string]string{
+		MetricLabelTaskQueue: taskQueue,
+	}
+}
+
+func (we *WorkflowEngine) recordClaimDuration(kind string, start time.Time) {
+	we.metrics.handler().Histogram(MetricClaimDuration, map[string]string{
+		MetricLabelKind: kind,
+	}, time.Since(start).Seconds())
+}
+
+// recordScheduleToStart records the latency of a run claimed now.
+func (we *WorkflowEngine) recordScheduleToStart(kind, taskQueue string, scheduledAt time.Time) {
+	we.metrics.handler().Histogram(MetricScheduleToStartLatency, map[string]string{
+		MetricLabelKind:      kind,
+		MetricLabelTaskQueue: taskQueue,
+	}, max(time.Since(scheduledAt), 0).Seconds())
+}
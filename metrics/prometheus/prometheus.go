@@ -0,0 +1,155 @@
+// Package prometheus exports the metrics of a pitlane engine to Prometheus.
+//
+//	handler := prometheus.NewHandler(prom.DefaultRegisterer)
+//	config.Metrics.Handler = handler
+//
+// Collectors are registered on first use, with the label keys of the first observation.
+package prometheus
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var help = map[string]string{
+	pitlane.MetricWorkflowRunsStarted:       "Workflow runs started.",
+	pitlane.MetricWorkflowRunsClosed:        "Workflow runs closed by a worker, by status.",
+	pitlane.MetricWorkflowTaskLatency:       "Time workflow task handlers took.",
+	pitlane.MetricActivityAttempts:          "Activity attempts executed by workers, by outcome.",
+	pitlane.MetricActivityLatency:           "Time activity attempts took.",
+	pitlane.MetricScheduleToStartLatency:    "Time between the scheduled time of a run and its claim by a worker.",
+	pitlane.MetricClaimDuration:             "Time claim queries took.",
+	pitlane.MetricTaskQueueBacklog:          "Pending runs of a task queue.",
+	pitlane.MetricTaskQueueOldestPendingAge: "Time the oldest pending run of a task queue has been due.",
+}
+
+// Handler is a pitlane.MetricsHandler registering its collectors with a prometheus.Registerer.
+// Observations that do not fit the collector of their metric, e.g. because their label keys
+// differ, are dropped.
+type Handler struct {
+	registerer prometheus.Registerer
+	// Buckets are the histogram buckets, in seconds. They default to prometheus.DefBuckets and
+	// apply to histograms registered after they are set.
+	Buckets []float64
+
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+	gauges     map[string]*prometheus.GaugeVec
+}
+
+var (
+	_ pitlane.MetricsHandler      = (*Handler)(nil)
+	_ pitlane.MetricsGaugeDeleter = (*Handler)(nil)
+)
+
+// NewHandler returns a handler registering with registerer, or with prometheus.DefaultRegisterer
+// when it is nil.
+func NewHandler(registerer prometheus.Registerer) *Handler {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	return &Handler{
+		registerer: registerer,
+		Buckets:    prometheus.DefBuckets,
+		counters:   map[string]*prometheus.CounterVec{},
+		histograms: map[string]*prometheus.HistogramVec{},
+		gauges:     map[string]*prometheus.GaugeVec{},
+	}
+}
+
+func (h *Handler) Counter(name string, labels map[string]string, delta float64) {
+	h.mu.Lock()
+	vec, ok := h.counters[name]
+	if !ok {
+		vec = register(h.registerer, prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: name, Help: helpOf(name)},
+			labelKeys(labels),
+		))
+		h.counters[name] = vec
+	}
+	h.mu.Unlock()
+
+	if counter, err := vec.GetMetricWith(labels); err == nil {
+		counter.Add(delta)
+	}
+}
+
+func (h *Handler) Histogram(name string, labels map[string]string, value float64) {
+	h.mu.Lock()
+	vec, ok := h.histograms[name]
+	if !ok {
+		vec = register(h.registerer, prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: name, Help: helpOf(name), Buckets: h.Buckets},
+			labelKeys(labels),
+		))
+		h.histograms[name] = vec
+	}
+	h.mu.Unlock()
+
+	if histogram, err := vec.GetMetricWith(labels); err == nil {
+		histogram.Observe(value)
+	}
+}
+
+func (h *Handler) Gauge(name string, labels map[string]string, value float64) {
+	h.mu.Lock()
+	vec, ok := h.gauges[name]
+	if !ok {
+		vec = register(h.registerer, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: name, Help: helpOf(name)},
+			labelKeys(labels),
+		))
+		h.gauges[name] = vec
+	}
+	h.mu.Unlock()
+
+	if gauge, err := vec.GetMetricWith(labels); err == nil {
+		gauge.Set(value)
+	}
+}
+
+// DeleteGauge removes the series of a gauge with the given labels.
+func (h *Handler) DeleteGauge(name string, labels map[string]string) {
+	h.mu.Lock()
+	vec, ok := h.gauges[name]
+	h.mu.Unlock()
+
+	if ok {
+		vec.Delete(labels)
+	}
+}
+
+// register registers collector, returning the collector registered before it under the same
+// name, e.g. by another handler on the same registerer. When registering fails otherwise, the
+// unregistered collector is returned so that observations are dropped rather than failing.
+func register[C prometheus.Collector](registerer prometheus.Registerer, collector C) C {
+	err := registerer.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+	return collector
+}
+
+func helpOf(name string) string {
+	if text, ok := help[name]; ok {
+		return text
+	}
+	return name
+}
+
+func labelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
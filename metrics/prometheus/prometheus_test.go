@@ -0,0 +1,59 @@
+package prometheus_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/metrics/prometheus"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	registry := prom.NewRegistry()
+	handler := prometheus.NewHandler(registry)
+
+	started := map[string]string{
+		pitlane.MetricLabelWorkflowName: "orders.Process",
+		pitlane.MetricLabelTaskQueue:    "orders",
+	}
+	handler.Counter(pitlane.MetricWorkflowRunsStarted, started, 1)
+	handler.Counter(pitlane.MetricWorkflowRunsStarted, started, 2)
+	// Observations with other label keys are dropped.
+	handler.Counter(pitlane.MetricWorkflowRunsStarted, map[string]string{pitlane.MetricLabelStatus: "failed"}, 1)
+	handler.Histogram(pitlane.MetricClaimDuration, map[string]string{pitlane.MetricLabelKind: "workflow"}, 0.02)
+	queue := map[string]string{pitlane.MetricLabelTaskQueue: "orders"}
+	handler.Gauge(pitlane.MetricTaskQueueOldestPendingAge, queue, 3)
+	handler.Gauge(pitlane.MetricTaskQueueOldestPendingAge, queue, 5)
+
+	// Deleted gauges are no longer exported.
+	gone := map[string]string{pitlane.MetricLabelTaskQueue: "gone"}
+	handler.Gauge(pitlane.MetricTaskQueueOldestPendingAge, gone, 1)
+	handler.DeleteGauge(pitlane.MetricTaskQueueOldestPendingAge, gone)
+	handler.DeleteGauge("unknown", gone)
+
+	expected := `
+# HELP pitlane_task_queue_oldest_pending_age_seconds Time the oldest pending run of a task queue has been due.
+# TYPE pitlane_task_queue_oldest_pending_age_seconds gauge
+pitlane_task_queue_oldest_pending_age_seconds{task_queue="orders"} 5
+# HELP pitlane_workflow_runs_started_total Workflow runs started.
+# TYPE pitlane_workflow_runs_started_total counter
+pitlane_workflow_runs_started_total{task_queue="orders",workflow_name="orders.Process"} 3
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		pitlane.MetricWorkflowRunsStarted, pitlane.MetricTaskQueueOldestPendingAge)
+	require.NoError(t, err)
+
+	count, err := testutil.GatherAndCount(registry, pitlane.MetricClaimDuration)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// A second handler on the same registry shares the collectors.
+	prometheus.NewHandler(registry).Counter(pitlane.MetricWorkflowRunsStarted, started, 1)
+	count, err = testutil.GatherAndCount(registry, pitlane.MetricWorkflowRunsStarted)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,175 @@
+package pitlane_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type metric struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+type recordingMetricsHandler struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func (h *recordingMetricsHandler) record(name string, labels map[string]string, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.metrics = append(h.metrics, metric{name: name, labels: labels, value: value})
+}
+
+func (h *recordingMetricsHandler) Counter(name string, labels map[string]string, delta float64) {
+	h.record(name, labels, delta)
+}
+
+func (h *recordingMetricsHandler) Histogram(name string, labels map[string]string, value float64) {
+	h.record(name, labels, value)
+}
+
+func (h *recordingMetricsHandler) Gauge(name string, labels map[string]string, value float64) {
+	h.record(name, labels, value)
+}
+
+// find returns the metrics recorded under name.
+func (h *recordingMetricsHandler) find(name string) []metric {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var found []metric
+	for _, m := range h.metrics {
+		if m.name == name {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	handler := &recordingMetricsHandler{}
+	we, b := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.Metrics.Handler = handler
+	})
+
+	options := pitlane.StartWorkflowOptions{TaskQueue: "measured"}
+	_, err := we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order-1")
+	require.NoError(t, err)
+
+	started := handler.find(pitlane.MetricWorkflowRunsStarted)
+	require.Len(t, started, 1)
+	workflowName := started[0].labels[pitlane.MetricLabelWorkflowName]
+	require.Equal(t, "measured", started[0].labels[pitlane.MetricLabelTaskQueue])
+
+	finished := make(chan struct{})
+	worker := we.NewWorker(pitlane.WorkerOptions{
+		TaskQueues:   []string{"measured"},
+		PollInterval: 10 * time.Millisecond,
+		WorkflowTaskHandler: func(ctx context.Context, run *backend.WorkflowRun) error {
+			defer close(finished)
+			return b.RunInTx(ctx, func(tx backend.Tx) error {
+				return tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, run.ID, backend.WorkflowStatusFinished)
+			})
+		},
+	})
+	require.NoError(t, worker.Start(ctx))
+	<-finished
+	require.NoError(t, worker.Stop(ctx))
+
+	require.NotEmpty(t, handler.find(pitlane.MetricClaimDuration))
+	scheduleToStart := handler.find(pitlane.MetricScheduleToStartLatency)
+	require.Len(t, scheduleToStart, 1)
+	require.Equal(t, map[string]string{
+		pitlane.MetricLabelKind:      pitlane.MetricKindWorkflow,
+		pitlane.MetricLabelTaskQueue: "measured",
+	}, scheduleToStart[0].labels)
+	require.Len(t, handler.find(pitlane.MetricWorkflowTaskLatency), 1)
+	closed := handler.find(pitlane.MetricWorkflowRunsClosed)
+	require.Len(t, closed, 1)
+	require.Equal(t, map[string]string{
+		pitlane.MetricLabelWorkflowName: workflowName,
+		pitlane.MetricLabelStatus:       string(backend.WorkflowStatusFinished),
+	}, closed[0].labels)
+
+	_, err = we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order-2")
+	require.NoError(t, err)
+	require.NoError(t, we.ReportTaskQueueMetrics(ctx))
+	backlog := handler.find(pitlane.MetricTaskQueueBacklog)
+	require.Len(t, backlog, 2)
+	require.Equal(t, pitlane.MetricKindWorkflow, backlog[0].labels[pitlane.MetricLabelKind])
+	require.Equal(t, float64(1), backlog[0].value)
+	require.Equal(t, float64(0), backlog[1].value)
+	require.Len(t, handler.find(pitlane.MetricTaskQueueOldestPendingAge), 1)
+}
+
+// deletingMetricsHandler is a recordingMetricsHandler that can delete gauges.
+type deletingMetricsHandler struct {
+	recordingMetricsHandler
+	deleted []metric
+}
+
+func (h *deletingMetricsHandler) DeleteGauge(name string, labels map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deleted = append(h.deleted, metric{name: name, labels: labels})
+}
+
+func TestReportTaskQueueMetrics_RemovedQueues(t *testing.T) {
+	ctx := context.Background()
+
+	// report records the gauges of a queue whose only run then closes, and reports again.
+	report := func(t *testing.T, handler pitlane.MetricsHandler) {
+		we, _ := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+			config.Metrics.Handler = handler
+		})
+
+		options := pitlane.StartWorkflowOptions{TaskQueue: "vanishing"}
+		_, err := we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order-1")
+		require.NoError(t, err)
+		require.NoError(t, we.ReportTaskQueueMetrics(ctx))
+
+		run, err := we.ClaimWorkflowRun(ctx, "worker-1", "vanishing")
+		require.NoError(t, err)
+		require.NotNil(t, run)
+		require.NoError(t, we.CompleteWorkflowRun(ctx, run, "done"))
+		require.NoError(t, we.ReportTaskQueueMetrics(ctx))
+	}
+
+	t.Run("deletes the gauges of removed queues", func(t *testing.T) {
+		handler := &deletingMetricsHandler{}
+		report(t, handler)
+		require.Len(t, handler.find(pitlane.MetricTaskQueueBacklog), 2)
+		require.ElementsMatch(t, []metric{
+			{name: pitlane.MetricTaskQueueBacklog, labels: map[string]string{
+				pitlane.MetricLabelKind: pitlane.MetricKindWorkflow, pitlane.MetricLabelTaskQueue: "vanishing",
+			}},
+			{name: pitlane.MetricTaskQueueBacklog, labels: map[string]string{
+				pitlane.MetricLabelKind: pitlane.MetricKindActivity, pitlane.MetricLabelTaskQueue: "vanishing",
+			}},
+			{name: pitlane.MetricTaskQueueOldestPendingAge, labels: map[string]string{
+				pitlane.MetricLabelTaskQueue: "vanishing",
+			}},
+		}, handler.deleted)
+	})
+
+	t.Run("resets the gauges of removed queues", func(t *testing.T) {
+		handler := &recordingMetricsHandler{}
+		report(t, handler)
+		backlog := handler.find(pitlane.MetricTaskQueueBacklog)
+		require.Len(t, backlog, 4)
+		require.Equal(t, float64(1), backlog[0].value)
+		require.Zero(t, backlog[2].value)
+		require.Zero(t, backlog[3].value)
+		age := handler.find(pitlane.MetricTaskQueueOldestPendingAge)
+		require.Len(t, age, 2)
+		require.Zero(t, age[1].value)
+	})
+}
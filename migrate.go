@@ -0,0 +1,24 @@
+package pitlane
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nurburg-dev/pitlane/backend/postgres"
+	"github.com/nurburg-dev/pitlane/internal/db"
+)
+
+// ErrSchemaVersionMismatch is returned by NewWorkflowEngine when the database schema is older or
+// newer than this version of pitlane expects.
+var ErrSchemaVersionMismatch = db.ErrSchemaVersionMismatch
+
+// Migrate brings the pitlane schema up to date. It is safe to run concurrently from several
+// processes, e.g. from a CI job while engines start with EngineConfig.InitDB unset.
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	return MigrateSchema(ctx, pool, SchemaConfig{})
+}
+
+// MigrateSchema is Migrate for engines configured with a non-default EngineConfig.SchemaConfig.
+func MigrateSchema(ctx context.Context, pool *pgxpool.Pool, schemaConfig SchemaConfig) error {
+	return postgres.New(pool, schemaConfig.Schema, schemaConfig.TablePrefix).Init(ctx)
+}
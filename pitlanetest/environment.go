@@ -0,0 +1,481 @@
+// Package pitlanetest runs workflows in process for unit tests.
+package pitlanetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/activity"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/nurburg-dev/pitlane/internal/utils"
+	"github.com/nurburg-dev/pitlane/workflow"
+)
+
+// ErrDeadlock is returned by GetWorkflowError when every coroutine of the workflow is blocked
+// and no timer or signal is left to wake any of them.
+var ErrDeadlock = errors.New("workflow is blocked with no pending timers or signals")
+
+type EventType string
+
+const (
+	EventWorkflowStarted   EventType = "workflow_started"
+	EventWorkflowCompleted EventType = "workflow_completed"
+	EventWorkflowFailed    EventType = "workflow_failed"
+	EventActivityCompleted EventType = "activity_completed"
+	EventActivityFailed    EventType = "activity_failed"
+	EventTimerStarted      EventType = "timer_started"
+	EventTimerFired        EventType = "timer_fired"
+	EventSignalReceived    EventType = "signal_received"
+)
+
+// HistoryEvent records a step of a workflow execution at the virtual time it happened.
+type HistoryEvent struct {
+	Type EventType
+	Time time.Time
+	// Name is the workflow, activity or signal name.
+	Name string
+	// Duration is set for timer events.
+	Duration time.Duration
+	// Error is set for failure events.
+	Error string
+}
+
+type timer struct {
+	at  time.Time
+	seq int
+	// c is nil for delayed signals.
+	c          *coroutine
+	duration   time.Duration
+	signalName string
+	signal     any
+}
+
+type coroutine struct {
+	resume chan struct{}
+	signal any
+}
+
+type coroutineKey struct{}
+
+// TestWorkflowEnvironment executes a workflow in process with a virtual clock. Only one
+// coroutine of the workflow runs at a time; when all of them are blocked, the clock jumps to
+// the next timer or delayed signal, so workflows sleeping for days complete instantly.
+//
+// An environment executes a single workflow and is not safe for concurrent use.
+type TestWorkflowEnvironment struct {
+	now           time.Time
+	dataConverter converter.DataConverter
+	activityMocks map[string]any
+	logger        *slog.Logger
+	info          workflow.Info
+
+	seq            int
+	timers         []*timer
+	pendingSignals map[string][]any
+	signalWaiters  map[string][]*coroutine
+	runnable       []*coroutine
+	yielded        chan struct{}
+	closed         chan struct{}
+	// replay serves activities from a recorded history when the environment is driven by a Replayer.
+	replay *replayState
+
+	searchAttributes map[string]any
+	memo             map[string]any
+
+	executed  bool
+	completed bool
+	panicked  bool
+	result    any
+	err       error
+	history   []HistoryEvent
+}
+
+var _ workflow.Environment = (*TestWorkflowEnvironment)(nil)
+
+func NewTestWorkflowEnvironment() *TestWorkflowEnvironment {
+	return &TestWorkflowEnvironment{
+		now:            time.Now(),
+		dataConverter:  converter.GetDefaultDataConverter(),
+		activityMocks:  map[string]any{},
+		logger:         slog.Default(),
+		pendingSignals: map[string][]any{},
+		signalWaiters:  map[string][]*coroutine{},
+		memo:           map[string]any{},
+		yielded:        make(chan struct{}),
+		closed:         make(chan struct{}),
+	}
+}
+
+// SetStartTime sets the virtual time the workflow starts at.
+func (env *TestWorkflowEnvironment) SetStartTime(t time.Time) {
+	env.now = t
+}
+
+// SetDataConverter sets the converter results and signals pass through, which defaults to
+// converter.GetDefaultDataConverter.
+func (env *TestWorkflowEnvironment) SetDataConverter(dc converter.DataConverter) {
+	env.dataConverter = dc
+}
+
+// SetLogger sets the logger of workflow.GetLogger and activity.GetLogger, which defaults to
+// slog.Default.
+func (env *TestWorkflowEnvironment) SetLogger(logger *slog.Logger) {
+	env.logger = logger
+}
+
+// OnActivity replaces activityFunction with mock, which must have the same signature.
+func (env *TestWorkflowEnvironment) OnActivity(activityFunction, mock any) error {
+	name, err := utils.GetFunctionName(activityFunction)
+	if err != nil {
+		return fmt.Errorf("failed to get activity function name: %w", err)
+	}
+	if reflect.TypeOf(activityFunction) != reflect.TypeOf(mock) {
+		return fmt.Errorf("mock for %s must be a %s, got %T", name, reflect.TypeOf(activityFunction), mock)
+	}
+	env.activityMocks[name] = mock
+	return nil
+}
+
+// SignalWorkflowAfter delivers a signal once the virtual clock has advanced by delay from the start time.
+func (env *TestWorkflowEnvironment) SignalWorkflowAfter(delay time.Duration, name string, value any) {
+	env.addTimer(&timer{at: env.now.Add(delay), signalName: name, signal: value})
+}
+
+// ExecuteWorkflow runs workflowFunction to completion. The returned error only reports
+// invalid arguments; the workflow's own outcome is available from GetWorkflowResult and
+// GetWorkflowError.
+func (env *TestWorkflowEnvironment) ExecuteWorkflow(workflowFunction any, args ...any) error {
+	if env.executed {
+		return errors.New("an environment can only execute a single workflow")
+	}
+	name, err := utils.GetFunctionName(workflowFunction)
+	if err != nil {
+		return fmt.Errorf("failed to get workflow function name: %w", err)
+	}
+	if err := utils.ValidateArgs(workflowFunction, args...); err != nil {
+		return err
+	}
+	env.executed = true
+	env.info.WorkflowName = name
+	if env.info.WorkflowRunID == "" {
+		env.info.WorkflowRunID = db.GenerateReadableID()
+	}
+	if env.info.Attempt == 0 {
+		env.info.Attempt = 1
+	}
+	env.record(HistoryEvent{Type: EventWorkflowStarted, Name: name})
+
+	env.spawn(context.Background(), func(ctx context.Context) {
+		result, err := call(ctx, workflowFunction, args)
+		env.completed = true
+		env.result, env.err = result, err
+	})
+	env.run()
+
+	switch {
+	case env.completed && env.err == nil:
+		env.record(HistoryEvent{Type: EventWorkflowCompleted, Name: name})
+	case env.err != nil:
+		env.record(HistoryEvent{Type: EventWorkflowFailed, Name: name, Error: env.err.Error()})
+	}
+	return nil
+}
+
+// run is the scheduler loop, handing control to one coroutine at a time.
+func (env *TestWorkflowEnvironment) run() {
+	defer close(env.closed)
+	for {
+		for len(env.runnable) > 0 {
+			c := env.runnable[0]
+			env.runnable = env.runnable[1:]
+			c.resume <- struct{}{}
+			<-env.yielded
+			if env.completed || env.replay.failed() {
+				return
+			}
+		}
+		if !env.fireNextTimer() {
+			env.err = fmt.Errorf("%w at %s", ErrDeadlock, env.now)
+			return
+		}
+	}
+}
+
+func (env *TestWorkflowEnvironment) fireNextTimer() bool {
+	if len(env.timers) == 0 {
+		return false
+	}
+	t := env.timers[0]
+	env.timers = env.timers[1:]
+	env.now = t.at
+
+	if t.c != nil {
+		env.record(HistoryEvent{Type: EventTimerFired, Duration: t.duration})
+		env.runnable = append(env.runnable, t.c)
+		return true
+	}
+
+	env.record(HistoryEvent{Type: EventSignalReceived, Name: t.signalName})
+	if waiters := env.signalWaiters[t.signalName]; len(waiters) > 0 {
+		c := waiters[0]
+		env.signalWaiters[t.signalName] = waiters[1:]
+		c.signal = t.signal
+		env.runnable = append(env.runnable, c)
+	} else {
+		env.pendingSignals[t.signalName] = append(env.pendingSignals[t.signalName], t.signal)
+	}
+	return true
+}
+
+func (env *TestWorkflowEnvironment) addTimer(t *timer) {
+	env.seq++
+	t.seq = env.seq
+	env.timers = append(env.timers, t)
+	sort.Slice(env.timers, func(i, j int) bool {
+		if env.timers[i].at.Equal(env.timers[j].at) {
+			return env.timers[i].seq < env.timers[j].seq
+		}
+		return env.timers[i].at.Before(env.timers[j].at)
+	})
+}
+
+// spawn starts fn as a coroutine that runs once the scheduler resumes it.
+func (env *TestWorkflowEnvironment) spawn(parent context.Context, fn func(ctx context.Context)) {
+	c := &coroutine{resume: make(chan struct{})}
+	ctx := workflow.WithEnvironment(context.WithValue(parent, coroutineKey{}, c), env)
+	env.runnable = append(env.runnable, c)
+
+	go func() {
+		select {
+		case <-c.resume:
+		case <-env.closed:
+			return
+		}
+		finished := false
+		defer func() {
+			if r := recover(); r != nil {
+				env.completed = true
+				env.panicked = true
+				env.err = fmt.Errorf("workflow panicked: %v", r)
+				finished = true
+			}
+			// A coroutine released by close(env.closed) exits without handing control back.
+			if finished {
+				env.yielded <- struct{}{}
+			}
+		}()
+		fn(ctx)
+		finished = true
+	}()
+}
+
+// block hands control back to the scheduler until the coroutine is resumed.
+func (env *TestWorkflowEnvironment) block(c *coroutine) {
+	env.yielded <- struct{}{}
+	select {
+	case <-c.resume:
+	case <-env.closed:
+		runtime.Goexit()
+	}
+}
+
+func (env *TestWorkflowEnvironment) record(event HistoryEvent) {
+	event.Time = env.now
+	env.history = append(env.history, event)
+}
+
+func coroutineFrom(ctx context.Context) (*coroutine, error) {
+	c, ok := ctx.Value(coroutineKey{}).(*coroutine)
+	if !ok {
+		return nil, workflow.ErrNotInWorkflow
+	}
+	return c, nil
+}
+
+// ExecuteActivity runs the activity, or its mock, synchronously without advancing the clock.
+func (env *TestWorkflowEnvironment) ExecuteActivity(
+	ctx context.Context,
+	activityFunction any,
+	args []any,
+	resultPtr any,
+) error {
+	name, err := utils.GetFunctionName(activityFunction)
+	if err != nil {
+		return fmt.Errorf("failed to get activity function name: %w", err)
+	}
+	if env.replay != nil {
+		c, err := coroutineFrom(ctx)
+		if err != nil {
+			return err
+		}
+		return env.replay.executeActivity(env, c, name, args, resultPtr)
+	}
+	impl := activityFunction
+	if mock, ok := env.activityMocks[name]; ok {
+		impl = mock
+	}
+
+	activityCtx := activity.WithInfo(context.Background(), activity.Info{
+		ActivityRunID: db.GenerateReadableID(),
+		ActivityName:  name,
+		WorkflowRunID: env.info.WorkflowRunID,
+		Attempt:       1,
+	}, env.logger)
+	result, err := call(activityCtx, impl, args)
+	if err != nil {
+		env.record(HistoryEvent{Type: EventActivityFailed, Name: name, Error: err.Error()})
+		return err
+	}
+	env.record(HistoryEvent{Type: EventActivityCompleted, Name: name})
+	return env.assign(result, resultPtr)
+}
+
+func (env *TestWorkflowEnvironment) Now(_ context.Context) time.Time {
+	return env.now
+}
+
+func (env *TestWorkflowEnvironment) Sleep(ctx context.Context, d time.Duration) error {
+	c, err := coroutineFrom(ctx)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return nil
+	}
+	env.record(HistoryEvent{Type: EventTimerStarted, Duration: d})
+	env.addTimer(&timer{at: env.now.Add(d), c: c, duration: d})
+	env.block(c)
+	return nil
+}
+
+func (env *TestWorkflowEnvironment) ReceiveSignal(ctx context.Context, name string, valuePtr any) error {
+	c, err := coroutineFrom(ctx)
+	if err != nil {
+		return err
+	}
+	if pending := env.pendingSignals[name]; len(pending) > 0 {
+		env.pendingSignals[name] = pending[1:]
+		return env.assign(pending[0], valuePtr)
+	}
+
+	env.signalWaiters[name] = append(env.signalWaiters[name], c)
+	env.block(c)
+	value := c.signal
+	c.signal = nil
+	return env.assign(value, valuePtr)
+}
+
+func (env *TestWorkflowEnvironment) Go(ctx context.Context, fn func(ctx context.Context)) {
+	env.spawn(ctx, fn)
+}
+
+// UpsertSearchAttributes validates attributes like the engine does and merges them into the
+// attributes returned by SearchAttributes.
+func (env *TestWorkflowEnvironment) UpsertSearchAttributes(_ context.Context, attributes map[string]any) error {
+	normalized, err := backend.NormalizeSearchAttributes(attributes)
+	if err != nil {
+		return err
+	}
+	if env.searchAttributes == nil {
+		env.searchAttributes = map[string]any{}
+	}
+	maps.Copy(env.searchAttributes, normalized)
+	return nil
+}
+
+func (env *TestWorkflowEnvironment) UpsertMemo(_ context.Context, memo map[string]any) error {
+	maps.Copy(env.memo, memo)
+	return nil
+}
+
+func (env *TestWorkflowEnvironment) GetInfo(_ context.Context) workflow.Info {
+	return env.info
+}
+
+// IsReplaying reports whether the environment is driven by a Replayer.
+func (env *TestWorkflowEnvironment) IsReplaying(_ context.Context) bool {
+	return env.replay != nil
+}
+
+func (env *TestWorkflowEnvironment) Logger(_ context.Context) *slog.Logger {
+	return env.logger
+}
+
+// SearchAttributes returns the normalized search attributes the workflow upserted.
+func (env *TestWorkflowEnvironment) SearchAttributes() map[string]any {
+	return maps.Clone(env.searchAttributes)
+}
+
+// GetMemoValue decodes the memo value the workflow upserted under key into valuePtr. It reports
+// false if the workflow did not set key.
+func (env *TestWorkflowEnvironment) GetMemoValue(key string, valuePtr any) (bool, error) {
+	value, ok := env.memo[key]
+	if !ok {
+		return false, nil
+	}
+	return true, env.assign(value, valuePtr)
+}
+
+// IsWorkflowCompleted reports whether the workflow function returned.
+func (env *TestWorkflowEnvironment) IsWorkflowCompleted() bool {
+	return env.completed
+}
+
+// GetWorkflowError returns the error the workflow returned, or ErrDeadlock if it never completed.
+func (env *TestWorkflowEnvironment) GetWorkflowError() error {
+	return env.err
+}
+
+// GetWorkflowResult decodes the workflow's result into valuePtr, or returns its error.
+func (env *TestWorkflowEnvironment) GetWorkflowResult(valuePtr any) error {
+	if env.err != nil {
+		return env.err
+	}
+	if !env.completed {
+		return errors.New("workflow has not completed")
+	}
+	return env.assign(env.result, valuePtr)
+}
+
+// History returns the events recorded so far.
+func (env *TestWorkflowEnvironment) History() []HistoryEvent {
+	return env.history
+}
+
+// assign passes value through the data converter, as it would be when stored by the engine.
+func (env *TestWorkflowEnvironment) assign(value, valuePtr any) error {
+	payload, err := env.dataConverter.ToPayload(value)
+	if err != nil {
+		return err
+	}
+	return env.dataConverter.FromPayload(payload, valuePtr)
+}
+
+// call invokes fn with ctx and args and returns its result and error.
+func call(ctx context.Context, fn any, args []any) (any, error) {
+	fnValue := reflect.ValueOf(fn)
+	in := make([]reflect.Value, 0, len(args)+1)
+	in = append(in, reflect.ValueOf(ctx))
+	for i, arg := range args {
+		if arg == nil {
+			in = append(in, reflect.Zero(fnValue.Type().In(i+1)))
+			continue
+		}
+		in = append(in, reflect.ValueOf(arg))
+	}
+
+	out := fnValue.Call(in)
+	if errValue := out[1].Interface(); errValue != nil {
+		return nil, errValue.(error)
+	}
+	return out[0].Interface(), nil
+}
@@ -0,0 +1,214 @@
+package pitlanetest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/activity"
+	"github.com/nurburg-dev/pitlane/pitlanetest"
+	"github.com/nurburg-dev/pitlane/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Order struct {
+	ID     string `json:"id"`
+	Amount int    `json:"amount"`
+}
+
+func ChargeCard(_ context.Context, _ Order) (string, error) {
+	return "", errors.New("must be mocked in tests")
+}
+
+func OrderWorkflow(ctx context.Context, order Order) (string, error) {
+	chargeID, err := workflow.ExecuteActivity1(ctx, ChargeCard, order)
+	if err != nil {
+		return "", err
+	}
+
+	if err := workflow.Sleep(ctx, 7*24*time.Hour); err != nil {
+		return "", err
+	}
+
+	var approved bool
+	if err := workflow.ReceiveSignal(ctx, "approved", &approved); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s:%t", order.ID, chargeID, approved), nil
+}
+
+func TestTestWorkflowEnvironment(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	env := pitlanetest.NewTestWorkflowEnvironment()
+	env.SetStartTime(start)
+	require.NoError(t, env.OnActivity(ChargeCard, func(_ context.Context, order Order) (string, error) {
+		return fmt.Sprintf("charge-%d", order.Amount), nil
+	}))
+	env.SignalWorkflowAfter(3*24*time.Hour, "approved", true)
+
+	require.NoError(t, env.ExecuteWorkflow(OrderWorkflow, Order{ID: "order-1", Amount: 42}))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	var result string
+	require.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, "order-1:charge-42:true", result)
+
+	history := env.History()
+	types := make([]pitlanetest.EventType, 0, len(history))
+	for _, event := range history {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []pitlanetest.EventType{
+		pitlanetest.EventWorkflowStarted,
+		pitlanetest.EventActivityCompleted,
+		pitlanetest.EventTimerStarted,
+		pitlanetest.EventSignalReceived,
+		pitlanetest.EventTimerFired,
+		pitlanetest.EventWorkflowCompleted,
+	}, types)
+	assert.Equal(t, start.Add(3*24*time.Hour), history[3].Time)
+	assert.Equal(t, start.Add(7*24*time.Hour), history[5].Time)
+}
+
+func TestTestWorkflowEnvironment_ActivityFailure(t *testing.T) {
+	env := pitlanetest.NewTestWorkflowEnvironment()
+	require.NoError(t, env.ExecuteWorkflow(OrderWorkflow, Order{ID: "order-1"}))
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.EqualError(t, env.GetWorkflowError(), "must be mocked in tests")
+	history := env.History()
+	assert.Equal(t, pitlanetest.EventActivityFailed, history[1].Type)
+	assert.Equal(t, pitlanetest.EventWorkflowFailed, history[len(history)-1].Type)
+}
+
+func TestTestWorkflowEnvironment_Deadlock(t *testing.T) {
+	env := pitlanetest.NewTestWorkflowEnvironment()
+	require.NoError(t, env.OnActivity(ChargeCard, func(_ context.Context, _ Order) (string, error) {
+		return "charge", nil
+	}))
+
+	require.NoError(t, env.ExecuteWorkflow(OrderWorkflow, Order{ID: "order-1"}))
+	require.False(t, env.IsWorkflowCompleted())
+	require.ErrorIs(t, env.GetWorkflowError(), pitlanetest.ErrDeadlock)
+}
+
+func FanOutWorkflow(ctx context.Context, delays []int) ([]int, error) {
+	var finished []int
+	for _, delay := range delays {
+		err := workflow.Go(ctx, func(ctx context.Context) {
+			_ = workflow.Sleep(ctx, time.Duration(delay)*time.Hour)
+			finished = append(finished, delay)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for len(finished) < len(delays) {
+		if err := workflow.Sleep(ctx, time.Hour); err != nil {
+			return nil, err
+		}
+	}
+	return finished, nil
+}
+
+func TestTestWorkflowEnvironment_Coroutines(t *testing.T) {
+	env := pitlanetest.NewTestWorkflowEnvironment()
+	start := env.Now(context.Background())
+	require.NoError(t, env.ExecuteWorkflow(FanOutWorkflow, []int{5, 1, 3}))
+
+	var finished []int
+	require.NoError(t, env.GetWorkflowResult(&finished))
+	assert.Equal(t, []int{1, 3, 5}, finished)
+	assert.Equal(t, start.Add(5*time.Hour), env.Now(context.Background()))
+}
+
+func TaggedWorkflow(ctx context.Context, order Order) (string, error) {
+	err := workflow.UpsertSearchAttributes(ctx, map[string]any{"CustomerID": order.ID, "OrderTotal": order.Amount})
+	if err != nil {
+		return "", err
+	}
+	return order.ID, workflow.UpsertMemo(ctx, map[string]any{"order": order})
+}
+
+func TestTestWorkflowEnvironment_SearchAttributes(t *testing.T) {
+	env := pitlanetest.NewTestWorkflowEnvironment()
+	require.NoError(t, env.ExecuteWorkflow(TaggedWorkflow, Order{ID: "order-1", Amount: 42}))
+	require.NoError(t, env.GetWorkflowError())
+
+	assert.Equal(t, map[string]any{"CustomerID": "order-1", "OrderTotal": 42.0}, env.SearchAttributes())
+	var order Order
+	ok, err := env.GetMemoValue("order", &order)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, Order{ID: "order-1", Amount: 42}, order)
+	ok, err = env.GetMemoValue("missing", &order)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestWorkflowAPI_OutsideWorkflow(t *testing.T) {
+	ctx := context.Background()
+	require.ErrorIs(t, workflow.Sleep(ctx, time.Second), workflow.ErrNotInWorkflow)
+	_, err := workflow.ExecuteActivity1(ctx, ChargeCard, Order{})
+	require.ErrorIs(t, err, workflow.ErrNotInWorkflow)
+	_, err = workflow.GetInfo(ctx)
+	require.ErrorIs(t, err, workflow.ErrNotInWorkflow)
+	assert.False(t, workflow.IsReplaying(ctx))
+	assert.Equal(t, slog.Default(), workflow.GetLogger(ctx))
+	assert.Equal(t, slog.Default(), activity.GetLogger(ctx))
+}
+
+func LoggedCharge(ctx context.Context, order Order) (string, error) {
+	activity.GetLogger(ctx).Info("charging card", "amount", order.Amount)
+	return "charge-1", nil
+}
+
+func LoggingWorkflow(ctx context.Context, order Order) (string, error) {
+	workflow.GetLogger(ctx).Info("order received", "order_id", order.ID)
+	return workflow.ExecuteActivity1(ctx, LoggedCharge, order)
+}
+
+// logRecords decodes the records of a slog.JSONHandler.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestTestWorkflowEnvironment_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	env := pitlanetest.NewTestWorkflowEnvironment()
+	env.SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	require.NoError(t, env.ExecuteWorkflow(LoggingWorkflow, Order{ID: "order-1", Amount: 42}))
+	require.NoError(t, env.GetWorkflowError())
+
+	records := logRecords(t, &buf)
+	require.Len(t, records, 2)
+	assert.Equal(t, "order received", records[0]["msg"])
+	assert.Equal(t, "order-1", records[0]["order_id"])
+	assert.Equal(t, "github.com/nurburg-dev/pitlane/pitlanetest_test.LoggingWorkflow", records[0]["workflow_name"])
+	assert.NotEmpty(t, records[0]["workflow_run_id"])
+	assert.Equal(t, float64(1), records[0]["attempt"])
+
+	assert.Equal(t, "charging card", records[1]["msg"])
+	assert.Equal(t, "github.com/nurburg-dev/pitlane/pitlanetest_test.LoggedCharge", records[1]["activity_name"])
+	assert.NotEmpty(t, records[1]["activity_run_id"])
+	assert.Equal(t, records[0]["workflow_run_id"], records[1]["workflow_run_id"])
+	assert.Equal(t, float64(1), records[1]["attempt"])
+}
@@ -0,0 +1,303 @@
+package pitlanetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/nurburg-dev/pitlane/history"
+	"github.com/nurburg-dev/pitlane/internal/utils"
+	"github.com/nurburg-dev/pitlane/workflow"
+)
+
+// ErrNonDeterministic is wrapped by every NonDeterminismError.
+var ErrNonDeterministic = errors.New("workflow is not deterministic")
+
+// History is a recorded workflow run, as exported by the history package.
+type History = history.Document
+
+// Command is a step a workflow asks the engine to perform.
+type Command struct {
+	ActivityName string
+	Input        json.RawMessage
+}
+
+func (c *Command) String() string {
+	if c == nil {
+		return "<none>"
+	}
+	return fmt.Sprintf("ExecuteActivity(%s, %s)", c.ActivityName, c.Input)
+}
+
+// NonDeterminismError describes the first point where a replay diverged from the recorded history.
+type NonDeterminismError struct {
+	WorkflowRunID string
+	// Index is the position of the diverging command in the history.
+	Index int
+	// Recorded is the command found in the history, nil if the history has no more commands.
+	Recorded *Command
+	// Replayed is the command the workflow code issued, nil if it issued no more commands.
+	Replayed *Command
+	// Reason explains divergences that are not about a single command, such as a different outcome.
+	Reason string
+}
+
+func (e *NonDeterminismError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: workflow run %s", ErrNonDeterministic, e.WorkflowRunID)
+	if e.Reason != "" {
+		fmt.Fprintf(&b, ": %s", e.Reason)
+	}
+	if e.Recorded != nil || e.Replayed != nil {
+		fmt.Fprintf(&b, "\ncommand %d differs from history:\n- recorded: %s\n+ replayed: %s",
+			e.Index, e.Recorded, e.Replayed)
+	}
+	return b.String()
+}
+
+func (e *NonDeterminismError) Unwrap() error {
+	return ErrNonDeterministic
+}
+
+// Replayer runs workflow code against recorded histories to detect changes that would break
+// runs already in flight. Activities are not executed: their recorded results are returned to
+// the workflow, and every activity the workflow schedules must match the recorded one.
+//
+// Timers fire immediately in virtual time. Signals are not recorded by the engine, so a
+// workflow blocked on ReceiveSignal is treated like a run still waiting for one.
+type Replayer struct {
+	dataConverter converter.DataConverter
+	compareInputs bool
+}
+
+func NewReplayer() *Replayer {
+	return &Replayer{
+		dataConverter: converter.GetDefaultDataConverter(),
+		compareInputs: true,
+	}
+}
+
+// SetDataConverter sets the converter the recorded payloads were written with, which defaults
+// to converter.GetDefaultDataConverter.
+func (r *Replayer) SetDataConverter(dc converter.DataConverter) {
+	r.dataConverter = dc
+}
+
+// SetCompareActivityInputs controls whether activity inputs must match the recorded ones, which
+// they do by default. Disable it when a codec does not encode deterministically, such as encryption.
+func (r *Replayer) SetCompareActivityInputs(compare bool) {
+	r.compareInputs = compare
+}
+
+// ReplayWorkflowFromBackend replays the workflow run stored in b.
+func (r *Replayer) ReplayWorkflowFromBackend(
+	ctx context.Context,
+	b backend.Backend,
+	workflowFunction any,
+	workflowRunID string,
+) error {
+	doc, err := history.Export(ctx, b, workflowRunID)
+	if err != nil {
+		return err
+	}
+	return r.ReplayWorkflow(workflowFunction, doc)
+}
+
+// ReplayWorkflowFromFile replays a history document stored at path.
+func (r *Replayer) ReplayWorkflowFromFile(workflowFunction any, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	doc, err := history.Read(f)
+	if err != nil {
+		return err
+	}
+	return r.ReplayWorkflow(workflowFunction, doc)
+}
+
+// ReplayWorkflow replays doc with workflowFunction. Activity results come from the events of
+// doc, or from its activities for documents recorded without events. It returns a
+// *NonDeterminismError if the workflow code diverges from the history.
+func (r *Replayer) ReplayWorkflow(workflowFunction any, doc *History) error {
+	name, err := utils.GetFunctionName(workflowFunction)
+	if err != nil {
+		return fmt.Errorf("failed to get workflow function name: %w", err)
+	}
+	if name != doc.Run.WorkflowName {
+		return fmt.Errorf("history is for workflow %s, not %s", doc.Run.WorkflowName, name)
+	}
+	args, err := r.decodeInput(workflowFunction, doc.Run.Input)
+	if err != nil {
+		return err
+	}
+	activities := doc.Activities
+	if len(doc.Events) > 0 {
+		if activities, err = history.ActivitiesFromEvents(doc.Events); err != nil {
+			return fmt.Errorf("failed to read activities from history events: %w", err)
+		}
+	}
+
+	state := &replayState{
+		runID:         doc.Run.ID,
+		activities:    activities,
+		closed:        isClosed(doc.Run.Status),
+		dataConverter: r.dataConverter,
+		compareInputs: r.compareInputs,
+	}
+	env := NewTestWorkflowEnvironment()
+	env.SetStartTime(doc.Run.ScheduledAt)
+	env.SetDataConverter(r.dataConverter)
+	env.replay = state
+	env.info = workflow.Info{WorkflowRunID: doc.Run.ID, Attempt: doc.Run.Attempt}
+	if err := env.ExecuteWorkflow(workflowFunction, args...); err != nil {
+		return err
+	}
+
+	if state.err != nil {
+		return state.err
+	}
+	if env.panicked {
+		return env.err
+	}
+	if state.next < len(state.activities) {
+		reason := "workflow completed"
+		if !env.IsWorkflowCompleted() {
+			reason = "workflow is blocked"
+		}
+		return state.mismatch(reason+" before issuing all recorded commands", nil)
+	}
+	return state.checkOutcome(doc.Run.Status, env)
+}
+
+// decodeInput decodes the recorded workflow input into the workflow function's parameter types.
+func (r *Replayer) decodeInput(workflowFunction any, input json.RawMessage) ([]any, error) {
+	fnType := reflect.TypeOf(workflowFunction)
+	ptrs := make([]any, fnType.NumIn()-1)
+	for i := range ptrs {
+		ptrs[i] = reflect.New(fnType.In(i + 1)).Interface()
+	}
+	if err := converter.DecodeValues(r.dataConverter, input, ptrs...); err != nil {
+		return nil, fmt.Errorf("failed to decode workflow input: %w", err)
+	}
+
+	args := make([]any, len(ptrs))
+	for i, ptr := range ptrs {
+		args[i] = reflect.ValueOf(ptr).Elem().Interface()
+	}
+	return args, nil
+}
+
+func isClosed(status backend.WorkflowStatus) bool {
+	return status == backend.WorkflowStatusFinished || status == backend.WorkflowStatusFailed
+}
+
+// replayState serves activity results from a history and records the first divergence.
+type replayState struct {
+	runID         string
+	activities    []backend.ActivityRun
+	closed        bool
+	dataConverter converter.DataConverter
+	compareInputs bool
+
+	next int
+	err  error
+}
+
+func (s *replayState) failed() bool {
+	return s != nil && s.err != nil
+}
+
+func (s *replayState) mismatch(reason string, replayed *Command) error {
+	err := &NonDeterminismError{WorkflowRunID: s.runID, Index: s.next, Replayed: replayed, Reason: reason}
+	if s.next < len(s.activities) {
+		recorded := s.activities[s.next]
+		err.Recorded = &Command{ActivityName: recorded.ActivityName, Input: recorded.Input}
+	}
+	return err
+}
+
+func (s *replayState) executeActivity(
+	env *TestWorkflowEnvironment,
+	c *coroutine,
+	name string,
+	args []any,
+	resultPtr any,
+) error {
+	input, err := converter.EncodeValues(s.dataConverter, args...)
+	if err != nil {
+		return fmt.Errorf("failed to encode activity input: %w", err)
+	}
+	replayed := &Command{ActivityName: name, Input: input}
+
+	if s.next >= len(s.activities) {
+		if s.closed {
+			s.err = s.mismatch("workflow issued a command after the end of the history", replayed)
+		}
+		// The recorded run had not reached this command yet, so the replay ends here.
+		env.block(c)
+		return nil
+	}
+
+	recorded := s.activities[s.next]
+	if recorded.ActivityName != name || (s.compareInputs && !jsonEqual(recorded.Input, input)) {
+		s.err = s.mismatch("", replayed)
+		env.block(c)
+		return nil
+	}
+	s.next++
+
+	switch recorded.Status {
+	case backend.ActivityStatusFinished:
+		if recorded.Output == nil {
+			return nil
+		}
+		return converter.DecodeValues(s.dataConverter, *recorded.Output, resultPtr)
+	case backend.ActivityStatusFailed:
+		if recorded.ErrorMessage == nil {
+			return errors.New("activity failed")
+		}
+		return errors.New(*recorded.ErrorMessage)
+	default:
+		// The activity was still running when the history was recorded.
+		env.block(c)
+		return nil
+	}
+}
+
+// checkOutcome compares how the replay ended with the recorded status of a closed run.
+func (s *replayState) checkOutcome(status backend.WorkflowStatus, env *TestWorkflowEnvironment) error {
+	if !s.closed {
+		return nil
+	}
+	completed := env.IsWorkflowCompleted()
+	switch {
+	case !completed:
+		return s.mismatch(fmt.Sprintf("recorded run is %s but the replay is blocked", status), nil)
+	case status == backend.WorkflowStatusFinished && env.GetWorkflowError() != nil:
+		return s.mismatch(fmt.Sprintf("recorded run finished but the replay failed: %v", env.GetWorkflowError()), nil)
+	case status == backend.WorkflowStatusFailed && env.GetWorkflowError() == nil:
+		return s.mismatch("recorded run failed but the replay completed", nil)
+	}
+	return nil
+}
+
+// jsonEqual compares two JSON documents regardless of formatting, as JSONB columns do not
+// keep the original key order or whitespace.
+func jsonEqual(a, b []byte) bool {
+	var av, bv any
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return string(a) == string(b)
+	}
+	return reflect.DeepEqual(av, bv)
+}
@@ -0,0 +1,256 @@
+package pitlanetest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/backend/memory"
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/nurburg-dev/pitlane/history"
+	"github.com/nurburg-dev/pitlane/internal/utils"
+	"github.com/nurburg-dev/pitlane/pitlanetest"
+	"github.com/nurburg-dev/pitlane/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ShipOrder(_ context.Context, _ string) (string, error) {
+	return "", errors.New("not executed during replay")
+}
+
+func RefundCard(_ context.Context, _ Order) (string, error) {
+	return "", errors.New("not executed during replay")
+}
+
+func FulfillmentWorkflow(ctx context.Context, order Order) (string, error) {
+	chargeID, err := workflow.ExecuteActivity1(ctx, ChargeCard, order)
+	if err != nil {
+		return "", err
+	}
+	if err := workflow.Sleep(ctx, 24*time.Hour); err != nil {
+		return "", err
+	}
+	return workflow.ExecuteActivity1(ctx, ShipOrder, chargeID)
+}
+
+func payloads(t *testing.T, values ...any) json.RawMessage {
+	t.Helper()
+	data, err := converter.EncodeValues(converter.GetDefaultDataConverter(), values...)
+	require.NoError(t, err)
+	return data
+}
+
+func activityRun(t *testing.T, fn any, input json.RawMessage, output any) backend.ActivityRun {
+	t.Helper()
+	name, err := utils.GetFunctionName(fn)
+	require.NoError(t, err)
+	run := backend.ActivityRun{ActivityName: name, Input: input, Status: backend.ActivityStatusPending}
+	if output != nil {
+		raw := payloads(t, output)
+		run.Output = &raw
+		run.Status = backend.ActivityStatusFinished
+	}
+	return run
+}
+
+// recordedHistory is the history FulfillmentWorkflow leaves behind for order.
+func recordedHistory(t *testing.T, status backend.WorkflowStatus) *pitlanetest.History {
+	t.Helper()
+	name, err := utils.GetFunctionName(FulfillmentWorkflow)
+	require.NoError(t, err)
+	order := Order{ID: "order-1", Amount: 42}
+	return &pitlanetest.History{
+		Run: backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        payloads(t, order),
+			WorkflowName: name,
+			Status:       status,
+			ScheduledAt:  time.Now(),
+		},
+		Activities: []backend.ActivityRun{
+			activityRun(t, ChargeCard, payloads(t, order), "charge-1"),
+			activityRun(t, ShipOrder, payloads(t, "charge-1"), "shipment-1"),
+		},
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	replayer := pitlanetest.NewReplayer()
+	require.NoError(t, replayer.ReplayWorkflow(FulfillmentWorkflow, recordedHistory(t, backend.WorkflowStatusFinished)))
+
+	// A run that has not reached its last activity yet replays up to the end of its history.
+	recorded := recordedHistory(t, backend.WorkflowStatusExecuting)
+	recorded.Activities = recorded.Activities[:1]
+	require.NoError(t, replayer.ReplayWorkflow(FulfillmentWorkflow, recorded))
+}
+
+func TestReplayer_SuppressesLogs(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	name, err := utils.GetFunctionName(LoggingWorkflow)
+	require.NoError(t, err)
+	order := Order{ID: "order-1", Amount: 42}
+	recorded := &pitlanetest.History{
+		Run: backend.WorkflowRun{
+			ID:           "run-1",
+			Input:        payloads(t, order),
+			WorkflowName: name,
+			Status:       backend.WorkflowStatusFinished,
+			ScheduledAt:  time.Now(),
+		},
+		Activities: []backend.ActivityRun{activityRun(t, LoggedCharge, payloads(t, order), "charge-1")},
+	}
+	require.NoError(t, pitlanetest.NewReplayer().ReplayWorkflow(LoggingWorkflow, recorded))
+	assert.Empty(t, buf.String())
+}
+
+func TestReplayer_ChangedActivity(t *testing.T) {
+	recorded := recordedHistory(t, backend.WorkflowStatusFinished)
+	recorded.Activities[0] = activityRun(t, RefundCard, recorded.Activities[0].Input, "refund-1")
+
+	err := pitlanetest.NewReplayer().ReplayWorkflow(FulfillmentWorkflow, recorded)
+	require.ErrorIs(t, err, pitlanetest.ErrNonDeterministic)
+	var ndErr *pitlanetest.NonDeterminismError
+	require.ErrorAs(t, err, &ndErr)
+	assert.Equal(t, 0, ndErr.Index)
+	assert.Contains(t, ndErr.Recorded.ActivityName, "RefundCard")
+	assert.Contains(t, ndErr.Replayed.ActivityName, "ChargeCard")
+	assert.Contains(t, err.Error(), "- recorded: ExecuteActivity(")
+}
+
+func TestReplayer_ChangedInput(t *testing.T) {
+	recorded := recordedHistory(t, backend.WorkflowStatusFinished)
+	recorded.Activities[1].Input = payloads(t, "charge-2")
+
+	replayer := pitlanetest.NewReplayer()
+	err := replayer.ReplayWorkflow(FulfillmentWorkflow, recorded)
+	var ndErr *pitlanetest.NonDeterminismError
+	require.ErrorAs(t, err, &ndErr)
+	assert.Equal(t, 1, ndErr.Index)
+	assert.Contains(t, string(ndErr.Recorded.Input), "charge-2")
+	assert.Contains(t, string(ndErr.Replayed.Input), "charge-1")
+
+	replayer.SetCompareActivityInputs(false)
+	require.NoError(t, replayer.ReplayWorkflow(FulfillmentWorkflow, recorded))
+}
+
+func TestReplayer_CommandCountMismatch(t *testing.T) {
+	recorded := recordedHistory(t, backend.WorkflowStatusFinished)
+	recorded.Activities = recorded.Activities[:1]
+	err := pitlanetest.NewReplayer().ReplayWorkflow(FulfillmentWorkflow, recorded)
+	var ndErr *pitlanetest.NonDeterminismError
+	require.ErrorAs(t, err, &ndErr)
+	assert.Equal(t, 1, ndErr.Index)
+	assert.Nil(t, ndErr.Recorded)
+	require.NotNil(t, ndErr.Replayed)
+	assert.Contains(t, ndErr.Replayed.ActivityName, "ShipOrder")
+
+	recorded = recordedHistory(t, backend.WorkflowStatusFinished)
+	recorded.Activities = append(recorded.Activities, activityRun(t, ShipOrder, payloads(t, "charge-1"), "shipment-2"))
+	err = pitlanetest.NewReplayer().ReplayWorkflow(FulfillmentWorkflow, recorded)
+	require.ErrorAs(t, err, &ndErr)
+	assert.Equal(t, 2, ndErr.Index)
+	assert.Nil(t, ndErr.Replayed)
+	assert.Contains(t, ndErr.Reason, "workflow completed")
+}
+
+func TestReplayer_OutcomeMismatch(t *testing.T) {
+	recorded := recordedHistory(t, backend.WorkflowStatusFailed)
+	err := pitlanetest.NewReplayer().ReplayWorkflow(FulfillmentWorkflow, recorded)
+	var ndErr *pitlanetest.NonDeterminismError
+	require.ErrorAs(t, err, &ndErr)
+	assert.Equal(t, "recorded run failed but the replay completed", ndErr.Reason)
+
+	// A recorded activity failure is returned to the workflow, which fails the same way.
+	message := "card declined"
+	recorded.Activities = recorded.Activities[:1]
+	recorded.Activities[0].Status = backend.ActivityStatusFailed
+	recorded.Activities[0].Output = nil
+	recorded.Activities[0].ErrorMessage = &message
+	require.NoError(t, pitlanetest.NewReplayer().ReplayWorkflow(FulfillmentWorkflow, recorded))
+}
+
+func TestReplayer_FromBackendAndFile(t *testing.T) {
+	ctx := context.Background()
+	recorded := recordedHistory(t, backend.WorkflowStatusFinished)
+	b := memory.New()
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		now := time.Now()
+		require.NoError(t, tx.WorkflowRepository().UpsertWorkflow(ctx, &backend.Workflow{
+			Name: recorded.Run.WorkflowName, CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, tx.WorkflowRepository().CreateWorkflowRun(ctx, &recorded.Run))
+		for i, activity := range recorded.Activities {
+			activity.ID = string(rune('a' + i))
+			activity.WorkflowRunID = recorded.Run.ID
+			activity.CreatedAt = now.Add(time.Duration(i) * time.Second)
+			require.NoError(t, tx.ActivityRunRepository().CreateActivityRun(ctx, &activity))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	replayer := pitlanetest.NewReplayer()
+	require.NoError(t, replayer.ReplayWorkflowFromBackend(ctx, b, FulfillmentWorkflow, recorded.Run.ID))
+	require.Error(t, replayer.ReplayWorkflowFromBackend(ctx, b, FulfillmentWorkflow, "missing"))
+
+	doc, err := history.Export(ctx, b, recorded.Run.ID)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, history.Write(&buf, doc))
+	path := filepath.Join(t.TempDir(), "history.json")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	require.NoError(t, replayer.ReplayWorkflowFromFile(FulfillmentWorkflow, path))
+
+	err = replayer.ReplayWorkflowFromFile(OrderWorkflow, path)
+	require.ErrorContains(t, err, "history is for workflow")
+}
+
+func TestReplayer_FromEvents(t *testing.T) {
+	ctx := context.Background()
+	recorded := recordedHistory(t, backend.WorkflowStatusFinished)
+	b := memory.New()
+	err := b.RunInTx(ctx, func(tx backend.Tx) error {
+		now := time.Now()
+		require.NoError(t, tx.WorkflowRepository().UpsertWorkflow(ctx, &backend.Workflow{
+			Name: recorded.Run.WorkflowName, CreatedAt: now, UpdatedAt: now,
+		}))
+		require.NoError(t, tx.WorkflowRepository().CreateWorkflowRun(ctx, &recorded.Run))
+		// Activities are scheduled pending and store their results when they close.
+		repo := tx.ActivityRunRepository()
+		for i, activity := range recorded.Activities {
+			activity.ID = string(rune('a' + i))
+			activity.WorkflowRunID = recorded.Run.ID
+			activity.Status = backend.ActivityStatusPending
+			activity.Output = nil
+			activity.CreatedAt = now.Add(time.Duration(i) * time.Second)
+			require.NoError(t, repo.CreateActivityRun(ctx, &activity))
+			_, err := repo.ClaimActivityRun(ctx, "worker-1", time.Minute)
+			require.NoError(t, err)
+			require.NoError(t, repo.CloseActivityRun(ctx, activity.ID, "worker-1", backend.ActivityStatusFinished,
+				recorded.Activities[i].Output, nil))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	doc, err := history.Export(ctx, b, recorded.Run.ID)
+	require.NoError(t, err)
+	require.Len(t, doc.Activities, 2)
+	assert.Equal(t, backend.ActivityStatusFinished, doc.Activities[1].Status)
+
+	// The events alone are enough to replay the run.
+	doc.Activities = nil
+	require.NoError(t, pitlanetest.NewReplayer().ReplayWorkflow(FulfillmentWorkflow, doc))
+}
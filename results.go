@@ -0,0 +1,116 @@
+package pitlane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+// resultPollInterval is the time between reads of a run whose result is awaited.
+const resultPollInterval = 200 * time.Millisecond
+
+// CompleteWorkflowRun finishes a workflow run with result, encoded by the data converter within
+// the payload size limits. run is a run passed to a WorkflowTaskHandler; CompleteWorkflowRun
+// returns an error matching backend.ErrLeaseLost when it is no longer leased to its worker.
+func (we *WorkflowEngine) CompleteWorkflowRun(ctx context.Context, run *backend.WorkflowRun, result any) error {
+	data, err := we.encodePayloads("workflow output", result)
+	if err != nil {
+		return err
+	}
+	output := json.RawMessage(data)
+	return we.closeWorkflowRun(ctx, run, backend.WorkflowStatusFinished, &output, nil)
+}
+
+// FailWorkflowRun fails a workflow run with the message of cause like CompleteWorkflowRun.
+func (we *WorkflowEngine) FailWorkflowRun(ctx context.Context, run *backend.WorkflowRun, cause error) error {
+	message := cause.Error()
+	return we.closeWorkflowRun(ctx, run, backend.WorkflowStatusFailed, nil, &message)
+}
+
+func (we *WorkflowEngine) closeWorkflowRun(
+	ctx context.Context,
+	run *backend.WorkflowRun,
+	status backend.WorkflowStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) error {
+	if run.LeaseOwner == nil {
+		return fmt.Errorf("failed to close workflow run %s: %w", run.ID, backend.ErrLeaseLost)
+	}
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkflowRepository().CloseWorkflowRun(ctx, run.ID, *run.LeaseOwner, status, output, errorMessage)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close workflow run %s: %w", run.ID, err)
+	}
+	return nil
+}
+
+// CompleteActivityRun finishes an activity run with result like CompleteWorkflowRun. run is a
+// run passed to an ActivityTaskHandler.
+func (we *WorkflowEngine) CompleteActivityRun(ctx context.Context, run *backend.ActivityRun, result any) error {
+	data, err := we.encodePayloads("activity output", result)
+	if err != nil {
+		return err
+	}
+	output := json.RawMessage(data)
+	return we.closeActivityRun(ctx, run, backend.ActivityStatusFinished, &output, nil)
+}
+
+// FailActivityRun fails an activity run with the message of cause like CompleteActivityRun.
+func (we *WorkflowEngine) FailActivityRun(ctx context.Context, run *backend.ActivityRun, cause error) error {
+	message := cause.Error()
+	return we.closeActivityRun(ctx, run, backend.ActivityStatusFailed, nil, &message)
+}
+
+func (we *WorkflowEngine) closeActivityRun(
+	ctx context.Context,
+	run *backend.ActivityRun,
+	status backend.ActivityStatus,
+	output *json.RawMessage,
+	errorMessage *string,
+) error {
+	if run.LeaseOwner == nil {
+		return fmt.Errorf("failed to close activity run %s: %w", run.ID, backend.ErrLeaseLost)
+	}
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.ActivityRunRepository().CloseActivityRun(ctx, run.ID, *run.LeaseOwner, status, output, errorMessage)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close activity run %s: %w", run.ID, err)
+	}
+	return nil
+}
+
+// waitForWorkflowRun reads a workflow run until it is closed or ctx is done.
+func (we *WorkflowEngine) waitForWorkflowRun(ctx context.Context, workflowRunID string) (*backend.WorkflowRun, error) {
+	ticker := time.NewTicker(resultPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var run *backend.WorkflowRun
+		err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+			var err error
+			run, err = tx.WorkflowRepository().GetWorkflowRun(ctx, workflowRunID)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get workflow run %s: %w", workflowRunID, err)
+		}
+		if run == nil {
+			return nil, fmt.Errorf("workflow run %s does not exist", workflowRunID)
+		}
+		if run.Status.IsClosed() {
+			return run, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
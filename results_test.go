@@ -0,0 +1,75 @@
+package pitlane_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/converter"
+	"github.com/stretchr/testify/require"
+)
+
+func ChargeWorkflow(_ context.Context, orderID string) (string, error) {
+	return orderID, nil
+}
+
+func TestCompleteActivityRun(t *testing.T) {
+	ctx := context.Background()
+	we, b := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.PayloadSizeLimits.HardLimit = 1024
+	})
+	require.NoError(t, pitlane.RegisterWorkflow(ChargeWorkflow))
+
+	runID, err := we.InvokeWorkflow(ctx, ChargeWorkflow, "order-1")
+	require.NoError(t, err)
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		now := time.Now()
+		for _, id := range []string{"charge-1", "charge-2"} {
+			require.NoError(t, tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+				ID:            id,
+				ActivityName:  "charge",
+				WorkflowRunID: runID,
+				Status:        backend.ActivityStatusPending,
+				ScheduledAt:   now,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	run, err := we.ClaimActivityRun(ctx, "worker-1")
+	require.NoError(t, err)
+	err = we.CompleteActivityRun(ctx, run, strings.Repeat("a", 2048))
+	require.ErrorIs(t, err, pitlane.ErrPayloadTooLarge)
+	var sizeErr *pitlane.PayloadSizeError
+	require.ErrorAs(t, err, &sizeErr)
+	require.Equal(t, "activity output", sizeErr.Kind)
+	require.NoError(t, we.CompleteActivityRun(ctx, run, "receipt-1"))
+	require.ErrorIs(t, we.CompleteActivityRun(ctx, run, "receipt-1"), backend.ErrLeaseLost)
+
+	failed, err := we.ClaimActivityRun(ctx, "worker-1")
+	require.NoError(t, err)
+	require.NoError(t, we.FailActivityRun(ctx, failed, errors.New("card declined")))
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		finished, err := tx.ActivityRunRepository().GetActivityRun(ctx, run.ID)
+		require.NoError(t, err)
+		require.Equal(t, backend.ActivityStatusFinished, finished.Status)
+		var receipt string
+		require.NoError(t, converter.DecodeValues(converter.GetDefaultDataConverter(), *finished.Output, &receipt))
+		require.Equal(t, "receipt-1", receipt)
+
+		failedRun, err := tx.ActivityRunRepository().GetActivityRun(ctx, failed.ID)
+		require.NoError(t, err)
+		require.Equal(t, backend.ActivityStatusFailed, failedRun.Status)
+		require.Equal(t, "card declined", *failedRun.ErrorMessage)
+		return nil
+	})
+	require.NoError(t, err)
+}
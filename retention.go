@@ -0,0 +1,215 @@
+package pitlane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/archive"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/history"
+)
+
+const (
+	defaultRetentionInterval  = time.Minute
+	defaultRetentionBatchSize = 100
+)
+
+// RetentionConfig sets how long closed workflow runs are kept before CleanupClosedRuns deletes
+// them with their activity runs and event history.
+type RetentionConfig struct {
+	// Default is the retention period of workflows missing from PerWorkflow. Zero keeps their
+	// runs forever.
+	Default time.Duration
+	// PerWorkflow overrides Default by workflow name, e.g. "github.com/acme/shop.OrderWorkflow".
+	// A zero period keeps the runs of that workflow forever.
+	PerWorkflow map[string]time.Duration
+	// Interval is the time between the cleanup passes of RunJanitor and defaults to one minute.
+	Interval time.Duration
+	// BatchSize is the number of runs deleted per transaction and defaults to 100. Small batches
+	// keep row locks short at the cost of more round trips.
+	BatchSize int
+	// OnError is called with the error of a failed pass of RunJanitor, which keeps running.
+	OnError func(err error)
+	// Archiver, when set, stores the history of every run before it is deleted. Runs are only
+	// deleted once their batch has been archived.
+	Archiver archive.Archiver
+}
+
+func (c RetentionConfig) period(workflowName string) time.Duration {
+	if period, ok := c.PerWorkflow[workflowName]; ok {
+		return period
+	}
+	return c.Default
+}
+
+// RunJanitor runs CleanupClosedRuns every Retention.Interval until ctx is done, and then
+// returns ctx's error. Start it in its own goroutine.
+func (we *WorkflowEngine) RunJanitor(ctx context.Context) error {
+	interval := we.retention.Interval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := we.CleanupClosedRuns(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			we.logger.ErrorContext(ctx, "failed to clean up closed runs", slog.Any("error", err))
+			if we.retention.OnError != nil {
+				we.retention.OnError(err)
+			}
+		case deleted > 0:
+			we.logger.InfoContext(ctx, "deleted closed runs", slog.Int64("count", deleted))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CleanupClosedRuns deletes the runs that closed longer than their workflow's retention period
+// ago and returns how many it deleted. With an Archiver configured, each batch is archived
+// before it is deleted. Runs are deleted in batches of Retention.BatchSize, each
+// in its own short transaction, so no lock is held for long. Child runs of deleted runs are kept and
+// lose their parent link.
+func (we *WorkflowEngine) CleanupClosedRuns(ctx context.Context) (int64, error) {
+	batchSize := we.retention.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRetentionBatchSize
+	}
+
+	var workflows []backend.Workflow
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		workflows, err = tx.WorkflowRepository().ListWorkflows(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list workflows: %w", err)
+	}
+
+	now := time.Now()
+	var total int64
+	for _, workflow := range workflows {
+		period := we.retention.period(workflow.Name)
+		if period <= 0 {
+			continue
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				return total, err
+			}
+			deleted, err := we.deleteClosedRuns(ctx, workflow.Name, now.Add(-period), batchSize)
+			total += deleted
+			if err != nil {
+				return total, err
+			}
+			if deleted < int64(batchSize) {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// deleteClosedRuns deletes up to limit runs of a workflow that closed before closedBefore. The
+// runs are archived between two short transactions, so no transaction stays open during the
+// archiver's I/O. The second transaction only deletes the runs that are still closed before
+// closedBefore and unchanged since they were exported; the others are kept, and a run changed
+// while it was archived is archived again, with its newer history, by a later pass.
+func (we *WorkflowEngine) deleteClosedRuns(
+	ctx context.Context,
+	workflowName string,
+	closedBefore time.Time,
+	limit int,
+) (int64, error) {
+	var ids []string
+	var docs []*history.Document
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		query := backend.WorkflowRunQuery{WorkflowName: workflowName, ClosedBefore: closedBefore}
+		runs, err := tx.WorkflowRepository().ListWorkflowRuns(ctx, query, nil, limit)
+		if err != nil {
+			return err
+		}
+		ids = make([]string, len(runs))
+		for i, run := range runs {
+			ids[i] = run.ID
+		}
+		docs, err = we.exportRuns(ctx, tx, ids)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list closed runs of workflow %s: %w", workflowName, err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if docs != nil {
+		if err := we.retention.Archiver.Archive(ctx, docs); err != nil {
+			return 0, fmt.Errorf("failed to archive workflow runs: %w", err)
+		}
+	}
+
+	deletion := backend.WorkflowRunDeletion{WorkflowRunIDs: ids, ClosedBefore: closedBefore}
+	if docs != nil {
+		deletion.LastEventSequences = make(map[string]int64, len(docs))
+		for _, doc := range docs {
+			var sequence int64
+			if len(doc.Events) > 0 {
+				sequence = doc.Events[len(doc.Events)-1].Sequence
+			}
+			deletion.LastEventSequences[doc.Run.ID] = sequence
+		}
+	}
+	var deleted []string
+	err = we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		deleted, err = tx.WorkflowRepository().DeleteWorkflowRuns(ctx, deletion)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete closed runs of workflow %s: %w", workflowName, err)
+	}
+	if kept := len(ids) - len(deleted); kept > 0 {
+		we.logger.WarnContext(ctx, "kept closed runs that changed before their deletion",
+			slog.String("workflow_name", workflowName), slog.Int("count", kept))
+	}
+	return int64(len(deleted)), nil
+}
+
+// exportRuns reads the history of the runs to archive, or returns nil without an Archiver.
+func (we *WorkflowEngine) exportRuns(
+	ctx context.Context,
+	tx backend.Tx,
+	workflowRunIDs []string,
+) ([]*history.Document, error) {
+	if we.retention.Archiver == nil || len(workflowRunIDs) == 0 {
+		return nil, nil
+	}
+	docs := make([]*history.Document, len(workflowRunIDs))
+	for i, id := range workflowRunIDs {
+		doc, err := history.ExportTx(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+	}
+	return docs, nil
+}
+
+// GetArchivedWorkflowRun returns the archived history of a workflow run deleted by retention,
+// from the Archiver of the engine's retention config.
+func (we *WorkflowEngine) GetArchivedWorkflowRun(ctx context.Context, workflowRunID string) (*history.Document, error) {
+	if we.retention.Archiver == nil {
+		return nil, errors.New("no archiver configured")
+	}
+	return we.retention.Archiver.Get(ctx, workflowRunID)
+}
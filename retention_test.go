@@ -0,0 +1,202 @@
+package pitlane_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/archive"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/history"
+	"github.com/stretchr/testify/require"
+)
+
+func LongLivedWorkflow(_ context.Context, orderID string) (string, error) {
+	return orderID, nil
+}
+
+func TestCleanupClosedRuns(t *testing.T) {
+	ctx := context.Background()
+	we, b := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.Retention = pitlane.RetentionConfig{
+			Default: time.Nanosecond,
+			PerWorkflow: map[string]time.Duration{
+				"github.com/nurburg-dev/pitlane_test.LongLivedWorkflow": time.Hour,
+			},
+			BatchSize: 2,
+		}
+	})
+	require.NoError(t, pitlane.RegisterWorkflow(LongLivedWorkflow))
+
+	var closed []string
+	for range 5 {
+		id, err := we.InvokeWorkflow(ctx, OrderWorkflow, "order")
+		require.NoError(t, err)
+		closed = append(closed, id)
+	}
+	pending, err := we.InvokeWorkflow(ctx, OrderWorkflow, "order")
+	require.NoError(t, err)
+	retained, err := we.InvokeWorkflow(ctx, LongLivedWorkflow, "order")
+	require.NoError(t, err)
+	child, err := we.InvokeWorkflowWithOptions(ctx,
+		pitlane.StartWorkflowOptions{ParentWorkflowRunID: closed[0]}, OrderWorkflow, "child")
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		now := time.Now()
+		for _, id := range append(closed, retained) {
+			require.NoError(t, tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, id, backend.WorkflowStatusFinished))
+		}
+		return tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+			ID: "activity-1", ActivityName: "test-activity", WorkflowRunID: closed[0], Input: json.RawMessage(`[]`),
+			Status: backend.ActivityStatusFinished, ScheduledAt: now, CreatedAt: now, UpdatedAt: now,
+		})
+	})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+
+	deleted, err := we.CleanupClosedRuns(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), deleted)
+
+	page, err := we.ListWorkflowRuns(ctx, pitlane.WorkflowRunFilter{})
+	require.NoError(t, err)
+	var remaining []string
+	for _, run := range page.Runs {
+		remaining = append(remaining, run.ID)
+		if run.ID == child {
+			require.Nil(t, run.ParentWorkflowRunID)
+		}
+	}
+	require.ElementsMatch(t, []string{pending, retained, child}, remaining)
+
+	deleted, err = we.CleanupClosedRuns(ctx)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+}
+
+type failingArchiver struct {
+	archive.Archiver
+}
+
+func (failingArchiver) Archive(context.Context, []*history.Document) error {
+	return errors.New("disk full")
+}
+
+func TestCleanupClosedRuns_Archive(t *testing.T) {
+	ctx := context.Background()
+	archiver, err := archive.NewFileArchiver(t.TempDir())
+	require.NoError(t, err)
+	we, b := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.Retention = pitlane.RetentionConfig{Default: time.Nanosecond, Archiver: archiver}
+	})
+
+	id, err := we.InvokeWorkflow(ctx, OrderWorkflow, "order")
+	require.NoError(t, err)
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, id, backend.WorkflowStatusFinished)
+	})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+
+	// Runs whose batch fails to archive are kept.
+	config := pitlane.NewEngineConfig(nil, true)
+	config.Retention = pitlane.RetentionConfig{Default: time.Nanosecond, Archiver: failingArchiver{}}
+	failing, err := pitlane.NewWorkflowEngineWithBackend(ctx, b, config)
+	require.NoError(t, err)
+	_, err = failing.CleanupClosedRuns(ctx)
+	require.ErrorContains(t, err, "disk full")
+	_, err = we.ExportWorkflowRun(ctx, id)
+	require.NoError(t, err)
+
+	deleted, err := we.CleanupClosedRuns(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	_, err = we.ExportWorkflowRun(ctx, id)
+	require.Error(t, err)
+
+	doc, err := we.GetArchivedWorkflowRun(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, id, doc.Run.ID)
+	require.Equal(t, backend.WorkflowStatusFinished, doc.Run.Status)
+	require.Len(t, doc.Events, 2)
+	require.Equal(t, backend.WorkflowEventRunStatusChanged, doc.Events[1].EventType)
+
+	_, err = we.GetArchivedWorkflowRun(ctx, "missing")
+	require.ErrorIs(t, err, archive.ErrNotFound)
+}
+
+// changingArchiver archives with Archiver, after calling change once with the first batch.
+type changingArchiver struct {
+	archive.Archiver
+	change func(docs []*history.Document)
+}
+
+func (a *changingArchiver) Archive(ctx context.Context, docs []*history.Document) error {
+	if a.change != nil {
+		a.change(docs)
+		a.change = nil
+	}
+	return a.Archiver.Archive(ctx, docs)
+}
+
+func TestCleanupClosedRuns_ChangedWhileArchiving(t *testing.T) {
+	ctx := context.Background()
+	fileArchiver, err := archive.NewFileArchiver(t.TempDir())
+	require.NoError(t, err)
+	archiver := &changingArchiver{Archiver: fileArchiver}
+	we, b := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.Retention = pitlane.RetentionConfig{Default: time.Nanosecond, Archiver: archiver}
+	})
+
+	var ids []string
+	for range 2 {
+		id, err := we.InvokeWorkflow(ctx, OrderWorkflow, "order")
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		for _, id := range ids {
+			require.NoError(t, tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, id, backend.WorkflowStatusFinished))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+
+	// One run gains a search attribute and the other is reopened after they were exported.
+	archiver.change = func(docs []*history.Document) {
+		require.Len(t, docs, 2)
+		require.NoError(t, we.UpsertSearchAttributes(ctx, ids[0], map[string]any{"Region": "eu"}))
+		require.NoError(t, b.RunInTx(ctx, func(tx backend.Tx) error {
+			return tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, ids[1], backend.WorkflowStatusPending)
+		}))
+	}
+	deleted, err := we.CleanupClosedRuns(ctx)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	for _, id := range ids {
+		_, err = we.ExportWorkflowRun(ctx, id)
+		require.NoError(t, err)
+	}
+
+	// The next pass archives the changed run again and deletes it; the reopened run stays.
+	deleted, err = we.CleanupClosedRuns(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	_, err = we.ExportWorkflowRun(ctx, ids[0])
+	require.Error(t, err)
+	_, err = we.ExportWorkflowRun(ctx, ids[1])
+	require.NoError(t, err)
+
+	// The archive returns the copy taken after the change.
+	doc, err := we.GetArchivedWorkflowRun(ctx, ids[0])
+	require.NoError(t, err)
+	require.NotEmpty(t, doc.Events)
+	last := doc.Events[len(doc.Events)-1]
+	require.Equal(t, backend.WorkflowEventRunAttributesUpdated, last.EventType)
+	require.Contains(t, string(last.Payload), `"Region":"eu"`)
+}
@@ -0,0 +1,86 @@
+package pitlane
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the engine's spans.
+const tracerName = "github.com/nurburg-dev/pitlane"
+
+// Span attributes set by the engine and its workers.
+const (
+	AttributeWorkflowName  = attribute.Key("pitlane.workflow.name")
+	AttributeWorkflowRunID = attribute.Key("pitlane.workflow.run_id")
+	AttributeActivityName  = attribute.Key("pitlane.activity.name")
+	AttributeActivityRunID = attribute.Key("pitlane.activity.run_id")
+	AttributeAttempt       = attribute.Key("pitlane.attempt")
+	AttributeTaskQueue     = attribute.Key("pitlane.task_queue")
+	AttributeWorkerID      = attribute.Key("pitlane.worker.id")
+	// AttributeOutcome is the metric outcome of a handler, e.g. MetricOutcomeReleased or the
+	// status it left the run in.
+	AttributeOutcome = attribute.Key("pitlane.outcome")
+)
+
+// TracingConfig sets how the engine traces invocations and the tasks of its workers.
+//
+// InvokeWorkflow starts a span and stores its context with the run, and activity runs inherit
+// the context of their workflow run. Workers continue the stored trace with a span per workflow
+// task and per activity attempt, so a trace spans processes and retries.
+type TracingConfig struct {
+	// TracerProvider creates the engine's tracer and defaults to otel.GetTracerProvider.
+	TracerProvider trace.TracerProvider
+	// Propagator stores trace context with runs and defaults to W3C trace context and baggage.
+	Propagator propagation.TextMapPropagator
+}
+
+func (c TracingConfig) tracer() trace.Tracer {
+	provider := c.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+func (c TracingConfig) propagator() propagation.TextMapPropagator {
+	if c.Propagator == nil {
+		return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	}
+	return c.Propagator
+}
+
+// injectTraceContext returns the trace context of ctx to store with a run, or nil.
+func (we *WorkflowEngine) injectTraceContext(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	we.propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// startRunSpan starts the span of a handler executing a run, continuing the trace stored with
+// the run.
+func (we *WorkflowEngine) startRunSpan(
+	ctx context.Context,
+	name string,
+	traceContext map[string]string,
+	attributes ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	ctx = we.propagator.Extract(ctx, propagation.MapCarrier(traceContext))
+	return we.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attributes...))
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
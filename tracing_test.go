@@ -0,0 +1,87 @@
+package pitlane_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	we, b := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.Tracing.TracerProvider = provider
+	})
+
+	requestCtx, request := provider.Tracer("test").Start(ctx, "request")
+	runID, err := we.InvokeWorkflowWithOptions(requestCtx, pitlane.StartWorkflowOptions{TaskQueue: "traced"},
+		OrderWorkflow, "order-1")
+	require.NoError(t, err)
+	request.End()
+
+	activityDone := make(chan struct{})
+	worker := we.NewWorker(pitlane.WorkerOptions{
+		TaskQueues:   []string{"traced"},
+		PollInterval: 10 * time.Millisecond,
+		WorkflowTaskHandler: func(ctx context.Context, run *backend.WorkflowRun) error {
+			return b.RunInTx(ctx, func(tx backend.Tx) error {
+				now := time.Now()
+				err := tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+					ID:            "traced-activity",
+					ActivityName:  "charge",
+					WorkflowRunID: run.ID,
+					Status:        backend.ActivityStatusPending,
+					ScheduledAt:   now,
+					CreatedAt:     now,
+					UpdatedAt:     now,
+				})
+				if err != nil {
+					return err
+				}
+				return tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, run.ID, backend.WorkflowStatusFinished)
+			})
+		},
+		ActivityTaskHandler: func(context.Context, *backend.ActivityRun) error {
+			defer close(activityDone)
+			return errors.New("card declined")
+		},
+	})
+	require.NoError(t, worker.Start(ctx))
+	<-activityDone
+	require.NoError(t, worker.Stop(ctx))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	workflowName := "github.com/nurburg-dev/pitlane_test.OrderWorkflow"
+	invoke := spans["InvokeWorkflow "+workflowName]
+	require.NotNil(t, invoke)
+	require.Equal(t, request.SpanContext().SpanID(), invoke.Parent().SpanID())
+	require.Contains(t, invoke.Attributes(), pitlane.AttributeWorkflowRunID.String(runID))
+
+	task := spans["RunWorkflowTask "+workflowName]
+	require.NotNil(t, task)
+	require.Equal(t, invoke.SpanContext().SpanID(), task.Parent().SpanID())
+	require.Equal(t, request.SpanContext().TraceID(), task.SpanContext().TraceID())
+	require.Contains(t, task.Attributes(), pitlane.AttributeAttempt.Int(1))
+	require.Contains(t, task.Attributes(), pitlane.AttributeTaskQueue.String("traced"))
+	require.Contains(t, task.Attributes(), pitlane.AttributeOutcome.String(string(backend.WorkflowStatusFinished)))
+
+	// The activity run inherits the trace context of its workflow run.
+	activity := spans["RunActivity charge"]
+	require.NotNil(t, activity)
+	require.Equal(t, invoke.SpanContext().SpanID(), activity.Parent().SpanID())
+	require.Contains(t, activity.Attributes(), pitlane.AttributeActivityRunID.String("traced-activity"))
+	require.Equal(t, codes.Error, activity.Status().Code)
+	require.Equal(t, "card declined", activity.Status().Description)
+}
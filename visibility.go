@@ -0,0 +1,110 @@
+package pitlane
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+)
+
+const (
+	defaultListPageSize = 100
+	maxListPageSize     = 1000
+)
+
+// ErrInvalidPageToken is returned by ListWorkflowRuns for a page token it did not issue.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// WorkflowRunFilter selects the workflow runs returned by ListWorkflowRuns.
+type WorkflowRunFilter struct {
+	backend.WorkflowRunQuery
+	// PageSize defaults to 100 and is capped at 1000.
+	PageSize int
+	// PageToken continues a listing from the NextPageToken of its previous page.
+	PageToken string
+	// IncludeCount also counts all runs matching the filter, which scans every match.
+	IncludeCount bool
+}
+
+// WorkflowRunPage is a page of workflow runs, newest created first.
+type WorkflowRunPage struct {
+	Runs []backend.WorkflowRun
+	// NextPageToken is empty on the last page.
+	NextPageToken string
+	// TotalCount is only set when the filter includes the count.
+	TotalCount int64
+}
+
+type pageToken struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        string    `json:"id"`
+}
+
+// ListWorkflowRuns returns the workflow runs matching filter. Pages are read by keyset, so runs
+// created while paging do not shift later pages.
+func (we *WorkflowEngine) ListWorkflowRuns(ctx context.Context, filter WorkflowRunFilter) (*WorkflowRunPage, error) {
+	pageSize := filter.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultListPageSize
+	}
+	pageSize = min(pageSize, maxListPageSize)
+
+	var after *backend.WorkflowRunCursor
+	if filter.PageToken != "" {
+		cursor, err := decodePageToken(filter.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		after = cursor
+	}
+
+	page := &WorkflowRunPage{}
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		repo := tx.WorkflowRepository()
+		// One extra run tells whether there is a next page.
+		runs, err := repo.ListWorkflowRuns(ctx, filter.WorkflowRunQuery, after, pageSize+1)
+		if err != nil {
+			return fmt.Errorf("failed to list workflow runs: %w", err)
+		}
+		if len(runs) > pageSize {
+			runs = runs[:pageSize]
+			last := runs[pageSize-1]
+			page.NextPageToken = encodePageToken(backend.WorkflowRunCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		}
+		page.Runs = runs
+
+		if filter.IncludeCount {
+			page.TotalCount, err = repo.CountWorkflowRuns(ctx, filter.WorkflowRunQuery)
+			if err != nil {
+				return fmt.Errorf("failed to count workflow runs: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func encodePageToken(cursor backend.WorkflowRunCursor) string {
+	// A struct of a time and a string always marshals.
+	data, _ := json.Marshal(pageToken{CreatedAt: cursor.CreatedAt, ID: cursor.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(token string) (*backend.WorkflowRunCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPageToken, err)
+	}
+	var pt pageToken
+	if err := json.Unmarshal(data, &pt); err != nil || pt.ID == "" {
+		return nil, ErrInvalidPageToken
+	}
+	return &backend.WorkflowRunCursor{CreatedAt: pt.CreatedAt, ID: pt.ID}, nil
+}
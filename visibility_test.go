@@ -0,0 +1,132 @@
+package pitlane_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func OtherListedWorkflow(_ context.Context, orderID string) (string, error) {
+	return orderID, nil
+}
+
+func TestListWorkflowRuns(t *testing.T) {
+	ctx := context.Background()
+	we, b := newMemoryEngine(t, nil)
+	require.NoError(t, pitlane.RegisterWorkflow(OtherListedWorkflow))
+
+	start := time.Now()
+	var ids []string
+	for i := range 5 {
+		options := pitlane.StartWorkflowOptions{Labels: map[string]string{"region": "eu"}}
+		if i%2 == 1 {
+			options.Labels["region"] = "us"
+		}
+		id, err2 := we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order")
+		require.NoError(t, err2)
+		ids = append(ids, id)
+	}
+	childID, err := we.InvokeWorkflowWithOptions(ctx,
+		pitlane.StartWorkflowOptions{ParentWorkflowRunID: ids[0]}, OtherListedWorkflow, "child")
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, ids[1], backend.WorkflowStatusFailed)
+	})
+	require.NoError(t, err)
+
+	// Page through all OrderWorkflow runs, newest first.
+	filter := pitlane.WorkflowRunFilter{PageSize: 2, IncludeCount: true}
+	filter.WorkflowName = "github.com/nurburg-dev/pitlane_test.OrderWorkflow"
+	var listed []string
+	for {
+		page, err2 := we.ListWorkflowRuns(ctx, filter)
+		require.NoError(t, err2)
+		require.Equal(t, int64(5), page.TotalCount)
+		for _, run := range page.Runs {
+			listed = append(listed, run.ID)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		filter.PageToken = page.NextPageToken
+	}
+	require.Len(t, listed, 5)
+	for _, id := range ids {
+		require.Contains(t, listed, id)
+	}
+
+	failed := pitlane.WorkflowRunFilter{}
+	failed.Statuses = []backend.WorkflowStatus{backend.WorkflowStatusFailed}
+	failed.ClosedAfter = start
+	page, err := we.ListWorkflowRuns(ctx, failed)
+	require.NoError(t, err)
+	require.Len(t, page.Runs, 1)
+	require.Equal(t, ids[1], page.Runs[0].ID)
+	require.NotNil(t, page.Runs[0].ClosedAt)
+	require.Empty(t, page.NextPageToken)
+
+	byLabel := pitlane.WorkflowRunFilter{}
+	byLabel.Labels = map[string]string{"region": "us"}
+	page, err = we.ListWorkflowRuns(ctx, byLabel)
+	require.NoError(t, err)
+	require.Len(t, page.Runs, 2)
+
+	children := pitlane.WorkflowRunFilter{}
+	children.ParentWorkflowRunID = ids[0]
+	page, err = we.ListWorkflowRuns(ctx, children)
+	require.NoError(t, err)
+	require.Len(t, page.Runs, 1)
+	require.Equal(t, childID, page.Runs[0].ID)
+
+	_, err = we.ListWorkflowRuns(ctx, pitlane.WorkflowRunFilter{PageToken: "not a token"})
+	require.ErrorIs(t, err, pitlane.ErrInvalidPageToken)
+}
+
+func TestListWorkflowRuns_SearchAttributes(t *testing.T) {
+	ctx := context.Background()
+	we, _ := newMemoryEngine(t, nil)
+
+	var ids []string
+	for i, total := range []float64{25, 120.5, 310} {
+		id, err2 := we.InvokeWorkflowWithOptions(ctx, pitlane.StartWorkflowOptions{
+			SearchAttributes: map[string]any{"CustomerID": "c-1", "Region": "eu", "OrderTotal": total},
+			Memo:             map[string]any{"index": i},
+		}, OrderWorkflow, "order")
+		require.NoError(t, err2)
+		ids = append(ids, id)
+	}
+	require.NoError(t, we.UpsertSearchAttributes(ctx, ids[2], map[string]any{"Region": "us"}))
+	require.NoError(t, we.UpsertMemo(ctx, ids[2], map[string]any{"note": "moved"}))
+
+	filter := pitlane.WorkflowRunFilter{}
+	filter.SearchAttributes = []backend.SearchAttributeCondition{
+		{Key: "CustomerID", Value: "c-1"},
+		{Key: "OrderTotal", Operator: backend.SearchAttributeGreater, Value: 100},
+	}
+	page, err := we.ListWorkflowRuns(ctx, filter)
+	require.NoError(t, err)
+	require.Len(t, page.Runs, 2)
+	require.Equal(t, ids[2], page.Runs[0].ID)
+	require.Equal(t, "us", page.Runs[0].SearchAttributes["Region"])
+
+	var index int
+	ok, err := we.DecodeMemoValue(&page.Runs[0], "index", &index)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 2, index)
+	var note string
+	ok, err = we.DecodeMemoValue(&page.Runs[0], "note", &note)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "moved", note)
+
+	_, err = we.InvokeWorkflowWithOptions(ctx, pitlane.StartWorkflowOptions{
+		SearchAttributes: map[string]any{"Items": []string{"a"}},
+	}, OrderWorkflow, "order")
+	require.ErrorIs(t, err, backend.ErrInvalidSearchAttribute)
+}
@@ -0,0 +1,606 @@
+package pitlane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/activity"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/internal/db"
+	"github.com/nurburg-dev/pitlane/workflow"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultPollInterval  = 10 * time.Second
+	defaultMaxConcurrent = 10
+	// cleanupTimeout bounds releasing leases and deregistering when Stop's context is done.
+	cleanupTimeout = 10 * time.Second
+)
+
+// WorkflowTaskHandler executes a workflow run claimed by a Worker. The run is executing under a
+// lease of the worker, which renews it until the handler returns. The handler moves the run out
+// of executing when it is done with it, e.g. with WorkflowEngine.CompleteWorkflowRun; a run it
+// leaves executing after returning nil is released back to pending. After an error the run stays
+// leased until its lease expires, and the lease reaper then returns it to pending for another
+// attempt.
+//
+// ctx is canceled when the worker lost the lease, or when Stop's deadline passed. The handler
+// must then return promptly without writing to the run, which another worker may execute.
+type WorkflowTaskHandler func(ctx context.Context, run *backend.WorkflowRun) error
+
+// ActivityTaskHandler executes an activity run claimed by a Worker like WorkflowTaskHandler. It
+// stores the result of the activity with WorkflowEngine.CompleteActivityRun or FailActivityRun,
+// which apply the engine's payload size limits.
+type ActivityTaskHandler func(ctx context.Context, run *backend.ActivityRun) error
+
+// WorkerOptions configures a Worker.
+type WorkerOptions struct {
+	// ID identifies the worker in the worker registry and owns its leases; it defaults to a
+	// generated ID.
+	ID string
+	// TaskQueues are the task queues the worker claims runs from and default to
+	// backend.DefaultTaskQueue.
+	TaskQueues []string
+	// WorkflowTaskHandler and ActivityTaskHandler execute the claimed runs. The worker only
+	// claims the kinds of runs it has a handler for.
+	WorkflowTaskHandler WorkflowTaskHandler
+	ActivityTaskHandler ActivityTaskHandler
+	// MaxConcurrentWorkflowTasks and MaxConcurrentActivities limit the runs of each kind executed
+	// at once and default to 10.
+	MaxConcurrentWorkflowTasks int
+	MaxConcurrentActivities    int
+	// PollInterval is the time between claims while no runs are announced on the task queues and
+	// defaults to 10 seconds.
+	PollInterval time.Duration
+	// HeartbeatInterval is the time between heartbeats, which also renew the leases of the runs
+	// being executed, and defaults to a third of the engine's Leases.Duration.
+	HeartbeatInterval time.Duration
+	// OnError is called with the errors of handlers and of the worker's background work, which
+	// keeps running.
+	OnError func(err error)
+}
+
+// Worker claims runs from task queues and executes them with its handlers. It registers itself
+// in the worker registry and heartbeats while it runs.
+type Worker struct {
+	engine  *WorkflowEngine
+	options WorkerOptions
+	logger  *slog.Logger
+
+	// stopPolling stops claiming runs, stopHeartbeat stops heartbeats and lease renewals, and
+	// stopHandlers cancels the contexts of running handlers.
+	stopPolling   context.CancelFunc
+	stopHeartbeat context.CancelFunc
+	stopHandlers  context.CancelFunc
+	handlerCtx    context.Context
+	polling       sync.WaitGroup
+	handlers      sync.WaitGroup
+	heartbeat     sync.WaitGroup
+	// listener is the worker's single subscription to its task queues, whose notifications are
+	// forwarded to every poller, so a worker holds at most one listening connection.
+	listener backend.TaskListener
+	// startedAt is the start time the worker registered with, kept when it registers again.
+	startedAt time.Time
+
+	mu       sync.Mutex
+	inFlight map[*workerTask]struct{}
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// workerTask is a run being executed by a handler.
+type workerTask struct {
+	id       string
+	workflow bool
+	// name is the name of the workflow or activity.
+	name string
+	// traceContext is the trace context stored with the run, which the task's span continues.
+	traceContext map[string]string
+	attributes   []attribute.KeyValue
+	// logAttrs identify the run in log records.
+	logAttrs []any
+	execute  func(ctx context.Context) error
+	cancel   context.CancelFunc
+	// lost is set once a renewal found the lease taken away.
+	lost bool
+}
+
+// NewWorker creates a worker executing runs of the engine. Start it with Start and shut it down
+// with Stop.
+func (we *WorkflowEngine) NewWorker(options WorkerOptions) *Worker {
+	if options.ID == "" {
+		options.ID = db.GenerateReadableID()
+	}
+	if len(options.TaskQueues) == 0 {
+		options.TaskQueues = []string{backend.DefaultTaskQueue}
+	}
+	if options.MaxConcurrentWorkflowTasks <= 0 {
+		options.MaxConcurrentWorkflowTasks = defaultMaxConcurrent
+	}
+	if options.MaxConcurrentActivities <= 0 {
+		options.MaxConcurrentActivities = defaultMaxConcurrent
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	if options.HeartbeatInterval <= 0 {
+		options.HeartbeatInterval = we.leases.duration() / 3
+	}
+	return &Worker{
+		engine:   we,
+		options:  options,
+		logger:   we.logger.With(slog.String("worker_id", options.ID)),
+		inFlight: map[*workerTask]struct{}{},
+	}
+}
+
+// ID returns the ID the worker registers with and leases runs to.
+func (w *Worker) ID() string {
+	return w.options.ID
+}
+
+// Start registers the worker and starts claiming and executing runs in the background. ctx only
+// bounds starting; the worker runs until Stop.
+func (w *Worker) Start(ctx context.Context) error {
+	if err := w.register(ctx); err != nil {
+		return err
+	}
+
+	pollers := []struct {
+		enabled       bool
+		maxConcurrent int
+		claim         func(ctx context.Context) (*workerTask, error)
+	}{
+		{w.options.WorkflowTaskHandler != nil, w.options.MaxConcurrentWorkflowTasks, w.claimWorkflowRun},
+		{w.options.ActivityTaskHandler != nil, w.options.MaxConcurrentActivities, w.claimActivityRun},
+	}
+	if w.options.WorkflowTaskHandler != nil || w.options.ActivityTaskHandler != nil {
+		listener, err := w.engine.ListenForTasks(ctx, w.options.TaskQueues...)
+		if err != nil {
+			_ = w.engine.DeregisterWorker(ctx, w.options.ID)
+			return err
+		}
+		w.listener = listener
+	}
+
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	w.handlerCtx, w.stopHandlers = context.WithCancel(context.Background())
+	// A notification wakes up a single receiver, so the pollers listen on a broadcaster fed by the
+	// worker's listener.
+	broadcaster := backend.NewTaskBroadcaster()
+	if w.listener != nil {
+		w.polling.Add(1)
+		go w.forwardTasks(pollCtx, broadcaster)
+	}
+	for _, p := range pollers {
+		if !p.enabled {
+			continue
+		}
+		w.polling.Add(1)
+		go w.poll(pollCtx, broadcaster.Listen(w.options.TaskQueues), p.maxConcurrent, p.claim)
+	}
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	w.stopPolling = stopPolling
+	w.stopHeartbeat = stopHeartbeat
+	w.heartbeat.Add(1)
+	go w.runHeartbeat(heartbeatCtx)
+	w.logger.InfoContext(ctx, "worker started", slog.Any("task_queues", w.options.TaskQueues))
+	return nil
+}
+
+// register adds the worker to the worker registry. Registering again, after the worker was
+// deleted as inactive, keeps the start time of the first registration.
+func (w *Worker) register(ctx context.Context) error {
+	worker := &backend.Worker{ID: w.options.ID, TaskQueues: w.options.TaskQueues, StartedAt: w.startedAt}
+	if err := w.engine.RegisterWorker(ctx, worker); err != nil {
+		return err
+	}
+	w.startedAt = worker.StartedAt
+	return nil
+}
+
+// Stop shuts the worker down. It stops claiming runs and waits for the runs being executed to
+// finish until ctx is done. It then cancels the handlers still running, releases their runs back
+// to pending for other workers, and deregisters the worker. Releasing and deregistering get a
+// few seconds of their own after ctx's deadline.
+//
+// Stop returns an error wrapping ctx's error when it had to release runs. Stopping a worker
+// does not close the engine, which other workers may share; see Shutdown.
+func (w *Worker) Stop(ctx context.Context) error {
+	w.stopOnce.Do(func() {
+		w.stopErr = w.stop(ctx)
+	})
+	return w.stopErr
+}
+
+// Shutdown stops the worker like Stop and then closes its engine with WorkflowEngine.Close,
+// which closes the connection pool created by NewWorkflowEngine. It is meant for processes
+// running a single worker; with several workers, Stop each of them and Close the engine once
+// they all stopped.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	return errors.Join(w.Stop(ctx), w.engine.Close())
+}
+
+func (w *Worker) stop(ctx context.Context) error {
+	if w.stopPolling == nil {
+		return nil
+	}
+	// Handlers still running when Stop returns, e.g. after a failed release, are canceled too.
+	defer w.stopHandlers()
+	w.stopPolling()
+	w.polling.Wait()
+	w.closeListener()
+
+	// Heartbeats keep renewing the leases of the runs being drained.
+	drained := make(chan struct{})
+	go func() {
+		w.handlers.Wait()
+		close(drained)
+	}()
+	var drainErr error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		drainErr = fmt.Errorf("failed to drain worker %s: %w", w.options.ID, ctx.Err())
+	}
+	w.stopHeartbeat()
+	w.heartbeat.Wait()
+
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
+	errs := []error{drainErr}
+	if drainErr != nil {
+		// Handlers returning once their run is no longer tracked leave it to the release here.
+		tasks := w.takeInFlight()
+		w.stopHandlers()
+		if len(tasks) > 0 {
+			w.logger.WarnContext(ctx, "releasing runs still executing at stop", slog.Int("count", len(tasks)))
+		}
+		for _, task := range tasks {
+			if err := w.release(cleanupCtx, task); err != nil && !errors.Is(err, backend.ErrLeaseLost) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	errs = append(errs, w.engine.DeregisterWorker(cleanupCtx, w.options.ID))
+	w.logger.InfoContext(ctx, "worker stopped")
+	return errors.Join(errs...)
+}
+
+func (w *Worker) closeListener() {
+	if w.listener != nil {
+		_ = w.listener.Close()
+		w.listener = nil
+	}
+}
+
+// forwardTasks wakes up the pollers listening on broadcaster whenever the worker's listener
+// fires, until ctx is done.
+func (w *Worker) forwardTasks(ctx context.Context, broadcaster *backend.TaskBroadcaster) {
+	defer w.polling.Done()
+	for {
+		select {
+		case <-w.listener.C():
+			broadcaster.Notify(w.options.TaskQueues...)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll claims runs while fewer than maxConcurrent are executing, until ctx is done.
+func (w *Worker) poll(
+	ctx context.Context,
+	listener backend.TaskListener,
+	maxConcurrent int,
+	claim func(ctx context.Context) (*workerTask, error),
+) {
+	defer w.polling.Done()
+	defer func() {
+		_ = listener.Close()
+	}()
+	slots := make(chan struct{}, maxConcurrent)
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		task, err := claim(ctx)
+		if err != nil || task == nil {
+			<-slots
+			if err != nil && ctx.Err() == nil {
+				w.reportError(err, "failed to claim run")
+			}
+			if backend.WaitForTask(ctx, listener, w.options.PollInterval) != nil {
+				return
+			}
+			continue
+		}
+
+		w.handlers.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+			}()
+			w.execute(task)
+		}()
+	}
+}
+
+func (w *Worker) claimWorkflowRun(ctx context.Context) (*workerTask, error) {
+	run, err := w.engine.ClaimWorkflowRun(ctx, w.options.ID, w.options.TaskQueues...)
+	if err != nil || run == nil {
+		return nil, err
+	}
+	return &workerTask{
+		id:           run.ID,
+		workflow:     true,
+		name:         run.WorkflowName,
+		traceContext: run.TraceContext,
+		logAttrs: []any{
+			slog.String("workflow_run_id", run.ID),
+			slog.String("workflow_name", run.WorkflowName),
+			slog.Int("attempt", run.Attempt),
+		},
+		attributes: []attribute.KeyValue{
+			AttributeWorkflowName.String(run.WorkflowName),
+			AttributeWorkflowRunID.String(run.ID),
+			AttributeAttempt.Int(run.Attempt),
+			AttributeTaskQueue.String(run.TaskQueue),
+		},
+		execute: func(ctx context.Context) error {
+			ctx = workflow.WithInfo(ctx, workflow.Info{
+				WorkflowRunID: run.ID,
+				WorkflowName:  run.WorkflowName,
+				Attempt:       run.Attempt,
+			}, w.engine.logger)
+			return w.options.WorkflowTaskHandler(ctx, run)
+		},
+	}, nil
+}
+
+func (w *Worker) claimActivityRun(ctx context.Context) (*workerTask, error) {
+	run, err := w.engine.ClaimActivityRun(ctx, w.options.ID, w.options.TaskQueues...)
+	if err != nil || run == nil {
+		return nil, err
+	}
+	return &workerTask{
+		id:           run.ID,
+		name:         run.ActivityName,
+		traceContext: run.TraceContext,
+		logAttrs: []any{
+			slog.String("activity_run_id", run.ID),
+			slog.String("activity_name", run.ActivityName),
+			slog.String("workflow_run_id", run.WorkflowRunID),
+			slog.Int("attempt", run.Attempt),
+		},
+		attributes: []attribute.KeyValue{
+			AttributeActivityName.String(run.ActivityName),
+			AttributeActivityRunID.String(run.ID),
+			AttributeWorkflowRunID.String(run.WorkflowRunID),
+			AttributeAttempt.Int(run.Attempt),
+			AttributeTaskQueue.String(run.TaskQueue),
+		},
+		execute: func(ctx context.Context) error {
+			ctx = activity.WithInfo(ctx, activity.Info{
+				ActivityRunID: run.ID,
+				ActivityName:  run.ActivityName,
+				WorkflowRunID: run.WorkflowRunID,
+				Attempt:       run.Attempt,
+			}, w.engine.logger)
+			return w.options.ActivityTaskHandler(ctx, run)
+		},
+	}, nil
+}
+
+func (w *Worker) execute(task *workerTask) {
+	defer w.handlers.Done()
+	ctx, cancel := context.WithCancel(w.handlerCtx)
+	defer cancel()
+	task.cancel = cancel
+
+	w.mu.Lock()
+	w.inFlight[task] = struct{}{}
+	w.mu.Unlock()
+
+	spanName := "RunActivity " + task.name
+	if task.workflow {
+		spanName = "RunWorkflowTask " + task.name
+	}
+	ctx, span := w.engine.startRunSpan(ctx, spanName, task.traceContext,
+		append(task.attributes, AttributeWorkerID.String(w.options.ID))...)
+
+	start := time.Now()
+	err := task.execute(ctx)
+	latency := time.Since(start)
+
+	w.mu.Lock()
+	_, tracked := w.inFlight[task]
+	delete(w.inFlight, task)
+	lost := task.lost
+	w.mu.Unlock()
+
+	outcome := MetricOutcomeFailed
+	switch {
+	case !tracked || lost:
+		// Stop released the run when it stopped tracking it, and a lost run was reset to pending.
+		outcome = MetricOutcomeReleased
+	case err != nil:
+		w.reportError(err, "task handler failed", task.logAttrs...)
+	default:
+		finishCtx, cancelFinish := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancelFinish()
+		finished, err := w.finish(finishCtx, task)
+		if err != nil {
+			w.reportError(err, "failed to finish run", task.logAttrs...)
+			break
+		}
+		outcome = finished
+	}
+	span.SetAttributes(AttributeOutcome.String(outcome))
+	endSpan(span, err)
+	w.recordTask(task, latency, outcome)
+}
+
+// finish releases a run its handler left executing and returns the outcome of the handler:
+// MetricOutcomeReleased, or the status the run was left in.
+func (w *Worker) finish(ctx context.Context, task *workerTask) (string, error) {
+	var outcome string
+	err := w.engine.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var executing bool
+		var leaseOwner *string
+		if task.workflow {
+			run, err := tx.WorkflowRepository().GetWorkflowRun(ctx, task.id)
+			if err != nil || run == nil {
+				return err
+			}
+			outcome, leaseOwner = string(run.Status), run.LeaseOwner
+			executing = run.Status == backend.WorkflowStatusExecuting
+		} else {
+			run, err := tx.ActivityRunRepository().GetActivityRun(ctx, task.id)
+			if err != nil || run == nil {
+				return err
+			}
+			outcome, leaseOwner = string(run.Status), run.LeaseOwner
+			executing = run.Status == backend.ActivityStatusExecuting
+		}
+		// Closed runs, and runs another worker took over, stay as they are.
+		if !executing || leaseOwner == nil || *leaseOwner != w.options.ID {
+			return nil
+		}
+		outcome = MetricOutcomeReleased
+		if task.workflow {
+			return tx.WorkflowRepository().ReleaseWorkflowRunLease(ctx, task.id, w.options.ID)
+		}
+		return tx.ActivityRunRepository().ReleaseActivityRunLease(ctx, task.id, w.options.ID)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to finish run %s: %w", task.id, err)
+	}
+	return outcome, nil
+}
+
+// recordTask records the metrics of a handler that returned after latency.
+func (w *Worker) recordTask(task *workerTask, latency time.Duration, outcome string) {
+	handler := w.engine.metrics.handler()
+	if task.workflow {
+		handler.Histogram(MetricWorkflowTaskLatency, map[string]string{
+			MetricLabelWorkflowName: task.name,
+		}, latency.Seconds())
+		switch backend.WorkflowStatus(outcome) {
+		case backend.WorkflowStatusFinished, backend.WorkflowStatusFailed, backend.WorkflowStatusAborted:
+			handler.Counter(MetricWorkflowRunsClosed, map[string]string{
+				MetricLabelWorkflowName: task.name,
+				MetricLabelStatus:       outcome,
+			}, 1)
+		}
+		return
+	}
+
+	switch backend.ActivityStatus(outcome) {
+	case backend.ActivityStatusFinished:
+		outcome = MetricOutcomeCompleted
+	case backend.ActivityStatusFailed:
+		outcome = MetricOutcomeFailed
+	}
+	handler.Histogram(MetricActivityLatency, map[string]string{
+		MetricLabelActivityName: task.name,
+	}, latency.Seconds())
+	handler.Counter(MetricActivityAttempts, map[string]string{
+		MetricLabelActivityName: task.name,
+		MetricLabelOutcome:      outcome,
+	}, 1)
+}
+
+// takeInFlight stops tracking the runs being executed and returns those still leased.
+func (w *Worker) takeInFlight() []*workerTask {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var tasks []*workerTask
+	for task := range w.inFlight {
+		if !task.lost {
+			tasks = append(tasks, task)
+		}
+		delete(w.inFlight, task)
+	}
+	return tasks
+}
+
+func (w *Worker) release(ctx context.Context, task *workerTask) error {
+	if task.workflow {
+		return w.engine.ReleaseWorkflowRunLease(ctx, task.id, w.options.ID)
+	}
+	return w.engine.ReleaseActivityRunLease(ctx, task.id, w.options.ID)
+}
+
+func (w *Worker) runHeartbeat(ctx context.Context) {
+	defer w.heartbeat.Done()
+	ticker := time.NewTicker(w.options.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.recordHeartbeat(ctx)
+	}
+}
+
+// recordHeartbeat marks the worker as alive and renews the leases of the runs it executes,
+// canceling the handlers of runs whose lease was lost.
+func (w *Worker) recordHeartbeat(ctx context.Context) {
+	err := w.engine.RecordWorkerHeartbeat(ctx, w.options.ID)
+	if errors.Is(err, backend.ErrWorkerNotFound) {
+		// The worker was deleted as inactive, e.g. after the process was paused.
+		err = w.register(ctx)
+	}
+	if err != nil && ctx.Err() == nil {
+		w.reportError(err, "failed to record heartbeat")
+	}
+
+	w.mu.Lock()
+	tasks := make([]*workerTask, 0, len(w.inFlight))
+	for task := range w.inFlight {
+		tasks = append(tasks, task)
+	}
+	w.mu.Unlock()
+
+	for _, task := range tasks {
+		var err error
+		if task.workflow {
+			err = w.engine.RenewWorkflowRunLease(ctx, task.id, w.options.ID)
+		} else {
+			err = w.engine.RenewActivityRunLease(ctx, task.id, w.options.ID)
+		}
+		if errors.Is(err, backend.ErrLeaseLost) {
+			w.mu.Lock()
+			task.lost = true
+			w.mu.Unlock()
+			w.logger.WarnContext(ctx, "lost lease of run", task.logAttrs...)
+			task.cancel()
+			continue
+		}
+		if err != nil && ctx.Err() == nil {
+			w.reportError(err, "failed to renew lease", task.logAttrs...)
+		}
+	}
+}
+
+// reportError logs err with msg and args, and passes it to OnError.
+func (w *Worker) reportError(err error, msg string, args ...any) {
+	w.logger.Error(msg, append(args, slog.Any("error", err))...)
+	if w.options.OnError != nil {
+		w.options.OnError(err)
+	}
+}
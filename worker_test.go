@@ -0,0 +1,218 @@
+package pitlane_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/activity"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/workflow"
+	"github.com/stretchr/testify/require"
+)
+
+func getWorkflowRun(
+	ctx context.Context,
+	t *testing.T,
+	b backend.Backend,
+	workflowRunID string,
+) *backend.WorkflowRun {
+	t.Helper()
+	var run *backend.WorkflowRun
+	require.NoError(t, b.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		run, err = tx.WorkflowRepository().GetWorkflowRun(ctx, workflowRunID)
+		return err
+	}))
+	require.NotNil(t, run)
+	return run
+}
+
+func TestWorker_Stop(t *testing.T) {
+	ctx := context.Background()
+	we, b := newMemoryEngine(t, nil)
+
+	t.Run("drains runs being executed", func(t *testing.T) {
+		options := pitlane.StartWorkflowOptions{TaskQueue: "drained"}
+		workflowRunID, err := we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order-1")
+		require.NoError(t, err)
+
+		started := make(chan struct{})
+		worker := we.NewWorker(pitlane.WorkerOptions{
+			TaskQueues:   []string{"drained"},
+			PollInterval: 10 * time.Millisecond,
+			WorkflowTaskHandler: func(ctx context.Context, run *backend.WorkflowRun) error {
+				close(started)
+				time.Sleep(20 * time.Millisecond)
+				return b.RunInTx(ctx, func(tx backend.Tx) error {
+					return tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, run.ID, backend.WorkflowStatusFinished)
+				})
+			},
+		})
+		require.NoError(t, worker.Start(ctx))
+		<-started
+		require.NoError(t, worker.Stop(ctx))
+
+		run := getWorkflowRun(ctx, t, b, workflowRunID)
+		require.Equal(t, backend.WorkflowStatusFinished, run.Status)
+
+		workers, err := we.ListWorkers(ctx)
+		require.NoError(t, err)
+		require.Empty(t, workers)
+	})
+
+	t.Run("releases runs unfinished at the deadline", func(t *testing.T) {
+		options := pitlane.StartWorkflowOptions{TaskQueue: "released"}
+		workflowRunID, err := we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order-2")
+		require.NoError(t, err)
+
+		var canceled atomic.Bool
+		started := make(chan struct{})
+		worker := we.NewWorker(pitlane.WorkerOptions{
+			TaskQueues:   []string{"released"},
+			PollInterval: 10 * time.Millisecond,
+			WorkflowTaskHandler: func(ctx context.Context, _ *backend.WorkflowRun) error {
+				close(started)
+				<-ctx.Done()
+				canceled.Store(true)
+				return ctx.Err()
+			},
+		})
+		require.NoError(t, worker.Start(ctx))
+		<-started
+
+		stopCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, worker.Stop(stopCtx), context.DeadlineExceeded)
+		require.Eventually(t, canceled.Load, time.Second, 5*time.Millisecond)
+
+		run := getWorkflowRun(ctx, t, b, workflowRunID)
+		require.Equal(t, backend.WorkflowStatusPending, run.Status)
+		require.Equal(t, 1, run.Attempt)
+		require.Nil(t, run.LeaseOwner)
+	})
+}
+
+func TestWorker_Logger(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	we, b := newMemoryEngine(t, func(config *pitlane.EngineConfig) {
+		config.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	})
+
+	runID, err := we.InvokeWorkflowWithOptions(ctx, pitlane.StartWorkflowOptions{TaskQueue: "logged"},
+		OrderWorkflow, "order-1")
+	require.NoError(t, err)
+
+	var info activity.Info
+	var workflowInfo workflow.Info
+	activityDone := make(chan struct{})
+	worker := we.NewWorker(pitlane.WorkerOptions{
+		ID:           "logged-worker",
+		TaskQueues:   []string{"logged"},
+		PollInterval: 10 * time.Millisecond,
+		WorkflowTaskHandler: func(ctx context.Context, run *backend.WorkflowRun) error {
+			var err error
+			workflowInfo, err = workflow.GetInfo(ctx)
+			if err != nil {
+				return err
+			}
+			workflow.GetLogger(ctx).Info("executing order")
+			return b.RunInTx(ctx, func(tx backend.Tx) error {
+				now := time.Now()
+				err := tx.ActivityRunRepository().CreateActivityRun(ctx, &backend.ActivityRun{
+					ID:            "logged-activity",
+					ActivityName:  "charge",
+					WorkflowRunID: run.ID,
+					Status:        backend.ActivityStatusPending,
+					ScheduledAt:   now,
+					CreatedAt:     now,
+					UpdatedAt:     now,
+				})
+				if err != nil {
+					return err
+				}
+				return tx.WorkflowRepository().ChangeWorkflowRunStatus(ctx, run.ID, backend.WorkflowStatusFinished)
+			})
+		},
+		ActivityTaskHandler: func(ctx context.Context, _ *backend.ActivityRun) error {
+			defer close(activityDone)
+			info, _ = activity.GetInfo(ctx)
+			activity.GetLogger(ctx).Info("charging card")
+			return errors.New("card declined")
+		},
+	})
+	require.NoError(t, worker.Start(ctx))
+	<-activityDone
+	require.NoError(t, worker.Stop(ctx))
+
+	require.Equal(t, activity.Info{
+		ActivityRunID: "logged-activity",
+		ActivityName:  "charge",
+		WorkflowRunID: runID,
+		Attempt:       1,
+	}, info)
+	require.Equal(t, workflow.Info{
+		WorkflowRunID: runID,
+		WorkflowName:  "github.com/nurburg-dev/pitlane_test.OrderWorkflow",
+		Attempt:       1,
+	}, workflowInfo)
+
+	records := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records[record["msg"].(string)] = record
+	}
+	require.Contains(t, records, "worker started")
+	require.Contains(t, records, "worker stopped")
+	require.Contains(t, records, "executing order")
+	require.Equal(t, runID, records["executing order"]["workflow_run_id"])
+	require.Equal(t, "github.com/nurburg-dev/pitlane_test.OrderWorkflow", records["executing order"]["workflow_name"])
+	require.InDelta(t, 1, records["executing order"]["attempt"], 0)
+	require.Contains(t, records, "charging card")
+	require.Equal(t, "logged-activity", records["charging card"]["activity_run_id"])
+	require.Equal(t, runID, records["charging card"]["workflow_run_id"])
+	require.Contains(t, records, "task handler failed")
+	failed := records["task handler failed"]
+	require.Equal(t, "logged-worker", failed["worker_id"])
+	require.Equal(t, "logged-activity", failed["activity_run_id"])
+	require.Equal(t, "card declined", failed["error"])
+}
+
+func TestWorker_Heartbeat(t *testing.T) {
+	ctx := context.Background()
+	we, _ := newMemoryEngine(t, nil)
+
+	worker := we.NewWorker(pitlane.WorkerOptions{
+		TaskQueues:        []string{"heartbeat"},
+		HeartbeatInterval: 10 * time.Millisecond,
+		WorkflowTaskHandler: func(context.Context, *backend.WorkflowRun) error {
+			return nil
+		},
+	})
+	require.NoError(t, worker.Start(ctx))
+	defer func() {
+		require.NoError(t, worker.Stop(ctx))
+	}()
+	workers, err := we.ListWorkers(ctx)
+	require.NoError(t, err)
+	require.Len(t, workers, 1)
+	startedAt := workers[0].StartedAt
+
+	// A worker deleted as inactive registers again on its next heartbeat, with its start time.
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, we.DeregisterWorker(ctx, worker.ID()))
+	require.Eventually(t, func() bool {
+		workers, err = we.ListWorkers(ctx)
+		return err == nil && len(workers) == 1
+	}, time.Second, 5*time.Millisecond)
+	require.True(t, startedAt.Equal(workers[0].StartedAt))
+}
@@ -0,0 +1,141 @@
+package pitlane
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/internal/db"
+)
+
+// RegisterWorker records a worker process in the worker registry, replacing a worker with the
+// same ID. Empty fields are filled in: the ID with a generated one, Hostname with os.Hostname,
+// TaskQueues with backend.DefaultTaskQueue, Workflows and Activities with the functions
+// registered with RegisterWorkflow and RegisterActivity, BuildVersion with the VCS revision or
+// module version of the binary, and StartedAt and LastHeartbeatAt with the current time.
+func (we *WorkflowEngine) RegisterWorker(ctx context.Context, worker *backend.Worker) error {
+	now := time.Now()
+	if worker.ID == "" {
+		worker.ID = db.GenerateReadableID()
+	}
+	if worker.Hostname == "" {
+		worker.Hostname, _ = os.Hostname()
+	}
+	if len(worker.TaskQueues) == 0 {
+		worker.TaskQueues = []string{backend.DefaultTaskQueue}
+	}
+	if worker.Workflows == nil {
+		worker.Workflows = slices.Sorted(maps.Keys(GetWorkflowStore()))
+	}
+	if worker.Activities == nil {
+		worker.Activities = slices.Sorted(maps.Keys(GetActivityStore()))
+	}
+	if worker.BuildVersion == "" {
+		worker.BuildVersion = buildVersion()
+	}
+	if worker.StartedAt.IsZero() {
+		worker.StartedAt = now
+	}
+	if worker.LastHeartbeatAt.IsZero() {
+		worker.LastHeartbeatAt = now
+	}
+
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkerRepository().RegisterWorker(ctx, worker)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register worker %s: %w", worker.ID, err)
+	}
+	return nil
+}
+
+// buildVersion identifies the running binary by its VCS revision, or by its module version
+// when it was built without VCS information.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
+
+// RecordWorkerHeartbeat marks a registered worker as alive. It returns an error matching
+// backend.ErrWorkerNotFound when the worker was deleted, in which case it should register again.
+func (we *WorkflowEngine) RecordWorkerHeartbeat(ctx context.Context, workerID string) error {
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkerRepository().RecordWorkerHeartbeat(ctx, workerID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record heartbeat of worker %s: %w", workerID, err)
+	}
+	return nil
+}
+
+// DeregisterWorker removes a worker from the registry, e.g. when it shuts down.
+func (we *WorkflowEngine) DeregisterWorker(ctx context.Context, workerID string) error {
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkerRepository().DeregisterWorker(ctx, workerID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deregister worker %s: %w", workerID, err)
+	}
+	return nil
+}
+
+// ListWorkers returns the registered workers ordered by ID, including those that stopped
+// heartbeating without deregistering.
+func (we *WorkflowEngine) ListWorkers(ctx context.Context) ([]backend.Worker, error) {
+	var workers []backend.Worker
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		workers, err = tx.WorkerRepository().ListWorkers(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	return workers, nil
+}
+
+// ListTaskQueues returns the task queues that have pending runs or registered workers, with the
+// number of workers polling them that recorded a heartbeat within aliveWithin.
+func (we *WorkflowEngine) ListTaskQueues(
+	ctx context.Context,
+	aliveWithin time.Duration,
+) ([]backend.TaskQueueInfo, error) {
+	var queues []backend.TaskQueueInfo
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		queues, err = tx.WorkerRepository().ListTaskQueues(ctx, aliveWithin)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task queues: %w", err)
+	}
+	return queues, nil
+}
+
+// DeleteInactiveWorkers removes the workers that recorded no heartbeat within aliveWithin and
+// returns how many it removed.
+func (we *WorkflowEngine) DeleteInactiveWorkers(ctx context.Context, aliveWithin time.Duration) (int64, error) {
+	var deleted int64
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		var err error
+		deleted, err = tx.WorkerRepository().DeleteInactiveWorkers(ctx, aliveWithin)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete inactive workers: %w", err)
+	}
+	return deleted, nil
+}
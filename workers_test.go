@@ -0,0 +1,49 @@
+package pitlane_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterWorker(t *testing.T) {
+	ctx := context.Background()
+	we, _ := newMemoryEngine(t, nil)
+
+	options := pitlane.StartWorkflowOptions{TaskQueue: "workers"}
+	_, err := we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order-1")
+	require.NoError(t, err)
+
+	worker := &backend.Worker{TaskQueues: []string{"workers"}}
+	require.NoError(t, we.RegisterWorker(ctx, worker))
+	require.NotEmpty(t, worker.ID)
+	require.NotEmpty(t, worker.Hostname)
+	require.NotEmpty(t, worker.BuildVersion)
+	require.Contains(t, worker.Workflows, "github.com/nurburg-dev/pitlane_test.OrderWorkflow")
+	require.False(t, worker.LastHeartbeatAt.IsZero())
+
+	workers, err := we.ListWorkers(ctx)
+	require.NoError(t, err)
+	require.Len(t, workers, 1)
+	require.Equal(t, worker.ID, workers[0].ID)
+
+	queues, err := we.ListTaskQueues(ctx, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, queues, 1)
+	require.NotNil(t, queues[0].OldestPendingScheduledAt)
+	require.Equal(t, 1, queues[0].Pollers)
+	require.Equal(t, int64(1), queues[0].PendingWorkflowRuns)
+
+	require.NoError(t, we.RecordWorkerHeartbeat(ctx, worker.ID))
+	require.NoError(t, we.DeregisterWorker(ctx, worker.ID))
+	require.ErrorIs(t, we.RecordWorkerHeartbeat(ctx, worker.ID), backend.ErrWorkerNotFound)
+
+	queues, err = we.ListTaskQueues(ctx, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, queues, 1)
+	require.Zero(t, queues[0].Pollers)
+}
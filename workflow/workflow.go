@@ -0,0 +1,268 @@
+// Package workflow is the API available to code running inside a workflow function.
+//
+// Every call is dispatched to the Environment stored in the workflow's context, which is provided
+// by the runtime executing the workflow, such as pitlanetest.TestWorkflowEnvironment. Workflow code
+// must use these functions instead of time.Now, time.Sleep and goroutines so that the runtime can
+// control time and scheduling.
+package workflow
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nurburg-dev/pitlane/internal/utils"
+)
+
+// ErrNotInWorkflow is returned when ctx does not belong to a running workflow.
+var ErrNotInWorkflow = errors.New("not running inside a workflow")
+
+// Environment executes workflow API calls on behalf of a workflow run.
+type Environment interface {
+	// ExecuteActivity runs activityFunction with args and decodes its result into resultPtr.
+	ExecuteActivity(ctx context.Context, activityFunction any, args []any, resultPtr any) error
+	Now(ctx context.Context) time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+	// ReceiveSignal blocks until a signal called name is received and decodes it into valuePtr.
+	ReceiveSignal(ctx context.Context, name string, valuePtr any) error
+	// Go runs fn concurrently with the calling workflow code.
+	Go(ctx context.Context, fn func(ctx context.Context))
+	// UpsertSearchAttributes merges attributes into the search attributes of the run.
+	UpsertSearchAttributes(ctx context.Context, attributes map[string]any) error
+	// UpsertMemo merges memo into the memo of the run.
+	UpsertMemo(ctx context.Context, memo map[string]any) error
+	// GetInfo describes the run being executed.
+	GetInfo(ctx context.Context) Info
+	// IsReplaying reports whether the workflow is re-executing recorded history, whose side effects
+	// already happened.
+	IsReplaying(ctx context.Context) bool
+	// Logger returns the logger the runtime logs workflow code to.
+	Logger(ctx context.Context) *slog.Logger
+}
+
+// Info describes the workflow run being executed.
+type Info struct {
+	WorkflowRunID string
+	WorkflowName  string
+	// Attempt is 1 for the first execution of the run and incremented for each retry.
+	Attempt int
+}
+
+type environmentKey struct{}
+
+type infoKey struct{}
+
+type infoContext struct {
+	info   Info
+	logger *slog.Logger
+}
+
+// WithInfo returns a context describing the workflow run of info, whose logger logs to logger, or
+// to slog.Default when it is nil. It is meant for runtimes handing a claimed run to code that
+// executes it; an Environment stored in the context takes precedence over both.
+func WithInfo(ctx context.Context, info Info, logger *slog.Logger) context.Context {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return context.WithValue(ctx, infoKey{}, &infoContext{
+		info: info,
+		logger: logger.With(
+			slog.String("workflow_run_id", info.WorkflowRunID),
+			slog.String("workflow_name", info.WorkflowName),
+			slog.Int("attempt", info.Attempt),
+		),
+	})
+}
+
+// WithEnvironment returns a workflow context dispatching to env. It is meant for runtimes
+// executing workflow functions.
+func WithEnvironment(ctx context.Context, env Environment) context.Context {
+	return context.WithValue(ctx, environmentKey{}, env)
+}
+
+func getEnvironment(ctx context.Context) (Environment, error) {
+	env, ok := ctx.Value(environmentKey{}).(Environment)
+	if !ok {
+		return nil, ErrNotInWorkflow
+	}
+	return env, nil
+}
+
+// ExecuteActivity runs an activity and decodes its result into resultPtr. Prefer the typed
+// ExecuteActivityN functions, which check argument types at compile time.
+func ExecuteActivity(ctx context.Context, activityFunction any, resultPtr any, args ...any) error {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	if err := utils.ValidateArgs(activityFunction, args...); err != nil {
+		return err
+	}
+	return env.ExecuteActivity(ctx, activityFunction, args, resultPtr)
+}
+
+func executeActivity[R any](ctx context.Context, activityFunction any, args ...any) (R, error) {
+	var result R
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return result, err
+	}
+	err = env.ExecuteActivity(ctx, activityFunction, args, &result)
+	return result, err
+}
+
+// ExecuteActivity0 runs an activity that takes no arguments besides the context.
+func ExecuteActivity0[R any](ctx context.Context, activityFunction func(context.Context) (R, error)) (R, error) {
+	return executeActivity[R](ctx, activityFunction)
+}
+
+// ExecuteActivity1 runs an activity that takes a single argument.
+func ExecuteActivity1[A, R any](
+	ctx context.Context,
+	activityFunction func(context.Context, A) (R, error),
+	a A,
+) (R, error) {
+	return executeActivity[R](ctx, activityFunction, a)
+}
+
+// ExecuteActivity2 runs an activity that takes two arguments.
+func ExecuteActivity2[A, B, R any](
+	ctx context.Context,
+	activityFunction func(context.Context, A, B) (R, error),
+	a A,
+	b B,
+) (R, error) {
+	return executeActivity[R](ctx, activityFunction, a, b)
+}
+
+// ExecuteActivity3 runs an activity that takes three arguments.
+func ExecuteActivity3[A, B, C, R any](
+	ctx context.Context,
+	activityFunction func(context.Context, A, B, C) (R, error),
+	a A,
+	b B,
+	c C,
+) (R, error) {
+	return executeActivity[R](ctx, activityFunction, a, b, c)
+}
+
+// Now returns the workflow's current time, or the wall clock time outside a workflow.
+func Now(ctx context.Context) time.Time {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return time.Now()
+	}
+	return env.Now(ctx)
+}
+
+// Sleep blocks the workflow for d, as a durable timer.
+func Sleep(ctx context.Context, d time.Duration) error {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	return env.Sleep(ctx, d)
+}
+
+// ReceiveSignal blocks until a signal called name is received and decodes it into valuePtr.
+func ReceiveSignal(ctx context.Context, name string, valuePtr any) error {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	return env.ReceiveSignal(ctx, name, valuePtr)
+}
+
+// Go runs fn concurrently with the calling workflow code, under the runtime's scheduling.
+func Go(ctx context.Context, fn func(ctx context.Context)) error {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	env.Go(ctx, fn)
+	return nil
+}
+
+// UpsertSearchAttributes adds or replaces search attributes of the workflow run, which can then
+// be used to filter it with ListWorkflowRuns. See backend.NormalizeSearchAttributes for the
+// supported keys and values.
+func UpsertSearchAttributes(ctx context.Context, attributes map[string]any) error {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	return env.UpsertSearchAttributes(ctx, attributes)
+}
+
+// UpsertMemo adds or replaces memo values of the workflow run. Memo values are encoded with the
+// data converter and returned with the run, but cannot be filtered on.
+func UpsertMemo(ctx context.Context, memo map[string]any) error {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	return env.UpsertMemo(ctx, memo)
+}
+
+// GetInfo describes the workflow run of ctx, or returns ErrNotInWorkflow.
+func GetInfo(ctx context.Context) (Info, error) {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		if workflow, ok := ctx.Value(infoKey{}).(*infoContext); ok {
+			return workflow.info, nil
+		}
+		return Info{}, err
+	}
+	return env.GetInfo(ctx), nil
+}
+
+// IsReplaying reports whether the workflow is re-executing recorded history. Code with side
+// effects outside of activities, such as metrics, can skip them while it is.
+func IsReplaying(ctx context.Context) bool {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		return false
+	}
+	return env.IsReplaying(ctx)
+}
+
+// GetLogger returns a logger with the run ID, workflow name and attempt of the workflow run. It
+// drops records while the workflow is replaying, so that replays do not log the same lines again.
+// Without an Environment it returns the logger set by WithInfo, and outside a workflow
+// slog.Default.
+func GetLogger(ctx context.Context) *slog.Logger {
+	env, err := getEnvironment(ctx)
+	if err != nil {
+		if workflow, ok := ctx.Value(infoKey{}).(*infoContext); ok {
+			return workflow.logger
+		}
+		return slog.Default()
+	}
+	info := env.GetInfo(ctx)
+	handler := &replayAwareHandler{Handler: env.Logger(ctx).Handler(), ctx: ctx, env: env}
+	return slog.New(handler).With(
+		slog.String("workflow_run_id", info.WorkflowRunID),
+		slog.String("workflow_name", info.WorkflowName),
+		slog.Int("attempt", info.Attempt),
+	)
+}
+
+// replayAwareHandler disables its handler while the workflow of ctx is replaying.
+type replayAwareHandler struct {
+	slog.Handler
+	ctx context.Context
+	env Environment
+}
+
+func (h *replayAwareHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return !h.env.IsReplaying(h.ctx) && h.Handler.Enabled(ctx, level)
+}
+
+func (h *replayAwareHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &replayAwareHandler{Handler: h.Handler.WithAttrs(attrs), ctx: h.ctx, env: h.env}
+}
+
+func (h *replayAwareHandler) WithGroup(name string) slog.Handler {
+	return &replayAwareHandler{Handler: h.Handler.WithGroup(name), ctx: h.ctx, env: h.env}
+}
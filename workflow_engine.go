@@ -2,52 +2,148 @@ package pitlane
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/backend/postgres"
+	"github.com/nurburg-dev/pitlane/converter"
 	"github.com/nurburg-dev/pitlane/internal/db"
-	"github.com/nurburg-dev/pitlane/internal/dbrepo"
 	"github.com/nurburg-dev/pitlane/internal/entities"
 	"github.com/nurburg-dev/pitlane/internal/utils"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WorkflowEngine struct {
-	pgPool *pgxpool.Pool
+	backend           backend.Backend
+	dataConverter     converter.DataConverter
+	payloadSizeLimits PayloadSizeLimits
+	retention         RetentionConfig
+	leases            LeaseConfig
+	metrics           MetricsConfig
+	taskQueueGauges   taskQueueGauges
+	tracer            trace.Tracer
+	propagator        propagation.TextMapPropagator
+	logger            *slog.Logger
+	// ownedPool is the pool NewWorkflowEngine created, which Close closes.
+	ownedPool *pgxpool.Pool
 }
 
 func NewWorkflowEngine(ctx context.Context, config *EngineConfig) (*WorkflowEngine, error) {
-	connString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		config.DBConfig.Username,
-		config.DBConfig.Password,
-		config.DBConfig.Host,
-		config.DBConfig.Port,
-		config.DBConfig.Database,
-	)
+	poolConfig, err := config.DBConfig.poolConfig()
+	if err != nil {
+		return nil, err
+	}
 
-	pgPool, err := pgxpool.New(ctx, connString)
+	pgPool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create connection pool: %w", err)
 	}
+	we, err := NewWorkflowEngineWithPool(ctx, pgPool, config)
+	if err != nil {
+		pgPool.Close()
+		return nil, err
+	}
+	we.ownedPool = pgPool
+	return we, nil
+}
+
+// NewWorkflowEngineWithPool creates an engine on an existing pool, e.g. one shared with the
+// application and its instrumentation. config.DBConfig is ignored.
+func NewWorkflowEngineWithPool(
+	ctx context.Context,
+	pgPool *pgxpool.Pool,
+	config *EngineConfig,
+) (*WorkflowEngine, error) {
+	pgBackend := postgres.New(pgPool, config.SchemaConfig.Schema, config.SchemaConfig.TablePrefix)
+	return NewWorkflowEngineWithBackend(ctx, pgBackend, config)
+}
+
+// NewWorkflowEngineWithBackend creates an engine storing its state in b. config.DBConfig and
+// config.SchemaConfig are ignored.
+func NewWorkflowEngineWithBackend(
+	ctx context.Context,
+	b backend.Backend,
+	config *EngineConfig,
+) (*WorkflowEngine, error) {
+	dataConverter := config.DataConverter
+	if dataConverter == nil {
+		dataConverter = converter.GetDefaultDataConverter()
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
 	we := &WorkflowEngine{
-		pgPool: pgPool,
+		backend:           b,
+		dataConverter:     dataConverter,
+		payloadSizeLimits: config.PayloadSizeLimits,
+		retention:         config.Retention,
+		leases:            config.Leases,
+		metrics:           config.Metrics,
+		tracer:            config.Tracing.tracer(),
+		propagator:        config.Tracing.propagator(),
+		logger:            logger,
 	}
-	if config.InitDB {
-		err := we.initializeDB(ctx)
-		if err != nil {
-			return nil, err
-		}
+	if err := we.initializeDB(ctx, config.InitDB); err != nil {
+		return nil, err
 	}
 	return we, nil
 }
 
-func (we *WorkflowEngine) initializeDB(ctx context.Context) error {
-	dbInitiator := db.NewPGInitiator(we.pgPool)
-	return dbInitiator.Init(ctx)
+// Close closes the connection pool of an engine created by NewWorkflowEngine. Pools passed to
+// NewWorkflowEngineWithPool and backends passed to NewWorkflowEngineWithBackend belong to the
+// caller and are left open. Stop the workers of the engine before closing it; calling Close
+// more than once is safe.
+func (we *WorkflowEngine) Close() error {
+	if we.ownedPool != nil {
+		we.ownedPool.Close()
+	}
+	return nil
+}
+
+// initializeDB migrates the schema when initDB is set and otherwise only checks that it is up to date.
+func (we *WorkflowEngine) initializeDB(ctx context.Context, initDB bool) error {
+	if initDB {
+		if err := we.backend.Init(ctx); err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+		return nil
+	}
+	return we.backend.Verify(ctx)
+}
+
+// StartWorkflowOptions sets optional attributes of a new workflow run.
+type StartWorkflowOptions struct {
+	// Labels are stored with the run for filtering in ListWorkflowRuns.
+	Labels map[string]string
+	// ParentWorkflowRunID links the run to the run that started it.
+	ParentWorkflowRunID string
+	// SearchAttributes are indexed values for filtering in ListWorkflowRuns, such as a customer
+	// ID or an order total. See backend.NormalizeSearchAttributes for the supported values.
+	SearchAttributes map[string]any
+	// Memo holds values returned with the run, encoded with the data converter. They are not
+	// indexed; read them with DecodeMemoValue.
+	Memo map[string]any
+	// TaskQueue is the queue of workers the run and, by default, its activities are dispatched
+	// to. It defaults to backend.DefaultTaskQueue.
+	TaskQueue string
 }
 
 func (we *WorkflowEngine) InvokeWorkflow(ctx context.Context, workflowFunction any, args ...any) (string, error) {
+	return we.InvokeWorkflowWithOptions(ctx, StartWorkflowOptions{}, workflowFunction, args...)
+}
+
+// InvokeWorkflowWithOptions starts a workflow run like InvokeWorkflow, with the given options.
+func (we *WorkflowEngine) InvokeWorkflowWithOptions(
+	ctx context.Context,
+	options StartWorkflowOptions,
+	workflowFunction any,
+	args ...any,
+) (string, error) {
 	workflowFuncName, err := utils.GetFunctionName(workflowFunction)
 	if err != nil {
 		return "", fmt.Errorf("failed to get workflow function name: %w", err)
@@ -61,54 +157,165 @@ func (we *WorkflowEngine) InvokeWorkflow(ctx context.Context, workflowFunction a
 		return "", err2
 	}
 
-	inputBytes, err := json.Marshal(args)
+	return we.createWorkflowRun(ctx, workflowFuncName, args, options)
+}
+
+func (we *WorkflowEngine) createWorkflowRun(
+	ctx context.Context,
+	workflowFuncName string,
+	args []any,
+	options StartWorkflowOptions,
+) (workflowRunID string, err error) {
+	taskQueue := options.TaskQueue
+	if taskQueue == "" {
+		taskQueue = backend.DefaultTaskQueue
+	}
+	ctx, span := we.tracer.Start(ctx, "InvokeWorkflow "+workflowFuncName,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(AttributeWorkflowName.String(workflowFuncName), AttributeTaskQueue.String(taskQueue)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	inputBytes, err := we.encodePayloads("workflow input", args...)
+	if err != nil {
+		return "", err
+	}
+	searchAttributes, err := backend.NormalizeSearchAttributes(options.SearchAttributes)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal workflow input: %w", err)
+		return "", err
+	}
+	var memo []byte
+	if len(options.Memo) > 0 {
+		if memo, err = converter.EncodeMemo(we.dataConverter, options.Memo); err != nil {
+			return "", err
+		}
 	}
 	now := time.Now()
 
-	tx, err := we.pgPool.Begin(ctx)
+	workflowRunID = db.GenerateReadableID()
+	span.SetAttributes(AttributeWorkflowRunID.String(workflowRunID))
+	traceContext := we.injectTraceContext(ctx)
+	err = we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		workflowRepo := tx.WorkflowRepository()
+
+		err := workflowRepo.UpsertWorkflow(
+			ctx,
+			&entities.DBWorkflow{
+				Name:      workflowFuncName,
+				CreatedAt: now,
+				UpdatedAt: now,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to upsert workflow: %w", err)
+		}
+
+		workflowRun := &entities.DBWorkflowRun{
+			ID:               workflowRunID,
+			Input:            inputBytes,
+			WorkflowName:     workflowFuncName,
+			Status:           entities.WorkflowStatusPending,
+			ScheduledAt:      now,
+			CreatedAt:        now,
+			UpdatedAt:        now,
+			Labels:           options.Labels,
+			SearchAttributes: searchAttributes,
+			Memo:             memo,
+			TaskQueue:        options.TaskQueue,
+			TraceContext:     traceContext,
+		}
+		if options.ParentWorkflowRunID != "" {
+			workflowRun.ParentWorkflowRunID = &options.ParentWorkflowRunID
+		}
+
+		err = workflowRepo.CreateWorkflowRun(ctx, workflowRun)
+		if err != nil {
+			return fmt.Errorf("failed to create workflow run: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to begin transaction: %w", err)
+		return "", err
 	}
-	defer func() {
-		_ = tx.Rollback(ctx)
-	}()
 
-	workflowRepo := dbrepo.NewPGWorkflowRepository(tx)
-
-	err = workflowRepo.UpsertWorkflow(
-		ctx,
-		&entities.DBWorkflow{
-			Name:      workflowFuncName,
-			CreatedAt: now,
-			UpdatedAt: now,
-		},
+	we.logger.DebugContext(ctx, "workflow run created",
+		slog.String("workflow_run_id", workflowRunID),
+		slog.String("workflow_name", workflowFuncName),
+		slog.String("task_queue", taskQueue),
 	)
+	we.metrics.handler().Counter(MetricWorkflowRunsStarted, map[string]string{
+		MetricLabelWorkflowName: workflowFuncName,
+		MetricLabelTaskQueue:    taskQueue,
+	}, 1)
+	return workflowRunID, nil
+}
+
+// ListenForTasks returns a listener firing when runs are created on taskQueues, for workers to
+// wait on with backend.WaitForTask when they found nothing to claim. On backends that do not
+// implement backend.TaskNotifier it never fires, and workers only poll.
+func (we *WorkflowEngine) ListenForTasks(ctx context.Context, taskQueues ...string) (backend.TaskListener, error) {
+	listener, err := backend.ListenForTasks(ctx, we.backend, taskQueues)
 	if err != nil {
-		return "", fmt.Errorf("failed to upsert workflow: %w", err)
+		return nil, fmt.Errorf("failed to listen for tasks: %w", err)
 	}
+	return listener, nil
+}
 
-	workflowRunID := db.GenerateReadableID()
-	workflowRun := &entities.DBWorkflowRun{
-		ID:           workflowRunID,
-		Input:        inputBytes,
-		WorkflowName: workflowFuncName,
-		Status:       entities.WorkflowStatusPending,
-		ScheduledAt:  now,
-		CreatedAt:    now,
-		UpdatedAt:    now,
+// UpsertSearchAttributes adds or replaces search attributes of a workflow run.
+func (we *WorkflowEngine) UpsertSearchAttributes(
+	ctx context.Context,
+	workflowRunID string,
+	attributes map[string]any,
+) error {
+	normalized, err := backend.NormalizeSearchAttributes(attributes)
+	if err != nil {
+		return err
 	}
+	return we.updateWorkflowRunAttributes(ctx, workflowRunID, normalized, nil)
+}
 
-	err = workflowRepo.CreateWorkflowRun(ctx, workflowRun)
+// UpsertMemo adds or replaces memo values of a workflow run.
+func (we *WorkflowEngine) UpsertMemo(ctx context.Context, workflowRunID string, memo map[string]any) error {
+	data, err := converter.EncodeMemo(we.dataConverter, memo)
 	if err != nil {
-		return "", fmt.Errorf("failed to create workflow run: %w", err)
+		return err
 	}
+	return we.updateWorkflowRunAttributes(ctx, workflowRunID, nil, data)
+}
 
-	err = tx.Commit(ctx)
+func (we *WorkflowEngine) updateWorkflowRunAttributes(
+	ctx context.Context,
+	workflowRunID string,
+	searchAttributes map[string]any,
+	memo []byte,
+) error {
+	err := we.backend.RunInTx(ctx, func(tx backend.Tx) error {
+		return tx.WorkflowRepository().UpdateWorkflowRunAttributes(ctx, workflowRunID, searchAttributes, memo)
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to update workflow run attributes: %w", err)
 	}
+	return nil
+}
 
-	return workflowRunID, nil
+// DecodeMemoValue decodes the memo value stored under key on run into valuePtr. It reports
+// false if the run has no such memo value.
+func (we *WorkflowEngine) DecodeMemoValue(run *backend.WorkflowRun, key string, valuePtr any) (bool, error) {
+	return converter.DecodeMemoValue(we.dataConverter, run.Memo, key, valuePtr)
+}
+
+func (we *WorkflowEngine) encodePayloads(kind string, values ...any) ([]byte, error) {
+	data, err := converter.EncodeValues(we.dataConverter, values...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", kind, err)
+	}
+
+	limits := we.payloadSizeLimits
+	if limits.HardLimit > 0 && len(data) > limits.HardLimit {
+		return nil, &PayloadSizeError{Kind: kind, Size: len(data), Limit: limits.HardLimit}
+	}
+	if limits.SoftLimit > 0 && len(data) > limits.SoftLimit && limits.OnSoftLimitExceeded != nil {
+		limits.OnSoftLimitExceeded(&PayloadSizeError{Kind: kind, Size: len(data), Limit: limits.SoftLimit})
+	}
+	return data, nil
 }
@@ -4,9 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nurburg-dev/pitlane"
+	"github.com/nurburg-dev/pitlane/backend"
+	"github.com/nurburg-dev/pitlane/backend/memory"
 	"github.com/nurburg-dev/pitlane/internal/db"
 	"github.com/nurburg-dev/pitlane/internal/utils"
 	"github.com/stretchr/testify/require"
@@ -14,7 +19,9 @@ import (
 
 var (
 	pgContainer *utils.PGTestContainer
-	tables      = []string{"workflows", "workflow_runs"}
+	tables      = []string{
+		"workflows", "workflow_runs", "activity_runs", "workflow_events", "workers", "pitlane_schema_migrations",
+	}
 )
 
 func TestMain(m *testing.M) {
@@ -39,25 +46,93 @@ func TestEngineInit(t *testing.T) {
 		pgContainer.GetDatabase(),
 		pgContainer.GetPassword(),
 	)
-	// Part 1: check if database tables are not created
-	we0, err := pitlane.NewWorkflowEngine(ctx, pitlane.NewEngineConfig(cfg, false))
-	require.NoError(t, err)
-	require.NotNil(t, we0)
+	// The test owns its schema, so other tests migrating the public schema don't affect it.
+	const schema = "engine_init"
+	dropSchema := func() {
+		_, err := pgContainer.GetPool().Exec(ctx, `DROP SCHEMA IF EXISTS `+schema+` CASCADE`)
+		require.NoError(t, err)
+	}
+	dropSchema()
+	t.Cleanup(dropSchema)
+	newConfig := func(initDB bool) *pitlane.EngineConfig {
+		config := pitlane.NewEngineConfig(cfg, initDB)
+		config.SchemaConfig = pitlane.SchemaConfig{Schema: schema}
+		return config
+	}
+
+	// Part 1: check if database tables are not created and the engine refuses the unmigrated schema
+	we0, err := pitlane.NewWorkflowEngine(ctx, newConfig(false))
+	require.ErrorIs(t, err, pitlane.ErrSchemaVersionMismatch)
+	require.Nil(t, we0)
 	for _, table := range tables {
-		t1, err2 := db.TableExists(ctx, pgContainer.GetPool(), table)
+		t1, err2 := db.TableExists(ctx, pgContainer.GetPool(), schema, table)
 		require.NoError(t, err2)
 		require.False(t, t1)
 	}
 
 	// Part 2: check if tables are created
-	we, err := pitlane.NewWorkflowEngine(ctx, pitlane.NewEngineConfig(cfg, true))
+	we, err := pitlane.NewWorkflowEngine(ctx, newConfig(true))
 	require.NoError(t, err)
 	require.NotNil(t, we)
 	for _, table := range tables {
-		t1, err2 := db.TableExists(ctx, pgContainer.GetPool(), table)
+		t1, err2 := db.TableExists(ctx, pgContainer.GetPool(), schema, table)
 		require.NoError(t, err2)
 		require.True(t, t1)
 	}
+
+	// Part 3: an engine without InitDB accepts the migrated schema
+	we1, err := pitlane.NewWorkflowEngine(ctx, newConfig(false))
+	require.NoError(t, err)
+	require.NotNil(t, we1)
+}
+
+func TestMigrate_Concurrent(t *testing.T) {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = pitlane.Migrate(ctx, pgContainer.GetPool())
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	migrations, err := db.LoadMigrations()
+	require.NoError(t, err)
+	var count int
+	err = pgContainer.GetPool().QueryRow(ctx, `SELECT COUNT(*) FROM pitlane_schema_migrations`).Scan(&count)
+	require.NoError(t, err)
+	require.Len(t, migrations, count)
+}
+
+func TestEngineInit_NewerSchema(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, pitlane.Migrate(ctx, pgContainer.GetPool()))
+
+	_, err := pgContainer.GetPool().Exec(ctx,
+		`INSERT INTO pitlane_schema_migrations (version, name) VALUES (1000, 'from_the_future')`)
+	require.NoError(t, err)
+	defer func() {
+		_, _ = pgContainer.GetPool().Exec(ctx, `DELETE FROM pitlane_schema_migrations WHERE version = 1000`)
+	}()
+
+	cfg := pitlane.NewDBConfig(
+		pgContainer.GetHost(),
+		pgContainer.GetPort(),
+		pgContainer.GetUsername(),
+		pgContainer.GetDatabase(),
+		pgContainer.GetPassword(),
+	)
+	for _, initDB := range []bool{true, false} {
+		_, err = pitlane.NewWorkflowEngine(ctx, pitlane.NewEngineConfig(cfg, initDB))
+		require.ErrorIs(t, err, pitlane.ErrSchemaVersionMismatch)
+	}
 }
 
 func Activity1(_ context.Context, a, b int) (int, error) {
@@ -109,3 +184,175 @@ func TestInvokeWorkflow(t *testing.T) {
 	require.Contains(t, string(input), "test")
 	require.Contains(t, string(input), "42")
 }
+
+func LargeInputWorkflow(_ context.Context, data string) (int, error) {
+	return len(data), nil
+}
+
+func TestInvokeWorkflow_PayloadSizeLimits(t *testing.T) {
+	ctx := context.Background()
+	cfg := pitlane.NewDBConfig(
+		pgContainer.GetHost(),
+		pgContainer.GetPort(),
+		pgContainer.GetUsername(),
+		pgContainer.GetDatabase(),
+		pgContainer.GetPassword(),
+	)
+
+	var softLimitErrs []*pitlane.PayloadSizeError
+	engineConfig := pitlane.NewEngineConfig(cfg, true)
+	engineConfig.PayloadSizeLimits = pitlane.PayloadSizeLimits{
+		SoftLimit: 512,
+		OnSoftLimitExceeded: func(err *pitlane.PayloadSizeError) {
+			softLimitErrs = append(softLimitErrs, err)
+		},
+		HardLimit: 2048,
+	}
+	we, err := pitlane.NewWorkflowEngine(ctx, engineConfig)
+	require.NoError(t, err)
+
+	err = pitlane.RegisterWorkflow(LargeInputWorkflow)
+	require.NoError(t, err)
+
+	_, err = we.InvokeWorkflow(ctx, LargeInputWorkflow, strings.Repeat("a", 1024))
+	require.NoError(t, err)
+	require.Len(t, softLimitErrs, 1)
+	require.Equal(t, 512, softLimitErrs[0].Limit)
+
+	_, err = we.InvokeWorkflow(ctx, LargeInputWorkflow, strings.Repeat("a", 4096))
+	require.ErrorIs(t, err, pitlane.ErrPayloadTooLarge)
+	var sizeErr *pitlane.PayloadSizeError
+	require.ErrorAs(t, err, &sizeErr)
+	require.Equal(t, "workflow input", sizeErr.Kind)
+	require.Equal(t, 2048, sizeErr.Limit)
+}
+
+func SchemaWorkflow(_ context.Context, name string) (string, error) {
+	return name, nil
+}
+
+func TestEngine_CustomSchemaAndPrefix(t *testing.T) {
+	ctx := context.Background()
+	cfg := pitlane.NewDBConfig(
+		pgContainer.GetHost(),
+		pgContainer.GetPort(),
+		pgContainer.GetUsername(),
+		pgContainer.GetDatabase(),
+		pgContainer.GetPassword(),
+	)
+	engineConfig := pitlane.NewEngineConfig(cfg, true)
+	engineConfig.SchemaConfig = pitlane.SchemaConfig{Schema: "pitlane_custom", TablePrefix: "pl_"}
+
+	we, err := pitlane.NewWorkflowEngine(ctx, engineConfig)
+	require.NoError(t, err)
+	for _, table := range tables {
+		exists, err2 := db.TableExists(ctx, pgContainer.GetPool(), "pitlane_custom", "pl_"+table)
+		require.NoError(t, err2)
+		require.True(t, exists)
+	}
+
+	require.NoError(t, pitlane.RegisterWorkflow(SchemaWorkflow))
+	workflowRunID, err := we.InvokeWorkflow(ctx, SchemaWorkflow, "test")
+	require.NoError(t, err)
+
+	var count int
+	err = pgContainer.GetPool().QueryRow(ctx,
+		`SELECT COUNT(*) FROM pitlane_custom.pl_workflow_runs WHERE id = $1`, workflowRunID,
+	).Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestNewWorkflowEngineWithPool(t *testing.T) {
+	ctx := context.Background()
+
+	we, err := pitlane.NewWorkflowEngineWithPool(ctx, pgContainer.GetPool(), pitlane.NewEngineConfig(nil, true))
+	require.NoError(t, err)
+	require.NotNil(t, we)
+	require.NoError(t, pgContainer.GetPool().Ping(ctx))
+}
+
+func TestWorker_Shutdown(t *testing.T) {
+	ctx := context.Background()
+	cfg := pitlane.NewDBConfig(
+		pgContainer.GetHost(),
+		pgContainer.GetPort(),
+		pgContainer.GetUsername(),
+		pgContainer.GetDatabase(),
+		pgContainer.GetPassword(),
+	)
+	we, err := pitlane.NewWorkflowEngine(ctx, pitlane.NewEngineConfig(cfg, true))
+	require.NoError(t, err)
+
+	worker := we.NewWorker(pitlane.WorkerOptions{
+		TaskQueues:   []string{"shutdown"},
+		PollInterval: 10 * time.Millisecond,
+		WorkflowTaskHandler: func(ctx context.Context, run *backend.WorkflowRun) error {
+			return we.CompleteWorkflowRun(ctx, run, "done")
+		},
+	})
+	require.NoError(t, worker.Start(ctx))
+	require.NoError(t, worker.Shutdown(ctx))
+
+	// The pool the engine created is closed, the pool of the container is left open.
+	_, err = we.ListWorkers(ctx)
+	require.Error(t, err)
+	require.NoError(t, pgContainer.GetPool().Ping(ctx))
+}
+
+func MemoryWorkflow(_ context.Context, name string) (string, error) {
+	return name, nil
+}
+
+func TestNewWorkflowEngineWithBackend_Memory(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+
+	we, err := pitlane.NewWorkflowEngineWithBackend(ctx, b, pitlane.NewEngineConfig(nil, true))
+	require.NoError(t, err)
+
+	require.NoError(t, pitlane.RegisterWorkflow(MemoryWorkflow))
+	workflowRunID, err := we.InvokeWorkflow(ctx, MemoryWorkflow, "test")
+	require.NoError(t, err)
+
+	err = b.RunInTx(ctx, func(tx backend.Tx) error {
+		run, err2 := tx.WorkflowRepository().GetNextWorkflowRun(ctx)
+		require.NoError(t, err2)
+		require.NotNil(t, run)
+		require.Equal(t, workflowRunID, run.ID)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestListenForTasks(t *testing.T) {
+	ctx := context.Background()
+
+	we, err := pitlane.NewWorkflowEngineWithPool(ctx, pgContainer.GetPool(), pitlane.NewEngineConfig(nil, true))
+	require.NoError(t, err)
+	require.NoError(t, registerOrderWorkflow())
+
+	listener, err := we.ListenForTasks(ctx, "listen-orders")
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	options := pitlane.StartWorkflowOptions{TaskQueue: "listen-payments"}
+	_, err = we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "payment")
+	require.NoError(t, err)
+	select {
+	case <-listener.C():
+		t.Fatal("notified of a run on another task queue")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	options.TaskQueue = "listen-orders"
+	_, err = we.InvokeWorkflowWithOptions(ctx, options, OrderWorkflow, "order")
+	require.NoError(t, err)
+	select {
+	case <-listener.C():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not notified of a run on the listened task queue")
+	}
+}